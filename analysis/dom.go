@@ -0,0 +1,292 @@
+// Package analysis computes properties of the control flow graph of IR
+// functions, such as dominator trees, dominance frontiers and loops.
+//
+// The analyses read the Successors and Predecessors edges maintained on
+// each basic block; they do not modify the function. SplitCriticalEdges and
+// SplitEdge are the exception: they reshape the control flow graph into
+// the form some analyses and transforms require.
+package analysis
+
+import "github.com/arc-language/core-builder/ir"
+
+// DomTree is a dominator or post-dominator tree over the blocks of a
+// function. Only blocks reachable from the root are in the tree. A
+// post-dominator tree is rooted at a virtual exit node joining every block
+// without successors; it is represented by nil, so IDom returns nil for
+// blocks post-dominated only by the virtual exit.
+type DomTree struct {
+	post     bool
+	exits    []*ir.BasicBlock // blocks without successors, for post-dominators
+	idom     map[*ir.BasicBlock]*ir.BasicBlock
+	children map[*ir.BasicBlock][]*ir.BasicBlock
+	order    []*ir.BasicBlock       // reverse postorder, excluding the virtual exit
+	num      map[*ir.BasicBlock]int // position in reverse postorder
+	in, out  map[*ir.BasicBlock]int // DFS intervals over the tree
+}
+
+// Dominators computes the dominator tree of fn, rooted at its entry block
+func Dominators(fn *ir.Function) *DomTree {
+	t := &DomTree{}
+	if entry := fn.EntryBlock(); entry != nil {
+		t.build(entry)
+	} else {
+		t.build(nil)
+	}
+	return t
+}
+
+// PostDominators computes the post-dominator tree of fn. Blocks that cannot
+// reach a block without successors, such as those in infinite loops, are
+// not part of the tree.
+func PostDominators(fn *ir.Function) *DomTree {
+	t := &DomTree{post: true}
+	for _, b := range fn.Blocks {
+		if len(b.Successors) == 0 {
+			t.exits = append(t.exits, b)
+		}
+	}
+	t.build(nil)
+	return t
+}
+
+// forward returns the edges leaving b in the direction of the analysis
+func (t *DomTree) forward(b *ir.BasicBlock) []*ir.BasicBlock {
+	if !t.post {
+		if b == nil {
+			return nil
+		}
+		return b.Successors
+	}
+	if b == nil {
+		return t.exits
+	}
+	return b.Predecessors
+}
+
+// backward returns the edges entering b in the direction of the analysis,
+// including the virtual exit for exit blocks of a post-dominator tree
+func (t *DomTree) backward(b *ir.BasicBlock) []*ir.BasicBlock {
+	if !t.post {
+		return b.Predecessors
+	}
+	if len(b.Successors) == 0 {
+		return []*ir.BasicBlock{nil}
+	}
+	return b.Successors
+}
+
+// build computes the tree with the iterative algorithm of Cooper, Harvey
+// and Kennedy
+func (t *DomTree) build(root *ir.BasicBlock) {
+	t.idom = make(map[*ir.BasicBlock]*ir.BasicBlock)
+	t.children = make(map[*ir.BasicBlock][]*ir.BasicBlock)
+	t.num = make(map[*ir.BasicBlock]int)
+	t.in = make(map[*ir.BasicBlock]int)
+	t.out = make(map[*ir.BasicBlock]int)
+	if root == nil && !t.post {
+		return
+	}
+
+	var post []*ir.BasicBlock
+	visited := map[*ir.BasicBlock]bool{root: true}
+	var walk func(b *ir.BasicBlock)
+	walk = func(b *ir.BasicBlock) {
+		for _, s := range t.forward(b) {
+			if !visited[s] {
+				visited[s] = true
+				walk(s)
+			}
+		}
+		post = append(post, b)
+	}
+	walk(root)
+
+	rpo := make([]*ir.BasicBlock, len(post))
+	for i, b := range post {
+		rpo[len(post)-1-i] = b
+	}
+	for i, b := range rpo {
+		t.num[b] = i
+	}
+
+	t.idom[root] = root
+	for changed := true; changed; {
+		changed = false
+		for _, b := range rpo[1:] {
+			var newIdom *ir.BasicBlock
+			found := false
+			for _, p := range t.backward(b) {
+				if _, ok := t.idom[p]; !ok {
+					continue
+				}
+				if !found {
+					newIdom, found = p, true
+				} else {
+					newIdom = t.intersect(p, newIdom)
+				}
+			}
+			if cur, ok := t.idom[b]; found && (!ok || cur != newIdom) {
+				t.idom[b] = newIdom
+				changed = true
+			}
+		}
+	}
+
+	for _, b := range rpo[1:] {
+		p := t.idom[b]
+		t.children[p] = append(t.children[p], b)
+	}
+	t.idom[root] = nil
+	t.order = rpo
+	if root == nil {
+		t.order = rpo[1:]
+	}
+
+	clock := 0
+	var number func(b *ir.BasicBlock)
+	number = func(b *ir.BasicBlock) {
+		t.in[b] = clock
+		clock++
+		for _, c := range t.children[b] {
+			number(c)
+		}
+		t.out[b] = clock
+		clock++
+	}
+	number(root)
+}
+
+func (t *DomTree) intersect(a, b *ir.BasicBlock) *ir.BasicBlock {
+	for a != b {
+		for t.num[a] > t.num[b] {
+			a = t.idom[a]
+		}
+		for t.num[b] > t.num[a] {
+			b = t.idom[b]
+		}
+	}
+	return a
+}
+
+// IsPostDom reports whether t is a post-dominator tree
+func (t *DomTree) IsPostDom() bool { return t.post }
+
+// Roots returns the top-level blocks of the tree: the entry block for a
+// dominator tree, or the children of the virtual exit for a post-dominator
+// tree
+func (t *DomTree) Roots() []*ir.BasicBlock {
+	if t.post {
+		return t.children[nil]
+	}
+	if len(t.order) == 0 {
+		return nil
+	}
+	return t.order[:1]
+}
+
+// Reachable reports whether b is in the tree
+func (t *DomTree) Reachable(b *ir.BasicBlock) bool {
+	if b == nil {
+		return false
+	}
+	_, ok := t.num[b]
+	return ok
+}
+
+// IDom returns the immediate dominator of b, or nil for a root
+func (t *DomTree) IDom(b *ir.BasicBlock) *ir.BasicBlock {
+	return t.idom[b]
+}
+
+// Children returns the blocks immediately dominated by b
+func (t *DomTree) Children(b *ir.BasicBlock) []*ir.BasicBlock {
+	if b == nil {
+		return nil
+	}
+	return t.children[b]
+}
+
+// Blocks returns the blocks of the tree in reverse postorder of the graph
+// the tree was computed over, so each block follows its dominator
+func (t *DomTree) Blocks() []*ir.BasicBlock {
+	return t.order
+}
+
+// Dominates reports whether a dominates b. Every block dominates itself;
+// blocks outside the tree dominate nothing and are dominated by nothing.
+func (t *DomTree) Dominates(a, b *ir.BasicBlock) bool {
+	if !t.Reachable(a) || !t.Reachable(b) {
+		return false
+	}
+	return t.in[a] <= t.in[b] && t.out[b] <= t.out[a]
+}
+
+// StrictlyDominates reports whether a dominates b and a != b
+func (t *DomTree) StrictlyDominates(a, b *ir.BasicBlock) bool {
+	return a != b && t.Dominates(a, b)
+}
+
+// Frontier maps each block to its dominance frontier
+type Frontier map[*ir.BasicBlock][]*ir.BasicBlock
+
+// Frontiers computes the dominance frontier of every block in the tree.
+// For a post-dominator tree these are the post-dominance frontiers, which
+// give the control dependences of each block.
+func (t *DomTree) Frontiers() Frontier {
+	df := make(Frontier)
+	for _, b := range t.order {
+		preds := t.backward(b)
+		if len(preds) < 2 {
+			continue
+		}
+		for _, p := range preds {
+			if p == nil || !t.Reachable(p) {
+				continue
+			}
+			for runner := p; runner != nil && runner != t.idom[b]; runner = t.idom[runner] {
+				if !contains(df[runner], b) {
+					df[runner] = append(df[runner], b)
+				}
+			}
+		}
+	}
+	return df
+}
+
+// Iterated returns the iterated dominance frontier of a set of blocks: the
+// blocks where definitions in the set meet and, in SSA construction, need
+// a phi
+func (df Frontier) Iterated(blocks []*ir.BasicBlock) []*ir.BasicBlock {
+	var result []*ir.BasicBlock
+	inResult := make(map[*ir.BasicBlock]bool)
+	work := append([]*ir.BasicBlock(nil), blocks...)
+	queued := make(map[*ir.BasicBlock]bool)
+	for _, b := range work {
+		queued[b] = true
+	}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, f := range df[b] {
+			if inResult[f] {
+				continue
+			}
+			inResult[f] = true
+			result = append(result, f)
+			if !queued[f] {
+				queued[f] = true
+				work = append(work, f)
+			}
+		}
+	}
+	return result
+}
+
+func contains(list []*ir.BasicBlock, b *ir.BasicBlock) bool {
+	for _, x := range list {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// cfg builds a function from a description of its control flow graph such
+// as "entry:a,b a:exit b:a exit:". Each block is listed with its successors
+// in order; the first block is the entry. Blocks without successors
+// return, those with one branch, those with two branch on the first
+// argument and those with more switch on the second.
+func cfg(desc string) *ir.Function {
+	b := builder.New()
+	b.CreateModule("test")
+	fn := b.CreateFunction("f", types.Void, []types.Type{types.I1, types.I32}, false)
+	var names []string
+	succs := make(map[string][]string)
+	blocks := make(map[string]*ir.BasicBlock)
+	for _, field := range strings.Fields(desc) {
+		name, list, _ := strings.Cut(field, ":")
+		names = append(names, name)
+		if list != "" {
+			succs[name] = strings.Split(list, ",")
+		}
+		blocks[name] = b.CreateBlock(name)
+	}
+	for _, name := range names {
+		b.SetInsertPoint(blocks[name])
+		s := succs[name]
+		switch len(s) {
+		case 0:
+			b.CreateRetVoid()
+		case 1:
+			b.CreateBr(blocks[s[0]])
+		case 2:
+			b.CreateCondBr(fn.Arguments[0], blocks[s[0]], blocks[s[1]])
+		default:
+			sw := b.CreateSwitch(fn.Arguments[1], blocks[s[0]], len(s)-1)
+			for i, c := range s[1:] {
+				b.AddCase(sw, b.ConstInt(types.I32, int64(i)), blocks[c])
+			}
+		}
+	}
+	return fn
+}
+
+// block returns the block of fn with the given name
+func block(fn *ir.Function, name string) *ir.BasicBlock {
+	for _, b := range fn.Blocks {
+		if b.Name() == name {
+			return b
+		}
+	}
+	panic("no block %" + name)
+}
+
+// names returns the sorted names of blocks, with nil as "-"
+func names(blocks []*ir.BasicBlock) string {
+	var list []string
+	for _, b := range blocks {
+		if b == nil {
+			list = append(list, "-")
+		} else {
+			list = append(list, b.Name())
+		}
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// irreducible has a loop between a and b entered at both blocks
+const irreducible = "entry:a,b a:b,exit b:a exit:"
+
+func TestDominatorsIrreducible(t *testing.T) {
+	fn := cfg(irreducible)
+	dom := Dominators(fn)
+	idoms := map[string]string{"entry": "-", "a": "entry", "b": "entry", "exit": "a"}
+	for name, want := range idoms {
+		if got := names([]*ir.BasicBlock{dom.IDom(block(fn, name))}); got != want {
+			t.Errorf("idom(%s) = %s, want %s", name, got, want)
+		}
+	}
+	if names(dom.Roots()) != "entry" {
+		t.Errorf("roots %s, want entry", names(dom.Roots()))
+	}
+	if !dom.Dominates(block(fn, "a"), block(fn, "exit")) || dom.Dominates(block(fn, "a"), block(fn, "b")) {
+		t.Errorf("a should dominate exit and not b")
+	}
+	if !dom.Dominates(block(fn, "b"), block(fn, "b")) || dom.StrictlyDominates(block(fn, "b"), block(fn, "b")) {
+		t.Errorf("b should dominate itself, but not strictly")
+	}
+
+	df := dom.Frontiers()
+	frontiers := map[string]string{"entry": "", "a": "b", "b": "a", "exit": ""}
+	for name, want := range frontiers {
+		if got := names(df[block(fn, name)]); got != want {
+			t.Errorf("DF(%s) = {%s}, want {%s}", name, got, want)
+		}
+	}
+	if got := names(df.Iterated([]*ir.BasicBlock{block(fn, "a")})); got != "a,b" {
+		t.Errorf("iterated DF(a) = {%s}, want {a,b}", got)
+	}
+}
+
+func TestPostDominatorsIrreducible(t *testing.T) {
+	fn := cfg(irreducible)
+	pdom := PostDominators(fn)
+	if !pdom.IsPostDom() {
+		t.Fatal("not a post-dominator tree")
+	}
+	ipdoms := map[string]string{"entry": "a", "a": "exit", "b": "a", "exit": "-"}
+	for name, want := range ipdoms {
+		if got := names([]*ir.BasicBlock{pdom.IDom(block(fn, name))}); got != want {
+			t.Errorf("ipdom(%s) = %s, want %s", name, got, want)
+		}
+	}
+	if names(pdom.Roots()) != "exit" {
+		t.Errorf("roots %s, want exit", names(pdom.Roots()))
+	}
+
+	// Post-dominance frontiers are control dependences: b runs depending on
+	// the branches in entry and a
+	pdf := pdom.Frontiers()
+	frontiers := map[string]string{"entry": "", "a": "a", "b": "a,entry", "exit": ""}
+	for name, want := range frontiers {
+		if got := names(pdf[block(fn, name)]); got != want {
+			t.Errorf("PDF(%s) = {%s}, want {%s}", name, got, want)
+		}
+	}
+}
+
+func TestDiamond(t *testing.T) {
+	fn := cfg("entry:l,r l:join r:join join:")
+	dom := Dominators(fn)
+	if got := names([]*ir.BasicBlock{dom.IDom(block(fn, "join"))}); got != "entry" {
+		t.Errorf("idom(join) = %s, want entry", got)
+	}
+	if got := names(dom.Children(block(fn, "entry"))); got != "join,l,r" {
+		t.Errorf("children of entry %s, want join,l,r", got)
+	}
+	df := dom.Frontiers()
+	if names(df[block(fn, "l")]) != "join" || names(df[block(fn, "r")]) != "join" {
+		t.Errorf("DF(l) = {%s}, DF(r) = {%s}, want {join}", names(df[block(fn, "l")]), names(df[block(fn, "r")]))
+	}
+	order := dom.Blocks()
+	if len(order) != 4 || order[0] != block(fn, "entry") || order[3] != block(fn, "join") {
+		t.Errorf("reverse postorder %v", order)
+	}
+}
+
+func TestUnreachableBlocks(t *testing.T) {
+	fn := cfg("entry:exit dead:exit spin:spin exit:")
+	dom := Dominators(fn)
+	if dom.Reachable(block(fn, "dead")) || dom.Dominates(block(fn, "dead"), block(fn, "dead")) {
+		t.Errorf("unreachable block is in the dominator tree")
+	}
+	if got := names([]*ir.BasicBlock{dom.IDom(block(fn, "exit"))}); got != "entry" {
+		t.Errorf("idom(exit) = %s, want entry; the unreachable predecessor must not count", got)
+	}
+
+	// spin never reaches the exit, so it has no post-dominator
+	pdom := PostDominators(fn)
+	if pdom.Reachable(block(fn, "spin")) {
+		t.Errorf("infinite loop is in the post-dominator tree")
+	}
+	if !pdom.Dominates(block(fn, "exit"), block(fn, "dead")) {
+		t.Errorf("exit should post-dominate dead")
+	}
+}
+
+func TestPostDominatorsSeveralExits(t *testing.T) {
+	fn := cfg("entry:a,b a: b:")
+	pdom := PostDominators(fn)
+	// Only the virtual exit post-dominates entry, which makes entry a root
+	// along with the exits
+	if got := names(pdom.Roots()); got != "a,b,entry" {
+		t.Errorf("roots %s, want a,b,entry", got)
+	}
+	if pdom.IDom(block(fn, "entry")) != nil || !pdom.Reachable(block(fn, "entry")) {
+		t.Errorf("ipdom(entry) = %v, want the virtual exit", pdom.IDom(block(fn, "entry")))
+	}
+}
@@ -0,0 +1,156 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/ir"
+)
+
+// IsCriticalEdge reports whether the edge from one block to another is
+// critical: from has other successors and to other predecessors, so code
+// for the edge alone fits in neither block. Several edges between the same
+// two blocks count as one.
+func IsCriticalEdge(from, to *ir.BasicBlock) bool {
+	return hasOther(from.Successors, to) && hasOther(to.Predecessors, from)
+}
+
+func hasOther(blocks []*ir.BasicBlock, b *ir.BasicBlock) bool {
+	for _, x := range blocks {
+		if x != b {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitCriticalEdges places a new block on every critical edge of fn and
+// reports whether it split any. The new blocks hold just a branch and
+// follow their predecessor in the block list.
+func SplitCriticalEdges(fn *ir.Function) bool {
+	type edge struct{ from, to *ir.BasicBlock }
+	var critical []edge
+	seen := make(map[edge]bool)
+	for _, b := range fn.Blocks {
+		for _, s := range b.Successors {
+			e := edge{b, s}
+			if !seen[e] && IsCriticalEdge(b, s) {
+				seen[e] = true
+				critical = append(critical, e)
+			}
+		}
+	}
+	for _, e := range critical {
+		SplitEdge(e.from, e.to)
+	}
+	return len(critical) > 0
+}
+
+// SplitEdge places a new block on the edge from one block to another and
+// returns it. The terminator of from, the Successors and Predecessors
+// lists and the phi entries of to are redirected through the new block.
+// Several edges between the same blocks, as from a switch with several
+// cases leading to the same block, all go through the new block, which
+// reaches to by a single edge.
+func SplitEdge(from, to *ir.BasicBlock) *ir.BasicBlock {
+	fn := from.Parent
+	nb := ir.NewBasicBlock(edgeBlockName(fn, from.Name()+"."+to.Name()))
+	nb.Parent = fn
+	pos := len(fn.Blocks)
+	for i, b := range fn.Blocks {
+		if b == from {
+			pos = i + 1
+			break
+		}
+	}
+	fn.Blocks = append(fn.Blocks, nil)
+	copy(fn.Blocks[pos+1:], fn.Blocks[pos:])
+	fn.Blocks[pos] = nb
+
+	switch t := from.Terminator().(type) {
+	case *ir.BrInst:
+		t.Target = nb
+	case *ir.CondBrInst:
+		if t.TrueBlock == to {
+			t.TrueBlock = nb
+		}
+		if t.FalseBlock == to {
+			t.FalseBlock = nb
+		}
+	case *ir.SwitchInst:
+		if t.DefaultBlock == to {
+			t.DefaultBlock = nb
+		}
+		for i := range t.Cases {
+			if t.Cases[i].Block == to {
+				t.Cases[i].Block = nb
+			}
+		}
+	default:
+		panic(fmt.Sprintf("block %%%s does not branch to %%%s", from.Name(), to.Name()))
+	}
+	for i, s := range from.Successors {
+		if s == to {
+			from.Successors[i] = nb
+			nb.Predecessors = append(nb.Predecessors, from)
+		}
+	}
+
+	// The edge from the new block takes the place of the first edge from
+	// from, along with its phi entries
+	preds := to.Predecessors[:0]
+	replaced := false
+	for _, p := range to.Predecessors {
+		switch {
+		case p != from:
+			preds = append(preds, p)
+		case !replaced:
+			preds = append(preds, nb)
+			replaced = true
+		}
+	}
+	for i := len(preds); i < len(to.Predecessors); i++ {
+		to.Predecessors[i] = nil
+	}
+	to.Predecessors = preds
+	for _, inst := range to.Instructions {
+		phi, ok := inst.(*ir.PhiInst)
+		if !ok {
+			break
+		}
+		for i := range phi.Incoming {
+			if phi.Incoming[i].Block == from {
+				phi.Incoming[i].Block = nb
+				break
+			}
+		}
+		for phi.RemoveIncoming(from) != nil {
+			// Drop the entries of the other edges
+		}
+	}
+
+	br := &ir.BrInst{Target: to}
+	br.Op = ir.OpBr
+	nb.AddInstruction(br)
+	nb.Successors = []*ir.BasicBlock{to}
+	return nb
+}
+
+// edgeBlockName returns base, or base with the first numeric suffix that no
+// block or value of fn uses
+func edgeBlockName(fn *ir.Function, base string) string {
+	used := make(map[string]bool)
+	for _, a := range fn.Arguments {
+		used[a.Name()] = true
+	}
+	for _, b := range fn.Blocks {
+		used[b.Name()] = true
+		for _, inst := range b.Instructions {
+			used[inst.Name()] = true
+		}
+	}
+	name := base
+	for n := 1; used[name]; n++ {
+		name = fmt.Sprintf("%s.%d", base, n)
+	}
+	return name
+}
@@ -0,0 +1,139 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/interp"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// dupCases reaches %join from the switch through two cases, and from the
+// entry block, so both edges into %join are critical
+const dupCases = `
+define external i32 @f(i1 %c, i32 %n) {
+entry:
+  br i1 %c, label %sw, label %join
+sw:
+  switch i32 %n, label %other [
+    i32 0, label %join
+    i32 1, label %join
+    i32 2, label %other
+  ]
+other:
+  br label %join
+join:
+  %r = phi i32 [ 10, %entry ], [ 20, %sw ], [ 30, %other ]
+  ret i32 %r
+}
+`
+
+// results calls @f of m with every combination of interesting arguments
+func results(t *testing.T, m *ir.Module) []int64 {
+	t.Helper()
+	in, err := interp.New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []int64
+	for c := int64(0); c <= 1; c++ {
+		for n := int64(0); n <= 3; n++ {
+			v, err := in.Call("f", interp.Int(c), interp.Int(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, v.Int())
+		}
+	}
+	return list
+}
+
+// noCriticalEdges fails if fn has a critical edge
+func noCriticalEdges(t *testing.T, fn *ir.Function) {
+	t.Helper()
+	for _, b := range fn.Blocks {
+		for _, s := range b.Successors {
+			if IsCriticalEdge(b, s) {
+				t.Errorf("edge %%%s -> %%%s is critical", b.Name(), s.Name())
+			}
+		}
+	}
+}
+
+func TestSplitSwitchDuplicateCases(t *testing.T) {
+	m, err := asm.Parse("test", dupCases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := m.Functions[0]
+	before := results(t, m)
+	sw, join := block(fn, "sw"), block(fn, "join")
+	if !IsCriticalEdge(sw, join) || !IsCriticalEdge(block(fn, "entry"), join) {
+		t.Fatalf("the edges into %%join are not critical")
+	}
+	if IsCriticalEdge(sw, block(fn, "other")) {
+		t.Errorf("edge %%sw -> %%other is critical, but %%other has no other predecessor")
+	}
+
+	if !SplitCriticalEdges(fn) {
+		t.Fatal("SplitCriticalEdges reports no change")
+	}
+	noCriticalEdges(t, fn)
+	if got := names(fn.Blocks); got != "entry,entry.join,join,other,sw,sw.join" {
+		t.Errorf("blocks are %s", got)
+	}
+
+	// Both cases now go through one new block, which reaches %join once
+	edge := block(fn, "sw.join")
+	s := sw.Terminator().(*ir.SwitchInst)
+	if s.Cases[0].Block != edge || s.Cases[1].Block != edge || s.Cases[2].Block.Name() != "other" || s.DefaultBlock.Name() != "other" {
+		t.Errorf("switch not rewired: %s", s)
+	}
+	if got := names(edge.Predecessors); got != "sw,sw" {
+		t.Errorf("%%sw.join has predecessors %s, want the two cases", got)
+	}
+	if got := names(join.Predecessors); got != "entry.join,other,sw.join" {
+		t.Errorf("%%join has predecessors %s", got)
+	}
+	phi := join.Instructions[0].(*ir.PhiInst)
+	if len(phi.Incoming) != 3 || phi.Incoming[1].Block != edge || phi.Incoming[1].Value.(*ir.ConstantInt).Value != 20 {
+		t.Errorf("phi not rewired: %s", phi)
+	}
+
+	if after := results(t, m); !equal(before, after) {
+		t.Errorf("results changed from %v to %v", before, after)
+	}
+	if SplitCriticalEdges(fn) {
+		t.Error("splitting again changed the function")
+	}
+}
+
+func TestSplitEdgeName(t *testing.T) {
+	// The edge block for %a -> %c would be called a.c, which is taken
+	fn := cfg("entry:a,c a:a.c,c a.c:c c:")
+	if !SplitCriticalEdges(fn) {
+		t.Fatal("SplitCriticalEdges reports no change")
+	}
+	noCriticalEdges(t, fn)
+	nb := block(fn, "a.c.1")
+	if got := names(nb.Predecessors); got != "a" || names(nb.Successors) != "c" {
+		t.Errorf("%%a.c.1 has edges %s -> %s, want a -> c", got, names(nb.Successors))
+	}
+	for i, b := range fn.Blocks {
+		if b.Name() == "a" && fn.Blocks[i+1] != nb {
+			t.Errorf("%%a.c.1 does not follow %%a")
+		}
+	}
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package analysis
+
+import "github.com/arc-language/core-builder/ir"
+
+// Loop is a natural loop: a header block and every block that reaches a
+// back edge into the header without passing through it. A back edge is an
+// edge whose target dominates its source.
+type Loop struct {
+	Header *ir.BasicBlock
+	// Blocks lists the blocks of the loop, including those of nested loops,
+	// in reverse postorder, so the header comes first
+	Blocks []*ir.BasicBlock
+	// Parent is the innermost loop containing this one, or nil
+	Parent *Loop
+	// Children lists the loops nested directly in this one
+	Children []*Loop
+	// Depth is the nesting depth, 1 for a loop not nested in another
+	Depth int
+
+	blocks map[*ir.BasicBlock]bool
+}
+
+// Contains reports whether b is in the loop
+func (l *Loop) Contains(b *ir.BasicBlock) bool {
+	return l.blocks[b]
+}
+
+// Latches returns the blocks of the loop that branch back to the header
+func (l *Loop) Latches() []*ir.BasicBlock {
+	var list []*ir.BasicBlock
+	for _, p := range l.Header.Predecessors {
+		if l.blocks[p] && !contains(list, p) {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// Latch returns the only block branching back to the header, or nil if
+// there are several
+func (l *Loop) Latch() *ir.BasicBlock {
+	if latches := l.Latches(); len(latches) == 1 {
+		return latches[0]
+	}
+	return nil
+}
+
+// ExitingBlocks returns the blocks of the loop with a successor outside it
+func (l *Loop) ExitingBlocks() []*ir.BasicBlock {
+	var list []*ir.BasicBlock
+	for _, b := range l.Blocks {
+		for _, s := range b.Successors {
+			if !l.blocks[s] {
+				list = append(list, b)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// ExitBlocks returns the blocks outside the loop that a block of the loop
+// branches to
+func (l *Loop) ExitBlocks() []*ir.BasicBlock {
+	var list []*ir.BasicBlock
+	for _, b := range l.Blocks {
+		for _, s := range b.Successors {
+			if !l.blocks[s] && !contains(list, s) {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// Preheader returns the block code hoisted out of the loop can go to: the
+// only predecessor of the header outside the loop, provided the header is
+// its only successor. It returns nil if there is no such block.
+func (l *Loop) Preheader() *ir.BasicBlock {
+	var pre *ir.BasicBlock
+	for _, p := range l.Header.Predecessors {
+		if l.blocks[p] || p == pre {
+			continue
+		}
+		if pre != nil {
+			return nil
+		}
+		pre = p
+	}
+	if pre == nil {
+		return nil
+	}
+	for _, s := range pre.Successors {
+		if s != l.Header {
+			return nil
+		}
+	}
+	return pre
+}
+
+// LoopInfo is the loop nesting forest of a function
+type LoopInfo struct {
+	// All lists every loop, each before the loops nested in it
+	All []*Loop
+	// TopLevel lists the loops not nested in another loop
+	TopLevel []*Loop
+
+	innermost map[*ir.BasicBlock]*Loop
+}
+
+// Loops finds the natural loops of fn
+func Loops(fn *ir.Function) *LoopInfo {
+	return Dominators(fn).Loops()
+}
+
+// Loops finds the natural loops of the function t was computed for. t must
+// be a dominator tree. Loops sharing a header are one loop; blocks
+// unreachable from the entry are in no loop.
+func (t *DomTree) Loops() *LoopInfo {
+	li := &LoopInfo{innermost: make(map[*ir.BasicBlock]*Loop)}
+	if t.post {
+		panic("loops need a dominator tree, not a post-dominator tree")
+	}
+
+	// Visit headers in postorder, so inner loops are found before the
+	// loops around them. Walking backwards from the latches, a block that
+	// already belongs to a loop stands for the outermost loop found so far
+	// around it, which becomes nested in the new loop.
+	headers := make(map[*ir.BasicBlock]*Loop)
+	for i := len(t.order) - 1; i >= 0; i-- {
+		h := t.order[i]
+		var work []*ir.BasicBlock
+		for _, p := range h.Predecessors {
+			if t.Dominates(h, p) {
+				work = append(work, p)
+			}
+		}
+		if len(work) == 0 {
+			continue
+		}
+		l := &Loop{Header: h}
+		headers[h] = l
+		li.innermost[h] = l
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			if sub := li.innermost[b]; sub != nil {
+				for sub.Parent != nil {
+					sub = sub.Parent
+				}
+				if sub == l {
+					continue
+				}
+				sub.Parent = l
+				b = sub.Header
+			} else {
+				li.innermost[b] = l
+			}
+			for _, p := range b.Predecessors {
+				if t.Reachable(p) {
+					work = append(work, p)
+				}
+			}
+		}
+	}
+
+	// Fill in the blocks and the nesting in reverse postorder, which puts
+	// every header before the blocks and loops it dominates
+	for _, b := range t.order {
+		if l := headers[b]; l != nil {
+			l.blocks = make(map[*ir.BasicBlock]bool)
+			if l.Parent == nil {
+				l.Depth = 1
+				li.TopLevel = append(li.TopLevel, l)
+			} else {
+				l.Depth = l.Parent.Depth + 1
+				l.Parent.Children = append(l.Parent.Children, l)
+			}
+			li.All = append(li.All, l)
+		}
+		for l := li.innermost[b]; l != nil; l = l.Parent {
+			l.Blocks = append(l.Blocks, b)
+			l.blocks[b] = true
+		}
+	}
+	return li
+}
+
+// LoopFor returns the innermost loop containing b, or nil
+func (li *LoopInfo) LoopFor(b *ir.BasicBlock) *Loop {
+	return li.innermost[b]
+}
+
+// Depth returns the number of loops containing b
+func (li *LoopInfo) Depth(b *ir.BasicBlock) int {
+	if l := li.innermost[b]; l != nil {
+		return l.Depth
+	}
+	return 0
+}
+
+// IsHeader reports whether b is the header of a loop
+func (li *LoopInfo) IsHeader(b *ir.BasicBlock) bool {
+	l := li.innermost[b]
+	return l != nil && l.Header == b
+}
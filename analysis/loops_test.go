@@ -0,0 +1,164 @@
+package analysis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+)
+
+// nested has a loop at inner nested in a loop at outer
+const nested = "entry:outer outer:inner,exit inner:body,latch body:inner latch:outer exit:"
+
+func TestNestedLoops(t *testing.T) {
+	fn := cfg(nested)
+	li := Loops(fn)
+	if len(li.All) != 2 || len(li.TopLevel) != 1 {
+		t.Fatalf("found %d loops, %d at top level; want 2 and 1", len(li.All), len(li.TopLevel))
+	}
+	outer, inner := li.All[0], li.All[1]
+	if outer.Header != block(fn, "outer") || inner.Header != block(fn, "inner") || li.TopLevel[0] != outer {
+		t.Fatalf("loops have headers %%%s and %%%s", outer.Header.Name(), inner.Header.Name())
+	}
+	if inner.Parent != outer || len(outer.Children) != 1 || outer.Children[0] != inner || outer.Parent != nil {
+		t.Error("inner loop is not nested in the outer loop")
+	}
+	if outer.Depth != 1 || inner.Depth != 2 {
+		t.Errorf("depths are %d and %d, want 1 and 2", outer.Depth, inner.Depth)
+	}
+
+	tests := []struct {
+		name                          string
+		blocks, latch, exiting, exits string
+		preheader                     string
+	}{
+		{"outer", "body,inner,latch,outer", "latch", "outer", "exit", "entry"},
+		// %outer also branches to %exit, so it is no preheader
+		{"inner", "body,inner", "body", "inner", "latch", ""},
+	}
+	for i, tt := range tests {
+		l := li.All[i]
+		if got := names(l.Blocks); got != tt.blocks {
+			t.Errorf("%s: blocks %s, want %s", tt.name, got, tt.blocks)
+		}
+		if l.Blocks[0] != l.Header {
+			t.Errorf("%s: blocks do not start with the header", tt.name)
+		}
+		if got := names(l.Latches()); got != tt.latch || l.Latch().Name() != tt.latch {
+			t.Errorf("%s: latches %s, want %s", tt.name, got, tt.latch)
+		}
+		if got := names(l.ExitingBlocks()); got != tt.exiting {
+			t.Errorf("%s: exiting blocks %s, want %s", tt.name, got, tt.exiting)
+		}
+		if got := names(l.ExitBlocks()); got != tt.exits {
+			t.Errorf("%s: exit blocks %s, want %s", tt.name, got, tt.exits)
+		}
+		pre := ""
+		if p := l.Preheader(); p != nil {
+			pre = p.Name()
+		}
+		if pre != tt.preheader {
+			t.Errorf("%s: preheader %q, want %q", tt.name, pre, tt.preheader)
+		}
+	}
+
+	depths := map[string]int{"entry": 0, "outer": 1, "inner": 2, "body": 2, "latch": 1, "exit": 0}
+	for name, want := range depths {
+		b := block(fn, name)
+		if got := li.Depth(b); got != want {
+			t.Errorf("%%%s has depth %d, want %d", name, got, want)
+		}
+	}
+	if li.LoopFor(block(fn, "body")) != inner || li.LoopFor(block(fn, "latch")) != outer || li.LoopFor(block(fn, "exit")) != nil {
+		t.Error("LoopFor does not return the innermost loop")
+	}
+	if !li.IsHeader(block(fn, "inner")) || li.IsHeader(block(fn, "body")) {
+		t.Error("IsHeader is wrong")
+	}
+	if !outer.Contains(block(fn, "body")) || inner.Contains(block(fn, "latch")) {
+		t.Error("Contains is wrong")
+	}
+}
+
+func TestSharedHeader(t *testing.T) {
+	// Two back edges into %h make one loop with two latches
+	fn := cfg("entry:h h:a,exit a:b,c b:h c:h exit:")
+	li := Loops(fn)
+	if len(li.All) != 1 {
+		t.Fatalf("found %d loops, want 1", len(li.All))
+	}
+	l := li.All[0]
+	if got := names(l.Blocks); got != "a,b,c,h" {
+		t.Errorf("blocks %s, want a,b,c,h", got)
+	}
+	if got := names(l.Latches()); got != "b,c" || l.Latch() != nil {
+		t.Errorf("latches %s, want b,c and no single latch", got)
+	}
+	if l.Preheader() != block(fn, "entry") {
+		t.Errorf("%%entry is not the preheader")
+	}
+}
+
+func TestSharedHeaderNested(t *testing.T) {
+	// The back edges from %b and %c enter the same header, so the cycles
+	// through them, one of which also passes %d, make one loop
+	fn := cfg("entry:h h:a,exit a:b,d b:h d:c c:h exit:")
+	li := Loops(fn)
+	if len(li.All) != 1 || li.All[0].Depth != 1 {
+		t.Fatalf("found %d loops, want 1 at depth 1", len(li.All))
+	}
+	if got := names(li.All[0].Blocks); got != "a,b,c,d,h" {
+		t.Errorf("blocks %s, want a,b,c,d,h", got)
+	}
+}
+
+func TestSelfLoop(t *testing.T) {
+	fn := cfg("entry:l l:l,exit exit:")
+	li := Loops(fn)
+	if len(li.All) != 1 {
+		t.Fatalf("found %d loops, want 1", len(li.All))
+	}
+	l, self := li.All[0], block(fn, "l")
+	if names(l.Blocks) != "l" || l.Latch() != self || l.Header != self {
+		t.Errorf("loop has blocks %s and latch %v", names(l.Blocks), l.Latch())
+	}
+}
+
+func TestIrreducibleHasNoLoop(t *testing.T) {
+	// Neither %a nor %b dominates the other, so there is no back edge
+	if li := Loops(cfg(irreducible)); len(li.All) != 0 {
+		t.Errorf("found %d loops in an irreducible CFG, want 0", len(li.All))
+	}
+}
+
+func TestGCDLoop(t *testing.T) {
+	src, err := os.ReadFile("../testdata/gcd.ll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := asm.Parse("gcd", string(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := m.GetFunction("gcd")
+	li := Loops(fn)
+	if len(li.All) != 1 {
+		t.Fatalf("found %d loops, want 1", len(li.All))
+	}
+	l := li.All[0]
+	if l.Header.Name() != "loop.head" || l.Latch().Name() != "loop.body" || l.Preheader().Name() != "entry" {
+		t.Errorf("loop has header %s, latch %s and preheader %s", l.Header.Name(), l.Latch().Name(), l.Preheader().Name())
+	}
+	if got := names(l.ExitBlocks()); got != "exit" {
+		t.Errorf("exit blocks %s, want exit", got)
+	}
+}
+
+func TestLoopsOfPostDominatorsPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic")
+		}
+	}()
+	PostDominators(cfg(nested)).Loops()
+}
@@ -0,0 +1,457 @@
+package asm
+
+import (
+	"strconv"
+
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+var binaryOps = map[string]func(*builder.Builder, ir.Value, ir.Value, string) *ir.BinaryInst{
+	"add":  (*builder.Builder).CreateAdd,
+	"sub":  (*builder.Builder).CreateSub,
+	"mul":  (*builder.Builder).CreateMul,
+	"udiv": (*builder.Builder).CreateUDiv,
+	"sdiv": (*builder.Builder).CreateSDiv,
+	"urem": (*builder.Builder).CreateURem,
+	"srem": (*builder.Builder).CreateSRem,
+	"fadd": (*builder.Builder).CreateFAdd,
+	"fsub": (*builder.Builder).CreateFSub,
+	"fmul": (*builder.Builder).CreateFMul,
+	"fdiv": (*builder.Builder).CreateFDiv,
+	"frem": (*builder.Builder).CreateFRem,
+	"shl":  (*builder.Builder).CreateShl,
+	"lshr": (*builder.Builder).CreateLShr,
+	"ashr": (*builder.Builder).CreateAShr,
+	"and":  (*builder.Builder).CreateAnd,
+	"or":   (*builder.Builder).CreateOr,
+	"xor":  (*builder.Builder).CreateXor,
+}
+
+var castOps = map[string]func(*builder.Builder, ir.Value, types.Type, string) *ir.CastInst{
+	"trunc":    (*builder.Builder).CreateTrunc,
+	"zext":     (*builder.Builder).CreateZExt,
+	"sext":     (*builder.Builder).CreateSExt,
+	"fptrunc":  (*builder.Builder).CreateFPTrunc,
+	"fpext":    (*builder.Builder).CreateFPExt,
+	"fptoui":   (*builder.Builder).CreateFPToUI,
+	"fptosi":   (*builder.Builder).CreateFPToSI,
+	"uitofp":   (*builder.Builder).CreateUIToFP,
+	"sitofp":   (*builder.Builder).CreateSIToFP,
+	"ptrtoint": (*builder.Builder).CreatePtrToInt,
+	"inttoptr": (*builder.Builder).CreateIntToPtr,
+	"bitcast":  (*builder.Builder).CreateBitCast,
+}
+
+var icmpPredicates = map[string]ir.ICmpPredicate{
+	"eq": ir.ICmpEQ, "ne": ir.ICmpNE,
+	"ugt": ir.ICmpUGT, "uge": ir.ICmpUGE, "ult": ir.ICmpULT, "ule": ir.ICmpULE,
+	"sgt": ir.ICmpSGT, "sge": ir.ICmpSGE, "slt": ir.ICmpSLT, "sle": ir.ICmpSLE,
+}
+
+var fcmpPredicates = map[string]ir.FCmpPredicate{
+	"false": ir.FCmpFalse, "oeq": ir.FCmpOEQ, "ogt": ir.FCmpOGT, "oge": ir.FCmpOGE,
+	"olt": ir.FCmpOLT, "ole": ir.FCmpOLE, "one": ir.FCmpONE, "ord": ir.FCmpORD,
+	"uno": ir.FCmpUNO, "ueq": ir.FCmpUEQ, "ugt": ir.FCmpUGT, "uge": ir.FCmpUGE,
+	"ult": ir.FCmpULT, "ule": ir.FCmpULE, "une": ir.FCmpUNE, "true": ir.FCmpTrue,
+}
+
+// setOp returns a fixup callback that patches operand idx of inst
+func setOp(inst *ir.Instruction, idx int) func(ir.Value) {
+	return func(v ir.Value) { (*inst).SetOperand(idx, v) }
+}
+
+// parseInstruction parses one instruction and inserts it into the current block
+func (p *parser) parseInstruction() {
+	var nameTok token
+	if p.peek().kind == tokLocal && p.peekAt(1).text == "=" {
+		nameTok = p.next()
+		p.next()
+		if nameTok.text == "<unnamed>" {
+			p.failAt(nameTok, "cannot define an unnamed value")
+		}
+	}
+	name := nameTok.text
+
+	opTok := p.expectKind(tokWord, "instruction")
+	var inst ir.Instruction
+	switch op := opTok.text; op {
+	case "ret":
+		inst = p.parseRet()
+	case "br":
+		inst = p.parseBr()
+	case "switch":
+		inst = p.parseSwitch()
+	case "unreachable":
+		inst = p.b.CreateUnreachable()
+	case "alloca":
+		inst = p.parseAlloca(name)
+	case "load":
+		inst = p.parseLoad(name)
+	case "store":
+		inst = p.parseStore()
+	case "getelementptr":
+		inst = p.parseGEP(name)
+	case "icmp":
+		inst = p.parseICmp(name)
+	case "fcmp":
+		inst = p.parseFCmp(name)
+	case "phi":
+		inst = p.parsePhi(name)
+	case "select":
+		inst = p.parseSelect(name)
+	case "call", "tail":
+		tail := op == "tail"
+		if tail {
+			p.expect("call")
+		}
+		call := p.parseCall(name)
+		call.IsTailCall = tail
+		inst = call
+	case "syscall":
+		inst = p.parseSyscall(name)
+	case "extractvalue":
+		inst = p.parseExtractValue(name)
+	case "insertvalue":
+		inst = p.parseInsertValue(name)
+	case "va_start":
+		var vaStart ir.Instruction
+		vaList := p.parseTypedValue(setOp(&vaStart, 0))
+		vaStart = p.b.CreateVaStart(vaList)
+		inst = vaStart
+	case "va_arg":
+		var vaArg ir.Instruction
+		vaList := p.parseTypedValue(setOp(&vaArg, 0))
+		p.expect(",")
+		vaArg = p.b.CreateVaArg(vaList, p.parseType(), name)
+		inst = vaArg
+	case "va_end":
+		var vaEnd ir.Instruction
+		vaList := p.parseTypedValue(setOp(&vaEnd, 0))
+		vaEnd = p.b.CreateVaEnd(vaList)
+		inst = vaEnd
+	default:
+		if create, ok := binaryOps[op]; ok {
+			inst = p.parseBinary(create, name)
+		} else if create, ok := castOps[op]; ok {
+			var cast ir.Instruction
+			src := p.parseTypedValue(setOp(&cast, 0))
+			p.expect("to")
+			cast = create(p.b, src, p.parseType(), name)
+			inst = cast
+		} else {
+			p.failAt(opTok, "unknown instruction %q", op)
+		}
+	}
+
+	producesValue := inst.Type() != nil && inst.Type().Kind() != types.VoidKind
+	switch {
+	case nameTok.kind == tokLocal && !producesValue:
+		p.failAt(nameTok, "%s does not produce a value", opTok.text)
+	case nameTok.kind == tokLocal:
+		p.define(nameTok, inst)
+	case producesValue:
+		p.failAt(opTok, "result of %s must be named", opTok.text)
+	}
+}
+
+func (p *parser) parseRet() ir.Instruction {
+	if p.accept("void") {
+		return p.b.CreateRetVoid()
+	}
+	var ret ir.Instruction
+	v := p.parseTypedValue(setOp(&ret, 0))
+	ret = p.b.CreateRet(v)
+	return ret
+}
+
+func (p *parser) parseBr() ir.Instruction {
+	if p.is("label") {
+		return p.b.CreateBr(p.parseLabel())
+	}
+	var br *ir.CondBrInst
+	p.expect("i1")
+	cond := p.parseValue(types.I1, func(v ir.Value) { br.SetOperand(0, v) })
+	p.expect(",")
+	trueBlock := p.parseLabel()
+	p.expect(",")
+	falseBlock := p.parseLabel()
+	br = p.b.CreateCondBr(cond, trueBlock, falseBlock)
+	return br
+}
+
+func (p *parser) parseSwitch() ir.Instruction {
+	var sw *ir.SwitchInst
+	typ := p.parseType()
+	cond := p.parseValue(typ, func(v ir.Value) { sw.SetOperand(0, v) })
+	p.expect(",")
+	defaultBlock := p.parseLabel()
+	p.expect("[")
+	type switchCase struct {
+		val   *ir.ConstantInt
+		block *ir.BasicBlock
+	}
+	var cases []switchCase
+	for !p.accept("]") {
+		caseTok := p.peek()
+		val, ok := p.parseConstant(p.parseType()).(*ir.ConstantInt)
+		if !ok {
+			p.failAt(caseTok, "switch case must be an integer constant")
+		}
+		p.expect(",")
+		cases = append(cases, switchCase{val, p.parseLabel()})
+	}
+	sw = p.b.CreateSwitch(cond, defaultBlock, len(cases))
+	for _, c := range cases {
+		p.b.AddCase(sw, c.val, c.block)
+	}
+	return sw
+}
+
+func (p *parser) parseBinary(create func(*builder.Builder, ir.Value, ir.Value, string) *ir.BinaryInst, name string) ir.Instruction {
+	var nuw, nsw, exact bool
+	for {
+		switch {
+		case p.accept("nuw"):
+			nuw = true
+		case p.accept("nsw"):
+			nsw = true
+		case p.accept("exact"):
+			exact = true
+		default:
+			var inst ir.Instruction
+			typ := p.parseType()
+			lhs := p.parseValue(typ, setOp(&inst, 0))
+			p.expect(",")
+			rhs := p.parseValue(typ, setOp(&inst, 1))
+			bin := create(p.b, lhs, rhs, name)
+			bin.NoUnsignedWrap = nuw
+			bin.NoSignedWrap = nsw
+			bin.Exact = exact
+			inst = bin
+			return inst
+		}
+	}
+}
+
+// parseAlign parses an optional trailing ", align N"
+func (p *parser) parseAlign() int {
+	if p.is(",") && p.peekAt(1).text == "align" {
+		p.next()
+		p.next()
+		return int(p.parseInt())
+	}
+	return 0
+}
+
+func (p *parser) parseAlloca(name string) ir.Instruction {
+	typ := p.parseType()
+	var alloca *ir.AllocaInst
+	if p.is(",") && p.peekAt(1).text != "align" {
+		p.next()
+		count := p.parseTypedValue(func(v ir.Value) { alloca.SetOperand(0, v) })
+		alloca = p.b.CreateAllocaWithCount(typ, count, name)
+	} else {
+		alloca = p.b.CreateAlloca(typ, name)
+	}
+	alloca.Alignment = p.parseAlign()
+	return alloca
+}
+
+func (p *parser) parseLoad(name string) ir.Instruction {
+	volatile := p.accept("volatile")
+	typ := p.parseType()
+	p.expect(",")
+	var inst ir.Instruction
+	ptr := p.parseTypedValue(setOp(&inst, 0))
+	load := p.b.CreateLoad(typ, ptr, name)
+	load.Volatile = volatile
+	load.Alignment = p.parseAlign()
+	inst = load
+	return inst
+}
+
+func (p *parser) parseStore() ir.Instruction {
+	volatile := p.accept("volatile")
+	var inst ir.Instruction
+	val := p.parseTypedValue(setOp(&inst, 0))
+	p.expect(",")
+	ptr := p.parseTypedValue(setOp(&inst, 1))
+	store := p.b.CreateStore(val, ptr)
+	store.Volatile = volatile
+	store.Alignment = p.parseAlign()
+	inst = store
+	return inst
+}
+
+func (p *parser) parseGEP(name string) ir.Instruction {
+	inBounds := p.accept("inbounds")
+	start := p.peek()
+	elemType := p.parseType()
+	p.expect(",")
+	var inst ir.Instruction
+	ptr := p.parseTypedValue(setOp(&inst, 0))
+	var indices []ir.Value
+	// The printer leaves a trailing comma when there are no indices
+	for p.is(",") {
+		p.next()
+		if !p.sameLine() {
+			break
+		}
+		indices = append(indices, p.parseTypedValue(setOp(&inst, len(indices)+1)))
+	}
+	if _, err := ir.GEPIndexedType(elemType, indices); err != nil {
+		p.failAt(start, "%v", err)
+	}
+	gep := p.b.CreateGEP(elemType, ptr, indices, name)
+	gep.InBounds = inBounds
+	inst = gep
+	return inst
+}
+
+func (p *parser) parseICmp(name string) ir.Instruction {
+	predTok := p.expectKind(tokWord, "icmp predicate")
+	pred, ok := icmpPredicates[predTok.text]
+	if !ok {
+		p.failAt(predTok, "unknown icmp predicate %q", predTok.text)
+	}
+	var inst ir.Instruction
+	typ := p.parseType()
+	lhs := p.parseValue(typ, setOp(&inst, 0))
+	p.expect(",")
+	rhs := p.parseValue(typ, setOp(&inst, 1))
+	inst = p.b.CreateICmp(pred, lhs, rhs, name)
+	return inst
+}
+
+func (p *parser) parseFCmp(name string) ir.Instruction {
+	predTok := p.expectKind(tokWord, "fcmp predicate")
+	pred, ok := fcmpPredicates[predTok.text]
+	if !ok {
+		p.failAt(predTok, "unknown fcmp predicate %q", predTok.text)
+	}
+	var inst ir.Instruction
+	typ := p.parseType()
+	lhs := p.parseValue(typ, setOp(&inst, 0))
+	p.expect(",")
+	rhs := p.parseValue(typ, setOp(&inst, 1))
+	inst = p.b.CreateFCmp(pred, lhs, rhs, name)
+	return inst
+}
+
+func (p *parser) parsePhi(name string) ir.Instruction {
+	typ := p.parseType()
+	phi := p.b.CreatePhi(typ, name)
+	for {
+		p.expect("[")
+		idx := len(phi.Incoming)
+		val := p.parseValue(typ, func(v ir.Value) { phi.SetOperand(idx, v) })
+		p.expect(",")
+		block := p.block(p.expectKind(tokLocal, "block label").text)
+		p.expect("]")
+		phi.AddIncoming(val, block)
+		if !p.accept(",") {
+			return phi
+		}
+	}
+}
+
+func (p *parser) parseSelect(name string) ir.Instruction {
+	var inst ir.Instruction
+	p.expect("i1")
+	cond := p.parseValue(types.I1, setOp(&inst, 0))
+	p.expect(",")
+	trueVal := p.parseTypedValue(setOp(&inst, 1))
+	p.expect(",")
+	falseVal := p.parseTypedValue(setOp(&inst, 2))
+	inst = p.b.CreateSelect(cond, trueVal, falseVal, name)
+	return inst
+}
+
+// parseArgs parses a parenthesized list of typed values
+func (p *parser) parseArgs(inst *ir.Instruction) []ir.Value {
+	p.expect("(")
+	var args []ir.Value
+	for !p.is(")") {
+		args = append(args, p.parseTypedValue(setOp(inst, len(args))))
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(")")
+	return args
+}
+
+func (p *parser) parseCall(name string) *ir.CallInst {
+	retType := p.parseType()
+	calleeTok := p.expectKind(tokGlobal, "callee")
+	var inst ir.Instruction
+	args := p.parseArgs(&inst)
+	var call *ir.CallInst
+	if fn := p.mod.GetFunction(calleeTok.text); fn != nil {
+		if !fn.FuncType.ReturnType.Equal(retType) {
+			p.failAt(calleeTok, "@%s returns %s, not %s", fn.Name(), fn.FuncType.ReturnType, retType)
+		}
+		call = p.b.CreateCall(fn, args, name)
+	} else {
+		call = p.b.CreateCallByName(calleeTok.text, retType, args, name)
+	}
+	inst = call
+	return call
+}
+
+func (p *parser) parseSyscall(name string) ir.Instruction {
+	var inst ir.Instruction
+	var args []ir.Value
+	for p.sameLine() {
+		args = append(args, p.parseTypedValue(setOp(&inst, len(args))))
+		if !p.accept(",") {
+			break
+		}
+	}
+	syscall := p.b.CreateSyscall(args)
+	syscall.SetName(name)
+	inst = syscall
+	return inst
+}
+
+// parseIndices parses the constant index list of extractvalue/insertvalue
+func (p *parser) parseIndices() []int {
+	var indices []int
+	for p.accept(",") {
+		t := p.expectKind(tokWord, "index")
+		idx, err := strconv.Atoi(t.text)
+		if err != nil || idx < 0 {
+			p.failAt(t, "invalid aggregate index %q", t.text)
+		}
+		indices = append(indices, idx)
+	}
+	return indices
+}
+
+func (p *parser) parseExtractValue(name string) ir.Instruction {
+	var inst ir.Instruction
+	start := p.peek()
+	agg := p.parseTypedValue(setOp(&inst, 0))
+	indices := p.parseIndices()
+	if _, err := ir.AggregateIndexedType(agg.Type(), indices); err != nil {
+		p.failAt(start, "%v", err)
+	}
+	inst = p.b.CreateExtractValue(agg, indices, name)
+	return inst
+}
+
+func (p *parser) parseInsertValue(name string) ir.Instruction {
+	var inst ir.Instruction
+	start := p.peek()
+	agg := p.parseTypedValue(setOp(&inst, 0))
+	p.expect(",")
+	val := p.parseTypedValue(setOp(&inst, 1))
+	indices := p.parseIndices()
+	if _, err := ir.AggregateIndexedType(agg.Type(), indices); err != nil {
+		p.failAt(start, "%v", err)
+	}
+	inst = p.b.CreateInsertValue(agg, val, indices, name)
+	return inst
+}
@@ -0,0 +1,134 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind classifies lexical tokens
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokWord             // keywords, type names, numbers and block labels
+	tokLocal            // %name
+	tokGlobal           // @name
+	tokString           // "text"
+	tokPunct            // = , ( ) [ ] { } < > : ... ->
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+	col  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokLocal:
+		return "%" + t.text
+	case tokGlobal:
+		return "@" + t.text
+	case tokString:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '$'
+}
+
+func isNameChar(c byte) bool {
+	return isWordChar(c) || c == '-'
+}
+
+// lex splits the source into tokens. Comments run from ';' to end of line.
+func lex(src string) ([]token, error) {
+	var toks []token
+	line, lineStart := 1, 0
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		col := i - lineStart + 1
+		switch {
+		case c == '\n':
+			line++
+			i++
+			lineStart = i
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ';':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '%' || c == '@':
+			kind := tokLocal
+			if c == '@' {
+				kind = tokGlobal
+			}
+			i++
+			if strings.HasPrefix(src[i:], "<unnamed>") {
+				toks = append(toks, token{kind, "<unnamed>", line, col})
+				i += len("<unnamed>")
+				continue
+			}
+			start := i
+			for i < len(src) && isNameChar(src[i]) {
+				i++
+			}
+			if i == start {
+				return nil, &Error{Line: line, Col: col, Msg: fmt.Sprintf("expected name after '%c'", c)}
+			}
+			toks = append(toks, token{kind, src[start:i], line, col})
+		case c == '"':
+			end := strings.IndexAny(src[i+1:], "\"\n")
+			if end < 0 || src[i+1+end] != '"' {
+				return nil, &Error{Line: line, Col: col, Msg: "unterminated string"}
+			}
+			toks = append(toks, token{tokString, src[i+1 : i+1+end], line, col})
+			i += end + 2
+		case strings.HasPrefix(src[i:], "..."):
+			toks = append(toks, token{tokPunct, "...", line, col})
+			i += 3
+		case strings.HasPrefix(src[i:], "->"):
+			toks = append(toks, token{tokPunct, "->", line, col})
+			i += 2
+		case (c == '-' || c == '+') && i+1 < len(src) && isWordChar(src[i+1]), isWordChar(c):
+			start := i
+			i++
+			for i < len(src) {
+				if isWordChar(src[i]) {
+					i++
+					continue
+				}
+				// Exponent signs inside numeric literals such as 1e+20
+				if (src[i] == '+' || src[i] == '-') && (src[i-1] == 'e' || src[i-1] == 'E') &&
+					isNumberStart(src[start:i]) {
+					i++
+					continue
+				}
+				break
+			}
+			toks = append(toks, token{tokWord, src[start:i], line, col})
+		case strings.IndexByte("=,()[]{}<>:", c) >= 0:
+			toks = append(toks, token{tokPunct, string(c), line, col})
+			i++
+		default:
+			return nil, &Error{Line: line, Col: col, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	toks = append(toks, token{tokEOF, "", line, i - lineStart + 1})
+	return toks, nil
+}
+
+func isNumberStart(s string) bool {
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
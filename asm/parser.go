@@ -0,0 +1,716 @@
+// Package asm reads the textual IR produced by Module.String back into an
+// in-memory module. Instructions are recreated through the builder package,
+// so parsed IR carries exactly the types and CFG edges the builder would
+// have produced for the same calls.
+package asm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Error is a syntax or semantic error with its source position
+type Error struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Parse parses a textual module. The module name is not part of the
+// textual form and is supplied by the caller.
+func Parse(name, src string) (*ir.Module, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, b: builder.New()}
+	p.mod = p.b.CreateModule(name)
+	if err := p.parseModule(); err != nil {
+		return nil, err
+	}
+	return p.mod, nil
+}
+
+// ParseReader parses a textual module read from r
+func ParseReader(name string, r io.Reader) (*ir.Module, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(name, string(src))
+}
+
+type parser struct {
+	toks []token
+	pos  int
+
+	b   *builder.Builder
+	mod *ir.Module
+
+	// Function bodies are parsed after every header so calls can refer to
+	// functions defined later in the module
+	bodies []pendingBody
+
+	// Per-function state
+	fn      *ir.Function
+	locals  map[string]ir.Value
+	blocks  map[string]*ir.BasicBlock
+	defined map[*ir.BasicBlock]bool
+	fixups  []fixup
+}
+
+type pendingBody struct {
+	fn  *ir.Function
+	pos int
+}
+
+// fixup patches a forward reference once the whole function has been read
+type fixup struct {
+	tok token
+	set func(ir.Value)
+}
+
+// bail aborts parsing; it is recovered in parseModule
+type bail struct{ err *Error }
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+func (p *parser) peekAt(n int) token {
+	if p.pos+n < len(p.toks) {
+		return p.toks[p.pos+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) failAt(t token, format string, args ...interface{}) {
+	panic(bail{&Error{Line: t.line, Col: t.col, Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	p.failAt(p.peek(), format, args...)
+}
+
+// is reports whether the next token is the given punctuation or keyword
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokPunct || t.kind == tokWord) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) {
+	if !p.accept(text) {
+		p.fail("expected %q, found %s", text, p.peek())
+	}
+}
+
+func (p *parser) expectKind(kind tokenKind, what string) token {
+	t := p.peek()
+	if t.kind != kind {
+		p.fail("expected %s, found %s", what, t)
+	}
+	return p.next()
+}
+
+// sameLine reports whether the next token continues the line of the
+// previous one, which is how optional trailing parts are detected
+func (p *parser) sameLine() bool {
+	t := p.peek()
+	return t.kind != tokEOF && p.pos > 0 && p.toks[p.pos-1].line == t.line
+}
+
+func (p *parser) parseInt() int64 {
+	t := p.expectKind(tokWord, "integer")
+	v, err := strconv.ParseInt(t.text, 10, 64)
+	if err != nil {
+		// Unsigned 64-bit constants are stored by bit pattern
+		u, uerr := strconv.ParseUint(t.text, 10, 64)
+		if uerr != nil {
+			p.failAt(t, "invalid integer %q", t.text)
+		}
+		v = int64(u)
+	}
+	return v
+}
+
+// ============================================================================
+// Module level
+// ============================================================================
+
+func (p *parser) parseModule() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bail)
+			if !ok {
+				panic(r)
+			}
+			err = b.err
+		}
+	}()
+
+	for p.peek().kind != tokEOF {
+		t := p.peek()
+		switch {
+		case t.kind == tokWord && t.text == "target":
+			p.parseTarget()
+		case t.kind == tokLocal:
+			p.parseTypeDef()
+		case t.kind == tokGlobal:
+			p.parseGlobal()
+		case t.kind == tokWord && (t.text == "define" || t.text == "declare"):
+			p.parseFunctionHeader()
+		default:
+			p.fail("unexpected %s at top level", t)
+		}
+	}
+	for _, body := range p.bodies {
+		p.pos = body.pos
+		p.parseBody(body.fn)
+	}
+	for name, st := range p.mod.Types {
+		if st.Fields == nil {
+			return &Error{Line: 1, Col: 1, Msg: fmt.Sprintf("named type %%%s is used but never defined", name)}
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseTarget() {
+	p.expect("target")
+	kind := p.expectKind(tokWord, "datalayout or triple")
+	p.expect("=")
+	val := p.expectKind(tokString, "string").text
+	switch kind.text {
+	case "datalayout":
+		p.mod.DataLayout = val
+	case "triple":
+		p.mod.TargetTriple = val
+	default:
+		p.failAt(kind, "unknown target property %q", kind.text)
+	}
+}
+
+// parseTypeDef parses "%Name = type { ... }"
+func (p *parser) parseTypeDef() {
+	nameTok := p.next()
+	p.expect("=")
+	p.expect("type")
+	st := p.namedType(nameTok.text)
+	if st.Fields != nil {
+		p.failAt(nameTok, "type %%%s is defined twice", nameTok.text)
+	}
+	body, ok := p.parseType().(*types.StructType)
+	if !ok || body.Name != "" {
+		p.failAt(nameTok, "type %%%s must be defined as a struct body", nameTok.text)
+	}
+	st.Fields = body.Fields
+	if st.Fields == nil {
+		st.Fields = []types.Type{}
+	}
+	st.Packed = body.Packed
+}
+
+// namedType returns the named struct, creating an empty placeholder for
+// forward references. A nil Fields slice marks it as not yet defined.
+func (p *parser) namedType(name string) *types.StructType {
+	if st, ok := p.mod.Types[name]; ok {
+		return st
+	}
+	st := types.NewStruct(name, nil, false)
+	p.mod.Types[name] = st
+	return st
+}
+
+func (p *parser) parseLinkage() ir.Linkage {
+	linkages := map[string]ir.Linkage{
+		"external":     ir.ExternalLinkage,
+		"internal":     ir.InternalLinkage,
+		"private":      ir.PrivateLinkage,
+		"linkonce_odr": ir.LinkOnceODRLinkage,
+		"weak_odr":     ir.WeakODRLinkage,
+		"common":       ir.CommonLinkage,
+	}
+	if t := p.peek(); t.kind == tokWord {
+		if l, ok := linkages[t.text]; ok {
+			p.next()
+			return l
+		}
+	}
+	return ir.ExternalLinkage
+}
+
+// parseGlobal parses "@name = linkage (global|constant) <init or type>"
+func (p *parser) parseGlobal() {
+	nameTok := p.next()
+	if p.mod.GetGlobal(nameTok.text) != nil {
+		p.failAt(nameTok, "global @%s is defined twice", nameTok.text)
+	}
+	p.expect("=")
+	g := &ir.Global{Linkage: p.parseLinkage()}
+	g.SetName(nameTok.text)
+	switch {
+	case p.accept("constant"):
+		g.IsConstant = true
+	case p.accept("global"):
+	default:
+		p.fail("expected global or constant, found %s", p.peek())
+	}
+	typ := p.parseType()
+	if p.sameLine() {
+		g.Initializer = p.parseConstant(typ)
+	}
+	g.SetType(types.NewPointer(typ))
+	p.mod.AddGlobal(g)
+}
+
+// ============================================================================
+// Types
+// ============================================================================
+
+var commonTypes = map[string]types.Type{
+	"void": types.Void, "label": types.Label,
+	"i1": types.I1, "i8": types.I8, "i16": types.I16, "i32": types.I32, "i64": types.I64, "i128": types.I128,
+	"u8": types.U8, "u16": types.U16, "u32": types.U32, "u64": types.U64,
+	"f16": types.F16, "f32": types.F32, "f64": types.F64, "f128": types.F128,
+}
+
+// isTypeStart reports whether the next token can begin a type
+func (p *parser) isTypeStart() bool {
+	t := p.peek()
+	switch t.kind {
+	case tokLocal:
+		return p.peekAt(1).text != "="
+	case tokPunct:
+		return t.text == "[" || t.text == "{" || t.text == "<"
+	case tokWord:
+		if _, ok := commonTypes[t.text]; ok {
+			return true
+		}
+		if t.text == "ptr" || t.text == "fn" {
+			return true
+		}
+		_, ok := bitWidth(t.text)
+		return ok
+	}
+	return false
+}
+
+// bitWidth decodes integer and float type names such as i7, u24 or f80
+func bitWidth(name string) (int, bool) {
+	if len(name) < 2 || strings.IndexByte("iuf", name[0]) < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[1:])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func (p *parser) parseType() types.Type {
+	t := p.next()
+	switch t.kind {
+	case tokLocal:
+		return p.namedType(t.text)
+	case tokWord:
+		if typ, ok := commonTypes[t.text]; ok {
+			return typ
+		}
+		switch t.text {
+		case "ptr":
+			p.expect("<")
+			elem := p.parseType()
+			space := 0
+			if p.accept(",") {
+				space = int(p.parseInt())
+			}
+			p.expect(">")
+			return types.NewPointerWithAddressSpace(elem, space)
+		case "fn":
+			return p.parseFunctionType()
+		}
+		if n, ok := bitWidth(t.text); ok {
+			switch t.text[0] {
+			case 'i':
+				return types.NewInt(n, true)
+			case 'u':
+				return types.NewInt(n, false)
+			default:
+				return types.NewFloat(n)
+			}
+		}
+	case tokPunct:
+		switch t.text {
+		case "[":
+			length := p.parseInt()
+			p.expect("x")
+			elem := p.parseType()
+			p.expect("]")
+			return types.NewArray(elem, length)
+		case "{":
+			return types.NewStruct("", p.parseFieldList("}"), false)
+		case "<":
+			if p.accept("{") {
+				fields := p.parseFieldList("}")
+				p.expect(">")
+				return types.NewStruct("", fields, true)
+			}
+			scalable := p.accept("vscale")
+			if scalable {
+				p.expect("x")
+			}
+			length := int(p.parseInt())
+			p.expect("x")
+			elem := p.parseType()
+			p.expect(">")
+			if scalable {
+				return types.NewScalableVector(elem, length)
+			}
+			return types.NewVector(elem, length)
+		}
+	}
+	p.failAt(t, "expected type, found %s", t)
+	return nil
+}
+
+func (p *parser) parseFieldList(closing string) []types.Type {
+	fields := []types.Type{}
+	if p.accept(closing) {
+		return fields
+	}
+	for {
+		fields = append(fields, p.parseType())
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(closing)
+	return fields
+}
+
+// parseFunctionType parses the remainder of "fn(T, ...) -> R"
+func (p *parser) parseFunctionType() types.Type {
+	p.expect("(")
+	var params []types.Type
+	variadic := false
+	for !p.is(")") {
+		if p.accept("...") {
+			variadic = true
+			break
+		}
+		params = append(params, p.parseType())
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(")")
+	p.expect("->")
+	return types.NewFunction(p.parseType(), params, variadic)
+}
+
+// ============================================================================
+// Constants
+// ============================================================================
+
+// parseConstant parses a constant of a known type, as printed after the type
+func (p *parser) parseConstant(typ types.Type) ir.Constant {
+	t := p.peek()
+	switch {
+	case p.accept("null"):
+		ptrTy, ok := typ.(*types.PointerType)
+		if !ok {
+			p.failAt(t, "null constant of non-pointer type %s", typ)
+		}
+		return p.b.ConstNull(ptrTy)
+	case p.accept("undef"):
+		return p.b.ConstUndef(typ)
+	case p.accept("zeroinitializer"):
+		return p.b.ConstZero(typ)
+	case p.accept("["):
+		arrTy, ok := typ.(*types.ArrayType)
+		if !ok {
+			p.failAt(t, "array constant of non-array type %s", typ)
+		}
+		c := &ir.ConstantArray{Elements: p.parseConstantList("]")}
+		c.SetType(arrTy)
+		return c
+	case p.accept("{"):
+		if _, ok := typ.(*types.StructType); !ok {
+			p.failAt(t, "struct constant of non-struct type %s", typ)
+		}
+		c := &ir.ConstantStruct{Fields: p.parseConstantList("}")}
+		c.SetType(typ)
+		return c
+	case t.kind == tokWord:
+		return p.parseScalar(typ)
+	}
+	p.fail("expected constant, found %s", t)
+	return nil
+}
+
+func (p *parser) parseConstantList(closing string) []ir.Constant {
+	var elems []ir.Constant
+	if p.accept(closing) {
+		return elems
+	}
+	for {
+		elems = append(elems, p.parseConstant(p.parseType()))
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(closing)
+	return elems
+}
+
+// parseScalar parses a bare numeric literal whose type comes from context
+func (p *parser) parseScalar(typ types.Type) ir.Constant {
+	t := p.peek()
+	switch ty := typ.(type) {
+	case *types.IntType:
+		return p.b.ConstInt(ty, p.parseInt())
+	case *types.FloatType:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.failAt(t, "invalid floating point literal %q", t.text)
+		}
+		return p.b.ConstFloat(ty, v)
+	}
+	p.failAt(t, "numeric literal of type %s", typ)
+	return nil
+}
+
+// ============================================================================
+// Functions
+// ============================================================================
+
+var attributes = map[string]ir.FuncAttribute{
+	"noreturn":     ir.AttrNoReturn,
+	"nounwind":     ir.AttrNoUnwind,
+	"readonly":     ir.AttrReadOnly,
+	"readnone":     ir.AttrReadNone,
+	"alwaysinline": ir.AttrAlwaysInline,
+	"noinline":     ir.AttrNoInline,
+}
+
+func (p *parser) parseFunctionHeader() {
+	isDefine := p.next().text == "define"
+	linkage := p.parseLinkage()
+	retType := p.parseType()
+	nameTok := p.expectKind(tokGlobal, "function name")
+	if p.mod.GetFunction(nameTok.text) != nil {
+		p.failAt(nameTok, "function @%s is defined twice", nameTok.text)
+	}
+
+	p.expect("(")
+	var params []types.Type
+	var names []string
+	variadic := false
+	for !p.is(")") {
+		if p.accept("...") {
+			variadic = true
+			break
+		}
+		params = append(params, p.parseType())
+		name := ""
+		if p.peek().kind == tokLocal {
+			name = p.next().text
+		}
+		names = append(names, name)
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(")")
+
+	var fn *ir.Function
+	if isDefine {
+		fn = p.b.CreateFunction(nameTok.text, retType, params, variadic)
+	} else {
+		fn = p.b.DeclareFunction(nameTok.text, retType, params, variadic)
+	}
+	fn.Linkage = linkage
+	for i, name := range names {
+		fn.Arguments[i].SetName(name)
+	}
+	for p.peek().kind == tokWord {
+		attr, ok := attributes[p.peek().text]
+		if !ok {
+			break
+		}
+		p.next()
+		fn.Attributes = append(fn.Attributes, attr)
+	}
+
+	if !isDefine {
+		return
+	}
+	open := p.peek()
+	p.expect("{")
+	p.bodies = append(p.bodies, pendingBody{fn: fn, pos: p.pos})
+	// Skip the body for now, balancing braces from struct types
+	for depth := 1; depth > 0; {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			p.failAt(open, "unterminated body of @%s", fn.Name())
+		case t.kind == tokPunct && t.text == "{":
+			depth++
+		case t.kind == tokPunct && t.text == "}":
+			depth--
+		}
+	}
+}
+
+func (p *parser) parseBody(fn *ir.Function) {
+	p.fn = fn
+	p.locals = make(map[string]ir.Value)
+	p.blocks = make(map[string]*ir.BasicBlock)
+	p.defined = make(map[*ir.BasicBlock]bool)
+	p.fixups = nil
+
+	for _, arg := range fn.Arguments {
+		if arg.Name() != "" {
+			p.locals[arg.Name()] = arg
+		} else {
+			p.locals[strconv.Itoa(arg.Index)] = arg
+		}
+	}
+
+	for !p.accept("}") {
+		t := p.peek()
+		if t.kind == tokWord && p.peekAt(1).text == ":" && p.peekAt(1).kind == tokPunct {
+			p.next()
+			p.next()
+			block := p.block(t.text)
+			if p.defined[block] {
+				p.failAt(t, "block %%%s is defined twice", t.text)
+			}
+			p.defined[block] = true
+			fn.AddBlock(block)
+			p.b.SetInsertPoint(block)
+			continue
+		}
+		if p.b.CurrentBlock() == nil || p.b.CurrentFunction() != fn {
+			p.failAt(t, "instruction outside of a block")
+		}
+		p.parseInstruction()
+	}
+
+	for name, block := range p.blocks {
+		if !p.defined[block] {
+			p.fail("block %%%s in @%s is used but never defined", name, fn.Name())
+		}
+	}
+	for _, f := range p.fixups {
+		v := p.lookup(f.tok.text)
+		if v == nil {
+			p.failAt(f.tok, "use of undefined value %%%s", f.tok.text)
+		}
+		f.set(v)
+	}
+}
+
+// block returns the block with the given name, creating it on first use
+func (p *parser) block(name string) *ir.BasicBlock {
+	if b, ok := p.blocks[name]; ok {
+		return b
+	}
+	b := ir.NewBasicBlock(name)
+	p.blocks[name] = b
+	return b
+}
+
+func (p *parser) parseLabel() *ir.BasicBlock {
+	p.expect("label")
+	return p.block(p.expectKind(tokLocal, "block label").text)
+}
+
+// lookup resolves a %name operand: function locals first, then globals
+// and functions, which the printer also writes with a '%' sigil
+func (p *parser) lookup(name string) ir.Value {
+	if v, ok := p.locals[name]; ok {
+		return v
+	}
+	if g := p.mod.GetGlobal(name); g != nil {
+		return g
+	}
+	if f := p.mod.GetFunction(name); f != nil {
+		return f
+	}
+	return nil
+}
+
+// define records the value produced by an instruction. Unnamed arguments
+// are printed by index and may be shadowed by an instruction of that name.
+func (p *parser) define(t token, v ir.Value) {
+	if old, ok := p.locals[t.text]; ok {
+		if _, isArg := old.(*ir.Argument); !isArg || old.Name() != "" {
+			p.failAt(t, "value %%%s is defined twice", t.text)
+		}
+	}
+	p.locals[t.text] = v
+}
+
+// parseValue parses an operand printed without its type. The type is only
+// used for literals; named values keep their own type, since checking
+// types is the verifier's job. Forward references return a placeholder
+// which set replaces once the function has been read.
+func (p *parser) parseValue(typ types.Type, set func(ir.Value)) ir.Value {
+	t := p.peek()
+	switch {
+	case t.kind == tokLocal:
+		p.next()
+		if t.text == "<unnamed>" {
+			p.failAt(t, "reference to an unnamed value")
+		}
+		if v, ok := p.locals[t.text]; ok {
+			return v
+		}
+		p.fixups = append(p.fixups, fixup{tok: t, set: set})
+		return p.b.ConstUndef(typ)
+	case p.accept("null"):
+		ptrTy, ok := typ.(*types.PointerType)
+		if !ok {
+			p.failAt(t, "null constant of non-pointer type %s", typ)
+		}
+		return p.b.ConstNull(ptrTy)
+	case p.accept("undef"):
+		return p.b.ConstUndef(typ)
+	case p.accept("void"):
+		p.failAt(t, "void operand")
+	case t.kind == tokWord:
+		return p.parseScalar(typ)
+	}
+	p.failAt(t, "expected value, found %s", t)
+	return nil
+}
+
+// parseTypedValue parses "T value"
+func (p *parser) parseTypedValue(set func(ir.Value)) ir.Value {
+	return p.parseValue(p.parseType(), set)
+}
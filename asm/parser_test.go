@@ -0,0 +1,130 @@
+package asm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// The files in ../testdata hold the Module.String output of the example
+// modules
+func TestRoundTripExamples(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.ll")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no example modules: %v", err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Parse(filepath.Base(file), string(src))
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if got := m.String(); got != string(src) {
+			t.Errorf("%s: printed module differs:\n%s\nwant:\n%s", file, got, src)
+		}
+	}
+}
+
+// buildMixed returns a module using instructions the examples do not
+func buildMixed() *ir.Module {
+	b := builder.New()
+	m := b.CreateModule("mixed")
+	pair := types.NewStruct("pair", []types.Type{types.I64, types.F64}, false)
+	m.Types["pair"] = pair
+	b.DeclareFunction("printf", types.I32, []types.Type{types.NewPointer(types.I8)}, true)
+
+	fn := b.CreateFunction("mixed", types.F64, []types.Type{types.I32, types.F64}, false)
+	x, f := fn.Arguments[0], fn.Arguments[1]
+	x.SetName("x")
+	f.SetName("f")
+	entry := b.CreateBlock("entry")
+	dead := b.CreateBlock("dead")
+	b.SetInsertPoint(entry)
+	wide := b.CreateSExt(x, types.I64, "wide")
+	conv := b.CreateSIToFP(x, types.F64, "conv")
+	sum := b.CreateFAdd(conv, f, "sum")
+	lt := b.CreateFCmp(ir.FCmpOLT, sum, f, "lt")
+	sel := b.CreateSelect(lt, sum, f, "sel")
+	slot := b.CreateAlloca(pair, "slot")
+	agg := b.CreateLoad(pair, slot, "agg")
+	ins := b.CreateInsertValue(agg, wide, []int{0}, "ins")
+	ext := b.CreateExtractValue(ins, []int{1}, "ext")
+	b.CreateStore(ins, slot)
+	res := b.CreateFMul(sel, ext, "res")
+	b.CreateRet(res)
+	b.SetInsertPoint(dead)
+	b.CreateUnreachable()
+	return m
+}
+
+func TestRoundTripMixed(t *testing.T) {
+	src := buildMixed().String()
+	m, err := Parse("mixed", src)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	if got := m.String(); got != src {
+		t.Errorf("printed module differs:\n%s\nwant:\n%s", got, src)
+	}
+}
+
+func TestParsedCFG(t *testing.T) {
+	src, err := os.ReadFile("../testdata/gcd.ll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse("gcd", string(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := m.GetFunction("gcd")
+	head := fn.Blocks[1]
+	if len(head.Predecessors) != 2 || head.Predecessors[0] != fn.Blocks[0] || head.Predecessors[1] != fn.Blocks[2] {
+		t.Errorf("loop.head has predecessors %v", head.Predecessors)
+	}
+	// %curr_a refers to %curr_b, which is defined after it
+	phi := head.Instructions[0].(*ir.PhiInst)
+	if phi.Incoming[1].Value != head.Instructions[1] {
+		t.Errorf("forward reference resolved to %v", phi.Incoming[1].Value)
+	}
+	if head.Instructions[1].NumUses() != 3 {
+		t.Errorf("%%curr_b has %d uses, want 3", head.Instructions[1].NumUses())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"define i32 @f() {\nentry:\n  ret i32 %x\n}\n", "3:11: use of undefined value %x"},
+		{"define i32 @f() {\nentry:\n  %a = frob i32 1\n  ret i32 %a\n}\n", "3:8: unknown instruction \"frob\""},
+		{"define void @f() {\nentry:\n  br label %nowhere\n}\n", "block %nowhere in @f is used but never defined"},
+		{"define i32 @f(i32 %a) {\nentry:\n  %a = add i32 1, 2\n  ret i32 %a\n}\n", "value %a is defined twice"},
+		{"define void @f() {\nentry:\n  ret void\n", "unterminated body of @f"},
+		{"define void @f() {\nentry:\n  ret void\n}\ndefine void @f() {\nentry:\n  ret void\n}\n", "function @f is defined twice"},
+		{"@g = global i32 1\n@g = global i32 2\n", "global @g is defined twice"},
+		{"define void @f() {\nentry:\n  %v = icmp foo i32 1, 2\n  ret void\n}\n", "unknown icmp predicate \"foo\""},
+	}
+	for _, tt := range tests {
+		_, err := Parse("bad", tt.src)
+		if err == nil {
+			t.Errorf("no error for:\n%s", tt.src)
+			continue
+		}
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("error %v has type %T, want *Error", err, err)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("error %q, want %q", err, tt.want)
+		}
+	}
+}
@@ -93,13 +93,7 @@ func (b *Builder) insert(inst ir.Instruction) {
 		b.currentBlock.AddInstruction(inst)
 	} else {
 		// Insert at specific position
-		insts := b.currentBlock.Instructions
-		newInsts := make([]ir.Instruction, len(insts)+1)
-		copy(newInsts, insts[:b.insertPoint])
-		newInsts[b.insertPoint] = inst
-		copy(newInsts[b.insertPoint+1:], insts[b.insertPoint:])
-		b.currentBlock.Instructions = newInsts
-		inst.SetParent(b.currentBlock)
+		b.currentBlock.InsertInstruction(b.insertPoint, inst)
 		b.insertPoint++
 	}
 }
@@ -218,11 +212,11 @@ func (b *Builder) CreateBr(target *ir.BasicBlock) *ir.BrInst {
 // CreateCondBr creates a conditional branch
 func (b *Builder) CreateCondBr(cond ir.Value, trueBlock, falseBlock *ir.BasicBlock) *ir.CondBrInst {
 	inst := &ir.CondBrInst{
-		Condition:  cond,
 		TrueBlock:  trueBlock,
 		FalseBlock: falseBlock,
 	}
 	inst.Op = ir.OpCondBr
+	inst.SetOperand(0, cond)
 	b.insert(inst)
 	// Update CFG
 	b.currentBlock.Successors = append(b.currentBlock.Successors, trueBlock, falseBlock)
@@ -234,11 +228,11 @@ func (b *Builder) CreateCondBr(cond ir.Value, trueBlock, falseBlock *ir.BasicBlo
 // CreateSwitch creates a switch instruction
 func (b *Builder) CreateSwitch(cond ir.Value, defaultBlock *ir.BasicBlock, numCases int) *ir.SwitchInst {
 	inst := &ir.SwitchInst{
-		Condition:    cond,
 		DefaultBlock: defaultBlock,
 		Cases:        make([]ir.SwitchCase, 0, numCases),
 	}
 	inst.Op = ir.OpSwitch
+	inst.SetOperand(0, cond)
 	b.insert(inst)
 	b.currentBlock.Successors = append(b.currentBlock.Successors, defaultBlock)
 	defaultBlock.Predecessors = append(defaultBlock.Predecessors, b.currentBlock)
@@ -435,9 +429,11 @@ func (b *Builder) CreateAllocaWithCount(typ types.Type, count ir.Value, name str
 	}
 	inst := &ir.AllocaInst{
 		AllocatedType: typ,
-		NumElements:   count,
 	}
 	inst.Op = ir.OpAlloca
+	if count != nil {
+		inst.SetOperand(0, count)
+	}
 	inst.SetType(types.NewPointer(typ))
 	inst.SetName(name)
 	b.insert(inst)
@@ -505,13 +501,21 @@ func (b *Builder) CreateGEP(pointeeType types.Type, ptr ir.Value, indices []ir.V
 	operands[0] = ptr
 	copy(operands[1:], indices)
 
+	elemType, err := ir.GEPIndexedType(pointeeType, indices)
+	if err != nil {
+		panic(fmt.Sprintf("invalid getelementptr: %v", err))
+	}
+	addrSpace := 0
+	if pt, ok := ptr.Type().(*types.PointerType); ok {
+		addrSpace = pt.AddressSpace
+	}
+
 	inst := &ir.GetElementPtrInst{
 		SourceElementType: pointeeType,
 	}
 	inst.Op = ir.OpGetElementPtr
 	inst.SetName(name)
-	// Simplified return type calculation
-	inst.SetType(types.NewPointer(pointeeType))
+	inst.SetType(types.NewPointerWithAddressSpace(elemType, addrSpace))
 	for i, op := range operands {
 		inst.SetOperand(i, op)
 	}
@@ -715,10 +719,9 @@ func (b *Builder) CreateCall(fn *ir.Function, args []ir.Value, name string) *ir.
 	if name == "" && fn.FuncType.ReturnType.Kind() != types.VoidKind {
 		name = b.generateName()
 	}
-	inst := &ir.CallInst{
-		Callee: fn,
-	}
+	inst := &ir.CallInst{}
 	inst.Op = ir.OpCall
+	inst.SetCallee(fn)
 	inst.SetName(name)
 	inst.SetType(fn.FuncType.ReturnType)
 	for i, arg := range args {
@@ -770,12 +773,16 @@ func (b *Builder) CreateExtractValue(agg ir.Value, indices []int, name string) *
 	if name == "" {
 		name = b.generateName()
 	}
+	typ, err := ir.AggregateIndexedType(agg.Type(), indices)
+	if err != nil {
+		panic(fmt.Sprintf("invalid extractvalue: %v", err))
+	}
 	inst := &ir.ExtractValueInst{
 		Indices: indices,
 	}
 	inst.Op = ir.OpExtractValue
 	inst.SetName(name)
-	inst.SetType(agg.Type()) // Approximation
+	inst.SetType(typ)
 	inst.SetOperand(0, agg)
 	b.insert(inst)
 	return inst
@@ -786,6 +793,9 @@ func (b *Builder) CreateInsertValue(agg ir.Value, val ir.Value, indices []int, n
 	if name == "" {
 		name = b.generateName()
 	}
+	if _, err := ir.AggregateIndexedType(agg.Type(), indices); err != nil {
+		panic(fmt.Sprintf("invalid insertvalue: %v", err))
+	}
 	inst := &ir.InsertValueInst{
 		Indices: indices,
 	}
@@ -0,0 +1,390 @@
+package builder
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Constant folding for FoldingBuilder. Each fold function returns nil when
+// the operation cannot be folded, in which case the instruction is emitted
+// as usual. Integers wider than 64 bits and floats other than f32 and f64
+// are never folded. Operations that trap at run time, such as division by
+// zero, are left in place; results the flags declare poison, such as an
+// overflowing nsw add, fold to undef.
+
+// intFlags carries the poison-generating flags of an integer operation
+type intFlags struct {
+	nsw, nuw, exact bool
+}
+
+// intConst returns the bits of c truncated to its type's width
+func intConst(v ir.Value) (*types.IntType, uint64, bool) {
+	c, ok := v.(*ir.ConstantInt)
+	if !ok {
+		return nil, 0, false
+	}
+	t, ok := c.Type().(*types.IntType)
+	if !ok || t.BitWidth < 1 || t.BitWidth > 64 {
+		return nil, 0, false
+	}
+	return t, mask(uint64(c.Value), t.BitWidth), true
+}
+
+func floatConst(v ir.Value) (*types.FloatType, float64, bool) {
+	c, ok := v.(*ir.ConstantFloat)
+	if !ok {
+		return nil, 0, false
+	}
+	t, ok := c.Type().(*types.FloatType)
+	if !ok || t.BitWidth != 32 && t.BitWidth != 64 {
+		return nil, 0, false
+	}
+	return t, c.Value, true
+}
+
+func mask(bits uint64, width int) uint64 {
+	if width >= 64 {
+		return bits
+	}
+	return bits & (1<<uint(width) - 1)
+}
+
+func signed(bits uint64, width int) int64 {
+	shift := uint(64 - width)
+	return int64(bits<<shift) >> shift
+}
+
+// makeInt builds a constant of type t from raw bits. Signed types hold the
+// sign-extended value and unsigned types the zero-extended one; i1 is
+// always 0 or 1.
+func makeInt(t *types.IntType, bits uint64) *ir.ConstantInt {
+	bits = mask(bits, t.BitWidth)
+	val := int64(bits)
+	if t.Signed && t.BitWidth > 1 {
+		val = signed(bits, t.BitWidth)
+	}
+	c := &ir.ConstantInt{Value: val}
+	c.SetType(t)
+	return c
+}
+
+func makeFloat(t *types.FloatType, f float64) *ir.ConstantFloat {
+	if t.BitWidth == 32 {
+		f = float64(float32(f))
+	}
+	c := &ir.ConstantFloat{Value: f}
+	c.SetType(t)
+	return c
+}
+
+func makeUndef(t types.Type) *ir.ConstantUndef {
+	c := &ir.ConstantUndef{}
+	c.SetType(t)
+	return c
+}
+
+func makeBool(v bool) *ir.ConstantInt {
+	if v {
+		return makeInt(types.I1, 1)
+	}
+	return makeInt(types.I1, 0)
+}
+
+// fitsSigned reports whether the exact result r fits in a signed width-bit integer
+func fitsSigned(r *big.Int, width int) bool {
+	lim := new(big.Int).Lsh(big.NewInt(1), uint(width-1))
+	return r.Cmp(new(big.Int).Neg(lim)) >= 0 && r.Cmp(lim) < 0
+}
+
+// fitsUnsigned reports whether the exact result r fits in an unsigned width-bit integer
+func fitsUnsigned(r *big.Int, width int) bool {
+	return r.Sign() >= 0 && r.BitLen() <= width
+}
+
+func foldBinary(op ir.Opcode, lhs, rhs ir.Value, fl intFlags) ir.Value {
+	switch op {
+	case ir.OpFAdd, ir.OpFSub, ir.OpFMul, ir.OpFDiv, ir.OpFRem:
+		return foldFloatBinary(op, lhs, rhs)
+	}
+	t, x, ok := intConst(lhs)
+	if !ok {
+		return nil
+	}
+	rt, y, ok := intConst(rhs)
+	if !ok || rt.BitWidth != t.BitWidth {
+		return nil
+	}
+	w := t.BitWidth
+	sx, sy := signed(x, w), signed(y, w)
+
+	switch op {
+	case ir.OpAdd, ir.OpSub, ir.OpMul:
+		exact := func(a, b *big.Int) *big.Int {
+			switch op {
+			case ir.OpAdd:
+				return new(big.Int).Add(a, b)
+			case ir.OpSub:
+				return new(big.Int).Sub(a, b)
+			}
+			return new(big.Int).Mul(a, b)
+		}
+		if fl.nsw && !fitsSigned(exact(big.NewInt(sx), big.NewInt(sy)), w) {
+			return makeUndef(t)
+		}
+		if fl.nuw && !fitsUnsigned(exact(new(big.Int).SetUint64(x), new(big.Int).SetUint64(y)), w) {
+			return makeUndef(t)
+		}
+		switch op {
+		case ir.OpAdd:
+			return makeInt(t, x+y)
+		case ir.OpSub:
+			return makeInt(t, x-y)
+		}
+		return makeInt(t, x*y)
+	case ir.OpUDiv, ir.OpURem:
+		if y == 0 {
+			return nil
+		}
+		if op == ir.OpURem {
+			return makeInt(t, x%y)
+		}
+		if fl.exact && x%y != 0 {
+			return makeUndef(t)
+		}
+		return makeInt(t, x/y)
+	case ir.OpSDiv, ir.OpSRem:
+		if y == 0 || sy == -1 && sx == signed(1<<uint(w-1), w) {
+			return nil
+		}
+		if op == ir.OpSRem {
+			return makeInt(t, uint64(sx%sy))
+		}
+		if fl.exact && sx%sy != 0 {
+			return makeUndef(t)
+		}
+		return makeInt(t, uint64(sx/sy))
+	case ir.OpShl, ir.OpLShr, ir.OpAShr:
+		if y >= uint64(w) {
+			return makeUndef(t)
+		}
+		switch op {
+		case ir.OpShl:
+			r := mask(x<<y, w)
+			if fl.nuw && r>>y != x || fl.nsw && uint64(signed(r, w)>>y) != uint64(sx) {
+				return makeUndef(t)
+			}
+			return makeInt(t, r)
+		case ir.OpLShr:
+			if fl.exact && x&(1<<y-1) != 0 {
+				return makeUndef(t)
+			}
+			return makeInt(t, x>>y)
+		}
+		if fl.exact && x&(1<<y-1) != 0 {
+			return makeUndef(t)
+		}
+		return makeInt(t, uint64(sx>>y))
+	case ir.OpAnd:
+		return makeInt(t, x&y)
+	case ir.OpOr:
+		return makeInt(t, x|y)
+	case ir.OpXor:
+		return makeInt(t, x^y)
+	}
+	return nil
+}
+
+func foldFloatBinary(op ir.Opcode, lhs, rhs ir.Value) ir.Value {
+	t, x, ok := floatConst(lhs)
+	if !ok {
+		return nil
+	}
+	rt, y, ok := floatConst(rhs)
+	if !ok || rt.BitWidth != t.BitWidth {
+		return nil
+	}
+	switch op {
+	case ir.OpFAdd:
+		return makeFloat(t, x+y)
+	case ir.OpFSub:
+		return makeFloat(t, x-y)
+	case ir.OpFMul:
+		return makeFloat(t, x*y)
+	case ir.OpFDiv:
+		return makeFloat(t, x/y)
+	case ir.OpFRem:
+		return makeFloat(t, math.Mod(x, y))
+	}
+	return nil
+}
+
+func foldICmp(pred ir.ICmpPredicate, lhs, rhs ir.Value) ir.Value {
+	t, x, ok := intConst(lhs)
+	if !ok {
+		return nil
+	}
+	rt, y, ok := intConst(rhs)
+	if !ok || rt.BitWidth != t.BitWidth {
+		return nil
+	}
+	sx, sy := signed(x, t.BitWidth), signed(y, t.BitWidth)
+	switch pred {
+	case ir.ICmpEQ:
+		return makeBool(x == y)
+	case ir.ICmpNE:
+		return makeBool(x != y)
+	case ir.ICmpUGT:
+		return makeBool(x > y)
+	case ir.ICmpUGE:
+		return makeBool(x >= y)
+	case ir.ICmpULT:
+		return makeBool(x < y)
+	case ir.ICmpULE:
+		return makeBool(x <= y)
+	case ir.ICmpSGT:
+		return makeBool(sx > sy)
+	case ir.ICmpSGE:
+		return makeBool(sx >= sy)
+	case ir.ICmpSLT:
+		return makeBool(sx < sy)
+	case ir.ICmpSLE:
+		return makeBool(sx <= sy)
+	}
+	return nil
+}
+
+func foldFCmp(pred ir.FCmpPredicate, lhs, rhs ir.Value) ir.Value {
+	_, x, ok := floatConst(lhs)
+	if !ok {
+		return nil
+	}
+	_, y, ok := floatConst(rhs)
+	if !ok {
+		return nil
+	}
+	uno := math.IsNaN(x) || math.IsNaN(y)
+	switch pred {
+	case ir.FCmpFalse:
+		return makeBool(false)
+	case ir.FCmpOEQ:
+		return makeBool(!uno && x == y)
+	case ir.FCmpOGT:
+		return makeBool(!uno && x > y)
+	case ir.FCmpOGE:
+		return makeBool(!uno && x >= y)
+	case ir.FCmpOLT:
+		return makeBool(!uno && x < y)
+	case ir.FCmpOLE:
+		return makeBool(!uno && x <= y)
+	case ir.FCmpONE:
+		return makeBool(!uno && x != y)
+	case ir.FCmpORD:
+		return makeBool(!uno)
+	case ir.FCmpUNO:
+		return makeBool(uno)
+	case ir.FCmpUEQ:
+		return makeBool(uno || x == y)
+	case ir.FCmpUGT:
+		return makeBool(uno || x > y)
+	case ir.FCmpUGE:
+		return makeBool(uno || x >= y)
+	case ir.FCmpULT:
+		return makeBool(uno || x < y)
+	case ir.FCmpULE:
+		return makeBool(uno || x <= y)
+	case ir.FCmpUNE:
+		return makeBool(uno || x != y)
+	case ir.FCmpTrue:
+		return makeBool(true)
+	}
+	return nil
+}
+
+func foldCast(op ir.Opcode, v ir.Value, dest types.Type) ir.Value {
+	switch dt := dest.(type) {
+	case *types.IntType:
+		if dt.BitWidth < 1 || dt.BitWidth > 64 {
+			return nil
+		}
+		if st, x, ok := intConst(v); ok {
+			switch op {
+			case ir.OpTrunc, ir.OpZExt:
+				return makeInt(dt, x)
+			case ir.OpSExt:
+				return makeInt(dt, uint64(signed(x, st.BitWidth)))
+			}
+			return nil
+		}
+		st, f, ok := floatConst(v)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case ir.OpFPToUI:
+			t := math.Trunc(f)
+			if math.IsNaN(f) || t < 0 || t >= math.Ldexp(1, dt.BitWidth) {
+				return makeUndef(dt)
+			}
+			return makeInt(dt, uint64(t))
+		case ir.OpFPToSI:
+			t := math.Trunc(f)
+			lim := math.Ldexp(1, dt.BitWidth-1)
+			if math.IsNaN(f) || t < -lim || t >= lim {
+				return makeUndef(dt)
+			}
+			return makeInt(dt, uint64(int64(t)))
+		case ir.OpBitcast:
+			if st.BitWidth != dt.BitWidth {
+				return nil
+			}
+			if st.BitWidth == 32 {
+				return makeInt(dt, uint64(math.Float32bits(float32(f))))
+			}
+			return makeInt(dt, math.Float64bits(f))
+		}
+	case *types.FloatType:
+		if dt.BitWidth != 32 && dt.BitWidth != 64 {
+			return nil
+		}
+		if _, f, ok := floatConst(v); ok {
+			switch op {
+			case ir.OpFPTrunc, ir.OpFPExt:
+				return makeFloat(dt, f)
+			}
+			return nil
+		}
+		st, x, ok := intConst(v)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case ir.OpUIToFP:
+			return makeFloat(dt, float64(x))
+		case ir.OpSIToFP:
+			return makeFloat(dt, float64(signed(x, st.BitWidth)))
+		case ir.OpBitcast:
+			if st.BitWidth != dt.BitWidth {
+				return nil
+			}
+			if dt.BitWidth == 32 {
+				return makeFloat(dt, float64(math.Float32frombits(uint32(x))))
+			}
+			return makeFloat(dt, math.Float64frombits(x))
+		}
+	}
+	return nil
+}
+
+func foldSelect(cond, trueVal, falseVal ir.Value) ir.Value {
+	c, ok := cond.(*ir.ConstantInt)
+	if !ok {
+		return nil
+	}
+	if c.Value&1 != 0 {
+		return trueVal
+	}
+	return falseVal
+}
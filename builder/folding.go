@@ -0,0 +1,290 @@
+package builder
+
+import (
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// FoldingBuilder is a Builder whose arithmetic, comparison, cast and select
+// methods return a constant instead of inserting an instruction when their
+// operands are constants. Those methods return ir.Value; every other method
+// is the Builder's own.
+type FoldingBuilder struct {
+	*Builder
+}
+
+// NewFolding creates a new folding IR builder
+func NewFolding() *FoldingBuilder {
+	return &FoldingBuilder{Builder: New()}
+}
+
+// Folding returns a folding builder sharing b's module, insertion point and
+// name counter
+func (b *Builder) Folding() *FoldingBuilder {
+	return &FoldingBuilder{Builder: b}
+}
+
+func (b *FoldingBuilder) binary(op ir.Opcode, lhs, rhs ir.Value, fl intFlags, create func() *ir.BinaryInst) ir.Value {
+	if c := foldBinary(op, lhs, rhs, fl); c != nil {
+		return c
+	}
+	return create()
+}
+
+func (b *FoldingBuilder) cast(op ir.Opcode, v ir.Value, destTy types.Type, create func() *ir.CastInst) ir.Value {
+	if c := foldCast(op, v, destTy); c != nil {
+		return c
+	}
+	return create()
+}
+
+// ============================================================================
+// Binary operations
+// ============================================================================
+
+// CreateAdd is Builder.CreateAdd with constant folding
+func (b *FoldingBuilder) CreateAdd(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpAdd, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateAdd(lhs, rhs, name) })
+}
+
+// CreateNSWAdd is Builder.CreateNSWAdd with constant folding
+func (b *FoldingBuilder) CreateNSWAdd(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpAdd, lhs, rhs, intFlags{nsw: true}, func() *ir.BinaryInst { return b.Builder.CreateNSWAdd(lhs, rhs, name) })
+}
+
+// CreateNUWAdd is Builder.CreateNUWAdd with constant folding
+func (b *FoldingBuilder) CreateNUWAdd(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpAdd, lhs, rhs, intFlags{nuw: true}, func() *ir.BinaryInst { return b.Builder.CreateNUWAdd(lhs, rhs, name) })
+}
+
+// CreateSub is Builder.CreateSub with constant folding
+func (b *FoldingBuilder) CreateSub(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpSub, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateSub(lhs, rhs, name) })
+}
+
+// CreateNSWSub is Builder.CreateNSWSub with constant folding
+func (b *FoldingBuilder) CreateNSWSub(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpSub, lhs, rhs, intFlags{nsw: true}, func() *ir.BinaryInst { return b.Builder.CreateNSWSub(lhs, rhs, name) })
+}
+
+// CreateMul is Builder.CreateMul with constant folding
+func (b *FoldingBuilder) CreateMul(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpMul, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateMul(lhs, rhs, name) })
+}
+
+// CreateNSWMul is Builder.CreateNSWMul with constant folding
+func (b *FoldingBuilder) CreateNSWMul(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpMul, lhs, rhs, intFlags{nsw: true}, func() *ir.BinaryInst { return b.Builder.CreateNSWMul(lhs, rhs, name) })
+}
+
+// CreateUDiv is Builder.CreateUDiv with constant folding
+func (b *FoldingBuilder) CreateUDiv(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpUDiv, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateUDiv(lhs, rhs, name) })
+}
+
+// CreateExactUDiv is Builder.CreateExactUDiv with constant folding
+func (b *FoldingBuilder) CreateExactUDiv(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpUDiv, lhs, rhs, intFlags{exact: true}, func() *ir.BinaryInst { return b.Builder.CreateExactUDiv(lhs, rhs, name) })
+}
+
+// CreateSDiv is Builder.CreateSDiv with constant folding
+func (b *FoldingBuilder) CreateSDiv(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpSDiv, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateSDiv(lhs, rhs, name) })
+}
+
+// CreateExactSDiv is Builder.CreateExactSDiv with constant folding
+func (b *FoldingBuilder) CreateExactSDiv(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpSDiv, lhs, rhs, intFlags{exact: true}, func() *ir.BinaryInst { return b.Builder.CreateExactSDiv(lhs, rhs, name) })
+}
+
+// CreateURem is Builder.CreateURem with constant folding
+func (b *FoldingBuilder) CreateURem(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpURem, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateURem(lhs, rhs, name) })
+}
+
+// CreateSRem is Builder.CreateSRem with constant folding
+func (b *FoldingBuilder) CreateSRem(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpSRem, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateSRem(lhs, rhs, name) })
+}
+
+// CreateFAdd is Builder.CreateFAdd with constant folding
+func (b *FoldingBuilder) CreateFAdd(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpFAdd, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateFAdd(lhs, rhs, name) })
+}
+
+// CreateFSub is Builder.CreateFSub with constant folding
+func (b *FoldingBuilder) CreateFSub(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpFSub, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateFSub(lhs, rhs, name) })
+}
+
+// CreateFMul is Builder.CreateFMul with constant folding
+func (b *FoldingBuilder) CreateFMul(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpFMul, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateFMul(lhs, rhs, name) })
+}
+
+// CreateFDiv is Builder.CreateFDiv with constant folding
+func (b *FoldingBuilder) CreateFDiv(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpFDiv, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateFDiv(lhs, rhs, name) })
+}
+
+// CreateFRem is Builder.CreateFRem with constant folding
+func (b *FoldingBuilder) CreateFRem(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpFRem, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateFRem(lhs, rhs, name) })
+}
+
+// CreateShl is Builder.CreateShl with constant folding
+func (b *FoldingBuilder) CreateShl(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpShl, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateShl(lhs, rhs, name) })
+}
+
+// CreateLShr is Builder.CreateLShr with constant folding
+func (b *FoldingBuilder) CreateLShr(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpLShr, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateLShr(lhs, rhs, name) })
+}
+
+// CreateAShr is Builder.CreateAShr with constant folding
+func (b *FoldingBuilder) CreateAShr(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpAShr, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateAShr(lhs, rhs, name) })
+}
+
+// CreateAnd is Builder.CreateAnd with constant folding
+func (b *FoldingBuilder) CreateAnd(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpAnd, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateAnd(lhs, rhs, name) })
+}
+
+// CreateOr is Builder.CreateOr with constant folding
+func (b *FoldingBuilder) CreateOr(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpOr, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateOr(lhs, rhs, name) })
+}
+
+// CreateXor is Builder.CreateXor with constant folding
+func (b *FoldingBuilder) CreateXor(lhs, rhs ir.Value, name string) ir.Value {
+	return b.binary(ir.OpXor, lhs, rhs, intFlags{}, func() *ir.BinaryInst { return b.Builder.CreateXor(lhs, rhs, name) })
+}
+
+// ============================================================================
+// Cast operations
+// ============================================================================
+
+// CreateTrunc is Builder.CreateTrunc with constant folding
+func (b *FoldingBuilder) CreateTrunc(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpTrunc, v, destTy, func() *ir.CastInst { return b.Builder.CreateTrunc(v, destTy, name) })
+}
+
+// CreateZExt is Builder.CreateZExt with constant folding
+func (b *FoldingBuilder) CreateZExt(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpZExt, v, destTy, func() *ir.CastInst { return b.Builder.CreateZExt(v, destTy, name) })
+}
+
+// CreateSExt is Builder.CreateSExt with constant folding
+func (b *FoldingBuilder) CreateSExt(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpSExt, v, destTy, func() *ir.CastInst { return b.Builder.CreateSExt(v, destTy, name) })
+}
+
+// CreateFPTrunc is Builder.CreateFPTrunc with constant folding
+func (b *FoldingBuilder) CreateFPTrunc(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpFPTrunc, v, destTy, func() *ir.CastInst { return b.Builder.CreateFPTrunc(v, destTy, name) })
+}
+
+// CreateFPExt is Builder.CreateFPExt with constant folding
+func (b *FoldingBuilder) CreateFPExt(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpFPExt, v, destTy, func() *ir.CastInst { return b.Builder.CreateFPExt(v, destTy, name) })
+}
+
+// CreateFPToUI is Builder.CreateFPToUI with constant folding
+func (b *FoldingBuilder) CreateFPToUI(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpFPToUI, v, destTy, func() *ir.CastInst { return b.Builder.CreateFPToUI(v, destTy, name) })
+}
+
+// CreateFPToSI is Builder.CreateFPToSI with constant folding
+func (b *FoldingBuilder) CreateFPToSI(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpFPToSI, v, destTy, func() *ir.CastInst { return b.Builder.CreateFPToSI(v, destTy, name) })
+}
+
+// CreateUIToFP is Builder.CreateUIToFP with constant folding
+func (b *FoldingBuilder) CreateUIToFP(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpUIToFP, v, destTy, func() *ir.CastInst { return b.Builder.CreateUIToFP(v, destTy, name) })
+}
+
+// CreateSIToFP is Builder.CreateSIToFP with constant folding
+func (b *FoldingBuilder) CreateSIToFP(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpSIToFP, v, destTy, func() *ir.CastInst { return b.Builder.CreateSIToFP(v, destTy, name) })
+}
+
+// CreateBitCast is Builder.CreateBitCast with constant folding
+func (b *FoldingBuilder) CreateBitCast(v ir.Value, destTy types.Type, name string) ir.Value {
+	return b.cast(ir.OpBitcast, v, destTy, func() *ir.CastInst { return b.Builder.CreateBitCast(v, destTy, name) })
+}
+
+// ============================================================================
+// Comparison operations
+// ============================================================================
+
+// CreateICmp is Builder.CreateICmp with constant folding
+func (b *FoldingBuilder) CreateICmp(pred ir.ICmpPredicate, lhs, rhs ir.Value, name string) ir.Value {
+	if c := foldICmp(pred, lhs, rhs); c != nil {
+		return c
+	}
+	return b.Builder.CreateICmp(pred, lhs, rhs, name)
+}
+
+// CreateFCmp is Builder.CreateFCmp with constant folding
+func (b *FoldingBuilder) CreateFCmp(pred ir.FCmpPredicate, lhs, rhs ir.Value, name string) ir.Value {
+	if c := foldFCmp(pred, lhs, rhs); c != nil {
+		return c
+	}
+	return b.Builder.CreateFCmp(pred, lhs, rhs, name)
+}
+
+// Convenience comparison methods
+func (b *FoldingBuilder) CreateICmpEQ(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpEQ, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpNE(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpNE, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpSLT(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpSLT, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpSLE(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpSLE, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpSGT(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpSGT, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpSGE(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpSGE, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpULT(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpULT, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpULE(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpULE, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpUGT(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpUGT, lhs, rhs, name)
+}
+
+func (b *FoldingBuilder) CreateICmpUGE(lhs, rhs ir.Value, name string) ir.Value {
+	return b.CreateICmp(ir.ICmpUGE, lhs, rhs, name)
+}
+
+// ============================================================================
+// Other operations
+// ============================================================================
+
+// CreateSelect returns the selected operand when the condition is constant
+func (b *FoldingBuilder) CreateSelect(cond ir.Value, trueVal, falseVal ir.Value, name string) ir.Value {
+	if v := foldSelect(cond, trueVal, falseVal); v != nil {
+		return v
+	}
+	return b.Builder.CreateSelect(cond, trueVal, falseVal, name)
+}
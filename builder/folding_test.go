@@ -0,0 +1,147 @@
+package builder
+
+import (
+	"math"
+	"testing"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// newFolding returns a folding builder positioned in the entry block of
+// a function with an i32 argument
+func newFolding() (*FoldingBuilder, *ir.Function) {
+	b := NewFolding()
+	b.CreateModule("test")
+	fn := b.CreateFunction("f", types.Void, []types.Type{types.I32}, false)
+	b.SetInsertPoint(b.CreateBlock("entry"))
+	return b, fn
+}
+
+// wantInt fails unless v is the integer constant want
+func wantInt(t *testing.T, what string, v ir.Value, want int64) {
+	t.Helper()
+	c, ok := v.(*ir.ConstantInt)
+	if !ok {
+		t.Errorf("%s: got %T, want constant %d", what, v, want)
+		return
+	}
+	if c.Value != want {
+		t.Errorf("%s = %d, want %d", what, c.Value, want)
+	}
+}
+
+// wantUndef fails unless v is undef
+func wantUndef(t *testing.T, what string, v ir.Value) {
+	t.Helper()
+	if _, ok := v.(*ir.ConstantUndef); !ok {
+		t.Errorf("%s: got %v, want undef", what, v)
+	}
+}
+
+// wantInst fails unless v is an instruction emitted into the block
+func wantInst(t *testing.T, what string, v ir.Value) {
+	t.Helper()
+	inst, ok := v.(ir.Instruction)
+	if !ok || inst.Parent() == nil {
+		t.Errorf("%s: got %v, want an emitted instruction", what, v)
+	}
+}
+
+func TestFoldIntegerArithmetic(t *testing.T) {
+	b, _ := newFolding()
+	i32 := func(v int64) ir.Value { return b.ConstInt(types.I32, v) }
+	wantInt(t, "2 + 3", b.CreateAdd(i32(2), i32(3), "x"), 5)
+	wantInt(t, "2 - 3", b.CreateSub(i32(2), i32(3), "x"), -1)
+	wantInt(t, "-4 * 5", b.CreateMul(i32(-4), i32(5), "x"), -20)
+	wantInt(t, "-7 sdiv 2", b.CreateSDiv(i32(-7), i32(2), "x"), -3)
+	wantInt(t, "-7 srem 2", b.CreateSRem(i32(-7), i32(2), "x"), -1)
+	wantInt(t, "-1 udiv 2", b.CreateUDiv(i32(-1), i32(2), "x"), math.MaxInt32)
+	wantInt(t, "-8 ashr 1", b.CreateAShr(i32(-8), i32(1), "x"), -4)
+	wantInt(t, "-8 lshr 28", b.CreateLShr(i32(-8), i32(28), "x"), 15)
+	wantInt(t, "6 xor 3", b.CreateXor(i32(6), i32(3), "x"), 5)
+	if n := len(b.CurrentBlock().Instructions); n != 0 {
+		t.Errorf("%d instructions emitted for constant operands", n)
+	}
+}
+
+func TestFoldOverflow(t *testing.T) {
+	b, _ := newFolding()
+	i32 := func(v int64) ir.Value { return b.ConstInt(types.I32, v) }
+	u8 := func(v int64) ir.Value { return b.ConstInt(types.U8, v) }
+
+	// Without flags the result wraps; with them it is poison
+	wantInt(t, "max + 1", b.CreateAdd(i32(math.MaxInt32), i32(1), "x"), math.MinInt32)
+	wantUndef(t, "nsw max + 1", b.CreateNSWAdd(i32(math.MaxInt32), i32(1), "x"))
+	wantUndef(t, "nsw min - 1", b.CreateNSWSub(i32(math.MinInt32), i32(1), "x"))
+	wantUndef(t, "nsw 65536 * 65536", b.CreateNSWMul(i32(65536), i32(65536), "x"))
+	wantInt(t, "nsw -2 + 1", b.CreateNSWAdd(i32(-2), i32(1), "x"), -1)
+
+	wantInt(t, "u8 255 + 1", b.CreateAdd(u8(255), u8(1), "x"), 0)
+	wantUndef(t, "nuw u8 255 + 1", b.CreateNUWAdd(u8(255), u8(1), "x"))
+	wantInt(t, "nuw u8 254 + 1", b.CreateNUWAdd(u8(254), u8(1), "x"), 255)
+	// -1 as i32 is 0xffffffff unsigned, so nuw overflows although nsw does not
+	wantUndef(t, "nuw -1 + 1", b.CreateNUWAdd(i32(-1), i32(1), "x"))
+
+	wantUndef(t, "exact 7 udiv 2", b.CreateExactUDiv(i32(7), i32(2), "x"))
+	wantInt(t, "exact 8 sdiv -2", b.CreateExactSDiv(i32(8), i32(-2), "x"), -4)
+	wantUndef(t, "shl by 32", b.CreateShl(i32(1), i32(32), "x"))
+}
+
+func TestNoFoldTraps(t *testing.T) {
+	b, _ := newFolding()
+	i32 := func(v int64) ir.Value { return b.ConstInt(types.I32, v) }
+	wantInst(t, "1 sdiv 0", b.CreateSDiv(i32(1), i32(0), "a"))
+	wantInst(t, "1 udiv 0", b.CreateUDiv(i32(1), i32(0), "b"))
+	wantInst(t, "1 srem 0", b.CreateSRem(i32(1), i32(0), "c"))
+	wantInst(t, "1 urem 0", b.CreateURem(i32(1), i32(0), "d"))
+	wantInst(t, "min sdiv -1", b.CreateSDiv(i32(math.MinInt32), i32(-1), "e"))
+	wantInst(t, "min srem -1", b.CreateSRem(i32(math.MinInt32), i32(-1), "f"))
+	if n := len(b.CurrentBlock().Instructions); n != 6 {
+		t.Errorf("%d instructions emitted, want 6", n)
+	}
+}
+
+func TestNoFoldVariables(t *testing.T) {
+	b, fn := newFolding()
+	x := fn.Arguments[0]
+	wantInst(t, "x + 1", b.CreateAdd(x, b.ConstInt(types.I32, 1), "a"))
+	wantInst(t, "x < 1", b.CreateICmpSLT(x, b.ConstInt(types.I32, 1), "b"))
+	wantInst(t, "sext x", b.CreateSExt(x, types.I64, "c"))
+}
+
+func TestFoldComparisons(t *testing.T) {
+	b, _ := newFolding()
+	i32 := func(v int64) ir.Value { return b.ConstInt(types.I32, v) }
+	wantInt(t, "-1 slt 0", b.CreateICmpSLT(i32(-1), i32(0), "x"), 1)
+	wantInt(t, "-1 ult 0", b.CreateICmpULT(i32(-1), i32(0), "x"), 0)
+	wantInt(t, "3 eq 3", b.CreateICmpEQ(i32(3), i32(3), "x"), 1)
+
+	nan := b.ConstFloat(types.F64, math.NaN())
+	one := b.ConstFloat(types.F64, 1)
+	wantInt(t, "nan oeq nan", b.CreateFCmp(ir.FCmpOEQ, nan, nan, "x"), 0)
+	wantInt(t, "nan une 1", b.CreateFCmp(ir.FCmpUNE, nan, one, "x"), 1)
+	wantInt(t, "1 olt nan", b.CreateFCmp(ir.FCmpOLT, one, nan, "x"), 0)
+
+	x, y := b.ConstInt(types.I32, 10), b.ConstInt(types.I32, 20)
+	if v := b.CreateSelect(b.CreateICmpSLT(x, y, "c"), x, y, "m"); v != x {
+		t.Errorf("select of a true constant = %v, want 10", v)
+	}
+}
+
+func TestFoldCastsAndFloats(t *testing.T) {
+	b, _ := newFolding()
+	wantInt(t, "trunc 300 to i8", b.CreateTrunc(b.ConstInt(types.I32, 300), types.I8, "x"), 44)
+	wantInt(t, "sext i8 -1", b.CreateSExt(b.ConstInt(types.I8, -1), types.I32, "x"), -1)
+	wantInt(t, "zext i8 -1", b.CreateZExt(b.ConstInt(types.I8, -1), types.I32, "x"), 255)
+	wantInt(t, "fptosi -2.5", b.CreateFPToSI(b.ConstFloat(types.F64, -2.5), types.I32, "x"), -2)
+	wantUndef(t, "fptosi 1e10", b.CreateFPToSI(b.ConstFloat(types.F64, 1e10), types.I32, "x"))
+	wantUndef(t, "fptoui -1", b.CreateFPToUI(b.ConstFloat(types.F64, -1), types.I32, "x"))
+
+	sum, ok := b.CreateFAdd(b.ConstFloat(types.F32, 0.1), b.ConstFloat(types.F32, 0.2), "x").(*ir.ConstantFloat)
+	if !ok || sum.Value != float64(float32(0.1)+float32(0.2)) {
+		t.Errorf("f32 0.1 + 0.2 = %v, want the single precision sum", sum)
+	}
+	bits := b.CreateBitCast(b.ConstFloat(types.F32, 1), types.I32, "x")
+	wantInt(t, "bitcast f32 1", bits, 0x3f800000)
+}
@@ -0,0 +1,168 @@
+package amd64
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/codegen/internal/golden"
+)
+
+// TestGolden compares the assembly for each example module in
+// ../../testdata with testdata/<name>.s
+func TestGolden(t *testing.T) {
+	golden.Run(t, ".s", Generate)
+}
+
+func TestUnsupported(t *testing.T) {
+	m, err := asm.Parse("vec", `
+define external <4 x i32> @vadd(<4 x i32> %a, <4 x i32> %b) {
+entry:
+  %s = add <4 x i32> %a, %b
+  ret <4 x i32> %s
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	if err := Generate(&bytes.Buffer{}, m); !errors.As(err, &e) || e.Func == nil || e.Func.Name() != "vadd" {
+		t.Errorf("got error %v, want an *Error for @vadd", err)
+	}
+}
+
+// cstring returns s with a terminating NUL as an [N x i8] constant
+func cstring(s string) string {
+	var elems []string
+	for _, c := range []byte(s + "\x00") {
+		elems = append(elems, fmt.Sprintf("i8 %d", c))
+	}
+	return fmt.Sprintf("[%d x i8] [%s]", len(s)+1, strings.Join(elems, ", "))
+}
+
+// abi has functions called from C and calling C with more arguments than
+// fit in registers, narrow integers and floats mixed with integers, and a
+// call to printf
+var abi = `
+@fmt = private constant ` + cstring("%ld %.2f %d\n") + `
+
+declare external i32 @printf(ptr<i8>, ...)
+declare external f64 @cmix(i32, f64, i64, f32, i8, f64, i16, i64, i32, i64)
+
+define external f64 @mix(i32 %a, f64 %x, i64 %b, f32 %y, i8 %c, f64 %z, i16 %d, i64 %e, i32 %f, i64 %g) {
+entry:
+  %a1 = sext i32 %a to i64
+  %c1 = sext i8 %c to i64
+  %d1 = sext i16 %d to i64
+  %f1 = sext i32 %f to i64
+  %s1 = mul i64 %a1, 10
+  %s2 = add i64 %s1, %b
+  %s3 = mul i64 %s2, 10
+  %s4 = add i64 %s3, %c1
+  %s5 = mul i64 %s4, 10
+  %s6 = add i64 %s5, %d1
+  %s7 = mul i64 %s6, 10
+  %s8 = add i64 %s7, %e
+  %s9 = mul i64 %s8, 10
+  %s10 = add i64 %s9, %f1
+  %s11 = mul i64 %s10, 10
+  %s12 = add i64 %s11, %g
+  %i = sitofp i64 %s12 to f64
+  %x4 = fmul f64 %x, 4.0
+  %y1 = fpext f32 %y to f64
+  %y2 = fmul f64 %y1, 2.0
+  %r1 = fadd f64 %i, %x4
+  %r2 = fadd f64 %r1, %y2
+  %r3 = fadd f64 %r2, %z
+  ret f64 %r3
+}
+
+define external f64 @callmix(i32 %a) {
+entry:
+  %r = call f64 @cmix(i32 %a, f64 0.25, i64 2, f32 0.5, i8 -3, f64 0.125, i16 4, i64 5, i32 6, i64 7)
+  ret f64 %r
+}
+
+define external i32 @report(i64 %n, f64 %v) {
+entry:
+  %p = getelementptr [13 x i8], ptr<[13 x i8]> %fmt, i32 0, i32 0
+  %r = call i32 @printf(ptr<i8> %p, i64 %n, f64 %v, i32 7)
+  ret i32 %r
+}
+`
+
+const harness = `#include <stdint.h>
+#include <stdio.h>
+
+int factorial(int);
+int fib(int);
+int gcd(int, int);
+int classify(int);
+double mix(int32_t, double, int64_t, float, int8_t, double, int16_t, int64_t, int32_t, int64_t);
+double callmix(int32_t);
+int report(int64_t, double);
+
+double cmix(int32_t a, double x, int64_t b, float y, int8_t c, double z, int16_t d, int64_t e, int32_t f, int64_t g) {
+	int64_t s = ((((((int64_t)a * 10 + b) * 10 + c) * 10 + d) * 10 + e) * 10 + f) * 10 + g;
+	return (double)s + x * 4 + (double)y * 2 + z;
+}
+
+int main(void) {
+	printf("%d %d %d %d %d %d %d\n", factorial(5), fib(10), gcd(48, 18), gcd(-12, 8), classify(0), classify(1), classify(7));
+	printf("%.3f %.3f\n", mix(1, 0.25, 2, 0.5f, -3, 0.125, 4, 5, 6, 7), callmix(1));
+	fflush(stdout);
+	int n = report(42, 2.5);
+	printf("%d\n", n);
+	return 0;
+}
+`
+
+// TestRun assembles the golden files and a module exercising the calling
+// convention, links them with a C program calling into them and checks
+// what it prints
+func TestRun(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs linux/amd64")
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	m, err := asm.Parse("abi", abi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Generate(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string][]byte{"abi.s": buf.Bytes(), "main.c": []byte(harness)}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	args := []string{"-o", filepath.Join(dir, "prog"), filepath.Join(dir, "main.c"), filepath.Join(dir, "abi.s")}
+	for _, name := range []string{"factorial", "fibonacci", "gcd", "switch"} {
+		args = append(args, filepath.Join("testdata", name+".s"))
+	}
+	if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+		t.Fatalf("cc: %v\n%s", err, out)
+	}
+	out, err := exec.Command(filepath.Join(dir, "prog")).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "120 55 6 -4 100 200 -1\n1174569.125 1174569.125\n42 2.50 7\n10\n"
+	if got := string(out); got != want {
+		t.Errorf("program printed %q, want %q", got, want)
+	}
+}
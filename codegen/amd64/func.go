@@ -0,0 +1,264 @@
+package amd64
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/codegen/internal/gas"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// reg is a general purpose register usable as scratch. None of them is
+// callee-saved, so functions never need to preserve them.
+type reg int
+
+const (
+	rax reg = iota
+	rcx
+	rdx
+	rsi
+	rdi
+	r8
+	r9
+	r10
+	r11
+)
+
+var regNames = [...][4]string{
+	rax: {"%rax", "%eax", "%ax", "%al"},
+	rcx: {"%rcx", "%ecx", "%cx", "%cl"},
+	rdx: {"%rdx", "%edx", "%dx", "%dl"},
+	rsi: {"%rsi", "%esi", "%si", "%sil"},
+	rdi: {"%rdi", "%edi", "%di", "%dil"},
+	r8:  {"%r8", "%r8d", "%r8w", "%r8b"},
+	r9:  {"%r9", "%r9d", "%r9w", "%r9b"},
+	r10: {"%r10", "%r10d", "%r10w", "%r10b"},
+	r11: {"%r11", "%r11d", "%r11w", "%r11b"},
+}
+
+func (r reg) q() string { return regNames[r][0] }
+func (r reg) l() string { return regNames[r][1] }
+func (r reg) w() string { return regNames[r][2] }
+func (r reg) b() string { return regNames[r][3] }
+
+// System V argument registers
+var intArgRegs = []reg{rdi, rsi, rdx, rcx, r8, r9}
+
+const floatArgRegs = 8
+
+// funcGen holds the state of one function being lowered
+type funcGen struct {
+	*gen
+	fn      *ir.Function
+	index   int
+	slots   map[ir.Value]int64       // rbp-relative offset of each value
+	temps   map[*ir.PhiInst]int64    // staging slot for each phi's edge copy
+	allocas map[*ir.AllocaInst]int64 // storage of fixed-size entry allocas
+	labels  map[*ir.BasicBlock]string
+	frame   int64
+}
+
+func (g *gen) Function(fn *ir.Function, index int) error {
+	f := &funcGen{
+		gen:     g,
+		fn:      fn,
+		index:   index,
+		slots:   make(map[ir.Value]int64),
+		temps:   make(map[*ir.PhiInst]int64),
+		allocas: make(map[*ir.AllocaInst]int64),
+		labels:  make(map[*ir.BasicBlock]string),
+	}
+	if err := f.layout(); err != nil {
+		return err
+	}
+
+	name := fn.Name()
+	g.FunctionHeader(name, fn.Linkage, 4)
+	f.emit("pushq %%rbp")
+	f.emit("movq %%rsp, %%rbp")
+	if f.frame > 0 {
+		f.emit("subq $%d, %%rsp", f.frame)
+	}
+	f.storeArguments()
+
+	for _, b := range fn.Blocks {
+		g.Line("%s:\t\t\t\t# %%%s", f.labels[b], b.Name())
+		for _, inst := range b.Instructions {
+			if err := f.instruction(inst); err != nil {
+				return err
+			}
+		}
+	}
+	g.Line("\t.size %s,.-%s", name, name)
+	return nil
+}
+
+func (f *funcGen) emit(format string, args ...interface{}) {
+	f.Line("\t"+format, args...)
+}
+
+func (f *funcGen) errorf(inst ir.Instruction, format string, args ...interface{}) error {
+	return &Error{Func: f.fn, Inst: inst, Msg: fmt.Sprintf(format, args...)}
+}
+
+// layout assigns a frame slot to every argument and value. Arguments passed
+// on the stack keep their slot in the caller's frame.
+func (f *funcGen) layout() error {
+	var size int64
+	alloc := func(n, align int64) int64 {
+		size = gas.AlignTo(size+n, max(align, 8))
+		return -size
+	}
+
+	if t := f.fn.FuncType.ReturnType; t.Kind() != types.VoidKind {
+		if err := gas.CheckScalar(t); err != nil {
+			return &Error{Func: f.fn, Msg: "return type: " + err.Error()}
+		}
+	}
+	nint, nfloat, stack := 0, 0, int64(16)
+	for _, a := range f.fn.Arguments {
+		if err := gas.CheckScalar(a.Type()); err != nil {
+			return &Error{Func: f.fn, Msg: fmt.Sprintf("argument %d: %v", a.Index, err)}
+		}
+		switch {
+		case types.IsFloat(a.Type()) && nfloat < floatArgRegs:
+			nfloat++
+		case !types.IsFloat(a.Type()) && nint < len(intArgRegs):
+			nint++
+		default:
+			f.slots[a] = stack
+			stack += 8
+			continue
+		}
+		f.slots[a] = alloc(8, 8)
+	}
+
+	for i, b := range f.fn.Blocks {
+		f.labels[b] = fmt.Sprintf(".LBB%d_%d", f.index, i)
+		for _, inst := range b.Instructions {
+			if a, ok := inst.(*ir.AllocaInst); ok && i == 0 {
+				if n, ok := gas.StaticCount(a); ok {
+					elem := f.DL.SizeOf(a.AllocatedType)
+					align := max(f.DL.AlignOf(a.AllocatedType), int64(a.Alignment))
+					if align > 16 {
+						return f.errorf(inst, "alignment %d exceeds the 16-byte stack alignment", align)
+					}
+					f.allocas[a] = alloc(max(elem*n, 1), align)
+					continue
+				}
+			}
+			t := inst.Type()
+			if t == nil || t.Kind() == types.VoidKind {
+				continue
+			}
+			if err := gas.CheckType(t); err != nil {
+				return f.errorf(inst, "%v", err)
+			}
+			n := gas.AlignTo(f.DL.SizeOf(t), 8)
+			f.slots[inst] = alloc(n, f.DL.AlignOf(t))
+			if phi, ok := inst.(*ir.PhiInst); ok {
+				f.temps[phi] = alloc(n, f.DL.AlignOf(t))
+			}
+		}
+	}
+	f.frame = gas.AlignTo(size, 16)
+	return nil
+}
+
+// storeArguments moves the register arguments to their slots
+func (f *funcGen) storeArguments() {
+	nint, nfloat := 0, 0
+	for _, a := range f.fn.Arguments {
+		off := f.slots[a]
+		if off > 0 {
+			continue
+		}
+		if types.IsFloat(a.Type()) {
+			f.emit("movsd %%xmm%d, %d(%%rbp)", nfloat, off)
+			nfloat++
+		} else {
+			f.emit("movq %s, %d(%%rbp)", intArgRegs[nint].q(), off)
+			nint++
+		}
+	}
+}
+
+// ============================================================================
+// Control flow
+// ============================================================================
+
+func (f *funcGen) terminator(inst ir.Instruction) error {
+	b := inst.Parent()
+	switch i := inst.(type) {
+	case *ir.RetInst:
+		if len(i.Ops) > 0 && i.Ops[0] != nil {
+			v := i.Ops[0]
+			if types.IsFloat(v.Type()) {
+				f.loadFloat(0, v)
+			} else {
+				f.loadInt(rax, v, isSigned(v.Type()))
+			}
+		}
+		f.emit("leave")
+		f.emit("ret")
+	case *ir.BrInst:
+		gas.EdgeCopies(f, b, i.Target)
+		f.emit("jmp %s", f.labels[i.Target])
+	case *ir.CondBrInst:
+		var stubs []func()
+		t := f.EdgeLabel(f, b, i.TrueBlock, &stubs)
+		fl := f.EdgeLabel(f, b, i.FalseBlock, &stubs)
+		if c, ok := i.Condition.(*ir.ConstantInt); ok {
+			if c.Value&1 != 0 {
+				f.emit("jmp %s", t)
+			} else {
+				f.emit("jmp %s", fl)
+			}
+		} else {
+			f.emit("testb $1, %s", f.slot(i.Condition))
+			f.emit("jne %s", t)
+			f.emit("jmp %s", fl)
+		}
+		for _, stub := range stubs {
+			stub()
+		}
+	case *ir.SwitchInst:
+		var stubs []func()
+		width := intWidth(i.Condition.Type())
+		f.loadInt(rax, i.Condition, false)
+		for _, c := range i.Cases {
+			target := f.EdgeLabel(f, b, c.Block, &stubs)
+			v := truncate(uint64(c.Value.Value), width)
+			if int64(v) == int64(int32(v)) {
+				f.emit("cmpq $%d, %%rax", int64(v))
+			} else {
+				f.emit("movabsq $%d, %%rcx", int64(v))
+				f.emit("cmpq %%rcx, %%rax")
+			}
+			f.emit("je %s", target)
+		}
+		f.emit("jmp %s", f.EdgeLabel(f, b, i.DefaultBlock, &stubs))
+		for _, stub := range stubs {
+			stub()
+		}
+	case *ir.UnreachableInst:
+		f.emit("ud2")
+	}
+	return nil
+}
+
+// Label, Jump, Stage and Commit implement gas.Edges
+
+func (f *funcGen) Label(b *ir.BasicBlock) string { return f.labels[b] }
+
+func (f *funcGen) Jump(label string) { f.emit("jmp %s", label) }
+
+func (f *funcGen) Stage(phi *ir.PhiInst, v ir.Value) { f.copyValue(f.temps[phi], v) }
+
+func (f *funcGen) Commit(phi *ir.PhiInst) {
+	n := gas.AlignTo(f.DL.SizeOf(phi.Type()), 8)
+	for off := int64(0); off < n; off += 8 {
+		f.emit("movq %d(%%rbp), %%rax", f.temps[phi]+off)
+		f.emit("movq %%rax, %d(%%rbp)", f.slots[phi]+off)
+	}
+}
@@ -0,0 +1,783 @@
+package amd64
+
+import (
+	"strconv"
+
+	"github.com/arc-language/core-builder/codegen/internal/gas"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// ============================================================================
+// Operands
+// ============================================================================
+
+// slot returns the memory operand holding v
+func (f *funcGen) slot(v ir.Value) string {
+	return f.offset(f.slots[v])
+}
+
+func (f *funcGen) offset(off int64) string {
+	return itoa(off) + "(%rbp)"
+}
+
+// symbol returns the address of a global or function. Symbols defined
+// elsewhere are reached through the GOT so the code links into PIEs.
+func (f *funcGen) symbol(r reg, name string) {
+	if f.Defined[name] {
+		f.emit("leaq %s(%%rip), %s", name, r.q())
+	} else {
+		f.emit("movq %s@GOTPCREL(%%rip), %s", name, r.q())
+	}
+}
+
+// loadRaw loads the 64 bits holding v into r. Only the low bits that
+// belong to v's type are meaningful.
+func (f *funcGen) loadRaw(r reg, v ir.Value) {
+	switch c := v.(type) {
+	case *ir.ConstantInt:
+		f.loadImm(r, c.Value)
+	case *ir.ConstantFloat:
+		f.loadImm(r, int64(gas.FloatBits(c)))
+	case *ir.ConstantNull, *ir.ConstantUndef, *ir.ConstantZero:
+		f.emit("xorl %s, %s", r.l(), r.l())
+	case *ir.Global:
+		f.symbol(r, c.Name())
+	case *ir.Function:
+		f.symbol(r, c.Name())
+	case *ir.AllocaInst:
+		if off, ok := f.allocas[c]; ok {
+			f.emit("leaq %s, %s", f.offset(off), r.q())
+			return
+		}
+		f.emit("movq %s, %s", f.slot(v), r.q())
+	default:
+		f.emit("movq %s, %s", f.slot(v), r.q())
+	}
+}
+
+func (f *funcGen) loadImm(r reg, v int64) {
+	switch {
+	case v == 0:
+		f.emit("xorl %s, %s", r.l(), r.l())
+	case v == int64(int32(v)):
+		f.emit("movq $%d, %s", v, r.q())
+	default:
+		f.emit("movabsq $%d, %s", v, r.q())
+	}
+}
+
+// loadInt loads integer or pointer v into r, sign- or zero-extended from its
+// width to 64 bits
+func (f *funcGen) loadInt(r reg, v ir.Value, signed bool) {
+	width := intWidth(v.Type())
+	if c, ok := v.(*ir.ConstantInt); ok {
+		bits := truncate(uint64(c.Value), width)
+		if signed {
+			bits = uint64(signExtend(bits, width))
+		}
+		f.loadImm(r, int64(bits))
+		return
+	}
+	if _, isInst := v.(ir.Instruction); !isInst {
+		if _, isArg := v.(*ir.Argument); !isArg {
+			f.loadRaw(r, v)
+			return
+		}
+	}
+	if _, ok := f.slots[v]; !ok || width == 64 {
+		f.loadRaw(r, v)
+		return
+	}
+	f.loadMem(r, f.slot(v), width, signed)
+}
+
+// loadMem loads a width-bit integer from mem into r, extended to 64 bits
+func (f *funcGen) loadMem(r reg, mem string, width int, signed bool) {
+	switch width {
+	case 64:
+		f.emit("movq %s, %s", mem, r.q())
+	case 32:
+		if signed {
+			f.emit("movslq %s, %s", mem, r.q())
+		} else {
+			f.emit("movl %s, %s", mem, r.l())
+		}
+	case 16:
+		if signed {
+			f.emit("movswq %s, %s", mem, r.q())
+		} else {
+			f.emit("movzwl %s, %s", mem, r.l())
+		}
+	case 8:
+		if signed {
+			f.emit("movsbq %s, %s", mem, r.q())
+		} else {
+			f.emit("movzbl %s, %s", mem, r.l())
+		}
+	case 1:
+		f.emit("movzbl %s, %s", mem, r.l())
+		if signed {
+			f.emit("negq %s", r.q())
+		}
+	}
+}
+
+// storeMem stores the low bytes of r to mem
+func (f *funcGen) storeMem(r reg, mem string, size int64) {
+	switch size {
+	case 1:
+		f.emit("movb %s, %s", r.b(), mem)
+	case 2:
+		f.emit("movw %s, %s", r.w(), mem)
+	case 4:
+		f.emit("movl %s, %s", r.l(), mem)
+	default:
+		f.emit("movq %s, %s", r.q(), mem)
+	}
+}
+
+// loadFloat loads f32 or f64 v into %xmm<x>
+func (f *funcGen) loadFloat(x int, v ir.Value) {
+	switch v.(type) {
+	case *ir.ConstantFloat, *ir.ConstantInt:
+		f.loadRaw(r11, v)
+		f.emit("movq %%r11, %%xmm%d", x)
+	case *ir.ConstantUndef, *ir.ConstantZero:
+		f.emit("xorps %%xmm%d, %%xmm%d", x, x)
+	default:
+		f.emit("mov%s %s, %%xmm%d", sse(v.Type()), f.slot(v), x)
+	}
+}
+
+func (f *funcGen) storeFloat(x int, inst ir.Instruction) {
+	f.emit("mov%s %%xmm%d, %s", sse(inst.Type()), x, f.slot(inst))
+}
+
+func (f *funcGen) store(r reg, inst ir.Instruction) {
+	f.emit("movq %s, %s", r.q(), f.slot(inst))
+}
+
+// addressOf loads into r the address of aggregate v's bytes. Undef and
+// zero constants have none and report false.
+func (f *funcGen) addressOf(r reg, v ir.Value) bool {
+	switch c := v.(type) {
+	case *ir.ConstantUndef, *ir.ConstantZero:
+		return false
+	case ir.Constant:
+		f.emit("leaq %s(%%rip), %s", f.ConstLabel(c), r.q())
+	default:
+		f.emit("leaq %s, %s", f.slot(v), r.q())
+	}
+	return true
+}
+
+// copyValue copies v to the frame slot at off
+func (f *funcGen) copyValue(off int64, v ir.Value) {
+	if types.IsAggregate(v.Type()) {
+		f.emit("leaq %s, %%rdi", f.offset(off))
+		f.copyAggregate(v)
+		return
+	}
+	f.loadRaw(rax, v)
+	f.emit("movq %%rax, %s", f.offset(off))
+}
+
+// copyAggregate copies aggregate v to the address in %rdi
+func (f *funcGen) copyAggregate(v ir.Value) {
+	f.emit("movq $%d, %%rcx", f.DL.SizeOf(v.Type()))
+	if f.addressOf(rsi, v) {
+		f.emit("rep movsb")
+	} else {
+		f.emit("xorl %%eax, %%eax")
+		f.emit("rep stosb")
+	}
+}
+
+// ============================================================================
+// Instructions
+// ============================================================================
+
+func (f *funcGen) instruction(inst ir.Instruction) error {
+	if inst.IsTerminator() {
+		return f.terminator(inst)
+	}
+	switch i := inst.(type) {
+	case *ir.PhiInst:
+		// Assigned by the copies on the incoming edges
+	case *ir.BinaryInst:
+		f.binary(i)
+	case *ir.ICmpInst:
+		f.icmp(i)
+	case *ir.FCmpInst:
+		f.fcmp(i)
+	case *ir.CastInst:
+		return f.cast(i)
+	case *ir.SelectInst:
+		f.selectInst(i)
+	case *ir.AllocaInst:
+		f.alloca(i)
+	case *ir.LoadInst:
+		f.load(i)
+	case *ir.StoreInst:
+		f.storeInst(i)
+	case *ir.GetElementPtrInst:
+		f.gep(i)
+	case *ir.ExtractValueInst:
+		return f.extractValue(i)
+	case *ir.InsertValueInst:
+		return f.insertValue(i)
+	case *ir.CallInst:
+		return f.call(i)
+	case *ir.SyscallInst:
+		return f.syscall(i)
+	default:
+		return f.errorf(inst, "unsupported instruction")
+	}
+	return nil
+}
+
+func (f *funcGen) binary(i *ir.BinaryInst) {
+	lhs, rhs := i.Ops[0], i.Ops[1]
+	if types.IsFloat(i.Type()) {
+		if i.Op == ir.OpFRem {
+			f.loadFloat(0, lhs)
+			f.loadFloat(1, rhs)
+			name := "fmod"
+			if sse(i.Type()) == "ss" {
+				name = "fmodf"
+			}
+			f.emit("call %s", f.callTarget(name))
+			f.storeFloat(0, i)
+			return
+		}
+		ops := map[ir.Opcode]string{ir.OpFAdd: "add", ir.OpFSub: "sub", ir.OpFMul: "mul", ir.OpFDiv: "div"}
+		f.loadFloat(0, lhs)
+		f.loadFloat(1, rhs)
+		f.emit("%s%s %%xmm1, %%xmm0", ops[i.Op], sse(i.Type()))
+		f.storeFloat(0, i)
+		return
+	}
+
+	switch i.Op {
+	case ir.OpUDiv, ir.OpURem, ir.OpLShr:
+		f.loadInt(rax, lhs, false)
+		f.loadInt(rcx, rhs, false)
+	case ir.OpSDiv, ir.OpSRem, ir.OpAShr:
+		f.loadInt(rax, lhs, true)
+		f.loadInt(rcx, rhs, true)
+	default:
+		f.loadRaw(rax, lhs)
+		f.loadRaw(rcx, rhs)
+	}
+	switch i.Op {
+	case ir.OpAdd:
+		f.emit("addq %%rcx, %%rax")
+	case ir.OpSub:
+		f.emit("subq %%rcx, %%rax")
+	case ir.OpMul:
+		f.emit("imulq %%rcx, %%rax")
+	case ir.OpAnd:
+		f.emit("andq %%rcx, %%rax")
+	case ir.OpOr:
+		f.emit("orq %%rcx, %%rax")
+	case ir.OpXor:
+		f.emit("xorq %%rcx, %%rax")
+	case ir.OpShl:
+		f.emit("shlq %%cl, %%rax")
+	case ir.OpLShr:
+		f.emit("shrq %%cl, %%rax")
+	case ir.OpAShr:
+		f.emit("sarq %%cl, %%rax")
+	case ir.OpUDiv, ir.OpURem:
+		f.emit("xorl %%edx, %%edx")
+		f.emit("divq %%rcx")
+	case ir.OpSDiv, ir.OpSRem:
+		f.emit("cqto")
+		f.emit("idivq %%rcx")
+	}
+	if i.Op == ir.OpURem || i.Op == ir.OpSRem {
+		f.emit("movq %%rdx, %%rax")
+	}
+	if intWidth(i.Type()) == 1 {
+		f.emit("andl $1, %%eax")
+	}
+	f.store(rax, i)
+}
+
+var icmpCodes = map[ir.ICmpPredicate]string{
+	ir.ICmpEQ: "e", ir.ICmpNE: "ne",
+	ir.ICmpUGT: "a", ir.ICmpUGE: "ae", ir.ICmpULT: "b", ir.ICmpULE: "be",
+	ir.ICmpSGT: "g", ir.ICmpSGE: "ge", ir.ICmpSLT: "l", ir.ICmpSLE: "le",
+}
+
+func (f *funcGen) icmp(i *ir.ICmpInst) {
+	signed := false
+	switch i.Predicate {
+	case ir.ICmpSGT, ir.ICmpSGE, ir.ICmpSLT, ir.ICmpSLE:
+		signed = true
+	}
+	f.loadInt(rax, i.Ops[0], signed)
+	f.loadInt(rcx, i.Ops[1], signed)
+	f.emit("cmpq %%rcx, %%rax")
+	f.emit("set%s %%al", icmpCodes[i.Predicate])
+	f.emit("movzbl %%al, %%eax")
+	f.store(rax, i)
+}
+
+func (f *funcGen) fcmp(i *ir.FCmpInst) {
+	f.loadFloat(0, i.Ops[0])
+	f.loadFloat(1, i.Ops[1])
+	s := sse(i.Ops[0].Type())
+
+	// Unordered predicates are the negation of the opposite ordered one
+	pred, negate := i.Predicate, false
+	if inv, ok := unorderedInverse[pred]; ok {
+		pred, negate = inv, true
+	}
+	switch pred {
+	case ir.FCmpFalse:
+		f.emit("xorl %%eax, %%eax")
+	case ir.FCmpTrue:
+		f.emit("movl $1, %%eax")
+	case ir.FCmpOEQ, ir.FCmpONE:
+		f.emit("ucomi%s %%xmm1, %%xmm0", s)
+		if pred == ir.FCmpOEQ {
+			f.emit("sete %%al")
+		} else {
+			f.emit("setne %%al")
+		}
+		f.emit("setnp %%cl")
+		f.emit("andb %%cl, %%al")
+	case ir.FCmpOGT, ir.FCmpOGE:
+		f.emit("ucomi%s %%xmm1, %%xmm0", s)
+		f.emit("set%s %%al", map[bool]string{true: "a", false: "ae"}[pred == ir.FCmpOGT])
+	case ir.FCmpOLT, ir.FCmpOLE:
+		f.emit("ucomi%s %%xmm0, %%xmm1", s)
+		f.emit("set%s %%al", map[bool]string{true: "a", false: "ae"}[pred == ir.FCmpOLT])
+	case ir.FCmpORD, ir.FCmpUNO:
+		f.emit("ucomi%s %%xmm1, %%xmm0", s)
+		f.emit("set%s %%al", map[bool]string{true: "np", false: "p"}[pred == ir.FCmpORD])
+	}
+	if negate {
+		f.emit("xorb $1, %%al")
+	}
+	f.emit("movzbl %%al, %%eax")
+	f.store(rax, i)
+}
+
+var unorderedInverse = map[ir.FCmpPredicate]ir.FCmpPredicate{
+	ir.FCmpUEQ: ir.FCmpONE, ir.FCmpUNE: ir.FCmpOEQ,
+	ir.FCmpUGT: ir.FCmpOLE, ir.FCmpUGE: ir.FCmpOLT,
+	ir.FCmpULT: ir.FCmpOGE, ir.FCmpULE: ir.FCmpOGT,
+}
+
+func (f *funcGen) cast(i *ir.CastInst) error {
+	v := i.Ops[0]
+	src, dst := v.Type(), i.Type()
+	switch i.Op {
+	case ir.OpTrunc:
+		f.loadRaw(rax, v)
+		if intWidth(dst) == 1 {
+			f.emit("andl $1, %%eax")
+		}
+		f.store(rax, i)
+	case ir.OpZExt:
+		f.loadInt(rax, v, false)
+		f.store(rax, i)
+	case ir.OpSExt:
+		f.loadInt(rax, v, true)
+		f.store(rax, i)
+	case ir.OpFPTrunc, ir.OpFPExt:
+		f.loadFloat(0, v)
+		if sse(src) != sse(dst) {
+			f.emit("cvt%s2%s %%xmm0, %%xmm0", sse(src), sse(dst))
+		}
+		f.storeFloat(0, i)
+	case ir.OpFPToSI:
+		f.loadFloat(0, v)
+		f.emit("cvtt%s2siq %%xmm0, %%rax", sse(src))
+		f.store(rax, i)
+	case ir.OpFPToUI:
+		f.loadFloat(0, v)
+		if intWidth(dst) < 64 {
+			f.emit("cvtt%s2siq %%xmm0, %%rax", sse(src))
+			f.store(rax, i)
+			return nil
+		}
+		// Values from 2^63 up are converted after subtracting 2^63
+		big, done := f.NewLabel(), f.NewLabel()
+		if sse(src) == "ss" {
+			f.emit("movl $0x5f000000, %%r11d")
+		} else {
+			f.emit("movabsq $0x43e0000000000000, %%r11")
+		}
+		f.emit("movq %%r11, %%xmm1")
+		f.emit("ucomi%s %%xmm1, %%xmm0", sse(src))
+		f.emit("jae %s", big)
+		f.emit("cvtt%s2siq %%xmm0, %%rax", sse(src))
+		f.emit("jmp %s", done)
+		f.Line("%s:", big)
+		f.emit("sub%s %%xmm1, %%xmm0", sse(src))
+		f.emit("cvtt%s2siq %%xmm0, %%rax", sse(src))
+		f.emit("btcq $63, %%rax")
+		f.Line("%s:", done)
+		f.store(rax, i)
+	case ir.OpSIToFP:
+		f.loadInt(rax, v, true)
+		f.emit("cvtsi2%sq %%rax, %%xmm0", sse(dst))
+		f.storeFloat(0, i)
+	case ir.OpUIToFP:
+		f.loadInt(rax, v, false)
+		if intWidth(src) < 64 {
+			f.emit("cvtsi2%sq %%rax, %%xmm0", sse(dst))
+			f.storeFloat(0, i)
+			return nil
+		}
+		// Values with the top bit set are halved, keeping the low bit for
+		// rounding, converted and doubled
+		neg, done := f.NewLabel(), f.NewLabel()
+		f.emit("testq %%rax, %%rax")
+		f.emit("js %s", neg)
+		f.emit("cvtsi2%sq %%rax, %%xmm0", sse(dst))
+		f.emit("jmp %s", done)
+		f.Line("%s:", neg)
+		f.emit("movq %%rax, %%rcx")
+		f.emit("shrq %%rcx")
+		f.emit("andl $1, %%eax")
+		f.emit("orq %%rax, %%rcx")
+		f.emit("cvtsi2%sq %%rcx, %%xmm0", sse(dst))
+		f.emit("add%s %%xmm0, %%xmm0", sse(dst))
+		f.Line("%s:", done)
+		f.storeFloat(0, i)
+	case ir.OpPtrToInt, ir.OpIntToPtr, ir.OpBitcast:
+		if types.IsAggregate(src) || types.IsAggregate(dst) {
+			return f.errorf(i, "cannot bitcast aggregate values")
+		}
+		f.loadRaw(rax, v)
+		f.store(rax, i)
+	default:
+		return f.errorf(i, "unsupported cast")
+	}
+	return nil
+}
+
+func (f *funcGen) selectInst(i *ir.SelectInst) {
+	cond, t, fv := i.Ops[0], i.Ops[1], i.Ops[2]
+	if types.IsAggregate(i.Type()) {
+		other, done := f.NewLabel(), f.NewLabel()
+		f.loadRaw(rax, cond)
+		f.emit("testb $1, %%al")
+		f.emit("je %s", other)
+		f.copyValue(f.slots[i], t)
+		f.emit("jmp %s", done)
+		f.Line("%s:", other)
+		f.copyValue(f.slots[i], fv)
+		f.Line("%s:", done)
+		return
+	}
+	f.loadRaw(rax, fv)
+	f.loadRaw(rcx, t)
+	f.loadRaw(rdx, cond)
+	f.emit("testb $1, %%dl")
+	f.emit("cmovneq %%rcx, %%rax")
+	f.store(rax, i)
+}
+
+// alloca handles the allocas the frame layout could not place: those with
+// a variable count or outside the entry block. They grow the stack in
+// 16-byte steps, which keeps calls aligned.
+func (f *funcGen) alloca(i *ir.AllocaInst) {
+	if _, ok := f.allocas[i]; ok {
+		return
+	}
+	elem := f.DL.SizeOf(i.AllocatedType)
+	if i.NumElements != nil {
+		f.loadInt(rax, i.NumElements, false)
+		f.emit("imulq $%d, %%rax, %%rax", elem)
+	} else {
+		f.loadImm(rax, elem)
+	}
+	f.emit("addq $15, %%rax")
+	f.emit("andq $-16, %%rax")
+	f.emit("subq %%rax, %%rsp")
+	f.emit("movq %%rsp, %s", f.slot(i))
+}
+
+func (f *funcGen) load(i *ir.LoadInst) {
+	t := i.Type()
+	f.loadRaw(rax, i.Ops[0])
+	if types.IsAggregate(t) {
+		f.emit("movq %%rax, %%rsi")
+		f.emit("leaq %s, %%rdi", f.slot(i))
+		f.emit("movq $%d, %%rcx", f.DL.SizeOf(t))
+		f.emit("rep movsb")
+		return
+	}
+	f.loadMem(rcx, "(%rax)", int(f.DL.StoreSize(t)*8), false)
+	if intWidth(t) == 1 {
+		f.emit("andl $1, %%ecx")
+	}
+	f.store(rcx, i)
+}
+
+func (f *funcGen) storeInst(i *ir.StoreInst) {
+	val, ptr := i.Ops[0], i.Ops[1]
+	if types.IsAggregate(val.Type()) {
+		f.loadRaw(rdi, ptr)
+		f.copyAggregate(val)
+		return
+	}
+	f.loadRaw(rcx, val)
+	f.loadRaw(rax, ptr)
+	f.storeMem(rcx, "(%rax)", f.DL.StoreSize(val.Type()))
+}
+
+func (f *funcGen) gep(i *ir.GetElementPtrInst) {
+	f.loadRaw(rax, i.Ops[0])
+	t := i.SourceElementType
+	var off int64
+	for n, idx := range i.Ops[1:] {
+		var stride int64
+		if n == 0 {
+			stride = f.DL.SizeOf(t)
+		} else {
+			switch ty := t.(type) {
+			case *types.StructType:
+				field := idx.(*ir.ConstantInt).Value
+				off += f.DL.FieldOffset(ty, int(field))
+				t = ty.Fields[field]
+				continue
+			case *types.ArrayType:
+				t = ty.ElementType
+			case *types.VectorType:
+				t = ty.ElementType
+			}
+			stride = f.DL.SizeOf(t)
+		}
+		if c, ok := idx.(*ir.ConstantInt); ok {
+			off += signExtend(truncate(uint64(c.Value), intWidth(c.Type())), intWidth(c.Type())) * stride
+			continue
+		}
+		f.loadInt(rcx, idx, true)
+		if stride != 1 {
+			f.emit("imulq $%d, %%rcx, %%rcx", stride)
+		}
+		f.emit("addq %%rcx, %%rax")
+	}
+	if off != 0 {
+		if off == int64(int32(off)) {
+			f.emit("addq $%d, %%rax", off)
+		} else {
+			f.emit("movabsq $%d, %%rcx", off)
+			f.emit("addq %%rcx, %%rax")
+		}
+	}
+	f.store(rax, i)
+}
+
+// memberOffset returns the byte offset and type of the member of an
+// aggregate of type t selected by indices
+func (f *funcGen) memberOffset(t types.Type, indices []int) (int64, types.Type) {
+	var off int64
+	for _, idx := range indices {
+		switch ty := t.(type) {
+		case *types.StructType:
+			off += f.DL.FieldOffset(ty, idx)
+			t = ty.Fields[idx]
+		case *types.ArrayType:
+			t = ty.ElementType
+			off += int64(idx) * f.DL.SizeOf(t)
+		}
+	}
+	return off, t
+}
+
+func (f *funcGen) extractValue(i *ir.ExtractValueInst) error {
+	agg := i.Ops[0]
+	if c, ok := agg.(ir.Constant); ok {
+		// Constant aggregates are folded to the selected member
+		m := memberConstant(c, i.Indices, i.Type())
+		f.copyValue(f.slots[i], m)
+		return nil
+	}
+	off, t := f.memberOffset(agg.Type(), i.Indices)
+	src := f.slots[agg] + off
+	if types.IsAggregate(t) {
+		f.emit("leaq %s, %%rsi", f.offset(src))
+		f.emit("leaq %s, %%rdi", f.slot(i))
+		f.emit("movq $%d, %%rcx", f.DL.SizeOf(t))
+		f.emit("rep movsb")
+		return nil
+	}
+	f.loadMem(rax, f.offset(src), int(f.DL.StoreSize(t)*8), false)
+	f.store(rax, i)
+	return nil
+}
+
+func (f *funcGen) insertValue(i *ir.InsertValueInst) error {
+	agg, val := i.Ops[0], i.Ops[1]
+	f.copyValue(f.slots[i], agg)
+	off, t := f.memberOffset(agg.Type(), i.Indices)
+	dst := f.slots[i] + off
+	if types.IsAggregate(t) {
+		f.emit("leaq %s, %%rdi", f.offset(dst))
+		f.copyAggregate(val)
+		return nil
+	}
+	f.loadRaw(rax, val)
+	f.storeMem(rax, f.offset(dst), f.DL.StoreSize(t))
+	return nil
+}
+
+// memberConstant returns the member of constant c selected by indices
+func memberConstant(c ir.Constant, indices []int, t types.Type) ir.Value {
+	for _, idx := range indices {
+		switch cc := c.(type) {
+		case *ir.ConstantStruct:
+			c = cc.Fields[idx]
+		case *ir.ConstantArray:
+			c = cc.Elements[idx]
+		default:
+			z := &ir.ConstantZero{}
+			z.SetType(t)
+			return z
+		}
+	}
+	return c
+}
+
+// ============================================================================
+// Calls
+// ============================================================================
+
+// callTarget returns the operand of a call to the named function
+func (f *funcGen) callTarget(name string) string {
+	if f.Defined[name] {
+		return name
+	}
+	return name + "@PLT"
+}
+
+func (f *funcGen) call(i *ir.CallInst) error {
+	name := i.CalleeName
+	if i.Callee != nil {
+		name = i.Callee.Name()
+	}
+	var args []ir.Value
+	for _, a := range i.Ops {
+		if a != nil {
+			args = append(args, a)
+		}
+	}
+
+	// Classify the arguments: the first six integers and eight floats go in
+	// registers, the rest on the stack in order
+	var ints, floats, stack []ir.Value
+	for _, a := range args {
+		if err := gas.CheckScalar(a.Type()); err != nil {
+			return f.errorf(i, "%v", err)
+		}
+		switch {
+		case types.IsFloat(a.Type()) && len(floats) < floatArgRegs:
+			floats = append(floats, a)
+		case !types.IsFloat(a.Type()) && len(ints) < len(intArgRegs):
+			ints = append(ints, a)
+		default:
+			stack = append(stack, a)
+		}
+	}
+	if t := i.Type(); t != nil && t.Kind() != types.VoidKind {
+		if err := gas.CheckScalar(t); err != nil {
+			return f.errorf(i, "%v", err)
+		}
+	}
+
+	stackSize := gas.AlignTo(int64(len(stack))*8, 16)
+	if stackSize > 0 {
+		f.emit("subq $%d, %%rsp", stackSize)
+		for n, a := range stack {
+			f.loadInt(rax, a, isSigned(a.Type()))
+			f.emit("movq %%rax, %d(%%rsp)", n*8)
+		}
+	}
+	for n, a := range floats {
+		f.loadFloat(n, a)
+	}
+	for n, a := range ints {
+		f.loadInt(intArgRegs[n], a, isSigned(a.Type()))
+	}
+	// Variadic callees read the number of vector registers used from %al
+	f.emit("movl $%d, %%eax", len(floats))
+	f.emit("call %s", f.callTarget(name))
+	if stackSize > 0 {
+		f.emit("addq $%d, %%rsp", stackSize)
+	}
+
+	if t := i.Type(); t != nil && t.Kind() != types.VoidKind {
+		if types.IsFloat(t) {
+			f.storeFloat(0, i)
+		} else {
+			f.store(rax, i)
+		}
+	}
+	return nil
+}
+
+// syscall passes the number in %rax and up to six arguments in the Linux
+// syscall registers
+func (f *funcGen) syscall(i *ir.SyscallInst) error {
+	regs := []reg{rax, rdi, rsi, rdx, r10, r8, r9}
+	if len(i.Ops) == 0 || len(i.Ops) > len(regs) {
+		return f.errorf(i, "syscall takes a number and up to 6 arguments")
+	}
+	for n := len(i.Ops) - 1; n >= 0; n-- {
+		f.loadInt(regs[n], i.Ops[n], isSigned(i.Ops[n].Type()))
+	}
+	f.emit("syscall")
+	f.store(rax, i)
+	return nil
+}
+
+// ============================================================================
+// Helpers
+// ============================================================================
+
+// intWidth returns the bit width of an integer type; pointers are 64 bits
+func intWidth(t types.Type) int {
+	if it, ok := t.(*types.IntType); ok {
+		return it.BitWidth
+	}
+	return 64
+}
+
+// isSigned reports whether values of t are sign-extended when widened for
+// calls and returns. Booleans are zero-extended.
+func isSigned(t types.Type) bool {
+	it, ok := t.(*types.IntType)
+	return ok && it.Signed && it.BitWidth > 1
+}
+
+// sse returns the SSE instruction suffix for a float type
+func sse(t types.Type) string {
+	if ft, ok := t.(*types.FloatType); ok && ft.BitWidth == 32 {
+		return "ss"
+	}
+	return "sd"
+}
+
+func truncate(bits uint64, width int) uint64 {
+	if width >= 64 {
+		return bits
+	}
+	return bits & (1<<uint(width) - 1)
+}
+
+func signExtend(bits uint64, width int) int64 {
+	shift := uint(64 - width)
+	return int64(bits<<shift) >> shift
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,42 @@
+	.text
+
+	.globl factorial
+	.p2align 4
+	.type factorial,@function
+factorial:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $48, %rsp
+	movq %rdi, -8(%rbp)
+.LBB0_0:				# %entry
+	movslq -8(%rbp), %rax
+	movq $1, %rcx
+	cmpq %rcx, %rax
+	setle %al
+	movzbl %al, %eax
+	movq %rax, -16(%rbp)
+	testb $1, -16(%rbp)
+	jne .LBB0_1
+	jmp .LBB0_2
+.LBB0_1:				# %then
+	movq $1, %rax
+	leave
+	ret
+.LBB0_2:				# %else
+	movq -8(%rbp), %rax
+	movq $1, %rcx
+	subq %rcx, %rax
+	movq %rax, -24(%rbp)
+	movslq -24(%rbp), %rdi
+	movl $0, %eax
+	call factorial
+	movq %rax, -32(%rbp)
+	movq -8(%rbp), %rax
+	movq -32(%rbp), %rcx
+	imulq %rcx, %rax
+	movq %rax, -40(%rbp)
+	movslq -40(%rbp), %rax
+	leave
+	ret
+	.size factorial,.-factorial
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,50 @@
+	.text
+
+	.globl fib
+	.p2align 4
+	.type fib,@function
+fib:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $64, %rsp
+	movq %rdi, -8(%rbp)
+.LBB0_0:				# %entry
+	movslq -8(%rbp), %rax
+	movq $2, %rcx
+	cmpq %rcx, %rax
+	setl %al
+	movzbl %al, %eax
+	movq %rax, -16(%rbp)
+	testb $1, -16(%rbp)
+	jne .LBB0_1
+	jmp .LBB0_2
+.LBB0_1:				# %base_case
+	movslq -8(%rbp), %rax
+	leave
+	ret
+.LBB0_2:				# %recurse
+	movq -8(%rbp), %rax
+	movq $1, %rcx
+	subq %rcx, %rax
+	movq %rax, -24(%rbp)
+	movslq -24(%rbp), %rdi
+	movl $0, %eax
+	call fib
+	movq %rax, -32(%rbp)
+	movq -8(%rbp), %rax
+	movq $2, %rcx
+	subq %rcx, %rax
+	movq %rax, -40(%rbp)
+	movslq -40(%rbp), %rdi
+	movl $0, %eax
+	call fib
+	movq %rax, -48(%rbp)
+	movq -32(%rbp), %rax
+	movq -48(%rbp), %rcx
+	addq %rcx, %rax
+	movq %rax, -56(%rbp)
+	movslq -56(%rbp), %rax
+	leave
+	ret
+	.size fib,.-fib
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,53 @@
+	.text
+
+	.globl gcd
+	.p2align 4
+	.type gcd,@function
+gcd:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $64, %rsp
+	movq %rdi, -8(%rbp)
+	movq %rsi, -16(%rbp)
+.LBB0_0:				# %entry
+	movq -8(%rbp), %rax
+	movq %rax, -32(%rbp)
+	movq -16(%rbp), %rax
+	movq %rax, -48(%rbp)
+	movq -32(%rbp), %rax
+	movq %rax, -24(%rbp)
+	movq -48(%rbp), %rax
+	movq %rax, -40(%rbp)
+	jmp .LBB0_1
+.LBB0_1:				# %loop.head
+	movl -40(%rbp), %eax
+	xorl %ecx, %ecx
+	cmpq %rcx, %rax
+	setne %al
+	movzbl %al, %eax
+	movq %rax, -56(%rbp)
+	testb $1, -56(%rbp)
+	jne .LBB0_2
+	jmp .LBB0_3
+.LBB0_2:				# %loop.body
+	movslq -24(%rbp), %rax
+	movslq -40(%rbp), %rcx
+	cqto
+	idivq %rcx
+	movq %rdx, %rax
+	movq %rax, -64(%rbp)
+	movq -40(%rbp), %rax
+	movq %rax, -32(%rbp)
+	movq -64(%rbp), %rax
+	movq %rax, -48(%rbp)
+	movq -32(%rbp), %rax
+	movq %rax, -24(%rbp)
+	movq -48(%rbp), %rax
+	movq %rax, -40(%rbp)
+	jmp .LBB0_1
+.LBB0_3:				# %exit
+	movslq -24(%rbp), %rax
+	leave
+	ret
+	.size gcd,.-gcd
+	.section .note.GNU-stack,"",@progbits
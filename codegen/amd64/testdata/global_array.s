@@ -0,0 +1,30 @@
+	.text
+
+	.globl main
+	.p2align 4
+	.type main,@function
+main:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $32, %rsp
+.LBB0_0:				# %entry
+	leaq g_val(%rip), %rax
+	movl (%rax), %ecx
+	movq %rcx, -8(%rbp)
+	leaq -16(%rbp), %rax
+	movq %rax, -24(%rbp)
+	movq -8(%rbp), %rcx
+	movq -24(%rbp), %rax
+	movl %ecx, (%rax)
+	movslq -8(%rbp), %rax
+	leave
+	ret
+	.size main,.-main
+	.data
+	.globl g_val
+	.p2align 2
+	.type g_val,@object
+	.size g_val,4
+g_val:
+	.long 42
+	.section .note.GNU-stack,"",@progbits
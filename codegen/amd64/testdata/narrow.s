@@ -0,0 +1,64 @@
+	.text
+
+	.globl wrap
+	.p2align 4
+	.type wrap,@function
+wrap:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $32, %rsp
+	movq %rdi, -8(%rbp)
+	movq %rsi, -16(%rbp)
+.LBB0_0:				# %entry
+	movq -8(%rbp), %rax
+	movq $3, %rcx
+	imulq %rcx, %rax
+	movq %rax, -24(%rbp)
+	movq -24(%rbp), %rax
+	movq -16(%rbp), %rcx
+	addq %rcx, %rax
+	movq %rax, -32(%rbp)
+	movsbq -32(%rbp), %rax
+	leave
+	ret
+	.size wrap,.-wrap
+
+	.globl twice
+	.p2align 4
+	.type twice,@function
+twice:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $16, %rsp
+	movq %rdi, -8(%rbp)
+.LBB1_0:				# %entry
+	movq -8(%rbp), %rax
+	movq -8(%rbp), %rcx
+	addq %rcx, %rax
+	movq %rax, -16(%rbp)
+	movzbl -16(%rbp), %eax
+	leave
+	ret
+	.size twice,.-twice
+
+	.globl widen
+	.p2align 4
+	.type widen,@function
+widen:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $32, %rsp
+	movq %rdi, -8(%rbp)
+.LBB2_0:				# %entry
+	movsbq -8(%rbp), %rdi
+	movq $1, %rsi
+	movl $0, %eax
+	call wrap
+	movq %rax, -16(%rbp)
+	movsbq -16(%rbp), %rax
+	movq %rax, -24(%rbp)
+	movslq -24(%rbp), %rax
+	leave
+	ret
+	.size widen,.-widen
+	.section .note.GNU-stack,"",@progbits
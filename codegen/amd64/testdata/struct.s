@@ -0,0 +1,22 @@
+	.text
+
+	.globl update_y
+	.p2align 4
+	.type update_y,@function
+update_y:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $32, %rsp
+	movq %rdi, -8(%rbp)
+	movq %rsi, -16(%rbp)
+.LBB0_0:				# %entry
+	movq -8(%rbp), %rax
+	addq $4, %rax
+	movq %rax, -24(%rbp)
+	movq -16(%rbp), %rcx
+	movq -24(%rbp), %rax
+	movl %ecx, (%rax)
+	leave
+	ret
+	.size update_y,.-update_y
+	.section .note.GNU-stack,"",@progbits
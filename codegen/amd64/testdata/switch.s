@@ -0,0 +1,41 @@
+	.text
+
+	.globl classify
+	.p2align 4
+	.type classify,@function
+classify:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $32, %rsp
+	movq %rdi, -8(%rbp)
+.LBB0_0:				# %entry
+	movl -8(%rbp), %eax
+	cmpq $0, %rax
+	je .LBB0_1
+	cmpq $1, %rax
+	je .LBB0_2
+	jmp .LBB0_3
+.LBB0_1:				# %case_zero
+	movq $100, %rax
+	movq %rax, -24(%rbp)
+	movq -24(%rbp), %rax
+	movq %rax, -16(%rbp)
+	jmp .LBB0_4
+.LBB0_2:				# %case_one
+	movq $200, %rax
+	movq %rax, -24(%rbp)
+	movq -24(%rbp), %rax
+	movq %rax, -16(%rbp)
+	jmp .LBB0_4
+.LBB0_3:				# %default
+	movq $-1, %rax
+	movq %rax, -24(%rbp)
+	movq -24(%rbp), %rax
+	movq %rax, -16(%rbp)
+	jmp .LBB0_4
+.LBB0_4:				# %merge
+	movslq -16(%rbp), %rax
+	leave
+	ret
+	.size classify,.-classify
+	.section .note.GNU-stack,"",@progbits
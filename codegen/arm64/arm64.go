@@ -0,0 +1,50 @@
+// Package arm64 lowers IR modules to AArch64 assembly in GNU assembler
+// syntax, following the AAPCS64 procedure call standard as used on Linux.
+//
+// Like the amd64 backend, it keeps every SSA value in a stack slot of its
+// function's frame and resolves phis by copies on the incoming edges.
+// Integers of 1, 8, 16, 32 and 64 bits, pointers, f32 and f64 are
+// supported, as are struct and array values in memory. Aggregate arguments
+// and return values, vectors and va_start/va_arg are not.
+package arm64
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/arc-language/core-builder/codegen/internal/gas"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// Error reports an instruction or function the backend cannot lower
+type Error struct {
+	Func *ir.Function
+	Inst ir.Instruction
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Inst != nil {
+		return fmt.Sprintf("arm64: @%s: %s: %s", e.Func.Name(), e.Inst, e.Msg)
+	}
+	if e.Func != nil {
+		return fmt.Sprintf("arm64: @%s: %s", e.Func.Name(), e.Msg)
+	}
+	return "arm64: " + e.Msg
+}
+
+// Generate writes the assembly for m to w. Functions with a body become
+// code in .text; global variables go to .data, constants to .rodata and
+// zero-initialized globals to .bss. Declarations are left to the linker.
+func Generate(w io.Writer, m *ir.Module) error {
+	return gas.Generate(w, m, moduleError, func(mod *gas.Module) gas.Target { return &gen{mod} })
+}
+
+func moduleError(format string, args ...interface{}) error {
+	return &Error{Msg: fmt.Sprintf(format, args...)}
+}
+
+// gen lowers the functions of one module
+type gen struct {
+	*gas.Module
+}
@@ -0,0 +1,270 @@
+package arm64
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/codegen/internal/gas"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// reg is a general purpose register number. Code uses x0-x8 for arguments
+// and the syscall number, x9-x12 as scratch and x16 to form addresses; none
+// of them is callee-saved.
+type reg int
+
+const (
+	x0  reg = 0
+	x8  reg = 8
+	x9  reg = 9
+	x10 reg = 10
+	x11 reg = 11
+	x12 reg = 12
+	x16 reg = 16
+)
+
+func (r reg) x() string { return fmt.Sprintf("x%d", int(r)) }
+func (r reg) w() string { return fmt.Sprintf("w%d", int(r)) }
+
+// AAPCS64 passes the first eight integer and eight floating point
+// arguments in registers
+const (
+	intArgRegs   = 8
+	floatArgRegs = 8
+)
+
+// frameRecord is the size of the saved x29/x30 pair at the bottom of the frame
+const frameRecord = 16
+
+// funcGen holds the state of one function being lowered
+type funcGen struct {
+	*gen
+	fn      *ir.Function
+	index   int
+	slots   map[ir.Value]int64       // x29-relative offset of each value
+	temps   map[*ir.PhiInst]int64    // staging slot for each phi's edge copy
+	allocas map[*ir.AllocaInst]int64 // storage of fixed-size entry allocas
+	labels  map[*ir.BasicBlock]string
+	frame   int64
+}
+
+func (g *gen) Function(fn *ir.Function, index int) error {
+	f := &funcGen{
+		gen:     g,
+		fn:      fn,
+		index:   index,
+		slots:   make(map[ir.Value]int64),
+		temps:   make(map[*ir.PhiInst]int64),
+		allocas: make(map[*ir.AllocaInst]int64),
+		labels:  make(map[*ir.BasicBlock]string),
+	}
+	if err := f.layout(); err != nil {
+		return err
+	}
+
+	name := fn.Name()
+	g.FunctionHeader(name, fn.Linkage, 2)
+	f.adjustSP("sub", f.frame)
+	f.emit("stp x29, x30, [sp]")
+	f.emit("mov x29, sp")
+	f.storeArguments()
+
+	for _, b := range fn.Blocks {
+		g.Line("%s:\t\t\t\t// %%%s", f.labels[b], b.Name())
+		for _, inst := range b.Instructions {
+			if err := f.instruction(inst); err != nil {
+				return err
+			}
+		}
+	}
+	g.Line("\t.size %s,.-%s", name, name)
+	return nil
+}
+
+func (f *funcGen) emit(format string, args ...interface{}) {
+	f.Line("\t"+format, args...)
+}
+
+func (f *funcGen) errorf(inst ir.Instruction, format string, args ...interface{}) error {
+	return &Error{Func: f.fn, Inst: inst, Msg: fmt.Sprintf(format, args...)}
+}
+
+// adjustSP adds or subtracts n from sp, splitting immediates that do not fit
+// in 12 bits
+func (f *funcGen) adjustSP(op string, n int64) {
+	if hi := n >> 12; hi > 0 {
+		f.emit("%s sp, sp, #%d, lsl #12", op, hi)
+	}
+	if lo := n & 0xfff; lo > 0 {
+		f.emit("%s sp, sp, #%d", op, lo)
+	}
+}
+
+// layout assigns a frame slot to every argument and value. The frame record
+// sits at the bottom of the frame, where x29 points, and slots are above it.
+// Arguments passed on the stack keep their slot in the caller's frame.
+func (f *funcGen) layout() error {
+	size := int64(frameRecord)
+	alloc := func(n, align int64) int64 {
+		off := gas.AlignTo(size, max(align, 8))
+		size = off + n
+		return off
+	}
+
+	if t := f.fn.FuncType.ReturnType; t.Kind() != types.VoidKind {
+		if err := gas.CheckScalar(t); err != nil {
+			return &Error{Func: f.fn, Msg: "return type: " + err.Error()}
+		}
+	}
+	var stackArgs []*ir.Argument
+	nint, nfloat := 0, 0
+	for _, a := range f.fn.Arguments {
+		if err := gas.CheckScalar(a.Type()); err != nil {
+			return &Error{Func: f.fn, Msg: fmt.Sprintf("argument %d: %v", a.Index, err)}
+		}
+		switch {
+		case types.IsFloat(a.Type()) && nfloat < floatArgRegs:
+			nfloat++
+		case !types.IsFloat(a.Type()) && nint < intArgRegs:
+			nint++
+		default:
+			stackArgs = append(stackArgs, a)
+			continue
+		}
+		f.slots[a] = alloc(8, 8)
+	}
+
+	for i, b := range f.fn.Blocks {
+		f.labels[b] = fmt.Sprintf(".LBB%d_%d", f.index, i)
+		for _, inst := range b.Instructions {
+			if a, ok := inst.(*ir.AllocaInst); ok && i == 0 {
+				if n, ok := gas.StaticCount(a); ok {
+					elem := f.DL.SizeOf(a.AllocatedType)
+					align := max(f.DL.AlignOf(a.AllocatedType), int64(a.Alignment))
+					if align > 16 {
+						return f.errorf(inst, "alignment %d exceeds the 16-byte stack alignment", align)
+					}
+					f.allocas[a] = alloc(max(elem*n, 1), align)
+					continue
+				}
+			}
+			t := inst.Type()
+			if t == nil || t.Kind() == types.VoidKind {
+				continue
+			}
+			if err := gas.CheckType(t); err != nil {
+				return f.errorf(inst, "%v", err)
+			}
+			n := gas.AlignTo(f.DL.SizeOf(t), 8)
+			f.slots[inst] = alloc(n, f.DL.AlignOf(t))
+			if phi, ok := inst.(*ir.PhiInst); ok {
+				f.temps[phi] = alloc(n, f.DL.AlignOf(t))
+			}
+		}
+	}
+	f.frame = gas.AlignTo(size, 16)
+	for k, a := range stackArgs {
+		f.slots[a] = f.frame + int64(k)*8
+	}
+	return nil
+}
+
+// storeArguments moves the register arguments to their slots
+func (f *funcGen) storeArguments() {
+	nint, nfloat := 0, 0
+	for _, a := range f.fn.Arguments {
+		if f.slots[a] >= f.frame {
+			continue
+		}
+		if types.IsFloat(a.Type()) {
+			f.emit("str d%d, %s", nfloat, f.mem(f.slots[a], 8))
+			nfloat++
+		} else {
+			f.emit("str %s, %s", reg(nint).x(), f.mem(f.slots[a], 8))
+			nint++
+		}
+	}
+}
+
+// ============================================================================
+// Control flow
+// ============================================================================
+
+func (f *funcGen) terminator(inst ir.Instruction) error {
+	b := inst.Parent()
+	switch i := inst.(type) {
+	case *ir.RetInst:
+		if len(i.Ops) > 0 && i.Ops[0] != nil {
+			v := i.Ops[0]
+			if types.IsFloat(v.Type()) {
+				f.loadFloat(0, v)
+			} else {
+				f.loadInt(x0, v, isSigned(v.Type()))
+			}
+		}
+		f.emit("mov sp, x29")
+		f.emit("ldp x29, x30, [sp]")
+		f.adjustSP("add", f.frame)
+		f.emit("ret")
+	case *ir.BrInst:
+		gas.EdgeCopies(f, b, i.Target)
+		f.emit("b %s", f.labels[i.Target])
+	case *ir.CondBrInst:
+		var stubs []func()
+		t := f.EdgeLabel(f, b, i.TrueBlock, &stubs)
+		fl := f.EdgeLabel(f, b, i.FalseBlock, &stubs)
+		if c, ok := i.Condition.(*ir.ConstantInt); ok {
+			if c.Value&1 != 0 {
+				f.emit("b %s", t)
+			} else {
+				f.emit("b %s", fl)
+			}
+		} else {
+			f.emit("ldrb w9, %s", f.mem(f.slots[i.Condition], 1))
+			f.emit("tst w9, #1")
+			f.emit("b.ne %s", t)
+			f.emit("b %s", fl)
+		}
+		for _, stub := range stubs {
+			stub()
+		}
+	case *ir.SwitchInst:
+		var stubs []func()
+		width := intWidth(i.Condition.Type())
+		f.loadInt(x9, i.Condition, false)
+		for _, c := range i.Cases {
+			target := f.EdgeLabel(f, b, c.Block, &stubs)
+			v := truncate(uint64(c.Value.Value), width)
+			if v < 4096 {
+				f.emit("cmp x9, #%d", v)
+			} else {
+				f.loadImm(x10, int64(v))
+				f.emit("cmp x9, x10")
+			}
+			f.emit("b.eq %s", target)
+		}
+		f.emit("b %s", f.EdgeLabel(f, b, i.DefaultBlock, &stubs))
+		for _, stub := range stubs {
+			stub()
+		}
+	case *ir.UnreachableInst:
+		f.emit("brk #1")
+	}
+	return nil
+}
+
+// Label, Jump, Stage and Commit implement gas.Edges
+
+func (f *funcGen) Label(b *ir.BasicBlock) string { return f.labels[b] }
+
+func (f *funcGen) Jump(label string) { f.emit("b %s", label) }
+
+func (f *funcGen) Stage(phi *ir.PhiInst, v ir.Value) { f.copyValue(f.temps[phi], v) }
+
+func (f *funcGen) Commit(phi *ir.PhiInst) {
+	n := gas.AlignTo(f.DL.SizeOf(phi.Type()), 8)
+	for off := int64(0); off < n; off += 8 {
+		f.emit("ldr x9, %s", f.mem(f.temps[phi]+off, 8))
+		f.emit("str x9, %s", f.mem(f.slots[phi]+off, 8))
+	}
+}
@@ -0,0 +1,754 @@
+package arm64
+
+import (
+	"strconv"
+
+	"github.com/arc-language/core-builder/codegen/internal/gas"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// ============================================================================
+// Operands
+// ============================================================================
+
+// mem returns the operand addressing the frame location at off for an
+// access of size bytes. Offsets out of reach of the scaled immediate form
+// are computed into x16.
+func (f *funcGen) mem(off, size int64) string {
+	if off >= 0 && off < 4096 && off%size == 0 {
+		return "[x29, #" + itoa(off) + "]"
+	}
+	f.frameAddr(x16, off)
+	return "[x16]"
+}
+
+// frameAddr loads the address of the frame location at off into r
+func (f *funcGen) frameAddr(r reg, off int64) {
+	if off >= 0 && off < 4096 {
+		f.emit("add %s, x29, #%d", r.x(), off)
+		return
+	}
+	f.loadImm(r, off)
+	f.emit("add %s, x29, %s", r.x(), r.x())
+}
+
+// symbol loads the address of a global or function into r. Symbols defined
+// elsewhere are reached through the GOT so the code links into PIEs.
+func (f *funcGen) symbol(r reg, name string) {
+	if f.Defined[name] {
+		f.emit("adrp %s, %s", r.x(), name)
+		f.emit("add %s, %s, :lo12:%s", r.x(), r.x(), name)
+	} else {
+		f.emit("adrp %s, :got:%s", r.x(), name)
+		f.emit("ldr %s, [%s, :got_lo12:%s]", r.x(), r.x(), name)
+	}
+}
+
+// loadImm materializes a 64-bit constant with movz/movk, or a single movn
+// for small negative values
+func (f *funcGen) loadImm(r reg, v int64) {
+	if v >= -0x10000 && v < 0x10000 {
+		f.emit("mov %s, #%d", r.x(), v)
+		return
+	}
+	first := true
+	for shift := uint(0); shift < 64; shift += 16 {
+		chunk := uint64(v) >> shift & 0xffff
+		if chunk == 0 {
+			continue
+		}
+		if first {
+			f.emit("movz %s, #%d, lsl #%d", r.x(), chunk, shift)
+			first = false
+		} else {
+			f.emit("movk %s, #%d, lsl #%d", r.x(), chunk, shift)
+		}
+	}
+}
+
+// loadRaw loads the 64 bits holding v into r. Only the low bits that
+// belong to v's type are meaningful.
+func (f *funcGen) loadRaw(r reg, v ir.Value) {
+	switch c := v.(type) {
+	case *ir.ConstantInt:
+		f.loadImm(r, c.Value)
+	case *ir.ConstantFloat:
+		f.loadImm(r, int64(gas.FloatBits(c)))
+	case *ir.ConstantNull, *ir.ConstantUndef, *ir.ConstantZero:
+		f.emit("mov %s, xzr", r.x())
+	case *ir.Global:
+		f.symbol(r, c.Name())
+	case *ir.Function:
+		f.symbol(r, c.Name())
+	case *ir.AllocaInst:
+		if off, ok := f.allocas[c]; ok {
+			f.frameAddr(r, off)
+			return
+		}
+		f.emit("ldr %s, %s", r.x(), f.mem(f.slots[v], 8))
+	default:
+		f.emit("ldr %s, %s", r.x(), f.mem(f.slots[v], 8))
+	}
+}
+
+// loadInt loads integer or pointer v into r, sign- or zero-extended from its
+// width to 64 bits
+func (f *funcGen) loadInt(r reg, v ir.Value, signed bool) {
+	width := intWidth(v.Type())
+	if c, ok := v.(*ir.ConstantInt); ok {
+		bits := truncate(uint64(c.Value), width)
+		if signed {
+			bits = uint64(signExtend(bits, width))
+		}
+		f.loadImm(r, int64(bits))
+		return
+	}
+	if _, ok := f.slots[v]; !ok || width == 64 {
+		f.loadRaw(r, v)
+		return
+	}
+	f.loadMem(r, f.slots[v], width, signed)
+}
+
+// loadMem loads a width-bit integer from the frame location at off into r,
+// extended to 64 bits
+func (f *funcGen) loadMem(r reg, off int64, width int, signed bool) {
+	f.loadInd(r, f.mem(off, int64(max(width, 8)/8)), width, signed)
+}
+
+// loadInd loads a width-bit integer from the memory operand addr into r
+func (f *funcGen) loadInd(r reg, addr string, width int, signed bool) {
+	switch width {
+	case 64:
+		f.emit("ldr %s, %s", r.x(), addr)
+	case 32:
+		if signed {
+			f.emit("ldrsw %s, %s", r.x(), addr)
+		} else {
+			f.emit("ldr %s, %s", r.w(), addr)
+		}
+	case 16:
+		if signed {
+			f.emit("ldrsh %s, %s", r.x(), addr)
+		} else {
+			f.emit("ldrh %s, %s", r.w(), addr)
+		}
+	case 8:
+		if signed {
+			f.emit("ldrsb %s, %s", r.x(), addr)
+		} else {
+			f.emit("ldrb %s, %s", r.w(), addr)
+		}
+	case 1:
+		f.emit("ldrb %s, %s", r.w(), addr)
+		if signed {
+			f.emit("neg %s, %s", r.x(), r.x())
+		}
+	}
+}
+
+// storeInd stores the low size bytes of r to the memory operand addr
+func (f *funcGen) storeInd(r reg, addr string, size int64) {
+	switch size {
+	case 1:
+		f.emit("strb %s, %s", r.w(), addr)
+	case 2:
+		f.emit("strh %s, %s", r.w(), addr)
+	case 4:
+		f.emit("str %s, %s", r.w(), addr)
+	default:
+		f.emit("str %s, %s", r.x(), addr)
+	}
+}
+
+// loadFloat loads f32 or f64 v into v<n>
+func (f *funcGen) loadFloat(n int, v ir.Value) {
+	p := fp(v.Type())
+	switch v.(type) {
+	case *ir.ConstantFloat, *ir.ConstantInt, *ir.ConstantUndef, *ir.ConstantZero:
+		f.loadRaw(x9, v)
+		if p == "s" {
+			f.emit("fmov s%d, w9", n)
+		} else {
+			f.emit("fmov d%d, x9", n)
+		}
+	default:
+		f.emit("ldr %s%d, %s", p, n, f.mem(f.slots[v], 4))
+	}
+}
+
+func (f *funcGen) storeFloat(n int, inst ir.Instruction) {
+	p := fp(inst.Type())
+	f.emit("str %s%d, %s", p, n, f.mem(f.slots[inst], 4))
+}
+
+func (f *funcGen) store(r reg, inst ir.Instruction) {
+	f.emit("str %s, %s", r.x(), f.mem(f.slots[inst], 8))
+}
+
+// copyValue copies v to the frame slot at off
+func (f *funcGen) copyValue(off int64, v ir.Value) {
+	if types.IsAggregate(v.Type()) {
+		f.frameAddr(x10, off)
+		f.copyAggregate(v)
+		return
+	}
+	f.loadRaw(x9, v)
+	f.emit("str x9, %s", f.mem(off, 8))
+}
+
+// copyAggregate copies aggregate v to the address in x10. Undef and zero
+// constants have no bytes to copy from and are stored as zeros.
+func (f *funcGen) copyAggregate(v ir.Value) {
+	size := f.DL.SizeOf(v.Type())
+	if size == 0 {
+		return
+	}
+	switch c := v.(type) {
+	case *ir.ConstantUndef, *ir.ConstantZero:
+		f.memset(size)
+		return
+	case ir.Constant:
+		f.emit("adrp x11, %s", f.ConstLabel(c))
+		f.emit("add x11, x11, :lo12:%s", f.ConstLabel(c))
+	default:
+		f.frameAddr(x11, f.slots[v])
+	}
+	f.memcpy(size)
+}
+
+// memcpy copies size bytes from x11 to x10, clobbering x9, x11 and x12
+func (f *funcGen) memcpy(size int64) {
+	loop := f.NewLabel()
+	f.loadImm(x12, size)
+	f.Line("%s:", loop)
+	f.emit("ldrb w9, [x11], #1")
+	f.emit("strb w9, [x10], #1")
+	f.emit("subs x12, x12, #1")
+	f.emit("b.ne %s", loop)
+}
+
+// memset zeroes size bytes at x10, clobbering x10 and x12
+func (f *funcGen) memset(size int64) {
+	loop := f.NewLabel()
+	f.loadImm(x12, size)
+	f.Line("%s:", loop)
+	f.emit("strb wzr, [x10], #1")
+	f.emit("subs x12, x12, #1")
+	f.emit("b.ne %s", loop)
+}
+
+// ============================================================================
+// Instructions
+// ============================================================================
+
+func (f *funcGen) instruction(inst ir.Instruction) error {
+	if inst.IsTerminator() {
+		return f.terminator(inst)
+	}
+	switch i := inst.(type) {
+	case *ir.PhiInst:
+		// Assigned by the copies on the incoming edges
+	case *ir.BinaryInst:
+		f.binary(i)
+	case *ir.ICmpInst:
+		f.icmp(i)
+	case *ir.FCmpInst:
+		f.fcmp(i)
+	case *ir.CastInst:
+		return f.cast(i)
+	case *ir.SelectInst:
+		f.selectInst(i)
+	case *ir.AllocaInst:
+		f.alloca(i)
+	case *ir.LoadInst:
+		f.load(i)
+	case *ir.StoreInst:
+		f.storeInst(i)
+	case *ir.GetElementPtrInst:
+		f.gep(i)
+	case *ir.ExtractValueInst:
+		f.extractValue(i)
+	case *ir.InsertValueInst:
+		f.insertValue(i)
+	case *ir.CallInst:
+		return f.call(i)
+	case *ir.SyscallInst:
+		return f.syscall(i)
+	default:
+		return f.errorf(inst, "unsupported instruction")
+	}
+	return nil
+}
+
+func (f *funcGen) binary(i *ir.BinaryInst) {
+	lhs, rhs := i.Ops[0], i.Ops[1]
+	if types.IsFloat(i.Type()) {
+		p := fp(i.Type())
+		f.loadFloat(0, lhs)
+		f.loadFloat(1, rhs)
+		if i.Op == ir.OpFRem {
+			name := "fmod"
+			if p == "s" {
+				name = "fmodf"
+			}
+			f.emit("bl %s", name)
+		} else {
+			ops := map[ir.Opcode]string{ir.OpFAdd: "fadd", ir.OpFSub: "fsub", ir.OpFMul: "fmul", ir.OpFDiv: "fdiv"}
+			f.emit("%s %s0, %s0, %s1", ops[i.Op], p, p, p)
+		}
+		f.storeFloat(0, i)
+		return
+	}
+
+	switch i.Op {
+	case ir.OpUDiv, ir.OpURem, ir.OpLShr:
+		f.loadInt(x9, lhs, false)
+		f.loadInt(x10, rhs, false)
+	case ir.OpSDiv, ir.OpSRem, ir.OpAShr:
+		f.loadInt(x9, lhs, true)
+		f.loadInt(x10, rhs, true)
+	default:
+		f.loadRaw(x9, lhs)
+		f.loadRaw(x10, rhs)
+	}
+	switch i.Op {
+	case ir.OpAdd:
+		f.emit("add x9, x9, x10")
+	case ir.OpSub:
+		f.emit("sub x9, x9, x10")
+	case ir.OpMul:
+		f.emit("mul x9, x9, x10")
+	case ir.OpAnd:
+		f.emit("and x9, x9, x10")
+	case ir.OpOr:
+		f.emit("orr x9, x9, x10")
+	case ir.OpXor:
+		f.emit("eor x9, x9, x10")
+	case ir.OpShl:
+		f.emit("lsl x9, x9, x10")
+	case ir.OpLShr:
+		f.emit("lsr x9, x9, x10")
+	case ir.OpAShr:
+		f.emit("asr x9, x9, x10")
+	case ir.OpUDiv:
+		f.emit("udiv x9, x9, x10")
+	case ir.OpSDiv:
+		f.emit("sdiv x9, x9, x10")
+	case ir.OpURem:
+		f.emit("udiv x11, x9, x10")
+		f.emit("msub x9, x11, x10, x9")
+	case ir.OpSRem:
+		f.emit("sdiv x11, x9, x10")
+		f.emit("msub x9, x11, x10, x9")
+	}
+	if intWidth(i.Type()) == 1 {
+		f.emit("and x9, x9, #1")
+	}
+	f.store(x9, i)
+}
+
+var icmpConds = map[ir.ICmpPredicate]string{
+	ir.ICmpEQ: "eq", ir.ICmpNE: "ne",
+	ir.ICmpUGT: "hi", ir.ICmpUGE: "hs", ir.ICmpULT: "lo", ir.ICmpULE: "ls",
+	ir.ICmpSGT: "gt", ir.ICmpSGE: "ge", ir.ICmpSLT: "lt", ir.ICmpSLE: "le",
+}
+
+func (f *funcGen) icmp(i *ir.ICmpInst) {
+	signed := false
+	switch i.Predicate {
+	case ir.ICmpSGT, ir.ICmpSGE, ir.ICmpSLT, ir.ICmpSLE:
+		signed = true
+	}
+	f.loadInt(x9, i.Ops[0], signed)
+	f.loadInt(x10, i.Ops[1], signed)
+	f.emit("cmp x9, x10")
+	f.emit("cset w9, %s", icmpConds[i.Predicate])
+	f.store(x9, i)
+}
+
+// fcmpConds maps each predicate to the conditions on the flags of fcmp
+// that make it true; an unordered comparison sets C and V.
+var fcmpConds = map[ir.FCmpPredicate][]string{
+	ir.FCmpOEQ: {"eq"}, ir.FCmpOGT: {"gt"}, ir.FCmpOGE: {"ge"},
+	ir.FCmpOLT: {"mi"}, ir.FCmpOLE: {"ls"}, ir.FCmpONE: {"mi", "gt"},
+	ir.FCmpORD: {"vc"}, ir.FCmpUNO: {"vs"},
+	ir.FCmpUEQ: {"eq", "vs"}, ir.FCmpUGT: {"hi"}, ir.FCmpUGE: {"pl"},
+	ir.FCmpULT: {"lt"}, ir.FCmpULE: {"le"}, ir.FCmpUNE: {"ne"},
+}
+
+var invertCond = map[string]string{"gt": "le", "vs": "vc"}
+
+func (f *funcGen) fcmp(i *ir.FCmpInst) {
+	switch i.Predicate {
+	case ir.FCmpFalse:
+		f.emit("mov x9, #0")
+	case ir.FCmpTrue:
+		f.emit("mov x9, #1")
+	default:
+		p := fp(i.Ops[0].Type())
+		f.loadFloat(0, i.Ops[0])
+		f.loadFloat(1, i.Ops[1])
+		f.emit("fcmp %s0, %s1", p, p)
+		conds := fcmpConds[i.Predicate]
+		f.emit("cset w9, %s", conds[0])
+		if len(conds) > 1 {
+			f.emit("csinc w9, w9, wzr, %s", invertCond[conds[1]])
+		}
+	}
+	f.store(x9, i)
+}
+
+func (f *funcGen) cast(i *ir.CastInst) error {
+	v := i.Ops[0]
+	src, dst := v.Type(), i.Type()
+	switch i.Op {
+	case ir.OpTrunc:
+		f.loadRaw(x9, v)
+		if intWidth(dst) == 1 {
+			f.emit("and x9, x9, #1")
+		}
+		f.store(x9, i)
+	case ir.OpZExt:
+		f.loadInt(x9, v, false)
+		f.store(x9, i)
+	case ir.OpSExt:
+		f.loadInt(x9, v, true)
+		f.store(x9, i)
+	case ir.OpFPTrunc, ir.OpFPExt:
+		f.loadFloat(0, v)
+		if fp(src) != fp(dst) {
+			f.emit("fcvt %s0, %s0", fp(dst), fp(src))
+		}
+		f.storeFloat(0, i)
+	case ir.OpFPToSI:
+		f.loadFloat(0, v)
+		f.emit("fcvtzs x9, %s0", fp(src))
+		f.store(x9, i)
+	case ir.OpFPToUI:
+		f.loadFloat(0, v)
+		f.emit("fcvtzu x9, %s0", fp(src))
+		f.store(x9, i)
+	case ir.OpSIToFP:
+		f.loadInt(x9, v, true)
+		f.emit("scvtf %s0, x9", fp(dst))
+		f.storeFloat(0, i)
+	case ir.OpUIToFP:
+		f.loadInt(x9, v, false)
+		f.emit("ucvtf %s0, x9", fp(dst))
+		f.storeFloat(0, i)
+	case ir.OpPtrToInt, ir.OpIntToPtr, ir.OpBitcast:
+		if types.IsAggregate(src) || types.IsAggregate(dst) {
+			return f.errorf(i, "cannot bitcast aggregate values")
+		}
+		f.loadRaw(x9, v)
+		f.store(x9, i)
+	default:
+		return f.errorf(i, "unsupported cast")
+	}
+	return nil
+}
+
+func (f *funcGen) selectInst(i *ir.SelectInst) {
+	cond, t, fv := i.Ops[0], i.Ops[1], i.Ops[2]
+	if types.IsAggregate(i.Type()) {
+		other, done := f.NewLabel(), f.NewLabel()
+		f.loadRaw(x9, cond)
+		f.emit("tst w9, #1")
+		f.emit("b.eq %s", other)
+		f.copyValue(f.slots[i], t)
+		f.emit("b %s", done)
+		f.Line("%s:", other)
+		f.copyValue(f.slots[i], fv)
+		f.Line("%s:", done)
+		return
+	}
+	f.loadRaw(x9, fv)
+	f.loadRaw(x10, t)
+	f.loadRaw(x11, cond)
+	f.emit("tst w11, #1")
+	f.emit("csel x9, x10, x9, ne")
+	f.store(x9, i)
+}
+
+// alloca handles the allocas the frame layout could not place: those with
+// a variable count or outside the entry block. They grow the stack in
+// 16-byte steps, keeping sp aligned.
+func (f *funcGen) alloca(i *ir.AllocaInst) {
+	if _, ok := f.allocas[i]; ok {
+		return
+	}
+	elem := f.DL.SizeOf(i.AllocatedType)
+	if i.NumElements != nil {
+		f.loadInt(x9, i.NumElements, false)
+		f.loadImm(x10, elem)
+		f.emit("mul x9, x9, x10")
+	} else {
+		f.loadImm(x9, elem)
+	}
+	f.emit("add x9, x9, #15")
+	f.emit("and x9, x9, #-16")
+	f.emit("sub sp, sp, x9")
+	f.emit("mov x9, sp")
+	f.store(x9, i)
+}
+
+func (f *funcGen) load(i *ir.LoadInst) {
+	t := i.Type()
+	if types.IsAggregate(t) {
+		f.loadRaw(x11, i.Ops[0])
+		f.frameAddr(x10, f.slots[i])
+		f.memcpy(f.DL.SizeOf(t))
+		return
+	}
+	f.loadRaw(x10, i.Ops[0])
+	f.loadInd(x9, "[x10]", int(f.DL.StoreSize(t)*8), false)
+	if intWidth(t) == 1 {
+		f.emit("and x9, x9, #1")
+	}
+	f.store(x9, i)
+}
+
+func (f *funcGen) storeInst(i *ir.StoreInst) {
+	val, ptr := i.Ops[0], i.Ops[1]
+	if types.IsAggregate(val.Type()) {
+		f.loadRaw(x10, ptr)
+		f.copyAggregate(val)
+		return
+	}
+	f.loadRaw(x9, val)
+	f.loadRaw(x10, ptr)
+	f.storeInd(x9, "[x10]", f.DL.StoreSize(val.Type()))
+}
+
+func (f *funcGen) gep(i *ir.GetElementPtrInst) {
+	f.loadRaw(x9, i.Ops[0])
+	t := i.SourceElementType
+	var off int64
+	for n, idx := range i.Ops[1:] {
+		var stride int64
+		if n == 0 {
+			stride = f.DL.SizeOf(t)
+		} else {
+			switch ty := t.(type) {
+			case *types.StructType:
+				field := idx.(*ir.ConstantInt).Value
+				off += f.DL.FieldOffset(ty, int(field))
+				t = ty.Fields[field]
+				continue
+			case *types.ArrayType:
+				t = ty.ElementType
+			case *types.VectorType:
+				t = ty.ElementType
+			}
+			stride = f.DL.SizeOf(t)
+		}
+		if c, ok := idx.(*ir.ConstantInt); ok {
+			off += signExtend(truncate(uint64(c.Value), intWidth(c.Type())), intWidth(c.Type())) * stride
+			continue
+		}
+		f.loadInt(x10, idx, true)
+		f.loadImm(x11, stride)
+		f.emit("madd x9, x10, x11, x9")
+	}
+	if off != 0 {
+		f.loadImm(x10, off)
+		f.emit("add x9, x9, x10")
+	}
+	f.store(x9, i)
+}
+
+// memberOffset returns the byte offset and type of the member of an
+// aggregate of type t selected by indices
+func (f *funcGen) memberOffset(t types.Type, indices []int) (int64, types.Type) {
+	var off int64
+	for _, idx := range indices {
+		switch ty := t.(type) {
+		case *types.StructType:
+			off += f.DL.FieldOffset(ty, idx)
+			t = ty.Fields[idx]
+		case *types.ArrayType:
+			t = ty.ElementType
+			off += int64(idx) * f.DL.SizeOf(t)
+		}
+	}
+	return off, t
+}
+
+func (f *funcGen) extractValue(i *ir.ExtractValueInst) {
+	agg := i.Ops[0]
+	if c, ok := agg.(ir.Constant); ok {
+		// Constant aggregates are folded to the selected member
+		f.copyValue(f.slots[i], memberConstant(c, i.Indices, i.Type()))
+		return
+	}
+	off, t := f.memberOffset(agg.Type(), i.Indices)
+	src := f.slots[agg] + off
+	if types.IsAggregate(t) {
+		f.frameAddr(x11, src)
+		f.frameAddr(x10, f.slots[i])
+		f.memcpy(f.DL.SizeOf(t))
+		return
+	}
+	f.loadMem(x9, src, int(f.DL.StoreSize(t)*8), false)
+	f.store(x9, i)
+}
+
+func (f *funcGen) insertValue(i *ir.InsertValueInst) {
+	agg, val := i.Ops[0], i.Ops[1]
+	f.copyValue(f.slots[i], agg)
+	off, t := f.memberOffset(agg.Type(), i.Indices)
+	dst := f.slots[i] + off
+	if types.IsAggregate(t) {
+		f.frameAddr(x10, dst)
+		f.copyAggregate(val)
+		return
+	}
+	size := f.DL.StoreSize(t)
+	f.loadRaw(x9, val)
+	f.storeInd(x9, f.mem(dst, size), size)
+}
+
+// memberConstant returns the member of constant c selected by indices
+func memberConstant(c ir.Constant, indices []int, t types.Type) ir.Value {
+	for _, idx := range indices {
+		switch cc := c.(type) {
+		case *ir.ConstantStruct:
+			c = cc.Fields[idx]
+		case *ir.ConstantArray:
+			c = cc.Elements[idx]
+		default:
+			z := &ir.ConstantZero{}
+			z.SetType(t)
+			return z
+		}
+	}
+	return c
+}
+
+// ============================================================================
+// Calls
+// ============================================================================
+
+func (f *funcGen) call(i *ir.CallInst) error {
+	name := i.CalleeName
+	if i.Callee != nil {
+		name = i.Callee.Name()
+	}
+	var args []ir.Value
+	for _, a := range i.Ops {
+		if a != nil {
+			args = append(args, a)
+		}
+	}
+
+	// Classify the arguments: the first eight integers and eight floats go
+	// in registers, the rest on the stack in 8-byte slots. Variadic
+	// arguments follow the same rules on Linux.
+	var ints, floats, stack []ir.Value
+	for _, a := range args {
+		if err := gas.CheckScalar(a.Type()); err != nil {
+			return f.errorf(i, "%v", err)
+		}
+		switch {
+		case types.IsFloat(a.Type()) && len(floats) < floatArgRegs:
+			floats = append(floats, a)
+		case !types.IsFloat(a.Type()) && len(ints) < intArgRegs:
+			ints = append(ints, a)
+		default:
+			stack = append(stack, a)
+		}
+	}
+	if t := i.Type(); t != nil && t.Kind() != types.VoidKind {
+		if err := gas.CheckScalar(t); err != nil {
+			return f.errorf(i, "%v", err)
+		}
+	}
+
+	stackSize := gas.AlignTo(int64(len(stack))*8, 16)
+	if stackSize > 0 {
+		f.adjustSP("sub", stackSize)
+		for n, a := range stack {
+			f.loadInt(x9, a, isSigned(a.Type()))
+			f.emit("str x9, [sp, #%d]", n*8)
+		}
+	}
+	for n, a := range floats {
+		f.loadFloat(n, a)
+	}
+	for n, a := range ints {
+		f.loadInt(reg(n), a, isSigned(a.Type()))
+	}
+	f.emit("bl %s", name)
+	if stackSize > 0 {
+		f.adjustSP("add", stackSize)
+	}
+
+	if t := i.Type(); t != nil && t.Kind() != types.VoidKind {
+		if types.IsFloat(t) {
+			f.storeFloat(0, i)
+		} else {
+			f.store(x0, i)
+		}
+	}
+	return nil
+}
+
+// syscall passes the number in x8 and up to six arguments in x0-x5, the
+// Linux convention for svc #0
+func (f *funcGen) syscall(i *ir.SyscallInst) error {
+	if len(i.Ops) == 0 || len(i.Ops) > 7 {
+		return f.errorf(i, "syscall takes a number and up to 6 arguments")
+	}
+	f.loadInt(x8, i.Ops[0], false)
+	for n, a := range i.Ops[1:] {
+		f.loadInt(reg(n), a, isSigned(a.Type()))
+	}
+	f.emit("svc #0")
+	f.store(x0, i)
+	return nil
+}
+
+// ============================================================================
+// Helpers
+// ============================================================================
+
+// intWidth returns the bit width of an integer type; pointers are 64 bits
+func intWidth(t types.Type) int {
+	if it, ok := t.(*types.IntType); ok {
+		return it.BitWidth
+	}
+	return 64
+}
+
+// isSigned reports whether values of t are sign-extended when widened for
+// calls and returns. Booleans are zero-extended.
+func isSigned(t types.Type) bool {
+	it, ok := t.(*types.IntType)
+	return ok && it.Signed && it.BitWidth > 1
+}
+
+// fp returns the register prefix for a float type: s for f32, d for f64
+func fp(t types.Type) string {
+	if ft, ok := t.(*types.FloatType); ok && ft.BitWidth == 32 {
+		return "s"
+	}
+	return "d"
+}
+
+func truncate(bits uint64, width int) uint64 {
+	if width >= 64 {
+		return bits
+	}
+	return bits & (1<<uint(width) - 1)
+}
+
+func signExtend(bits uint64, width int) int64 {
+	shift := uint(64 - width)
+	return int64(bits<<shift) >> shift
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
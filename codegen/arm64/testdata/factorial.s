@@ -0,0 +1,45 @@
+	.text
+
+	.globl factorial
+	.p2align 2
+	.type factorial,@function
+factorial:
+	sub sp, sp, #64
+	stp x29, x30, [sp]
+	mov x29, sp
+	str x0, [x29, #16]
+.LBB0_0:				// %entry
+	ldrsw x9, [x29, #16]
+	mov x10, #1
+	cmp x9, x10
+	cset w9, le
+	str x9, [x29, #24]
+	ldrb w9, [x29, #24]
+	tst w9, #1
+	b.ne .LBB0_1
+	b .LBB0_2
+.LBB0_1:				// %then
+	mov x0, #1
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #64
+	ret
+.LBB0_2:				// %else
+	ldr x9, [x29, #16]
+	mov x10, #1
+	sub x9, x9, x10
+	str x9, [x29, #32]
+	ldrsw x0, [x29, #32]
+	bl factorial
+	str x0, [x29, #40]
+	ldr x9, [x29, #16]
+	ldr x10, [x29, #40]
+	mul x9, x9, x10
+	str x9, [x29, #48]
+	ldrsw x0, [x29, #48]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #64
+	ret
+	.size factorial,.-factorial
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,52 @@
+	.text
+
+	.globl fib
+	.p2align 2
+	.type fib,@function
+fib:
+	sub sp, sp, #80
+	stp x29, x30, [sp]
+	mov x29, sp
+	str x0, [x29, #16]
+.LBB0_0:				// %entry
+	ldrsw x9, [x29, #16]
+	mov x10, #2
+	cmp x9, x10
+	cset w9, lt
+	str x9, [x29, #24]
+	ldrb w9, [x29, #24]
+	tst w9, #1
+	b.ne .LBB0_1
+	b .LBB0_2
+.LBB0_1:				// %base_case
+	ldrsw x0, [x29, #16]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #80
+	ret
+.LBB0_2:				// %recurse
+	ldr x9, [x29, #16]
+	mov x10, #1
+	sub x9, x9, x10
+	str x9, [x29, #32]
+	ldrsw x0, [x29, #32]
+	bl fib
+	str x0, [x29, #40]
+	ldr x9, [x29, #16]
+	mov x10, #2
+	sub x9, x9, x10
+	str x9, [x29, #48]
+	ldrsw x0, [x29, #48]
+	bl fib
+	str x0, [x29, #56]
+	ldr x9, [x29, #40]
+	ldr x10, [x29, #56]
+	add x9, x9, x10
+	str x9, [x29, #64]
+	ldrsw x0, [x29, #64]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #80
+	ret
+	.size fib,.-fib
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,54 @@
+	.text
+
+	.globl gcd
+	.p2align 2
+	.type gcd,@function
+gcd:
+	sub sp, sp, #80
+	stp x29, x30, [sp]
+	mov x29, sp
+	str x0, [x29, #16]
+	str x1, [x29, #24]
+.LBB0_0:				// %entry
+	ldr x9, [x29, #16]
+	str x9, [x29, #40]
+	ldr x9, [x29, #24]
+	str x9, [x29, #56]
+	ldr x9, [x29, #40]
+	str x9, [x29, #32]
+	ldr x9, [x29, #56]
+	str x9, [x29, #48]
+	b .LBB0_1
+.LBB0_1:				// %loop.head
+	ldr w9, [x29, #48]
+	mov x10, #0
+	cmp x9, x10
+	cset w9, ne
+	str x9, [x29, #64]
+	ldrb w9, [x29, #64]
+	tst w9, #1
+	b.ne .LBB0_2
+	b .LBB0_3
+.LBB0_2:				// %loop.body
+	ldrsw x9, [x29, #32]
+	ldrsw x10, [x29, #48]
+	sdiv x11, x9, x10
+	msub x9, x11, x10, x9
+	str x9, [x29, #72]
+	ldr x9, [x29, #48]
+	str x9, [x29, #40]
+	ldr x9, [x29, #72]
+	str x9, [x29, #56]
+	ldr x9, [x29, #40]
+	str x9, [x29, #32]
+	ldr x9, [x29, #56]
+	str x9, [x29, #48]
+	b .LBB0_1
+.LBB0_3:				// %exit
+	ldrsw x0, [x29, #32]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #80
+	ret
+	.size gcd,.-gcd
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,33 @@
+	.text
+
+	.globl main
+	.p2align 2
+	.type main,@function
+main:
+	sub sp, sp, #48
+	stp x29, x30, [sp]
+	mov x29, sp
+.LBB0_0:				// %entry
+	adrp x10, g_val
+	add x10, x10, :lo12:g_val
+	ldr w9, [x10]
+	str x9, [x29, #16]
+	add x9, x29, #24
+	str x9, [x29, #32]
+	ldr x9, [x29, #16]
+	ldr x10, [x29, #32]
+	str w9, [x10]
+	ldrsw x0, [x29, #16]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #48
+	ret
+	.size main,.-main
+	.data
+	.globl g_val
+	.p2align 2
+	.type g_val,@object
+	.size g_val,4
+g_val:
+	.long 42
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,69 @@
+	.text
+
+	.globl wrap
+	.p2align 2
+	.type wrap,@function
+wrap:
+	sub sp, sp, #48
+	stp x29, x30, [sp]
+	mov x29, sp
+	str x0, [x29, #16]
+	str x1, [x29, #24]
+.LBB0_0:				// %entry
+	ldr x9, [x29, #16]
+	mov x10, #3
+	mul x9, x9, x10
+	str x9, [x29, #32]
+	ldr x9, [x29, #32]
+	ldr x10, [x29, #24]
+	add x9, x9, x10
+	str x9, [x29, #40]
+	ldrsb x0, [x29, #40]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #48
+	ret
+	.size wrap,.-wrap
+
+	.globl twice
+	.p2align 2
+	.type twice,@function
+twice:
+	sub sp, sp, #32
+	stp x29, x30, [sp]
+	mov x29, sp
+	str x0, [x29, #16]
+.LBB1_0:				// %entry
+	ldr x9, [x29, #16]
+	ldr x10, [x29, #16]
+	add x9, x9, x10
+	str x9, [x29, #24]
+	ldrb w0, [x29, #24]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #32
+	ret
+	.size twice,.-twice
+
+	.globl widen
+	.p2align 2
+	.type widen,@function
+widen:
+	sub sp, sp, #48
+	stp x29, x30, [sp]
+	mov x29, sp
+	str x0, [x29, #16]
+.LBB2_0:				// %entry
+	ldrsb x0, [x29, #16]
+	mov x1, #1
+	bl wrap
+	str x0, [x29, #24]
+	ldrsb x9, [x29, #24]
+	str x9, [x29, #32]
+	ldrsw x0, [x29, #32]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #48
+	ret
+	.size widen,.-widen
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,25 @@
+	.text
+
+	.globl update_y
+	.p2align 2
+	.type update_y,@function
+update_y:
+	sub sp, sp, #48
+	stp x29, x30, [sp]
+	mov x29, sp
+	str x0, [x29, #16]
+	str x1, [x29, #24]
+.LBB0_0:				// %entry
+	ldr x9, [x29, #16]
+	mov x10, #4
+	add x9, x9, x10
+	str x9, [x29, #32]
+	ldr x9, [x29, #24]
+	ldr x10, [x29, #32]
+	str w9, [x10]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #48
+	ret
+	.size update_y,.-update_y
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,43 @@
+	.text
+
+	.globl classify
+	.p2align 2
+	.type classify,@function
+classify:
+	sub sp, sp, #48
+	stp x29, x30, [sp]
+	mov x29, sp
+	str x0, [x29, #16]
+.LBB0_0:				// %entry
+	ldr w9, [x29, #16]
+	cmp x9, #0
+	b.eq .LBB0_1
+	cmp x9, #1
+	b.eq .LBB0_2
+	b .LBB0_3
+.LBB0_1:				// %case_zero
+	mov x9, #100
+	str x9, [x29, #32]
+	ldr x9, [x29, #32]
+	str x9, [x29, #24]
+	b .LBB0_4
+.LBB0_2:				// %case_one
+	mov x9, #200
+	str x9, [x29, #32]
+	ldr x9, [x29, #32]
+	str x9, [x29, #24]
+	b .LBB0_4
+.LBB0_3:				// %default
+	mov x9, #-1
+	str x9, [x29, #32]
+	ldr x9, [x29, #32]
+	str x9, [x29, #24]
+	b .LBB0_4
+.LBB0_4:				// %merge
+	ldrsw x0, [x29, #24]
+	mov sp, x29
+	ldp x29, x30, [sp]
+	add sp, sp, #48
+	ret
+	.size classify,.-classify
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,588 @@
+// Package c translates IR modules to a single C99 source file.
+//
+// Integers map to the exact-width types of stdint.h, signed or unsigned as
+// their IntType says; i1 is a uint8_t holding 0 or 1. Arithmetic is done on
+// unsigned values so that it wraps as in the IR, and operations that
+// depend on signedness convert their operands explicitly. Struct types are
+// emitted as C structs with fields f0, f1, ...; array types are wrapped in
+// structs with a single member a so they can be assigned and returned.
+//
+// Every SSA value is a local variable of its function, blocks are labels
+// and branches are gotos. Each edge into a block with phis assigns the
+// phis' variables before jumping, in the order transform.EdgeCopies gives,
+// with a temporary for copies that form a cycle. Fixed-size allocas in the entry block are local arrays, others
+// call alloca. Values nothing reads get no variable. SyscallInst calls
+// syscall() from unistd.h, and va_start/va_arg/va_end treat their operand
+// as pointing to a va_list. Declarations of C library functions that
+// compilers treat as builtins, such as printf, include their header
+// instead of being declared with the types of the IR.
+//
+// Integers of 1, 8, 16, 32 and 64 bits, pointers, f32 and f64, structs and
+// arrays are supported. Vectors, packed structs, empty structs and arrays
+// and indirect calls are not.
+package c
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Error reports an instruction or function the backend cannot translate
+type Error struct {
+	Func *ir.Function
+	Inst ir.Instruction
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Inst != nil {
+		return fmt.Sprintf("c: @%s: %s: %s", e.Func.Name(), e.Inst, e.Msg)
+	}
+	if e.Func != nil {
+		return fmt.Sprintf("c: @%s: %s", e.Func.Name(), e.Msg)
+	}
+	return "c: " + e.Msg
+}
+
+// Generate writes the C source for m to w. Functions and globals with
+// internal or private linkage are static, declarations become extern
+// declarations and constant globals are const.
+func Generate(w io.Writer, m *ir.Module) error {
+	g := &gen{
+		m:        m,
+		names:    make(map[ir.Value]string),
+		used:     make(map[string]bool),
+		aggs:     make(map[string]*aggregate),
+		tags:     make(map[string]bool),
+		includes: map[string]bool{"stdint.h": true},
+	}
+	if err := g.declare(); err != nil {
+		return err
+	}
+	// Named struct types are emitted even if nothing refers to them
+	var named []string
+	for name := range m.Types {
+		named = append(named, name)
+	}
+	sort.Strings(named)
+	for _, name := range named {
+		if _, err := g.typeName(m.Types[name]); err != nil {
+			return &Error{Msg: fmt.Sprintf("%%%s: %v", name, err)}
+		}
+	}
+
+	var globals, protos, bodies strings.Builder
+	for _, gv := range m.Globals {
+		if err := g.global(&globals, gv); err != nil {
+			return err
+		}
+	}
+	for _, f := range m.Functions {
+		if lf, ok := libcFunction(f); ok {
+			g.includes[lf.header] = true
+			continue
+		}
+		proto, err := g.prototype(f, nil)
+		if err != nil {
+			return err
+		}
+		protos.WriteString(proto + ";\n")
+	}
+	for _, f := range m.Functions {
+		if len(f.Blocks) == 0 {
+			continue
+		}
+		if err := g.function(&bodies, f); err != nil {
+			return err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "/* Generated from module %s */\n\n", m.Name)
+	if g.includes["unistd.h"] {
+		bw.WriteString("#define _GNU_SOURCE\n")
+	}
+	var includes []string
+	for h := range g.includes {
+		includes = append(includes, h)
+	}
+	sort.Strings(includes)
+	for _, h := range includes {
+		fmt.Fprintf(bw, "#include <%s>\n", h)
+	}
+	bw.WriteString("\n")
+	if err := g.aggregates(bw); err != nil {
+		return err
+	}
+	for _, s := range []*strings.Builder{&globals, &protos} {
+		if s.Len() > 0 {
+			bw.WriteString(s.String())
+			bw.WriteString("\n")
+		}
+	}
+	bw.WriteString(bodies.String())
+	return bw.Flush()
+}
+
+type gen struct {
+	m        *ir.Module
+	names    map[ir.Value]string // C names of globals and functions
+	used     map[string]bool
+	aggs     map[string]*aggregate
+	tags     map[string]bool // struct tags in use
+	includes map[string]bool
+}
+
+// aggregate is a struct or array type emitted as a C struct
+type aggregate struct {
+	name    string // including the struct keyword
+	t       types.Type
+	order   int
+	defined bool
+}
+
+// declare names the globals and functions of the module. External names
+// are kept as they are and must be valid C identifiers.
+func (g *gen) declare() error {
+	var values []ir.Value
+	for _, gv := range g.m.Globals {
+		values = append(values, gv)
+	}
+	for _, f := range g.m.Functions {
+		values = append(values, f)
+	}
+	// External names are claimed first so internal ones make way for them
+	for _, v := range values {
+		if linkage(v) == ir.InternalLinkage || linkage(v) == ir.PrivateLinkage {
+			continue
+		}
+		name := v.Name()
+		if !isIdent(name) || keywords[name] {
+			return &Error{Msg: fmt.Sprintf("@%s is not a valid C identifier", name)}
+		}
+		if g.used[name] {
+			return &Error{Msg: fmt.Sprintf("@%s is defined twice", name)}
+		}
+		g.used[name] = true
+		g.names[v] = name
+	}
+	for _, v := range values {
+		if _, ok := g.names[v]; !ok {
+			g.names[v] = unique(g.used, ident(v.Name()))
+		}
+	}
+	return nil
+}
+
+func linkage(v ir.Value) ir.Linkage {
+	switch v := v.(type) {
+	case *ir.Global:
+		return v.Linkage
+	case *ir.Function:
+		return v.Linkage
+	}
+	return ir.ExternalLinkage
+}
+
+func storageClass(l ir.Linkage) string {
+	if l == ir.InternalLinkage || l == ir.PrivateLinkage {
+		return "static "
+	}
+	return ""
+}
+
+// ============================================================================
+// Types
+// ============================================================================
+
+// typeName returns the C type of values of type t
+func (g *gen) typeName(t types.Type) (string, error) {
+	switch t := t.(type) {
+	case *types.VoidType:
+		return "void", nil
+	case *types.IntType:
+		switch t.BitWidth {
+		case 1:
+			return "uint8_t", nil
+		case 8, 16, 32, 64:
+			if t.Signed {
+				return fmt.Sprintf("int%d_t", t.BitWidth), nil
+			}
+			return fmt.Sprintf("uint%d_t", t.BitWidth), nil
+		}
+	case *types.FloatType:
+		switch t.BitWidth {
+		case 32:
+			return "float", nil
+		case 64:
+			return "double", nil
+		}
+	case *types.PointerType:
+		if t.ElementType == nil || t.ElementType.Kind() == types.FunctionKind || t.ElementType.Kind() == types.VoidKind {
+			return "void *", nil
+		}
+		elem, err := g.typeName(t.ElementType)
+		if err != nil {
+			return "", err
+		}
+		return elem + " *", nil
+	case *types.ArrayType, *types.StructType:
+		return g.aggregate(t)
+	}
+	return "", fmt.Errorf("unsupported type %s", t)
+}
+
+// aggregate returns the name of the C struct for a struct or array type,
+// registering it for definition
+func (g *gen) aggregate(t types.Type) (string, error) {
+	key := t.String()
+	if a, ok := g.aggs[key]; ok {
+		return a.name, nil
+	}
+	var name string
+	switch t := t.(type) {
+	case *types.StructType:
+		if t.Packed {
+			return "", fmt.Errorf("packed struct %s is not supported", t)
+		}
+		if t.Name != "" {
+			name = "struct " + unique(g.tags, ident(t.Name))
+		} else {
+			name = "struct " + g.tag("anon")
+		}
+	case *types.ArrayType:
+		if t.Length == 0 {
+			return "", fmt.Errorf("empty array %s is not supported", t)
+		}
+		name = "struct " + g.tag("array")
+	}
+	g.aggs[key] = &aggregate{name: name, t: t, order: len(g.aggs)}
+	// Element and field types are named now, so that everything the
+	// definitions need is registered before they are written
+	var elems []types.Type
+	switch t := t.(type) {
+	case *types.StructType:
+		elems = t.Fields
+	case *types.ArrayType:
+		elems = []types.Type{t.ElementType}
+	}
+	for _, e := range elems {
+		if _, err := g.typeName(e); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// aggregates writes the forward declarations and definitions of the
+// aggregate types, defining the types of fields held by value first
+func (g *gen) aggregates(w *bufio.Writer) error {
+	if len(g.aggs) == 0 {
+		return nil
+	}
+	list := make([]*aggregate, 0, len(g.aggs))
+	for _, a := range g.aggs {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].order < list[j].order })
+	for _, a := range list {
+		fmt.Fprintf(w, "%s;\n", a.name)
+	}
+	w.WriteString("\n")
+
+	var define func(a *aggregate) error
+	define = func(a *aggregate) error {
+		if a.defined {
+			return nil
+		}
+		a.defined = true
+		var fields []types.Type
+		switch t := a.t.(type) {
+		case *types.StructType:
+			fields = t.Fields
+		case *types.ArrayType:
+			fields = []types.Type{t.ElementType}
+		}
+		for _, f := range fields {
+			if dep, ok := g.aggs[f.String()]; ok {
+				if err := define(dep); err != nil {
+					return err
+				}
+			}
+		}
+		switch t := a.t.(type) {
+		case *types.StructType:
+			if len(t.Fields) == 0 {
+				// Opaque or empty; only usable behind a pointer
+				return nil
+			}
+			fmt.Fprintf(w, "%s {\n", a.name)
+			for i, f := range t.Fields {
+				name, _ := g.typeName(f)
+				fmt.Fprintf(w, "\t%s f%d;\n", name, i)
+			}
+		case *types.ArrayType:
+			name, _ := g.typeName(t.ElementType)
+			fmt.Fprintf(w, "%s {\n\t%s a[%d];\n", a.name, name, t.Length)
+		}
+		w.WriteString("};\n\n")
+		return nil
+	}
+	for _, a := range list {
+		if err := define(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tag returns an unused struct tag made of prefix and a number
+func (g *gen) tag(prefix string) string {
+	for i := 0; ; i++ {
+		if s := fmt.Sprintf("%s%d", prefix, i); !g.tags[s] {
+			g.tags[s] = true
+			return s
+		}
+	}
+}
+
+// ============================================================================
+// Globals and constants
+// ============================================================================
+
+func (g *gen) global(w *strings.Builder, gv *ir.Global) error {
+	t := gv.Type()
+	if pt, ok := t.(*types.PointerType); ok {
+		t = pt.ElementType
+	}
+	name, err := g.typeName(t)
+	if err != nil {
+		return &Error{Msg: fmt.Sprintf("@%s: %v", gv.Name(), err)}
+	}
+	qual := ""
+	if gv.IsConstant {
+		qual = "const "
+	}
+	switch {
+	case gv.Initializer == nil:
+		fmt.Fprintf(w, "extern %s%s %s;\n", qual, name, g.names[gv])
+	case gv.Linkage == ir.CommonLinkage:
+		fmt.Fprintf(w, "%s %s;\n", name, g.names[gv])
+	default:
+		init, err := g.initializer(gv.Initializer, t)
+		if err != nil {
+			return &Error{Msg: fmt.Sprintf("@%s: %v", gv.Name(), err)}
+		}
+		fmt.Fprintf(w, "%s%s%s %s = %s;\n", storageClass(gv.Linkage), qual, name, g.names[gv], init)
+	}
+	return nil
+}
+
+// initializer returns the C initializer of constant c of type t
+func (g *gen) initializer(c ir.Constant, t types.Type) (string, error) {
+	switch c := c.(type) {
+	case *ir.ConstantInt:
+		it, ok := t.(*types.IntType)
+		if !ok {
+			return "", fmt.Errorf("integer constant of type %s", t)
+		}
+		return intLiteral(c.Value, it), nil
+	case *ir.ConstantFloat:
+		return g.floatLiteral(c.Value, t), nil
+	case *ir.ConstantNull:
+		return "0", nil
+	case *ir.ConstantZero, *ir.ConstantUndef:
+		if types.IsAggregate(t) {
+			return "{0}", nil
+		}
+		return "0", nil
+	case *ir.ConstantArray:
+		at, ok := t.(*types.ArrayType)
+		if !ok {
+			return "", fmt.Errorf("array constant of type %s", t)
+		}
+		elems := make([]string, len(c.Elements))
+		for i, e := range c.Elements {
+			s, err := g.initializer(e, at.ElementType)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = s
+		}
+		return "{{" + strings.Join(elems, ", ") + "}}", nil
+	case *ir.ConstantStruct:
+		st, ok := t.(*types.StructType)
+		if !ok || len(st.Fields) != len(c.Fields) {
+			return "", fmt.Errorf("struct constant of type %s", t)
+		}
+		fields := make([]string, len(c.Fields))
+		for i, f := range c.Fields {
+			s, err := g.initializer(f, st.Fields[i])
+			if err != nil {
+				return "", err
+			}
+			fields[i] = s
+		}
+		return "{" + strings.Join(fields, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("unsupported constant %s", c)
+}
+
+// intLiteral returns v truncated to the width of t as a C literal of a type
+// no wider than t, interpreted with the signedness of t
+func intLiteral(v int64, t *types.IntType) string {
+	w := t.BitWidth
+	bits := uint64(v)
+	if w < 64 {
+		bits &= 1<<uint(w) - 1
+	}
+	if !t.Signed || w == 1 {
+		switch {
+		case bits <= math.MaxInt32:
+			return strconv.FormatUint(bits, 10)
+		case w <= 32:
+			return fmt.Sprintf("%#xu", bits)
+		}
+		return fmt.Sprintf("UINT64_C(%#x)", bits)
+	}
+	s := int64(bits<<uint(64-w)) >> uint(64-w)
+	switch {
+	case s == math.MinInt32 && w == 32:
+		return "INT32_MIN"
+	case s == math.MinInt64:
+		return "INT64_MIN"
+	case s >= math.MinInt32 && s <= math.MaxInt32:
+		return strconv.FormatInt(s, 10)
+	}
+	return fmt.Sprintf("INT64_C(%d)", s)
+}
+
+// floatLiteral returns v as a C literal of type t. Infinities and NaNs use
+// the macros of math.h.
+func (g *gen) floatLiteral(v float64, t types.Type) string {
+	bits, suffix := 64, ""
+	if ft, ok := t.(*types.FloatType); ok && ft.BitWidth == 32 {
+		bits, suffix = 32, "f"
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		g.includes["math.h"] = true
+	}
+	switch {
+	case math.IsNaN(v):
+		return "NAN"
+	case math.IsInf(v, 1):
+		return "INFINITY"
+	case math.IsInf(v, -1):
+		return "-INFINITY"
+	}
+	s := strconv.FormatFloat(v, 'g', -1, bits)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s + suffix
+}
+
+// ============================================================================
+// Identifiers
+// ============================================================================
+
+var keywords = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true, "const": true,
+	"continue": true, "default": true, "do": true, "double": true, "else": true,
+	"enum": true, "extern": true, "float": true, "for": true, "goto": true,
+	"if": true, "inline": true, "int": true, "long": true, "register": true,
+	"restrict": true, "return": true, "short": true, "signed": true,
+	"sizeof": true, "static": true, "struct": true, "switch": true,
+	"typedef": true, "union": true, "unsigned": true, "void": true,
+	"volatile": true, "while": true, "_Bool": true, "_Complex": true,
+	"_Imaginary": true,
+}
+
+// libcFunc is a C library function that compilers treat as a builtin. A
+// prototype with the exact-width types of the IR conflicts with the
+// builtin, so the header declaring it is included instead and arguments
+// are converted to the parameter types of the C prototype.
+type libcFunc struct {
+	header string
+	params []string
+}
+
+var libc = map[string]libcFunc{
+	"printf":  {"stdio.h", []string{"const char *"}},
+	"puts":    {"stdio.h", []string{"const char *"}},
+	"putchar": {"stdio.h", []string{"int"}},
+	"abort":   {"stdlib.h", nil},
+	"exit":    {"stdlib.h", []string{"int"}},
+	"malloc":  {"stdlib.h", []string{"size_t"}},
+	"calloc":  {"stdlib.h", []string{"size_t", "size_t"}},
+	"free":    {"stdlib.h", []string{"void *"}},
+	"memcpy":  {"string.h", []string{"void *", "const void *", "size_t"}},
+	"memmove": {"string.h", []string{"void *", "const void *", "size_t"}},
+	"memset":  {"string.h", []string{"void *", "int", "size_t"}},
+	"strlen":  {"string.h", []string{"const char *"}},
+}
+
+// libcFunction returns the C library function that declaration f stands
+// for. Only external declarations with the right number of parameters
+// count.
+func libcFunction(f *ir.Function) (libcFunc, bool) {
+	lf, ok := libc[f.Name()]
+	if !ok || len(f.Blocks) > 0 || linkage(f) != ir.ExternalLinkage || len(f.FuncType.ParamTypes) != len(lf.params) {
+		return libcFunc{}, false
+	}
+	return lf, true
+}
+
+func isIdent(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ident turns an IR name into a C identifier
+func ident(s string) string {
+	s = sanitize(s)
+	if !isIdent(s) || keywords[s] {
+		s = "_" + s
+	}
+	return s
+}
+
+// sanitize replaces the characters C does not allow in identifiers with
+// underscores
+func sanitize(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+// unique returns name, or name with a numeric suffix if it is already used,
+// and marks the result used
+func unique(used map[string]bool, name string) string {
+	s := name
+	for i := 1; used[s]; i++ {
+		s = fmt.Sprintf("%s_%d", name, i)
+	}
+	used[s] = true
+	return s
+}
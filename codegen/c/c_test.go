@@ -0,0 +1,133 @@
+package c
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/codegen/internal/golden"
+)
+
+// TestGolden compares the C source for each example module in
+// ../../testdata with testdata/<name>.c
+func TestGolden(t *testing.T) {
+	golden.Run(t, ".c", Generate)
+}
+
+func TestUnsupported(t *testing.T) {
+	m, err := asm.Parse("vec", `
+define external <4 x i32> @vadd(<4 x i32> %a, <4 x i32> %b) {
+entry:
+  %s = add <4 x i32> %a, %b
+  ret <4 x i32> %s
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	if err := Generate(&bytes.Buffer{}, m); !errors.As(err, &e) || e.Func == nil || e.Func.Name() != "vadd" {
+		t.Errorf("got error %v, want an *Error for @vadd", err)
+	}
+}
+
+// libcSrc calls printf, a C library builtin, and ignores its result
+const libcSrc = `
+@fmt = private constant [4 x i8] [i8 37, i8 100, i8 10, i8 0]
+
+declare external i32 @printf(ptr<i8>, ...)
+
+define external i32 @main() {
+entry:
+  %p = getelementptr [4 x i8], ptr<[4 x i8]> %fmt, i32 0, i32 0
+  %n = call i32 @printf(ptr<i8> %p, i32 42)
+  %unused = add i32 %n, 1
+  ret i32 0
+}
+`
+
+// compileAndRun translates the module in src, compiles it with every
+// warning enabled and returns what the program prints
+func compileAndRun(t *testing.T, cc, name, src string) string {
+	t.Helper()
+	m, err := asm.Parse(name, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Generate(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	file, prog := filepath.Join(dir, name+".c"), filepath.Join(dir, name)
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(cc, "-std=c99", "-Wall", "-Werror", "-o", prog, file).CombinedOutput(); err != nil {
+		t.Fatalf("cc: %v\n%s\n%s", err, out, buf.Bytes())
+	}
+	out, err := exec.Command(prog).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// TestCompile compiles the golden files and a program calling printf
+// with every warning enabled, and runs the program
+func TestCompile(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	files, err := filepath.Glob("testdata/*.c")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no golden files: %v", err)
+	}
+	for _, file := range files {
+		if out, err := exec.Command(cc, "-std=c99", "-Wall", "-Werror", "-c", "-o", os.DevNull, file).CombinedOutput(); err != nil {
+			t.Errorf("%s: %v\n%s", file, err, out)
+		}
+	}
+	if got := compileAndRun(t, cc, "libc", libcSrc); got != "42\n" {
+		t.Errorf("program printed %q, want \"42\\n\"", got)
+	}
+}
+
+// swapSrc swaps two phis on the back edge, which needs a temporary, and
+// prints them after n iterations
+const swapSrc = `
+@fmt = private constant [7 x i8] [i8 37, i8 100, i8 32, i8 37, i8 100, i8 10, i8 0]
+
+declare external i32 @printf(ptr<i8>, ...)
+
+define external i32 @main() {
+entry:
+  br label %loop
+loop:
+  %i = phi i32 [ 0, %entry ], [ %i1, %loop ]
+  %a = phi i32 [ 1, %entry ], [ %b, %loop ]
+  %b = phi i32 [ 2, %entry ], [ %a, %loop ]
+  %i1 = add i32 %i, 1
+  %done = icmp sge i32 %i1, 3
+  br i1 %done, label %exit, label %loop
+exit:
+  %p = getelementptr [7 x i8], ptr<[7 x i8]> %fmt, i32 0, i32 0
+  %n = call i32 @printf(ptr<i8> %p, i32 %a, i32 %b)
+  ret i32 0
+}
+`
+
+func TestPhiSwap(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	if got := compileAndRun(t, cc, "swap", swapSrc); got != "1 2\n" {
+		t.Errorf("program printed %q, want \"1 2\\n\"", got)
+	}
+}
@@ -0,0 +1,340 @@
+package c
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/transform"
+	"github.com/arc-language/core-builder/types"
+)
+
+// funcGen holds the state of one function being translated
+type funcGen struct {
+	*gen
+	fn      *ir.Function
+	body    strings.Builder
+	decls   []string
+	names   map[ir.Value]string
+	arrays  map[*ir.AllocaInst]string // storage of fixed-size entry allocas
+	labels  map[*ir.BasicBlock]string
+	targets map[*ir.BasicBlock]bool // blocks that are branched to
+	used    map[string]bool
+}
+
+// prototype returns the declarator of f. With params, the parameters are
+// named after them.
+func (g *gen) prototype(f *ir.Function, params []string) (string, error) {
+	ft := f.FuncType
+	ret, err := g.typeName(ft.ReturnType)
+	if err != nil {
+		return "", &Error{Func: f, Msg: "return type: " + err.Error()}
+	}
+	var list []string
+	for i, t := range ft.ParamTypes {
+		name, err := g.typeName(t)
+		if err != nil {
+			return "", &Error{Func: f, Msg: fmt.Sprintf("parameter %d: %v", i, err)}
+		}
+		if params != nil {
+			name = declare(name, params[i])
+		}
+		list = append(list, name)
+	}
+	if ft.Variadic {
+		if len(list) == 0 {
+			return "", &Error{Func: f, Msg: "variadic functions need a named parameter in C"}
+		}
+		list = append(list, "...")
+	}
+	if len(list) == 0 {
+		list = []string{"void"}
+	}
+	return fmt.Sprintf("%s%s %s(%s)", storageClass(f.Linkage), ret, g.names[f], strings.Join(list, ", ")), nil
+}
+
+// declare returns the declaration of name with C type t
+func declare(t, name string) string {
+	if strings.HasSuffix(t, "*") {
+		return t + name
+	}
+	return t + " " + name
+}
+
+func (g *gen) function(w *strings.Builder, fn *ir.Function) error {
+	f := &funcGen{
+		gen:     g,
+		fn:      fn,
+		names:   make(map[ir.Value]string),
+		arrays:  make(map[*ir.AllocaInst]string),
+		labels:  make(map[*ir.BasicBlock]string),
+		targets: make(map[*ir.BasicBlock]bool),
+		used:    make(map[string]bool),
+	}
+	// Locals must not shadow the globals and functions they refer to
+	for name := range g.used {
+		f.used[name] = true
+	}
+	var params []string
+	for _, a := range fn.Arguments {
+		params = append(params, f.local(a, "v_"))
+	}
+	proto, err := g.prototype(fn, params)
+	if err != nil {
+		return err
+	}
+	if err := f.layout(); err != nil {
+		return err
+	}
+
+	for _, b := range fn.Blocks {
+		if f.targets[b] {
+			fmt.Fprintf(&f.body, "%s:\n", f.labels[b])
+		}
+		for _, inst := range b.Instructions {
+			var err error
+			if inst.IsTerminator() {
+				err = f.terminator(inst)
+			} else {
+				err = f.instruction(inst)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%s\n{\n", proto)
+	for _, d := range f.decls {
+		fmt.Fprintf(w, "\t%s;\n", d)
+	}
+	if len(f.decls) > 0 {
+		w.WriteString("\n")
+	}
+	w.WriteString(f.body.String())
+	w.WriteString("}\n\n")
+	return nil
+}
+
+func (f *funcGen) errorf(inst ir.Instruction, format string, args ...interface{}) error {
+	return &Error{Func: f.fn, Inst: inst, Msg: fmt.Sprintf(format, args...)}
+}
+
+// line writes a statement
+func (f *funcGen) line(format string, args ...interface{}) {
+	f.body.WriteString("\t")
+	fmt.Fprintf(&f.body, format, args...)
+	f.body.WriteString("\n")
+}
+
+// local names the C variable for v
+func (f *funcGen) local(v ir.Value, prefix string) string {
+	name := v.Name()
+	if name == "" {
+		name = "tmp"
+	}
+	s := unique(f.used, prefix+sanitize(name))
+	if _, ok := f.names[v]; !ok {
+		f.names[v] = s
+	}
+	return s
+}
+
+// layout declares a variable for every value and phi temporary and local
+// arrays for the fixed-size entry allocas, and names the blocks
+func (f *funcGen) layout() error {
+	live := f.live()
+	labels := make(map[string]bool)
+	for i, b := range f.fn.Blocks {
+		name := b.Name()
+		if name == "" {
+			name = fmt.Sprintf("bb%d", i)
+		}
+		f.labels[b] = unique(labels, "L_"+sanitize(name))
+		for _, s := range targets(b.Terminator()) {
+			f.targets[s] = true
+		}
+
+		for _, inst := range b.Instructions {
+			t := inst.Type()
+			if t == nil || t.Kind() == types.VoidKind || !live[inst] {
+				continue
+			}
+			tn, err := f.typeName(t)
+			if err != nil {
+				return f.errorf(inst, "%v", err)
+			}
+			f.decls = append(f.decls, declare(tn, f.local(inst, "v_")))
+			if v, ok := inst.(*ir.AllocaInst); ok {
+				n, ok := staticCount(v)
+				if !ok || i != 0 {
+					f.includes["alloca.h"] = true
+					continue
+				}
+				elem, err := f.typeName(v.AllocatedType)
+				if err != nil {
+					return f.errorf(inst, "%v", err)
+				}
+				f.arrays[v] = unique(f.used, "s_"+strings.TrimPrefix(f.names[v], "v_"))
+				f.decls = append(f.decls, fmt.Sprintf("%s[%d]", declare(elem, f.arrays[v]), max(n, 1)))
+			}
+		}
+	}
+	return nil
+}
+
+// live returns the instructions whose results are read, directly or
+// through other values, by an instruction with effects. The others get no
+// variable, so that C compilers do not warn about them.
+func (f *funcGen) live() map[ir.Value]bool {
+	live := make(map[ir.Value]bool)
+	var work []ir.Instruction
+	for _, b := range f.fn.Blocks {
+		for _, inst := range b.Instructions {
+			if hasEffects(inst) {
+				work = append(work, inst)
+			}
+		}
+	}
+	for len(work) > 0 {
+		inst := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, op := range inst.Operands() {
+			if i, ok := op.(ir.Instruction); ok && !live[i] {
+				live[i] = true
+				work = append(work, i)
+			}
+		}
+	}
+	return live
+}
+
+// staticCount returns the element count of an alloca if it is a constant
+func staticCount(a *ir.AllocaInst) (int64, bool) {
+	if a.NumElements == nil {
+		return 1, true
+	}
+	if c, ok := a.NumElements.(*ir.ConstantInt); ok && c.Value >= 0 {
+		return c.Value, true
+	}
+	return 0, false
+}
+
+// targets returns the branch targets of a terminator
+func targets(term ir.Instruction) []*ir.BasicBlock {
+	switch t := term.(type) {
+	case *ir.BrInst:
+		return []*ir.BasicBlock{t.Target}
+	case *ir.CondBrInst:
+		return []*ir.BasicBlock{t.TrueBlock, t.FalseBlock}
+	case *ir.SwitchInst:
+		list := []*ir.BasicBlock{t.DefaultBlock}
+		for _, c := range t.Cases {
+			list = append(list, c.Block)
+		}
+		return list
+	}
+	return nil
+}
+
+// ============================================================================
+// Control flow
+// ============================================================================
+
+func (f *funcGen) terminator(inst ir.Instruction) error {
+	b := inst.Parent()
+	switch i := inst.(type) {
+	case *ir.RetInst:
+		if len(i.Ops) == 0 || i.Ops[0] == nil {
+			f.line("return;")
+			return nil
+		}
+		v, err := f.value(i.Ops[0])
+		if err != nil {
+			return err
+		}
+		f.line("return %s;", v)
+	case *ir.BrInst:
+		return f.edge(b, i.Target, "\t")
+	case *ir.CondBrInst:
+		cond, err := f.value(i.Condition)
+		if err != nil {
+			return err
+		}
+		if hasPhis(i.TrueBlock) {
+			f.line("if (%s) {", cond)
+			if err := f.edge(b, i.TrueBlock, "\t\t"); err != nil {
+				return err
+			}
+			f.line("}")
+		} else {
+			f.line("if (%s)", cond)
+			f.line("\tgoto %s;", f.labels[i.TrueBlock])
+		}
+		return f.edge(b, i.FalseBlock, "\t")
+	case *ir.SwitchInst:
+		cond, err := f.value(i.Condition)
+		if err != nil {
+			return err
+		}
+		it, ok := i.Condition.Type().(*types.IntType)
+		if !ok {
+			return f.errorf(inst, "switch on %s", i.Condition.Type())
+		}
+		f.line("switch (%s) {", f.unsigned(cond, it))
+		for _, c := range i.Cases {
+			f.body.WriteString("\tcase " + intLiteral(c.Value.Value, types.NewInt(it.BitWidth, false)) + ":\n")
+			if err := f.edge(b, c.Block, "\t\t"); err != nil {
+				return err
+			}
+		}
+		f.body.WriteString("\tdefault:\n")
+		if err := f.edge(b, i.DefaultBlock, "\t\t"); err != nil {
+			return err
+		}
+		f.line("}")
+	case *ir.UnreachableInst:
+		f.includes["stdlib.h"] = true
+		f.line("abort();")
+	default:
+		return f.errorf(inst, "unsupported terminator")
+	}
+	return nil
+}
+
+// edge assigns the phis of succ their values for the edge from b and jumps
+// to succ. Phis nothing reads have no variable, and neither do the
+// temporaries saving them.
+func (f *funcGen) edge(b, succ *ir.BasicBlock, indent string) error {
+	for _, c := range transform.EdgeCopies(b, succ) {
+		if tmp, ok := c.Dst.(*transform.Temp); ok {
+			if _, ok := f.names[c.Src]; !ok {
+				continue
+			}
+			tn, err := f.typeName(tmp.Type())
+			if err != nil {
+				return err
+			}
+			f.decls = append(f.decls, declare(tn, f.local(tmp, "t_")))
+		}
+		dst, ok := f.names[c.Dst]
+		if !ok {
+			continue
+		}
+		v, err := f.value(c.Src)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&f.body, "%s%s = %s;\n", indent, dst, v)
+	}
+	fmt.Fprintf(&f.body, "%sgoto %s;\n", indent, f.labels[succ])
+	return nil
+}
+
+func hasPhis(b *ir.BasicBlock) bool {
+	if len(b.Instructions) == 0 {
+		return false
+	}
+	_, ok := b.Instructions[0].(*ir.PhiInst)
+	return ok
+}
@@ -0,0 +1,623 @@
+package c
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// ============================================================================
+// Operands
+// ============================================================================
+
+// value returns a C expression for v. Expressions are atoms or casts, so
+// they can be used as operands without further parentheses.
+func (f *funcGen) value(v ir.Value) (string, error) {
+	switch c := v.(type) {
+	case *ir.ConstantInt:
+		it, ok := c.Type().(*types.IntType)
+		if !ok {
+			return "", &Error{Func: f.fn, Msg: "integer constant of type " + c.Type().String()}
+		}
+		return intLiteral(c.Value, it), nil
+	case *ir.ConstantFloat:
+		return f.floatLiteral(c.Value, c.Type()), nil
+	case *ir.ConstantNull, *ir.ConstantZero, *ir.ConstantUndef, *ir.ConstantArray, *ir.ConstantStruct:
+		tn, err := f.typeName(v.Type())
+		if err != nil {
+			return "", &Error{Func: f.fn, Msg: err.Error()}
+		}
+		if !types.IsAggregate(v.Type()) {
+			return "((" + tn + ")0)", nil
+		}
+		init, err := f.initializer(c.(ir.Constant), v.Type())
+		if err != nil {
+			return "", &Error{Func: f.fn, Msg: err.Error()}
+		}
+		return "((" + tn + ")" + init + ")", nil
+	case *ir.Global:
+		if c.IsConstant {
+			tn, err := f.typeName(c.Type())
+			if err != nil {
+				return "", &Error{Func: f.fn, Msg: err.Error()}
+			}
+			return "((" + tn + ")&" + f.gen.names[c] + ")", nil
+		}
+		return "(&" + f.gen.names[c] + ")", nil
+	case *ir.Function:
+		return "((void *)&" + f.gen.names[c] + ")", nil
+	}
+	name, ok := f.names[v]
+	if !ok {
+		return "", &Error{Func: f.fn, Msg: "use of unknown value " + v.String()}
+	}
+	return name, nil
+}
+
+// operands returns the expressions for vals
+func (f *funcGen) operands(vals ...ir.Value) ([]string, error) {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		s, err := f.value(v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// unsigned converts expression x of integer or pointer type t to the
+// unsigned type of the same width, zero-extending it where it is widened
+func (f *funcGen) unsigned(x string, t types.Type) string {
+	switch t := t.(type) {
+	case *types.IntType:
+		if t.BitWidth == 1 {
+			return "(uint8_t)" + x
+		}
+		return fmt.Sprintf("(uint%d_t)%s", t.BitWidth, x)
+	case *types.PointerType:
+		return "(uintptr_t)" + x
+	}
+	return x
+}
+
+// signed converts expression x of integer or pointer type t to the signed
+// type of the same width, sign-extending it where it is widened
+func (f *funcGen) signed(x string, t types.Type) string {
+	switch t := t.(type) {
+	case *types.IntType:
+		if t.BitWidth == 1 {
+			return "(-(int32_t)" + x + ")"
+		}
+		return fmt.Sprintf("(int%d_t)%s", t.BitWidth, x)
+	case *types.PointerType:
+		return "(intptr_t)" + x
+	}
+	return x
+}
+
+// wide returns the unsigned type integer arithmetic of type t is done in:
+// no narrower than int, so that operands are not promoted to signed int
+func wide(t types.Type) string {
+	if it, ok := t.(*types.IntType); ok && it.BitWidth > 32 {
+		return "uint64_t"
+	}
+	return "uint32_t"
+}
+
+// convert returns x converted to C type tn unless v already has that type
+func (f *funcGen) convert(x, tn string, v ir.Value) string {
+	if have, err := f.typeName(v.Type()); err == nil && have == tn {
+		return x
+	}
+	return "(" + tn + ")" + x
+}
+
+// deref returns the lvalue that ptr points to, as type t
+func (f *funcGen) deref(ptr ir.Value, t types.Type) (string, error) {
+	p, err := f.value(ptr)
+	if err != nil {
+		return "", err
+	}
+	tn, err := f.typeName(t)
+	if err != nil {
+		return "", err
+	}
+	return "*" + f.convert(p, declare(tn, "*"), ptr), nil
+}
+
+// member returns the member access selecting indices of type t, and the
+// type of the member
+func (f *funcGen) member(t types.Type, indices []int) (string, types.Type, error) {
+	var sb strings.Builder
+	for _, idx := range indices {
+		switch ty := t.(type) {
+		case *types.StructType:
+			if idx < 0 || idx >= len(ty.Fields) {
+				return "", nil, fmt.Errorf("field %d of %s out of range", idx, ty)
+			}
+			fmt.Fprintf(&sb, ".f%d", idx)
+			t = ty.Fields[idx]
+		case *types.ArrayType:
+			fmt.Fprintf(&sb, ".a[%d]", idx)
+			t = ty.ElementType
+		default:
+			return "", nil, fmt.Errorf("index into non-aggregate type %s", t)
+		}
+	}
+	return sb.String(), t, nil
+}
+
+// ============================================================================
+// Instructions
+// ============================================================================
+
+func (f *funcGen) instruction(inst ir.Instruction) error {
+	if _, ok := f.names[inst]; !ok && !hasEffects(inst) {
+		return nil
+	}
+	var err error
+	switch i := inst.(type) {
+	case *ir.PhiInst:
+		// The incoming edges assign the phi's variable
+	case *ir.BinaryInst:
+		err = f.binary(i)
+	case *ir.ICmpInst:
+		err = f.icmp(i)
+	case *ir.FCmpInst:
+		err = f.fcmp(i)
+	case *ir.CastInst:
+		err = f.cast(i)
+	case *ir.SelectInst:
+		var ops []string
+		if ops, err = f.operands(i.Ops...); err == nil {
+			f.line("%s = %s ? %s : %s;", f.names[i], ops[0], ops[1], ops[2])
+		}
+	case *ir.AllocaInst:
+		err = f.alloca(i)
+	case *ir.LoadInst:
+		var src string
+		if src, err = f.deref(i.Ops[0], i.Type()); err == nil {
+			f.assign(i, src)
+		}
+	case *ir.StoreInst:
+		var dst, val string
+		if dst, err = f.deref(i.Ops[1], i.Ops[0].Type()); err == nil {
+			if val, err = f.value(i.Ops[0]); err == nil {
+				f.line("%s = %s;", dst, val)
+			}
+		}
+	case *ir.GetElementPtrInst:
+		err = f.gep(i)
+	case *ir.ExtractValueInst:
+		err = f.extractValue(i)
+	case *ir.InsertValueInst:
+		err = f.insertValue(i)
+	case *ir.CallInst:
+		err = f.call(i)
+	case *ir.SyscallInst:
+		err = f.syscall(i)
+	case *ir.VaStartInst, *ir.VaArgInst, *ir.VaEndInst:
+		err = f.vaInst(inst)
+	default:
+		return f.errorf(inst, "unsupported instruction")
+	}
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			err = f.errorf(inst, "%v", err)
+		}
+	}
+	return err
+}
+
+// hasEffects reports whether inst must be translated even if nothing reads
+// its result
+func hasEffects(inst ir.Instruction) bool {
+	switch i := inst.(type) {
+	case *ir.StoreInst, *ir.CallInst, *ir.SyscallInst, *ir.VaStartInst, *ir.VaArgInst, *ir.VaEndInst:
+		return true
+	case *ir.LoadInst:
+		return i.Volatile
+	}
+	return inst.Type() == nil || inst.Type().Kind() == types.VoidKind
+}
+
+// assign writes a statement storing expression x in the variable of v, or
+// evaluating it for its effects if v has none
+func (f *funcGen) assign(v ir.Value, x string) {
+	if name, ok := f.names[v]; ok {
+		f.line("%s = %s;", name, x)
+	} else {
+		f.line("(void)%s;", x)
+	}
+}
+
+var binaryOps = map[ir.Opcode]string{
+	ir.OpAdd: "+", ir.OpSub: "-", ir.OpMul: "*",
+	ir.OpUDiv: "/", ir.OpSDiv: "/", ir.OpURem: "%", ir.OpSRem: "%",
+	ir.OpFAdd: "+", ir.OpFSub: "-", ir.OpFMul: "*", ir.OpFDiv: "/",
+	ir.OpShl: "<<", ir.OpLShr: ">>", ir.OpAShr: ">>",
+	ir.OpAnd: "&", ir.OpOr: "|", ir.OpXor: "^",
+}
+
+func (f *funcGen) binary(i *ir.BinaryInst) error {
+	ops, err := f.operands(i.Ops[0], i.Ops[1])
+	if err != nil {
+		return err
+	}
+	x, y := ops[0], ops[1]
+	t := i.Type()
+	name := f.names[i]
+	if types.IsFloat(t) {
+		if i.Op == ir.OpFRem {
+			f.includes["math.h"] = true
+			fn := "fmod"
+			if t.(*types.FloatType).BitWidth == 32 {
+				fn = "fmodf"
+			}
+			f.line("%s = %s(%s, %s);", name, fn, x, y)
+			return nil
+		}
+		f.line("%s = %s %s %s;", name, x, binaryOps[i.Op], y)
+		return nil
+	}
+
+	it, ok := t.(*types.IntType)
+	if !ok {
+		return f.errorf(i, "unsupported operand type %s", t)
+	}
+	var expr string
+	switch i.Op {
+	case ir.OpUDiv, ir.OpURem, ir.OpLShr:
+		expr = f.unsigned(x, it) + " " + binaryOps[i.Op] + " " + f.unsigned(y, it)
+	case ir.OpSDiv, ir.OpSRem:
+		expr = f.signed(x, it) + " " + binaryOps[i.Op] + " " + f.signed(y, it)
+	case ir.OpAShr:
+		expr = f.signed(x, it) + " >> " + f.unsigned(y, it)
+	default:
+		op, ok := binaryOps[i.Op]
+		if !ok {
+			return f.errorf(i, "unsupported operation")
+		}
+		w := wide(it)
+		expr = "(" + w + ")" + x + " " + op + " (" + w + ")" + y
+	}
+	tn, _ := f.typeName(t)
+	if it.BitWidth == 1 {
+		f.line("%s = (%s)((%s) & 1);", name, tn, expr)
+	} else {
+		f.line("%s = (%s)(%s);", name, tn, expr)
+	}
+	return nil
+}
+
+var icmpOps = map[ir.ICmpPredicate]string{
+	ir.ICmpEQ: "==", ir.ICmpNE: "!=",
+	ir.ICmpUGT: ">", ir.ICmpUGE: ">=", ir.ICmpULT: "<", ir.ICmpULE: "<=",
+	ir.ICmpSGT: ">", ir.ICmpSGE: ">=", ir.ICmpSLT: "<", ir.ICmpSLE: "<=",
+}
+
+func (f *funcGen) icmp(i *ir.ICmpInst) error {
+	ops, err := f.operands(i.Ops[0], i.Ops[1])
+	if err != nil {
+		return err
+	}
+	t := i.Ops[0].Type()
+	conv := f.unsigned
+	switch i.Predicate {
+	case ir.ICmpSGT, ir.ICmpSGE, ir.ICmpSLT, ir.ICmpSLE:
+		conv = f.signed
+	}
+	f.line("%s = %s %s %s;", f.names[i], conv(ops[0], t), icmpOps[i.Predicate], conv(ops[1], t))
+	return nil
+}
+
+// unorderedInverse maps each unordered predicate to the ordered one it
+// negates
+var unorderedInverse = map[ir.FCmpPredicate]ir.FCmpPredicate{
+	ir.FCmpUEQ: ir.FCmpONE, ir.FCmpUNE: ir.FCmpOEQ,
+	ir.FCmpUGT: ir.FCmpOLE, ir.FCmpUGE: ir.FCmpOLT,
+	ir.FCmpULT: ir.FCmpOGE, ir.FCmpULE: ir.FCmpOGT,
+	ir.FCmpUNO: ir.FCmpORD,
+}
+
+// fcmp relies on the C comparisons other than != being false for NaN
+func (f *funcGen) fcmp(i *ir.FCmpInst) error {
+	ops, err := f.operands(i.Ops[0], i.Ops[1])
+	if err != nil {
+		return err
+	}
+	x, y := ops[0], ops[1]
+	pred, negate := i.Predicate, false
+	if inv, ok := unorderedInverse[pred]; ok {
+		pred, negate = inv, true
+	}
+	var expr string
+	switch pred {
+	case ir.FCmpFalse:
+		expr = "0"
+	case ir.FCmpTrue:
+		expr = "1"
+	case ir.FCmpOEQ:
+		expr = x + " == " + y
+	case ir.FCmpOGT:
+		expr = x + " > " + y
+	case ir.FCmpOGE:
+		expr = x + " >= " + y
+	case ir.FCmpOLT:
+		expr = x + " < " + y
+	case ir.FCmpOLE:
+		expr = x + " <= " + y
+	case ir.FCmpONE:
+		expr = x + " < " + y + " || " + x + " > " + y
+	case ir.FCmpORD:
+		expr = x + " == " + x + " && " + y + " == " + y
+	}
+	if negate {
+		expr = "!(" + expr + ")"
+	}
+	f.line("%s = %s;", f.names[i], expr)
+	return nil
+}
+
+func (f *funcGen) cast(i *ir.CastInst) error {
+	v := i.Ops[0]
+	x, err := f.value(v)
+	if err != nil {
+		return err
+	}
+	src, dst := v.Type(), i.Type()
+	tn, _ := f.typeName(dst)
+	name := f.names[i]
+	var expr string
+	switch i.Op {
+	case ir.OpTrunc, ir.OpZExt, ir.OpUIToFP:
+		expr = f.unsigned(x, src)
+	case ir.OpSExt, ir.OpSIToFP:
+		expr = f.signed(x, src)
+	case ir.OpFPTrunc, ir.OpFPExt:
+		expr = x
+	case ir.OpFPToUI, ir.OpFPToSI:
+		// Converting to the signedness of the operation first keeps values
+		// in its range defined
+		it, ok := dst.(*types.IntType)
+		switch {
+		case !ok:
+			return f.errorf(i, "conversion to %s", dst)
+		case it.BitWidth == 1:
+			expr = "(" + x + " != 0)"
+		case i.Op == ir.OpFPToUI:
+			expr = fmt.Sprintf("(uint%d_t)%s", it.BitWidth, x)
+		default:
+			expr = fmt.Sprintf("(int%d_t)%s", it.BitWidth, x)
+		}
+	case ir.OpPtrToInt:
+		expr = "(uintptr_t)" + x
+	case ir.OpIntToPtr:
+		expr = "(uintptr_t)" + f.unsigned(x, src)
+	case ir.OpBitcast:
+		_, srcPtr := src.(*types.PointerType)
+		_, dstPtr := dst.(*types.PointerType)
+		switch {
+		case srcPtr && dstPtr:
+			expr = x
+		case types.IsAggregate(src) || types.IsAggregate(dst) || srcPtr || dstPtr:
+			return f.errorf(i, "cannot bitcast %s to %s", src, dst)
+		default:
+			// Reinterpreting the bits of a value goes through memory
+			srcName, _ := f.typeName(src)
+			f.includes["string.h"] = true
+			f.line("memcpy(&%s, &(%s){%s}, sizeof %s);", name, srcName, x, name)
+			return nil
+		}
+	default:
+		return f.errorf(i, "unsupported cast")
+	}
+	f.line("%s = (%s)%s;", name, tn, expr)
+	return nil
+}
+
+// alloca points fixed-size entry allocas at their local array; the others
+// call alloca, whose memory lasts until the function returns
+func (f *funcGen) alloca(i *ir.AllocaInst) error {
+	if arr, ok := f.arrays[i]; ok {
+		f.line("%s = %s;", f.names[i], arr)
+		return nil
+	}
+	elem, err := f.typeName(i.AllocatedType)
+	if err != nil {
+		return err
+	}
+	size := "sizeof(" + elem + ")"
+	if i.NumElements != nil {
+		n, err := f.value(i.NumElements)
+		if err != nil {
+			return err
+		}
+		size += " * (size_t)" + f.unsigned(n, i.NumElements.Type())
+	}
+	f.line("%s = alloca(%s);", f.names[i], size)
+	return nil
+}
+
+func (f *funcGen) gep(i *ir.GetElementPtrInst) error {
+	base, err := f.value(i.Ops[0])
+	if err != nil {
+		return err
+	}
+	elem, err := f.typeName(i.SourceElementType)
+	if err != nil {
+		return err
+	}
+	index := func(idx ir.Value) (string, error) {
+		if c, ok := idx.(*ir.ConstantInt); ok {
+			it := c.Type().(*types.IntType)
+			return intLiteral(c.Value, types.NewInt(it.BitWidth, true)), nil
+		}
+		x, err := f.value(idx)
+		if err != nil {
+			return "", err
+		}
+		return f.signed(x, idx.Type()), nil
+	}
+
+	first, err := index(i.Ops[1])
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "&%s[%s]", f.convert(base, declare(elem, "*"), i.Ops[0]), first)
+	t := i.SourceElementType
+	for _, idx := range i.Ops[2:] {
+		switch ty := t.(type) {
+		case *types.StructType:
+			c, ok := idx.(*ir.ConstantInt)
+			if !ok || c.Value < 0 || c.Value >= int64(len(ty.Fields)) {
+				return f.errorf(i, "invalid field index %s", idx)
+			}
+			fmt.Fprintf(&sb, ".f%d", c.Value)
+			t = ty.Fields[c.Value]
+		case *types.ArrayType:
+			x, err := index(idx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&sb, ".a[%s]", x)
+			t = ty.ElementType
+		default:
+			return f.errorf(i, "cannot index into %s", t)
+		}
+	}
+	f.line("%s = %s;", f.names[i], sb.String())
+	return nil
+}
+
+func (f *funcGen) extractValue(i *ir.ExtractValueInst) error {
+	agg, err := f.value(i.Ops[0])
+	if err != nil {
+		return err
+	}
+	m, _, err := f.member(i.Ops[0].Type(), i.Indices)
+	if err != nil {
+		return err
+	}
+	f.line("%s = %s%s;", f.names[i], agg, m)
+	return nil
+}
+
+func (f *funcGen) insertValue(i *ir.InsertValueInst) error {
+	ops, err := f.operands(i.Ops[0], i.Ops[1])
+	if err != nil {
+		return err
+	}
+	m, _, err := f.member(i.Ops[0].Type(), i.Indices)
+	if err != nil {
+		return err
+	}
+	name := f.names[i]
+	f.line("%s = %s;", name, ops[0])
+	f.line("%s%s = %s;", name, m, ops[1])
+	return nil
+}
+
+// ============================================================================
+// Calls
+// ============================================================================
+
+func (f *funcGen) call(i *ir.CallInst) error {
+	callee := i.Callee
+	if callee == nil {
+		callee = f.m.GetFunction(i.CalleeName)
+	}
+	if callee == nil {
+		return f.errorf(i, "call to unknown function @%s", i.CalleeName)
+	}
+	var args []ir.Value
+	for _, a := range i.Ops {
+		if a != nil {
+			args = append(args, a)
+		}
+	}
+	ft := callee.FuncType
+	if len(args) < len(ft.ParamTypes) || len(args) > len(ft.ParamTypes) && !ft.Variadic {
+		return f.errorf(i, "wrong number of arguments")
+	}
+	list := make([]string, len(args))
+	for k, a := range args {
+		x, err := f.value(a)
+		if err != nil {
+			return err
+		}
+		// Variadic arguments have no parameter type to convert them to, so
+		// they are converted to their own type
+		t := a.Type()
+		if k < len(ft.ParamTypes) {
+			t = ft.ParamTypes[k]
+		}
+		tn, err := f.typeName(t)
+		if err != nil {
+			return err
+		}
+		if lf, ok := libcFunction(callee); ok && k < len(lf.params) {
+			list[k] = "(" + lf.params[k] + ")" + x
+		} else if k < len(ft.ParamTypes) {
+			list[k] = f.convert(x, tn, a)
+		} else {
+			list[k] = "(" + tn + ")" + x
+		}
+	}
+	f.assign(i, fmt.Sprintf("%s(%s)", f.gen.names[callee], strings.Join(list, ", ")))
+	return nil
+}
+
+func (f *funcGen) syscall(i *ir.SyscallInst) error {
+	ops, err := f.operands(i.Ops...)
+	if err != nil {
+		return err
+	}
+	for k, x := range ops {
+		ops[k] = "(long)" + x
+	}
+	f.includes["unistd.h"] = true
+	f.assign(i, fmt.Sprintf("syscall(%s)", strings.Join(ops, ", ")))
+	return nil
+}
+
+// vaInst translates va_start, va_arg and va_end, whose operand points to
+// storage for a va_list
+func (f *funcGen) vaInst(inst ir.Instruction) error {
+	f.includes["stdarg.h"] = true
+	list, err := f.value(inst.Operands()[0])
+	if err != nil {
+		return err
+	}
+	list = "*(va_list *)" + list
+	switch i := inst.(type) {
+	case *ir.VaStartInst:
+		if !f.fn.FuncType.Variadic || len(f.fn.Arguments) == 0 {
+			return f.errorf(inst, "va_start in a function without variadic arguments")
+		}
+		f.line("va_start(%s, %s);", list, f.names[f.fn.Arguments[len(f.fn.Arguments)-1]])
+	case *ir.VaArgInst:
+		// va_arg must name the type the argument was promoted to
+		tn, err := f.typeName(i.ArgType)
+		if err != nil {
+			return err
+		}
+		switch t := i.ArgType.(type) {
+		case *types.IntType:
+			if t.BitWidth < 32 {
+				tn = "int"
+			}
+		case *types.FloatType:
+			tn = "double"
+		}
+		f.assign(i, fmt.Sprintf("va_arg(%s, %s)", list, tn))
+	case *ir.VaEndInst:
+		f.line("va_end(%s);", list)
+	}
+	return nil
+}
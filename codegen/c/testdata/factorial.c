@@ -0,0 +1,26 @@
+/* Generated from module factorial */
+
+#include <stdint.h>
+
+int32_t factorial(int32_t);
+
+int32_t factorial(int32_t v_n)
+{
+	uint8_t v_cmp;
+	int32_t v_sub;
+	int32_t v_call;
+	int32_t v_mul;
+
+	v_cmp = (int32_t)v_n <= (int32_t)1;
+	if (v_cmp)
+		goto L_then;
+	goto L_else;
+L_then:
+	return 1;
+L_else:
+	v_sub = (int32_t)((uint32_t)v_n - (uint32_t)1);
+	v_call = factorial(v_sub);
+	v_mul = (int32_t)((uint32_t)v_n * (uint32_t)v_call);
+	return v_mul;
+}
+
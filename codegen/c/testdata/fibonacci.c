@@ -0,0 +1,30 @@
+/* Generated from module fibonacci */
+
+#include <stdint.h>
+
+int32_t fib(int32_t);
+
+int32_t fib(int32_t v_n)
+{
+	uint8_t v_cond;
+	int32_t v_sub1;
+	int32_t v_call1;
+	int32_t v_sub2;
+	int32_t v_call2;
+	int32_t v_sum;
+
+	v_cond = (int32_t)v_n < (int32_t)2;
+	if (v_cond)
+		goto L_base_case;
+	goto L_recurse;
+L_base_case:
+	return v_n;
+L_recurse:
+	v_sub1 = (int32_t)((uint32_t)v_n - (uint32_t)1);
+	v_call1 = fib(v_sub1);
+	v_sub2 = (int32_t)((uint32_t)v_n - (uint32_t)2);
+	v_call2 = fib(v_sub2);
+	v_sum = (int32_t)((uint32_t)v_call1 + (uint32_t)v_call2);
+	return v_sum;
+}
+
@@ -0,0 +1,30 @@
+/* Generated from module gcd */
+
+#include <stdint.h>
+
+int32_t gcd(int32_t, int32_t);
+
+int32_t gcd(int32_t v_a, int32_t v_b)
+{
+	int32_t v_curr_a;
+	int32_t v_curr_b;
+	uint8_t v_cond;
+	int32_t v_rem;
+
+	v_curr_a = v_a;
+	v_curr_b = v_b;
+	goto L_loop_head;
+L_loop_head:
+	v_cond = (uint32_t)v_curr_b != (uint32_t)0;
+	if (v_cond)
+		goto L_loop_body;
+	goto L_exit;
+L_loop_body:
+	v_rem = (int32_t)((int32_t)v_curr_a % (int32_t)v_curr_b);
+	v_curr_a = v_curr_b;
+	v_curr_b = v_rem;
+	goto L_loop_head;
+L_exit:
+	return v_curr_a;
+}
+
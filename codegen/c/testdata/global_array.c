@@ -0,0 +1,28 @@
+/* Generated from module global_array */
+
+#include <stdint.h>
+
+struct array0;
+
+struct array0 {
+	int32_t a[2];
+};
+
+int32_t g_val = 42;
+
+int32_t main(void);
+
+int32_t main(void)
+{
+	int32_t v_loaded_val;
+	struct array0 *v_stack_arr;
+	struct array0 s_stack_arr[1];
+	int32_t *v_elem_ptr;
+
+	v_loaded_val = *(&g_val);
+	v_stack_arr = s_stack_arr;
+	v_elem_ptr = &v_stack_arr[0].a[0];
+	*v_elem_ptr = v_loaded_val;
+	return v_loaded_val;
+}
+
@@ -0,0 +1,36 @@
+/* Generated from module narrow */
+
+#include <stdint.h>
+
+int8_t wrap(int8_t, int8_t);
+uint8_t twice(uint8_t);
+int32_t widen(int8_t);
+
+int8_t wrap(int8_t v_a, int8_t v_b)
+{
+	int8_t v_m;
+	int8_t v_r;
+
+	v_m = (int8_t)((uint32_t)v_a * (uint32_t)3);
+	v_r = (int8_t)((uint32_t)v_m + (uint32_t)v_b);
+	return v_r;
+}
+
+uint8_t twice(uint8_t v_b)
+{
+	uint8_t v_s;
+
+	v_s = (uint8_t)((uint32_t)v_b + (uint32_t)v_b);
+	return v_s;
+}
+
+int32_t widen(int8_t v_a)
+{
+	int8_t v_w;
+	int32_t v_x;
+
+	v_w = wrap(v_a, 1);
+	v_x = (int32_t)(int8_t)v_w;
+	return v_x;
+}
+
@@ -0,0 +1,22 @@
+/* Generated from module struct */
+
+#include <stdint.h>
+
+struct Point;
+
+struct Point {
+	int32_t f0;
+	int32_t f1;
+};
+
+void update_y(struct Point *, int32_t);
+
+void update_y(struct Point *v_p, int32_t v_new_y)
+{
+	int32_t *v_y_ptr;
+
+	v_y_ptr = &v_p[0].f1;
+	*v_y_ptr = v_new_y;
+	return;
+}
+
@@ -0,0 +1,31 @@
+/* Generated from module switch */
+
+#include <stdint.h>
+
+int32_t classify(int32_t);
+
+int32_t classify(int32_t v_n)
+{
+	int32_t v_result;
+
+	switch ((uint32_t)v_n) {
+	case 0:
+		goto L_case_zero;
+	case 1:
+		goto L_case_one;
+	default:
+		goto L_default;
+	}
+L_case_zero:
+	v_result = 100;
+	goto L_merge;
+L_case_one:
+	v_result = 200;
+	goto L_merge;
+L_default:
+	v_result = -1;
+	goto L_merge;
+L_merge:
+	return v_result;
+}
+
@@ -0,0 +1,265 @@
+// Package gas holds the parts of GNU assembler output that do not depend on
+// the instruction set, for the native backends: the walk over a module,
+// symbol directives, local labels, the data of global variables and pooled
+// constants, and the copies for phis on control flow edges.
+package gas
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Emitter accumulates the assembly text of one module
+type Emitter struct {
+	DL   *types.DataLayout
+	Out  strings.Builder
+	pool []poolEntry // aggregate and float constants used as operands
+
+	labels int
+}
+
+type poolEntry struct {
+	label string
+	value ir.Constant
+}
+
+// Line appends one formatted line
+func (e *Emitter) Line(format string, args ...interface{}) {
+	fmt.Fprintf(&e.Out, format, args...)
+	e.Out.WriteByte('\n')
+}
+
+// NewLabel returns a fresh local label
+func (e *Emitter) NewLabel() string {
+	e.labels++
+	return fmt.Sprintf(".Ltmp%d", e.labels)
+}
+
+// ConstLabel returns the label of c in the constant pool, adding it if needed
+func (e *Emitter) ConstLabel(c ir.Constant) string {
+	for _, p := range e.pool {
+		if p.value == c {
+			return p.label
+		}
+	}
+	label := fmt.Sprintf(".LCPI%d", len(e.pool))
+	e.pool = append(e.pool, poolEntry{label, c})
+	return label
+}
+
+// SymbolHeader emits the visibility directives for a symbol
+func (e *Emitter) SymbolHeader(name string, linkage ir.Linkage) {
+	switch linkage {
+	case ir.ExternalLinkage:
+		e.Line("\t.globl %s", name)
+	case ir.LinkOnceODRLinkage, ir.WeakODRLinkage:
+		e.Line("\t.weak %s", name)
+	}
+}
+
+// FunctionHeader emits the directives and label starting function name
+func (e *Emitter) FunctionHeader(name string, linkage ir.Linkage, align int) {
+	e.Line("")
+	e.SymbolHeader(name, linkage)
+	e.Line("\t.p2align %d", align)
+	e.Line("\t.type %s,@function", name)
+	e.Line("%s:", name)
+}
+
+// Global emits a global variable. Globals without an initializer are
+// declarations and produce nothing. Constants go to .rodata, globals
+// initialized to zero to .bss and the rest to .data.
+func (e *Emitter) Global(gv *ir.Global) error {
+	if gv.Initializer == nil {
+		return nil
+	}
+	t := gv.Type()
+	if pt, ok := t.(*types.PointerType); ok {
+		t = pt.ElementType
+	}
+	size := e.DL.SizeOf(t)
+	align := e.DL.PrefAlignOf(t)
+	name := gv.Name()
+
+	if gv.Linkage == ir.CommonLinkage {
+		e.Line("\t.comm %s,%d,%d", name, size, align)
+		return nil
+	}
+	switch {
+	case gv.IsConstant:
+		e.Line("\t.section .rodata")
+	case IsZero(gv.Initializer):
+		e.Line("\t.bss")
+	default:
+		e.Line("\t.data")
+	}
+	e.SymbolHeader(name, gv.Linkage)
+	e.Line("\t.p2align %d", Log2(align))
+	e.Line("\t.type %s,@object", name)
+	e.Line("\t.size %s,%d", name, size)
+	e.Line("%s:", name)
+	return e.Data(gv.Initializer, t)
+}
+
+// Finish emits the constant pool and marks the stack non-executable
+func (e *Emitter) Finish() error {
+	if len(e.pool) > 0 {
+		e.Line("\t.section .rodata")
+		// Data may not add entries, so the pool can be walked by index
+		for i := 0; i < len(e.pool); i++ {
+			c := e.pool[i]
+			e.Line("\t.p2align %d", Log2(e.DL.AlignOf(c.value.Type())))
+			e.Line("%s:", c.label)
+			if err := e.Data(c.value, c.value.Type()); err != nil {
+				return err
+			}
+		}
+	}
+	e.Line("\t.section .note.GNU-stack,\"\",@progbits")
+	return nil
+}
+
+// Data emits the bytes of constant c, laid out as type t
+func (e *Emitter) Data(c ir.Constant, t types.Type) error {
+	switch c := c.(type) {
+	case *ir.ConstantInt:
+		return e.scalar(t, uint64(c.Value))
+	case *ir.ConstantFloat:
+		return e.scalar(t, FloatBits(c))
+	case *ir.ConstantNull:
+		return e.scalar(t, 0)
+	case *ir.ConstantZero, *ir.ConstantUndef:
+		if size := e.DL.SizeOf(t); size > 0 {
+			e.Line("\t.zero %d", size)
+		}
+		return nil
+	case *ir.ConstantArray:
+		at, ok := t.(*types.ArrayType)
+		if !ok {
+			return fmt.Errorf("array constant of type %s", t)
+		}
+		if s, ok := asciiData(c, at); ok {
+			e.Line("\t.ascii \"%s\"", s)
+			return nil
+		}
+		for _, el := range c.Elements {
+			if err := e.Data(el, at.ElementType); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *ir.ConstantStruct:
+		st, ok := t.(*types.StructType)
+		if !ok || len(st.Fields) != len(c.Fields) {
+			return fmt.Errorf("struct constant of type %s", t)
+		}
+		l := e.DL.StructLayout(st)
+		var off int64
+		for i, f := range c.Fields {
+			if pad := l.Offsets[i] - off; pad > 0 {
+				e.Line("\t.zero %d", pad)
+			}
+			if err := e.Data(f, st.Fields[i]); err != nil {
+				return err
+			}
+			off = l.Offsets[i] + e.DL.SizeOf(st.Fields[i])
+		}
+		if pad := l.Size - off; pad > 0 {
+			e.Line("\t.zero %d", pad)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported constant %s", c)
+}
+
+func (e *Emitter) scalar(t types.Type, bits uint64) error {
+	size := e.DL.StoreSize(t)
+	switch size {
+	case 1:
+		e.Line("\t.byte %d", uint8(bits))
+	case 2:
+		e.Line("\t.short %d", uint16(bits))
+	case 4:
+		e.Line("\t.long %d", uint32(bits))
+	case 8:
+		e.Line("\t.quad %d", bits)
+	default:
+		return fmt.Errorf("unsupported constant type %s", t)
+	}
+	if pad := e.DL.SizeOf(t) - size; pad > 0 {
+		e.Line("\t.zero %d", pad)
+	}
+	return nil
+}
+
+// asciiData renders an i8 array constant as the operand of .ascii
+func asciiData(c *ir.ConstantArray, t *types.ArrayType) (string, bool) {
+	if it, ok := t.ElementType.(*types.IntType); !ok || it.BitWidth != 8 || len(c.Elements) == 0 {
+		return "", false
+	}
+	var sb strings.Builder
+	for _, el := range c.Elements {
+		ci, ok := el.(*ir.ConstantInt)
+		if !ok {
+			return "", false
+		}
+		switch b := byte(ci.Value); {
+		case b == '"' || b == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case b >= 0x20 && b < 0x7f:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "\\%03o", b)
+		}
+	}
+	return sb.String(), true
+}
+
+// FloatBits returns the IEEE bits of a f32 or f64 constant
+func FloatBits(c *ir.ConstantFloat) uint64 {
+	if ft, ok := c.Type().(*types.FloatType); ok && ft.BitWidth == 32 {
+		return uint64(math.Float32bits(float32(c.Value)))
+	}
+	return math.Float64bits(c.Value)
+}
+
+// IsZero reports whether every byte of c is zero
+func IsZero(c ir.Constant) bool {
+	switch c := c.(type) {
+	case *ir.ConstantZero, *ir.ConstantNull:
+		return true
+	case *ir.ConstantInt:
+		return c.Value == 0
+	case *ir.ConstantFloat:
+		return math.Float64bits(c.Value) == 0
+	case *ir.ConstantArray:
+		for _, el := range c.Elements {
+			if !IsZero(el) {
+				return false
+			}
+		}
+		return true
+	case *ir.ConstantStruct:
+		for _, f := range c.Fields {
+			if !IsZero(f) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Log2 returns the exponent of the smallest power of two not below n
+func Log2(n int64) int {
+	k := 0
+	for int64(1)<<uint(k) < n {
+		k++
+	}
+	return k
+}
@@ -0,0 +1,193 @@
+package gas
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Module holds the state shared by the functions of one module
+type Module struct {
+	*Emitter
+	M       *ir.Module
+	Defined map[string]bool // symbols defined in this module
+}
+
+// Target lowers functions for one instruction set
+type Target interface {
+	// Function appends the code of fn, the index-th function of the module
+	Function(fn *ir.Function, index int) error
+}
+
+// Generate writes the assembly for m to w. Functions with a body become
+// code in .text, lowered by the target newTarget returns; global variables
+// go to .data, constants to .rodata and zero-initialized globals to .bss.
+// Declarations are left to the linker. Errors about the module rather than
+// one function are made with errorf, so that each backend reports its own
+// error type.
+func Generate(w io.Writer, m *ir.Module, errorf func(format string, args ...interface{}) error, newTarget func(*Module) Target) error {
+	dl, err := m.Layout()
+	if err != nil {
+		return errorf("%v", err)
+	}
+	if dl.BigEndian || dl.PointerSize(0) != 8 {
+		return errorf("data layout %q is not a little-endian 64-bit layout", m.DataLayout)
+	}
+
+	mod := &Module{Emitter: &Emitter{DL: dl}, M: m, Defined: make(map[string]bool)}
+	for _, f := range m.Functions {
+		if len(f.Blocks) > 0 {
+			mod.Defined[f.Name()] = true
+		}
+	}
+	for _, gv := range m.Globals {
+		if gv.Initializer != nil {
+			mod.Defined[gv.Name()] = true
+		}
+	}
+
+	t := newTarget(mod)
+	mod.Line("\t.text")
+	for i, f := range m.Functions {
+		if len(f.Blocks) == 0 {
+			continue
+		}
+		if err := t.Function(f, i); err != nil {
+			return err
+		}
+	}
+	for _, gv := range m.Globals {
+		if err := mod.Global(gv); err != nil {
+			return errorf("@%s: %v", gv.Name(), err)
+		}
+	}
+	if err := mod.Finish(); err != nil {
+		return errorf("%v", err)
+	}
+	_, err = io.WriteString(w, mod.Out.String())
+	return err
+}
+
+// ============================================================================
+// Frames
+// ============================================================================
+
+// AlignTo rounds n up to a multiple of align
+func AlignTo(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
+
+// StaticCount returns the element count of an alloca if it is a constant
+func StaticCount(a *ir.AllocaInst) (int64, bool) {
+	if a.NumElements == nil {
+		return 1, true
+	}
+	if c, ok := a.NumElements.(*ir.ConstantInt); ok && c.Value >= 0 {
+		return c.Value, true
+	}
+	return 0, false
+}
+
+// CheckScalar reports types that cannot be passed in registers
+func CheckScalar(t types.Type) error {
+	switch t.(type) {
+	case *types.IntType, *types.FloatType, *types.PointerType:
+		return CheckType(t)
+	}
+	return fmt.Errorf("%s values cannot be passed or returned", t)
+}
+
+// CheckType reports types the backends have no representation for
+func CheckType(t types.Type) error {
+	switch t := t.(type) {
+	case *types.IntType:
+		switch t.BitWidth {
+		case 1, 8, 16, 32, 64:
+			return nil
+		}
+	case *types.FloatType:
+		if t.BitWidth == 32 || t.BitWidth == 64 {
+			return nil
+		}
+	case *types.PointerType:
+		return nil
+	case *types.ArrayType:
+		return CheckType(t.ElementType)
+	case *types.StructType:
+		for _, ft := range t.Fields {
+			if err := CheckType(ft); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", t)
+}
+
+// ============================================================================
+// Edges
+// ============================================================================
+
+// Edges is implemented by the function lowering of each backend, which
+// keeps every phi in a frame slot and stages its incoming values in a
+// second slot
+type Edges interface {
+	// Label returns the label of b
+	Label(b *ir.BasicBlock) string
+	// Jump emits an unconditional jump to label
+	Jump(label string)
+	// Stage copies v to the staging slot of phi
+	Stage(phi *ir.PhiInst, v ir.Value)
+	// Commit copies the staging slot of phi to its own slot
+	Commit(phi *ir.PhiInst)
+}
+
+// EdgeLabel returns the label to jump to for the edge from b to succ. Edges
+// into blocks with phis go through a stub that performs the copies; the
+// stub is added to stubs, to be emitted after the jumps of b.
+func (e *Emitter) EdgeLabel(t Edges, b, succ *ir.BasicBlock, stubs *[]func()) string {
+	if !HasPhis(succ) {
+		return t.Label(succ)
+	}
+	label := e.NewLabel()
+	*stubs = append(*stubs, func() {
+		e.Line("%s:", label)
+		EdgeCopies(t, b, succ)
+		t.Jump(t.Label(succ))
+	})
+	return label
+}
+
+// EdgeCopies assigns the phis of succ their values for the edge from b.
+// The values are staged first since a phi may read another phi of the same
+// block.
+func EdgeCopies(t Edges, b, succ *ir.BasicBlock) {
+	var phis []*ir.PhiInst
+	for _, inst := range succ.Instructions {
+		phi, ok := inst.(*ir.PhiInst)
+		if !ok {
+			break
+		}
+		for _, inc := range phi.Incoming {
+			if inc.Block == b {
+				t.Stage(phi, inc.Value)
+				phis = append(phis, phi)
+				break
+			}
+		}
+	}
+	for _, phi := range phis {
+		t.Commit(phi)
+	}
+}
+
+// HasPhis reports whether b starts with a phi
+func HasPhis(b *ir.BasicBlock) bool {
+	if len(b.Instructions) == 0 {
+		return false
+	}
+	_, ok := b.Instructions[0].(*ir.PhiInst)
+	return ok
+}
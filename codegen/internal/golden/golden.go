@@ -0,0 +1,82 @@
+// Package golden runs a backend over the example modules in the testdata
+// directory at the root of the repository and compares its output with the
+// golden files in the testdata directory of the backend. Run the tests of a
+// backend with -update to rewrite its golden files.
+package golden
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/ir"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Modules parses the example modules, in the order of their file names.
+// The name of each module is its file name without the extension.
+func Modules(t *testing.T) []*ir.Module {
+	t.Helper()
+	files, err := filepath.Glob("../../testdata/*.ll")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no example modules: %v", err)
+	}
+	var list []*ir.Module
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := asm.Parse(strings.TrimSuffix(filepath.Base(file), ".ll"), string(src))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		list = append(list, m)
+	}
+	return list
+}
+
+// Run writes each example module with gen and compares the output with
+// testdata/<name><ext>, in a subtest per module
+func Run(t *testing.T, ext string, gen func(io.Writer, *ir.Module) error) {
+	for _, m := range Modules(t) {
+		m := m
+		t.Run(m.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := gen(&buf, m); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", m.Name+ext)
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := buf.Bytes()
+			if bytes.Equal(got, want) {
+				return
+			}
+			if utf8.Valid(got) {
+				t.Errorf("output differs from %s:\n%s", golden, got)
+				return
+			}
+			i := 0
+			for i < len(got) && i < len(want) && got[i] == want[i] {
+				i++
+			}
+			t.Errorf("output differs from %s at byte %d: got %d bytes, want %d", golden, i, len(got), len(want))
+		})
+	}
+}
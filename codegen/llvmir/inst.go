@@ -0,0 +1,554 @@
+package llvmir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// funcGen holds the state of one function being written
+type funcGen struct {
+	*gen
+	fn    *ir.Function
+	w     *strings.Builder
+	names map[ir.Value]string // reference of each local value and block
+	used  map[string]bool
+}
+
+func (g *gen) function(w *strings.Builder, fn *ir.Function) error {
+	f := &funcGen{gen: g, fn: fn, w: w, names: make(map[ir.Value]string), used: make(map[string]bool)}
+	f.number()
+
+	ret, err := g.typeName(fn.FuncType.ReturnType)
+	if err != nil {
+		return &Error{Func: fn, Msg: "return type: " + err.Error()}
+	}
+	var params []string
+	for i, t := range fn.FuncType.ParamTypes {
+		tn, err := g.typeName(t)
+		if err != nil {
+			return &Error{Func: fn, Msg: fmt.Sprintf("parameter %d: %v", i, err)}
+		}
+		if len(fn.Blocks) > 0 && i < len(fn.Arguments) {
+			tn += " " + f.names[fn.Arguments[i]]
+		}
+		params = append(params, tn)
+	}
+	if fn.FuncType.Variadic {
+		params = append(params, "...")
+	}
+	var attrs string
+	for _, a := range fn.Attributes {
+		attrs += " " + a.String()
+	}
+	if len(fn.Blocks) == 0 {
+		fmt.Fprintf(w, "declare %s @%s(%s)%s\n\n", ret, ident(fn.Name()), strings.Join(params, ", "), attrs)
+		return nil
+	}
+	if fn.Linkage == ir.CommonLinkage {
+		return &Error{Func: fn, Msg: "functions cannot have common linkage"}
+	}
+	fmt.Fprintf(w, "define %s%s @%s(%s)%s {\n", linkage(fn.Linkage), ret, ident(fn.Name()), strings.Join(params, ", "), attrs)
+	for i, b := range fn.Blocks {
+		if i > 0 {
+			w.WriteString("\n")
+		}
+		fmt.Fprintf(w, "%s:\n", strings.TrimPrefix(f.names[b], "%"))
+		for _, inst := range b.Instructions {
+			if err := f.instruction(inst); err != nil {
+				return err
+			}
+		}
+	}
+	w.WriteString("}\n\n")
+	return nil
+}
+
+// number names the arguments, blocks and values of the function. Unnamed
+// ones are numbered in order of definition as LLVM requires; names that
+// look like numbers get a dot in front.
+func (f *funcGen) number() {
+	n := 0
+	name := func(v ir.Value) {
+		s := v.Name()
+		if s == "" {
+			f.names[v] = "%" + strconv.Itoa(n)
+			n++
+			return
+		}
+		if s[0] >= '0' && s[0] <= '9' {
+			s = "." + s
+		}
+		f.names[v] = "%" + ident(f.unique(s))
+	}
+	for _, a := range f.fn.Arguments {
+		name(a)
+	}
+	for _, b := range f.fn.Blocks {
+		name(b)
+		for _, inst := range b.Instructions {
+			if t := inst.Type(); t != nil && t.Kind() != types.VoidKind {
+				name(inst)
+			}
+		}
+	}
+}
+
+// unique returns s, or s with a numeric suffix if it is already used, and
+// marks the result used
+func (f *funcGen) unique(s string) string {
+	name := s
+	for i := 1; f.used[name]; i++ {
+		name = fmt.Sprintf("%s.%d", s, i)
+	}
+	f.used[name] = true
+	return name
+}
+
+func (f *funcGen) errorf(inst ir.Instruction, format string, args ...interface{}) error {
+	return &Error{Func: f.fn, Inst: inst, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (f *funcGen) line(format string, args ...interface{}) {
+	f.w.WriteString("  ")
+	fmt.Fprintf(f.w, format, args...)
+	f.w.WriteString("\n")
+}
+
+// ref returns the operand spelling of v without its type
+func (f *funcGen) ref(v ir.Value) (string, error) {
+	switch v := v.(type) {
+	case *ir.Global:
+		return "@" + ident(v.Name()), nil
+	case *ir.Function:
+		return "@" + ident(v.Name()), nil
+	case ir.Constant:
+		s, err := f.constant(v, v.Type())
+		if err != nil {
+			return "", &Error{Func: f.fn, Msg: err.Error()}
+		}
+		return s, nil
+	}
+	if s, ok := f.names[v]; ok {
+		return s, nil
+	}
+	return "", &Error{Func: f.fn, Msg: "use of unknown value " + v.String()}
+}
+
+// operand returns v with its type
+func (f *funcGen) operand(v ir.Value) (string, error) {
+	tn, err := f.typeName(v.Type())
+	if err != nil {
+		return "", &Error{Func: f.fn, Msg: err.Error()}
+	}
+	r, err := f.ref(v)
+	if err != nil {
+		return "", err
+	}
+	return tn + " " + r, nil
+}
+
+// operands returns vals with their types
+func (f *funcGen) operands(vals ...ir.Value) ([]string, error) {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		s, err := f.operand(v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// ============================================================================
+// Instructions
+// ============================================================================
+
+func (f *funcGen) instruction(inst ir.Instruction) error {
+	var text string
+	var err error
+	switch i := inst.(type) {
+	case *ir.RetInst, *ir.BrInst, *ir.CondBrInst, *ir.SwitchInst, *ir.UnreachableInst:
+		return f.terminator(inst)
+	case *ir.BinaryInst:
+		text, err = f.binary(i)
+	case *ir.ICmpInst:
+		text, err = f.compare("icmp", i.Predicate.String(), i.Ops)
+	case *ir.FCmpInst:
+		text, err = f.compare("fcmp", i.Predicate.String(), i.Ops)
+	case *ir.CastInst:
+		var v, tn string
+		if v, err = f.operand(i.Ops[0]); err == nil {
+			if tn, err = f.typeName(i.Type()); err == nil {
+				text = fmt.Sprintf("%s %s to %s", i.Op, v, tn)
+			}
+		}
+	case *ir.AllocaInst:
+		text, err = f.alloca(i)
+	case *ir.LoadInst:
+		text, err = f.load(i)
+	case *ir.StoreInst:
+		text, err = f.store(i)
+	case *ir.GetElementPtrInst:
+		text, err = f.gep(i)
+	case *ir.PhiInst:
+		text, err = f.phi(i)
+	case *ir.SelectInst:
+		var ops []string
+		if ops, err = f.operands(i.Ops...); err == nil {
+			text = "select " + strings.Join(ops, ", ")
+		}
+	case *ir.CallInst:
+		text, err = f.call(i)
+	case *ir.SyscallInst:
+		text, err = f.syscall(i)
+	case *ir.ExtractValueInst:
+		var agg string
+		if agg, err = f.operand(i.Ops[0]); err == nil {
+			text = "extractvalue " + agg + indexList(i.Indices)
+		}
+	case *ir.InsertValueInst:
+		var ops []string
+		if ops, err = f.operands(i.Ops[0], i.Ops[1]); err == nil {
+			text = "insertvalue " + strings.Join(ops, ", ") + indexList(i.Indices)
+		}
+	case *ir.VaStartInst:
+		f.vaStart = true
+		var list string
+		if list, err = f.operand(i.Ops[0]); err == nil {
+			text = "call void @llvm.va_start(" + list + ")"
+		}
+	case *ir.VaEndInst:
+		f.vaEnd = true
+		var list string
+		if list, err = f.operand(i.Ops[0]); err == nil {
+			text = "call void @llvm.va_end(" + list + ")"
+		}
+	case *ir.VaArgInst:
+		var list, tn string
+		if list, err = f.operand(i.Ops[0]); err == nil {
+			if tn, err = f.typeName(i.ArgType); err == nil {
+				text = "va_arg " + list + ", " + tn
+			}
+		}
+	default:
+		return f.errorf(inst, "unsupported instruction")
+	}
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			err = f.errorf(inst, "%v", err)
+		}
+		return err
+	}
+	if name, ok := f.names[inst]; ok {
+		f.line("%s = %s", name, text)
+	} else {
+		f.line("%s", text)
+	}
+	return nil
+}
+
+func indexList(indices []int) string {
+	var sb strings.Builder
+	for _, idx := range indices {
+		fmt.Fprintf(&sb, ", %d", idx)
+	}
+	return sb.String()
+}
+
+func (f *funcGen) terminator(inst ir.Instruction) error {
+	switch i := inst.(type) {
+	case *ir.RetInst:
+		if len(i.Ops) == 0 || i.Ops[0] == nil {
+			f.line("ret void")
+			return nil
+		}
+		v, err := f.operand(i.Ops[0])
+		if err != nil {
+			return err
+		}
+		f.line("ret %s", v)
+	case *ir.BrInst:
+		f.line("br label %s", f.names[i.Target])
+	case *ir.CondBrInst:
+		cond, err := f.operand(i.Condition)
+		if err != nil {
+			return err
+		}
+		f.line("br %s, label %s, label %s", cond, f.names[i.TrueBlock], f.names[i.FalseBlock])
+	case *ir.SwitchInst:
+		cond, err := f.operand(i.Condition)
+		if err != nil {
+			return err
+		}
+		f.line("switch %s, label %s [", cond, f.names[i.DefaultBlock])
+		for _, c := range i.Cases {
+			v, err := f.operand(c.Value)
+			if err != nil {
+				return err
+			}
+			f.line("  %s, label %s", v, f.names[c.Block])
+		}
+		f.line("]")
+	case *ir.UnreachableInst:
+		f.line("unreachable")
+	}
+	return nil
+}
+
+func (f *funcGen) binary(i *ir.BinaryInst) (string, error) {
+	ops, err := f.operands(i.Ops[0], i.Ops[1])
+	if err != nil {
+		return "", err
+	}
+	text := i.Op.String()
+	switch i.Op {
+	case ir.OpAdd, ir.OpSub, ir.OpMul, ir.OpShl:
+		if i.NoUnsignedWrap {
+			text += " nuw"
+		}
+		if i.NoSignedWrap {
+			text += " nsw"
+		}
+	case ir.OpUDiv, ir.OpSDiv, ir.OpLShr, ir.OpAShr:
+		if i.Exact {
+			text += " exact"
+		}
+	}
+	// The type is written once for both operands
+	rhs, _ := f.ref(i.Ops[1])
+	return text + " " + ops[0] + ", " + rhs, nil
+}
+
+func (f *funcGen) compare(op, pred string, vals []ir.Value) (string, error) {
+	lhs, err := f.operand(vals[0])
+	if err != nil {
+		return "", err
+	}
+	rhs, err := f.ref(vals[1])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s, %s", op, pred, lhs, rhs), nil
+}
+
+func (f *funcGen) alloca(i *ir.AllocaInst) (string, error) {
+	tn, err := f.typeName(i.AllocatedType)
+	if err != nil {
+		return "", err
+	}
+	text := "alloca " + tn
+	if i.NumElements != nil {
+		n, err := f.operand(i.NumElements)
+		if err != nil {
+			return "", err
+		}
+		text += ", " + n
+	}
+	if i.Alignment > 0 {
+		text += fmt.Sprintf(", align %d", i.Alignment)
+	}
+	return text, nil
+}
+
+func (f *funcGen) load(i *ir.LoadInst) (string, error) {
+	tn, err := f.typeName(i.Type())
+	if err != nil {
+		return "", err
+	}
+	ptr, err := f.operand(i.Ops[0])
+	if err != nil {
+		return "", err
+	}
+	text := "load "
+	if i.Volatile {
+		text += "volatile "
+	}
+	text += tn + ", " + ptr
+	if i.Alignment > 0 {
+		text += fmt.Sprintf(", align %d", i.Alignment)
+	}
+	return text, nil
+}
+
+func (f *funcGen) store(i *ir.StoreInst) (string, error) {
+	ops, err := f.operands(i.Ops[0], i.Ops[1])
+	if err != nil {
+		return "", err
+	}
+	text := "store "
+	if i.Volatile {
+		text += "volatile "
+	}
+	text += ops[0] + ", " + ops[1]
+	if i.Alignment > 0 {
+		text += fmt.Sprintf(", align %d", i.Alignment)
+	}
+	return text, nil
+}
+
+// gep writes the indices into structs as i32, the only type LLVM accepts
+// for them
+func (f *funcGen) gep(i *ir.GetElementPtrInst) (string, error) {
+	src, err := f.typeName(i.SourceElementType)
+	if err != nil {
+		return "", err
+	}
+	base, err := f.operand(i.Ops[0])
+	if err != nil {
+		return "", err
+	}
+	text := "getelementptr "
+	if i.InBounds {
+		text += "inbounds "
+	}
+	text += src + ", " + base
+	t := i.SourceElementType
+	for k, idx := range i.Ops[1:] {
+		var s string
+		if st, ok := t.(*types.StructType); ok && k > 0 {
+			c, ok := idx.(*ir.ConstantInt)
+			if !ok || c.Value < 0 || c.Value >= int64(len(st.Fields)) {
+				return "", fmt.Errorf("invalid field index %s", idx)
+			}
+			s = fmt.Sprintf("i32 %d", c.Value)
+			t = st.Fields[c.Value]
+		} else {
+			if s, err = f.operand(idx); err != nil {
+				return "", err
+			}
+			if k > 0 {
+				switch ty := t.(type) {
+				case *types.ArrayType:
+					t = ty.ElementType
+				case *types.VectorType:
+					t = ty.ElementType
+				default:
+					return "", fmt.Errorf("cannot index into %s", t)
+				}
+			}
+		}
+		text += ", " + s
+	}
+	return text, nil
+}
+
+func (f *funcGen) phi(i *ir.PhiInst) (string, error) {
+	tn, err := f.typeName(i.Type())
+	if err != nil {
+		return "", err
+	}
+	arms := make([]string, len(i.Incoming))
+	for k, inc := range i.Incoming {
+		v, err := f.ref(inc.Value)
+		if err != nil {
+			return "", err
+		}
+		arms[k] = fmt.Sprintf("[ %s, %s ]", v, f.names[inc.Block])
+	}
+	return "phi " + tn + " " + strings.Join(arms, ", "), nil
+}
+
+// call spells out the callee's function type for variadic callees, as
+// LLVM requires
+func (f *funcGen) call(i *ir.CallInst) (string, error) {
+	callee := i.Callee
+	if callee == nil {
+		callee = f.m.GetFunction(i.CalleeName)
+	}
+	if callee == nil {
+		return "", fmt.Errorf("call to unknown function @%s", i.CalleeName)
+	}
+	var args []ir.Value
+	for _, a := range i.Ops {
+		if a != nil {
+			args = append(args, a)
+		}
+	}
+	list, err := f.operands(args...)
+	if err != nil {
+		return "", err
+	}
+	ft := callee.FuncType
+	var tn string
+	if ft.Variadic {
+		tn, err = f.typeName(ft)
+	} else {
+		tn, err = f.typeName(ft.ReturnType)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("call %s @%s(%s)", tn, ident(callee.Name()), strings.Join(list, ", ")), nil
+}
+
+// syscallABI is the inline assembly of a system call: the instruction, the
+// register of the result, the number and the arguments, and the clobbers
+type syscallABI struct {
+	asm     string
+	result  string
+	number  string
+	args    []string
+	clobber string
+}
+
+var syscallABIs = map[string]syscallABI{
+	"x86_64":  {"syscall", "{rax}", "{rax}", []string{"{rdi}", "{rsi}", "{rdx}", "{r10}", "{r8}", "{r9}"}, "~{rcx},~{r11},~{memory}"},
+	"aarch64": {"svc #0", "{x0}", "{x8}", []string{"{x0}", "{x1}", "{x2}", "{x3}", "{x4}", "{x5}"}, "~{memory}"},
+	"riscv64": {"ecall", "{x10}", "{x17}", []string{"{x10}", "{x11}", "{x12}", "{x13}", "{x14}", "{x15}"}, "~{memory}"},
+}
+
+// syscall widens the operands to i64 with extra instructions named after
+// the syscall and calls the inline assembly
+func (f *funcGen) syscall(i *ir.SyscallInst) (string, error) {
+	arch := strings.SplitN(f.m.TargetTriple, "-", 2)[0]
+	if arch == "arm64" {
+		arch = "aarch64"
+	}
+	abi, ok := syscallABIs[arch]
+	if !ok {
+		return "", fmt.Errorf("syscalls are not supported for target triple %q", f.m.TargetTriple)
+	}
+	if len(i.Ops) == 0 || len(i.Ops) > len(abi.args)+1 {
+		return "", fmt.Errorf("syscall takes a number and up to %d arguments", len(abi.args))
+	}
+
+	constraints := []string{"=" + abi.result, abi.number}
+	constraints = append(constraints, abi.args[:len(i.Ops)-1]...)
+	constraints = append(constraints, abi.clobber)
+	base := strings.TrimPrefix(f.names[i], "%")
+	var args []string
+	for _, v := range i.Ops {
+		op, err := f.operand(v)
+		if err != nil {
+			return "", err
+		}
+		var conv string
+		switch t := v.Type().(type) {
+		case *types.IntType:
+			switch {
+			case t.BitWidth < 64 && t.Signed && t.BitWidth > 1:
+				conv = "sext"
+			case t.BitWidth < 64:
+				conv = "zext"
+			case t.BitWidth > 64:
+				conv = "trunc"
+			}
+		case *types.PointerType:
+			conv = "ptrtoint"
+		default:
+			return "", fmt.Errorf("syscall operand of type %s", v.Type())
+		}
+		if conv != "" {
+			name := "%" + ident(f.unique(strings.Trim(base, `"`)+".arg"))
+			f.line("%s = %s %s to i64", name, conv, op)
+			op = "i64 " + name
+		}
+		args = append(args, op)
+	}
+	return fmt.Sprintf("call i64 asm sideeffect %s, %s(%s)", quote(abi.asm), quote(strings.Join(constraints, ",")), strings.Join(args, ", ")), nil
+}
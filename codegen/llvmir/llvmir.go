@@ -0,0 +1,416 @@
+// Package llvmir writes IR modules as LLVM textual IR that llvm-as, opt and
+// llc accept, so that LLVM can serve as an alternative backend.
+//
+// The output follows the LLVM 17 syntax. Pointers are opaque ptr values,
+// integers lose their signedness, which the IR only uses to pick default
+// conversions, and definitions with external linkage carry no linkage
+// keyword. va_start and va_end become calls to the llvm.va_start and
+// llvm.va_end intrinsics. Syscalls become inline assembly for the
+// architecture of the module's target triple, which must be x86_64,
+// aarch64 or riscv64.
+//
+// Values keep their names where LLVM allows them; unnamed values and blocks
+// are numbered in order of definition, and names used twice in a function
+// get a numeric suffix.
+package llvmir
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Error reports a value that has no LLVM equivalent
+type Error struct {
+	Func *ir.Function
+	Inst ir.Instruction
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Inst != nil {
+		return fmt.Sprintf("llvmir: @%s: %s: %s", e.Func.Name(), e.Inst, e.Msg)
+	}
+	if e.Func != nil {
+		return fmt.Sprintf("llvmir: @%s: %s", e.Func.Name(), e.Msg)
+	}
+	return "llvmir: " + e.Msg
+}
+
+// Write writes m to w as LLVM textual IR
+func Write(w io.Writer, m *ir.Module) error {
+	g := &gen{m: m, named: make(map[string]*types.StructType)}
+	for name, st := range m.Types {
+		g.named[name] = st
+	}
+
+	var body strings.Builder
+	for _, gv := range m.Globals {
+		if err := g.global(&body, gv); err != nil {
+			return err
+		}
+	}
+	if len(m.Globals) > 0 {
+		body.WriteString("\n")
+	}
+	for _, f := range m.Functions {
+		if err := g.function(&body, f); err != nil {
+			return err
+		}
+	}
+	if g.vaStart {
+		body.WriteString("declare void @llvm.va_start(ptr)\n")
+	}
+	if g.vaEnd {
+		body.WriteString("declare void @llvm.va_end(ptr)\n")
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "; ModuleID = %s\n", quote(m.Name))
+	fmt.Fprintf(bw, "source_filename = %s\n", quote(m.Name))
+	if m.DataLayout != "" {
+		fmt.Fprintf(bw, "target datalayout = %s\n", quote(m.DataLayout))
+	}
+	if m.TargetTriple != "" {
+		fmt.Fprintf(bw, "target triple = %s\n", quote(m.TargetTriple))
+	}
+	bw.WriteString("\n")
+	if err := g.typeDefs(bw); err != nil {
+		return err
+	}
+	bw.WriteString(body.String())
+	return bw.Flush()
+}
+
+type gen struct {
+	m       *ir.Module
+	named   map[string]*types.StructType // named struct types seen so far
+	vaStart bool
+	vaEnd   bool
+}
+
+// typeDefs writes the definitions of the named struct types
+func (g *gen) typeDefs(w *bufio.Writer) error {
+	var names []string
+	for name := range g.named {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st := g.named[name]
+		if st.Fields == nil {
+			fmt.Fprintf(w, "%%%s = type opaque\n", ident(name))
+			continue
+		}
+		body, err := g.structBody(st)
+		if err != nil {
+			return &Error{Msg: fmt.Sprintf("%%%s: %v", name, err)}
+		}
+		fmt.Fprintf(w, "%%%s = type %s\n", ident(name), body)
+	}
+	w.WriteString("\n")
+	return nil
+}
+
+// ============================================================================
+// Types
+// ============================================================================
+
+// typeName returns the LLVM spelling of t
+func (g *gen) typeName(t types.Type) (string, error) {
+	switch t := t.(type) {
+	case *types.VoidType:
+		return "void", nil
+	case *types.LabelType:
+		return "label", nil
+	case *types.IntType:
+		return fmt.Sprintf("i%d", t.BitWidth), nil
+	case *types.FloatType:
+		switch t.BitWidth {
+		case 16:
+			return "half", nil
+		case 32:
+			return "float", nil
+		case 64:
+			return "double", nil
+		case 128:
+			return "fp128", nil
+		}
+	case *types.PointerType:
+		if t.AddressSpace != 0 {
+			return fmt.Sprintf("ptr addrspace(%d)", t.AddressSpace), nil
+		}
+		return "ptr", nil
+	case *types.ArrayType:
+		elem, err := g.typeName(t.ElementType)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("[%d x %s]", t.Length, elem), nil
+	case *types.VectorType:
+		elem, err := g.typeName(t.ElementType)
+		if err != nil {
+			return "", err
+		}
+		if t.Scalable {
+			return fmt.Sprintf("<vscale x %d x %s>", t.Length, elem), nil
+		}
+		return fmt.Sprintf("<%d x %s>", t.Length, elem), nil
+	case *types.StructType:
+		if t.Name != "" {
+			if prev, ok := g.named[t.Name]; ok && prev != t {
+				return "", fmt.Errorf("two struct types named %%%s", t.Name)
+			}
+			g.named[t.Name] = t
+			return "%" + ident(t.Name), nil
+		}
+		return g.structBody(t)
+	case *types.FunctionType:
+		ret, err := g.typeName(t.ReturnType)
+		if err != nil {
+			return "", err
+		}
+		params, err := g.typeList(t.ParamTypes)
+		if err != nil {
+			return "", err
+		}
+		if t.Variadic {
+			params = append(params, "...")
+		}
+		return fmt.Sprintf("%s (%s)", ret, strings.Join(params, ", ")), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", t)
+}
+
+func (g *gen) typeList(list []types.Type) ([]string, error) {
+	out := make([]string, len(list))
+	for i, t := range list {
+		s, err := g.typeName(t)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+func (g *gen) structBody(t *types.StructType) (string, error) {
+	fields, err := g.typeList(t.Fields)
+	if err != nil {
+		return "", err
+	}
+	body := "{}"
+	if len(fields) > 0 {
+		body = "{ " + strings.Join(fields, ", ") + " }"
+	}
+	if t.Packed {
+		body = "<" + body + ">"
+	}
+	return body, nil
+}
+
+// ============================================================================
+// Globals and constants
+// ============================================================================
+
+// linkage returns the linkage keyword of a definition followed by a space,
+// or nothing for external linkage
+func linkage(l ir.Linkage) string {
+	if l == ir.ExternalLinkage {
+		return ""
+	}
+	return l.String() + " "
+}
+
+func (g *gen) global(w *strings.Builder, gv *ir.Global) error {
+	t := gv.Type()
+	if pt, ok := t.(*types.PointerType); ok {
+		t = pt.ElementType
+	}
+	tn, err := g.typeName(t)
+	if err != nil {
+		return &Error{Msg: fmt.Sprintf("@%s: %v", gv.Name(), err)}
+	}
+	kind := "global"
+	if gv.IsConstant {
+		kind = "constant"
+	}
+	if gv.AddressSpace != 0 {
+		kind = fmt.Sprintf("addrspace(%d) %s", gv.AddressSpace, kind)
+	}
+	if gv.Initializer == nil {
+		fmt.Fprintf(w, "@%s = external %s %s\n", ident(gv.Name()), kind, tn)
+		return nil
+	}
+	init, err := g.constant(gv.Initializer, t)
+	if err != nil {
+		return &Error{Msg: fmt.Sprintf("@%s: %v", gv.Name(), err)}
+	}
+	fmt.Fprintf(w, "@%s = %s%s %s %s\n", ident(gv.Name()), linkage(gv.Linkage), kind, tn, init)
+	return nil
+}
+
+// constant returns the LLVM spelling of constant c of type t, without the
+// type
+func (g *gen) constant(c ir.Constant, t types.Type) (string, error) {
+	switch c := c.(type) {
+	case *ir.ConstantInt:
+		it, ok := t.(*types.IntType)
+		if !ok {
+			return "", fmt.Errorf("integer constant of type %s", t)
+		}
+		return intLiteral(c.Value, it.BitWidth), nil
+	case *ir.ConstantFloat:
+		return floatLiteral(c.Value, t)
+	case *ir.ConstantNull:
+		return "null", nil
+	case *ir.ConstantUndef:
+		return "undef", nil
+	case *ir.ConstantZero:
+		return "zeroinitializer", nil
+	case *ir.ConstantArray:
+		var elem types.Type
+		open, close := "[", "]"
+		switch at := t.(type) {
+		case *types.ArrayType:
+			elem = at.ElementType
+		case *types.VectorType:
+			elem, open, close = at.ElementType, "<", ">"
+		default:
+			return "", fmt.Errorf("array constant of type %s", t)
+		}
+		en, err := g.typeName(elem)
+		if err != nil {
+			return "", err
+		}
+		elems := make([]string, len(c.Elements))
+		for i, e := range c.Elements {
+			s, err := g.constant(e, elem)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = en + " " + s
+		}
+		return open + strings.Join(elems, ", ") + close, nil
+	case *ir.ConstantStruct:
+		st, ok := t.(*types.StructType)
+		if !ok || len(st.Fields) != len(c.Fields) {
+			return "", fmt.Errorf("struct constant of type %s", t)
+		}
+		fields := make([]string, len(c.Fields))
+		for i, f := range c.Fields {
+			fn, err := g.typeName(st.Fields[i])
+			if err != nil {
+				return "", err
+			}
+			s, err := g.constant(f, st.Fields[i])
+			if err != nil {
+				return "", err
+			}
+			fields[i] = fn + " " + s
+		}
+		body := "{ " + strings.Join(fields, ", ") + " }"
+		if len(fields) == 0 {
+			body = "{}"
+		}
+		if st.Packed {
+			body = "<" + body + ">"
+		}
+		return body, nil
+	}
+	return "", fmt.Errorf("unsupported constant %s", c)
+}
+
+// intLiteral returns v truncated to width bits as a signed decimal, the
+// way LLVM prints integers
+func intLiteral(v int64, width int) string {
+	if width == 1 {
+		if v&1 != 0 {
+			return "true"
+		}
+		return "false"
+	}
+	if width < 64 {
+		shift := uint(64 - width)
+		v = v << shift >> shift
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// floatLiteral returns v as a constant of type t. Finite doubles are
+// written in the shortest decimal that reads back exactly; everything else
+// uses the hexadecimal form, which for float is the bits of the value as a
+// double.
+func floatLiteral(v float64, t types.Type) (string, error) {
+	ft, ok := t.(*types.FloatType)
+	if !ok {
+		return "", fmt.Errorf("float constant of type %s", t)
+	}
+	switch ft.BitWidth {
+	case 32:
+		v = float64(float32(v))
+	case 64:
+		if !math.IsInf(v, 0) && !math.IsNaN(v) {
+			s := strconv.FormatFloat(v, 'e', -1, 64)
+			if !strings.Contains(s, ".") {
+				// LLVM requires a decimal point
+				s = strings.Replace(s, "e", ".0e", 1)
+			}
+			return s, nil
+		}
+	default:
+		return "", fmt.Errorf("constants of type %s are not supported", t)
+	}
+	return fmt.Sprintf("0x%016X", math.Float64bits(v)), nil
+}
+
+// ============================================================================
+// Identifiers
+// ============================================================================
+
+// ident returns name as an LLVM identifier, quoting it if needed
+func ident(name string) string {
+	if isIdent(name) {
+		return name
+	}
+	return quote(name)
+}
+
+func isIdent(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '-' || r == '$' || r == '.' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// quote returns s as an LLVM string, escaping quotes, backslashes and
+// unprintable bytes as \XX
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&sb, "\\%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
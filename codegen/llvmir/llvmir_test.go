@@ -0,0 +1,36 @@
+package llvmir
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/codegen/internal/golden"
+)
+
+// TestGolden compares the LLVM IR for each example module in
+// ../../testdata with testdata/<name>.ll
+func TestGolden(t *testing.T) {
+	golden.Run(t, ".ll", Write)
+}
+
+func TestUnsupportedSyscall(t *testing.T) {
+	// Syscalls need a target triple to pick their inline assembly
+	m, err := asm.Parse("exit", `
+target triple = "wasm32-unknown-unknown"
+
+define external void @exit(i64 %code) {
+entry:
+  %r = syscall i64 60, i64 %code
+  ret void
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	if err := Write(&bytes.Buffer{}, m); !errors.As(err, &e) || e.Func == nil || e.Func.Name() != "exit" {
+		t.Errorf("got error %v, want an *Error for @exit", err)
+	}
+}
@@ -0,0 +1,18 @@
+; ModuleID = "factorial"
+source_filename = "factorial"
+
+define i32 @factorial(i32 %n) {
+entry:
+  %cmp = icmp sle i32 %n, 1
+  br i1 %cmp, label %then, label %else
+
+then:
+  ret i32 1
+
+else:
+  %sub = sub i32 %n, 1
+  %call = call i32 @factorial(i32 %sub)
+  %mul = mul i32 %n, %call
+  ret i32 %mul
+}
+
@@ -0,0 +1,21 @@
+; ModuleID = "gcd"
+source_filename = "gcd"
+
+define i32 @gcd(i32 %a, i32 %b) {
+entry:
+  br label %loop.head
+
+loop.head:
+  %curr_a = phi i32 [ %a, %entry ], [ %curr_b, %loop.body ]
+  %curr_b = phi i32 [ %b, %entry ], [ %rem, %loop.body ]
+  %cond = icmp ne i32 %curr_b, 0
+  br i1 %cond, label %loop.body, label %exit
+
+loop.body:
+  %rem = srem i32 %curr_a, %curr_b
+  br label %loop.head
+
+exit:
+  ret i32 %curr_a
+}
+
@@ -0,0 +1,14 @@
+; ModuleID = "global_array"
+source_filename = "global_array"
+
+@g_val = global i32 42
+
+define i32 @main() {
+entry:
+  %loaded_val = load i32, ptr @g_val
+  %stack_arr = alloca [2 x i32]
+  %elem_ptr = getelementptr [2 x i32], ptr %stack_arr, i32 0, i32 0
+  store i32 %loaded_val, ptr %elem_ptr
+  ret i32 %loaded_val
+}
+
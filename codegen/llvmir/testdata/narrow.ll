@@ -0,0 +1,23 @@
+; ModuleID = "narrow"
+source_filename = "narrow"
+
+define i8 @wrap(i8 %a, i8 %b) {
+entry:
+  %m = mul i8 %a, 3
+  %r = add i8 %m, %b
+  ret i8 %r
+}
+
+define i8 @twice(i8 %b) {
+entry:
+  %s = add i8 %b, %b
+  ret i8 %s
+}
+
+define i32 @widen(i8 %a) {
+entry:
+  %w = call i8 @wrap(i8 %a, i8 1)
+  %x = sext i8 %w to i32
+  ret i32 %x
+}
+
@@ -0,0 +1,12 @@
+; ModuleID = "struct"
+source_filename = "struct"
+
+%Point = type { i32, i32 }
+
+define void @update_y(ptr %p, i32 %new_y) {
+entry:
+  %y_ptr = getelementptr %Point, ptr %p, i32 0, i32 1
+  store i32 %new_y, ptr %y_ptr
+  ret void
+}
+
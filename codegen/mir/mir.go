@@ -0,0 +1,344 @@
+// Package mir is a target-independent machine IR for native backends, and a
+// register allocator over it.
+//
+// A backend selects instructions for an IR function into a Func whose
+// blocks mirror the function's basic blocks, naming values with an
+// unlimited supply of virtual registers and using physical registers only
+// where the ABI or an instruction demands a particular one. Allocate then
+// assigns a physical register to every virtual register with a linear-scan
+// allocator, spilling to stack slots when registers run out and coalescing
+// copies by giving both sides the same register where possible.
+//
+// Opcodes below FirstTargetOp are generic: copies, spills and reloads. All
+// others belong to the backend, which defines their meaning and emits
+// them; the allocator only looks at their register operands and clobbers.
+// The input must be free of phis: the backend runs transform.LowerPhis and
+// emits its copies as Copy instructions at the end of each block, with a
+// fresh virtual register for each of its temporaries.
+package mir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arc-language/core-builder/ir"
+)
+
+// Error reports a function that cannot be allocated
+type Error struct {
+	Func *Func
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Func != nil {
+		return fmt.Sprintf("mir: @%s: %s", e.Func.Name, e.Msg)
+	}
+	return "mir: " + e.Msg
+}
+
+// ============================================================================
+// Registers
+// ============================================================================
+
+// Reg is a physical or virtual register. Targets number their physical
+// registers from 1; virtual registers are created by Func.NewVReg and
+// start at FirstVirtual.
+type Reg int32
+
+// NoReg is the zero Reg, which names no register
+const NoReg Reg = 0
+
+// FirstVirtual is the first virtual register
+const FirstVirtual Reg = 1 << 16
+
+// IsVirtual reports whether r is a virtual register
+func (r Reg) IsVirtual() bool { return r >= FirstVirtual }
+
+// IsPhysical reports whether r is a physical register
+func (r Reg) IsPhysical() bool { return r > NoReg && r < FirstVirtual }
+
+func (r Reg) String() string {
+	switch {
+	case r == NoReg:
+		return "%noreg"
+	case r.IsVirtual():
+		return fmt.Sprintf("%%v%d", r-FirstVirtual)
+	}
+	return fmt.Sprintf("$r%d", r)
+}
+
+// Class is a register class, such as general purpose or floating-point
+// registers. Targets number their classes from 0.
+type Class uint8
+
+// ============================================================================
+// Instructions
+// ============================================================================
+
+// Opcode identifies a machine instruction
+type Opcode int
+
+// Generic opcodes
+const (
+	OpCopy   Opcode = iota // def, use: copies a register
+	OpSpill                // use, slot: stores a register to a stack slot
+	OpReload               // def, slot: loads a register from a stack slot
+	FirstTargetOp
+)
+
+var opNames = [...]string{
+	OpCopy:   "copy",
+	OpSpill:  "spill",
+	OpReload: "reload",
+}
+
+func (op Opcode) String() string {
+	if op >= 0 && op < FirstTargetOp {
+		return opNames[op]
+	}
+	return fmt.Sprintf("op%d", op)
+}
+
+// OperandKind identifies what an operand holds
+type OperandKind uint8
+
+const (
+	RegOperand OperandKind = iota
+	ImmOperand
+	BlockOperand
+	SymOperand
+	SlotOperand
+)
+
+// Operand is an operand of a machine instruction
+type Operand struct {
+	Kind  OperandKind
+	Reg   Reg
+	Def   bool // the instruction writes Reg rather than reading it
+	Imm   int64
+	Block *Block
+	Sym   string
+	Slot  int
+}
+
+// UseReg returns an operand reading r
+func UseReg(r Reg) Operand { return Operand{Kind: RegOperand, Reg: r} }
+
+// DefReg returns an operand writing r
+func DefReg(r Reg) Operand { return Operand{Kind: RegOperand, Reg: r, Def: true} }
+
+// ImmOp returns an immediate operand
+func ImmOp(v int64) Operand { return Operand{Kind: ImmOperand, Imm: v} }
+
+// BlockOp returns an operand naming a branch target
+func BlockOp(b *Block) Operand { return Operand{Kind: BlockOperand, Block: b} }
+
+// SymOp returns an operand naming a symbol
+func SymOp(name string) Operand { return Operand{Kind: SymOperand, Sym: name} }
+
+// SlotOp returns an operand naming a stack slot of the function
+func SlotOp(slot int) Operand { return Operand{Kind: SlotOperand, Slot: slot} }
+
+func (o Operand) String() string {
+	switch o.Kind {
+	case RegOperand:
+		return o.Reg.String()
+	case ImmOperand:
+		return fmt.Sprint(o.Imm)
+	case BlockOperand:
+		return "%" + o.Block.Name
+	case SymOperand:
+		return "@" + o.Sym
+	case SlotOperand:
+		return fmt.Sprintf("slot%d", o.Slot)
+	}
+	return "?"
+}
+
+// Inst is a machine instruction. Register operands are read before any
+// are written, so an instruction may read and write the same register.
+type Inst struct {
+	Op       Opcode
+	Operands []Operand
+	// Clobbers lists the physical registers the instruction overwrites
+	// besides its defs, such as the caller-saved registers of a call
+	Clobbers []Reg
+}
+
+// NewInst returns an instruction with the given operands
+func NewInst(op Opcode, ops ...Operand) *Inst {
+	return &Inst{Op: op, Operands: ops}
+}
+
+// Copy returns an instruction copying src to dst
+func Copy(dst, src Reg) *Inst {
+	return NewInst(OpCopy, DefReg(dst), UseReg(src))
+}
+
+// IsCopy reports whether i is a register to register copy
+func (i *Inst) IsCopy() bool { return i.Op == OpCopy }
+
+// Uses returns the registers i reads
+func (i *Inst) Uses() []Reg {
+	var regs []Reg
+	for _, o := range i.Operands {
+		if o.Kind == RegOperand && !o.Def {
+			regs = append(regs, o.Reg)
+		}
+	}
+	return regs
+}
+
+// Defs returns the registers i writes, excluding clobbers
+func (i *Inst) Defs() []Reg {
+	var regs []Reg
+	for _, o := range i.Operands {
+		if o.Kind == RegOperand && o.Def {
+			regs = append(regs, o.Reg)
+		}
+	}
+	return regs
+}
+
+func (i *Inst) String() string {
+	var sb strings.Builder
+	var defs, uses []string
+	for _, o := range i.Operands {
+		if o.Kind == RegOperand && o.Def {
+			defs = append(defs, o.String())
+		} else {
+			uses = append(uses, o.String())
+		}
+	}
+	if len(defs) > 0 {
+		sb.WriteString(strings.Join(defs, ", "))
+		sb.WriteString(" = ")
+	}
+	sb.WriteString(i.Op.String())
+	if len(uses) > 0 {
+		sb.WriteString(" ")
+		sb.WriteString(strings.Join(uses, ", "))
+	}
+	if len(i.Clobbers) > 0 {
+		regs := make([]string, len(i.Clobbers))
+		for j, r := range i.Clobbers {
+			regs[j] = r.String()
+		}
+		sb.WriteString(" clobbers ")
+		sb.WriteString(strings.Join(regs, ", "))
+	}
+	return sb.String()
+}
+
+// ============================================================================
+// Blocks and functions
+// ============================================================================
+
+// Block is a machine basic block. Blocks created by NewFunc mirror a basic
+// block of the IR function, including its control flow edges.
+type Block struct {
+	Name         string
+	Source       *ir.BasicBlock // nil for blocks added by the backend
+	Insts        []*Inst
+	Predecessors []*Block
+	Successors   []*Block
+	Parent       *Func
+}
+
+// Append adds i to the end of b
+func (b *Block) Append(i *Inst) {
+	b.Insts = append(b.Insts, i)
+}
+
+// Slot is a stack slot of a function's frame. The backend decides where
+// slots live in the frame.
+type Slot struct {
+	Size  int64
+	Align int64
+}
+
+// Func is a machine function. The order of Blocks is the layout order.
+type Func struct {
+	Name   string
+	Source *ir.Function
+	Blocks []*Block
+	Slots  []Slot
+	// UsedRegs lists the physical registers Allocate assigned, so the
+	// backend can save those that are callee-saved
+	UsedRegs []Reg
+
+	classes []Class // class of each virtual register
+	blocks  map[*ir.BasicBlock]*Block
+}
+
+// NewFunc returns a machine function with an empty block for every basic
+// block of fn, in the same order and with the same edges
+func NewFunc(fn *ir.Function) *Func {
+	f := &Func{Name: fn.Name(), Source: fn, blocks: make(map[*ir.BasicBlock]*Block)}
+	for _, b := range fn.Blocks {
+		mb := f.NewBlock(b.Name())
+		mb.Source = b
+		f.blocks[b] = mb
+	}
+	for _, b := range fn.Blocks {
+		for _, s := range b.Successors {
+			f.AddEdge(f.blocks[b], f.blocks[s])
+		}
+	}
+	return f
+}
+
+// Block returns the machine block mirroring the basic block b
+func (f *Func) Block(b *ir.BasicBlock) *Block {
+	return f.blocks[b]
+}
+
+// NewBlock appends an empty block to f
+func (f *Func) NewBlock(name string) *Block {
+	b := &Block{Name: name, Parent: f}
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// AddEdge records a control flow edge from one block to another
+func (f *Func) AddEdge(from, to *Block) {
+	from.Successors = append(from.Successors, to)
+	to.Predecessors = append(to.Predecessors, from)
+}
+
+// NewVReg returns a new virtual register of the given class
+func (f *Func) NewVReg(class Class) Reg {
+	f.classes = append(f.classes, class)
+	return FirstVirtual + Reg(len(f.classes)-1)
+}
+
+// NumVRegs returns the number of virtual registers created so far
+func (f *Func) NumVRegs() int { return len(f.classes) }
+
+// RegClass returns the class of the virtual register r
+func (f *Func) RegClass(r Reg) Class {
+	return f.classes[r-FirstVirtual]
+}
+
+// NewSlot adds a stack slot to the frame and returns its index
+func (f *Func) NewSlot(size, align int64) int {
+	f.Slots = append(f.Slots, Slot{Size: size, Align: align})
+	return len(f.Slots) - 1
+}
+
+func (f *Func) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "func @%s {\n", f.Name)
+	for i, s := range f.Slots {
+		fmt.Fprintf(&sb, "  slot%d: size %d, align %d\n", i, s.Size, s.Align)
+	}
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&sb, "%s:\n", b.Name)
+		for _, i := range b.Insts {
+			fmt.Fprintf(&sb, "  %s\n", i)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
@@ -0,0 +1,433 @@
+package mir
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Target describes the registers of a machine to the allocator
+type Target struct {
+	// Registers lists the physical registers the allocator may assign for
+	// each class, in order of preference
+	Registers [][]Reg
+	// SpillSize is the size and alignment of a spill slot of each class
+	SpillSize []int64
+}
+
+// Allocate assigns physical registers to the virtual registers of f and
+// rewrites its instructions to use them.
+//
+// Each virtual register gets one live interval, from its first to its last
+// live point in the layout order of the blocks, and keeps one register or
+// one stack slot for all of it. Physical registers named by instructions
+// and the clobbers of instructions block their registers only where they
+// are live; values in physical registers must not live across blocks,
+// except into the entry block. When no register is free, the interval
+// ending last is spilled: every use of it reloads a new short-lived
+// register from its slot and every def stores one, and allocation starts
+// over. Copies hint the allocator to give their operands the same
+// register; copies whose operands end up in the same register are removed.
+func Allocate(f *Func, t *Target) error {
+	// Registers created while spilling, which must not spill again
+	temps := make(map[Reg]bool)
+	for {
+		a := &allocator{f: f, t: t, temps: temps, fixed: make(map[Reg][]span)}
+		a.buildIntervals()
+		spilled, err := a.scan()
+		if err != nil {
+			return err
+		}
+		if len(spilled) == 0 {
+			a.rewrite()
+			return nil
+		}
+		a.spill(spilled)
+	}
+}
+
+// ============================================================================
+// Liveness
+// ============================================================================
+
+// regSet is a set of virtual registers, indexed from FirstVirtual
+type regSet []uint64
+
+func newRegSet(n int) regSet { return make(regSet, (n+63)/64) }
+
+func (s regSet) has(r Reg) bool {
+	i := int(r - FirstVirtual)
+	return s[i/64]&(1<<(i%64)) != 0
+}
+
+func (s regSet) add(r Reg) {
+	i := int(r - FirstVirtual)
+	s[i/64] |= 1 << (i % 64)
+}
+
+// each calls fn for every register in s
+func (s regSet) each(fn func(Reg)) {
+	for w, bits := range s {
+		for i := 0; bits != 0; i++ {
+			if bits&1 != 0 {
+				fn(FirstVirtual + Reg(w*64+i))
+			}
+			bits >>= 1
+		}
+	}
+}
+
+// liveness returns the virtual registers live into and out of each block
+func liveness(f *Func) (liveIn, liveOut []regSet) {
+	n := f.NumVRegs()
+	index := make(map[*Block]int, len(f.Blocks))
+	use := make([]regSet, len(f.Blocks)) // read before any write in the block
+	def := make([]regSet, len(f.Blocks))
+	liveIn = make([]regSet, len(f.Blocks))
+	liveOut = make([]regSet, len(f.Blocks))
+	for i, b := range f.Blocks {
+		index[b] = i
+		use[i], def[i] = newRegSet(n), newRegSet(n)
+		liveIn[i], liveOut[i] = newRegSet(n), newRegSet(n)
+		for _, inst := range b.Insts {
+			for _, r := range inst.Uses() {
+				if r.IsVirtual() && !def[i].has(r) {
+					use[i].add(r)
+				}
+			}
+			for _, r := range inst.Defs() {
+				if r.IsVirtual() {
+					def[i].add(r)
+				}
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(f.Blocks) - 1; i >= 0; i-- {
+			out := liveOut[i]
+			for _, s := range f.Blocks[i].Successors {
+				for w, bits := range liveIn[index[s]] {
+					out[w] |= bits
+				}
+			}
+			in := liveIn[i]
+			for w := range in {
+				bits := use[i][w] | out[w]&^def[i][w]
+				if bits != in[w] {
+					in[w] = bits
+					changed = true
+				}
+			}
+		}
+	}
+	return liveIn, liveOut
+}
+
+// ============================================================================
+// Live intervals
+// ============================================================================
+
+// Instructions are numbered in layout order; the instruction numbered k
+// reads its operands at position 2k and writes its results at 2k+1, so a
+// register can be reused by the result of the instruction that last reads
+// it.
+
+// interval is the live range of a virtual register
+type interval struct {
+	reg        Reg
+	class      Class
+	start, end int
+	hints      []Reg // copy-related registers, virtual or physical
+	assigned   Reg
+	spillable  bool
+}
+
+// span is a range of positions in which a physical register is live
+type span struct{ start, end int }
+
+type allocator struct {
+	f         *Func
+	t         *Target
+	temps     map[Reg]bool
+	intervals []*interval    // indexed by virtual register
+	fixed     map[Reg][]span // live ranges of physical registers
+}
+
+// interval returns the interval of the virtual register r, creating it on
+// first use
+func (a *allocator) interval(r Reg) *interval {
+	it := a.intervals[r-FirstVirtual]
+	if it == nil {
+		it = &interval{reg: r, class: a.f.RegClass(r), start: -1, spillable: !a.temps[r]}
+		a.intervals[r-FirstVirtual] = it
+	}
+	return it
+}
+
+// extend makes the interval of r cover pos
+func (a *allocator) extend(r Reg, pos int) {
+	it := a.interval(r)
+	if it.start < 0 || pos < it.start {
+		it.start = pos
+	}
+	if pos > it.end {
+		it.end = pos
+	}
+}
+
+func (a *allocator) buildIntervals() {
+	f := a.f
+	a.intervals = make([]*interval, f.NumVRegs())
+	liveIn, liveOut := liveness(f)
+	pos := 0
+	for i, b := range f.Blocks {
+		start, end := pos, pos+2*len(b.Insts)-1
+		if len(b.Insts) == 0 {
+			end = start
+		}
+		liveIn[i].each(func(r Reg) { a.extend(r, start) })
+		liveOut[i].each(func(r Reg) { a.extend(r, end) })
+
+		// The open live range of each physical register in the block
+		open := make(map[Reg]span)
+		closeSpan := func(r Reg) {
+			if s, ok := open[r]; ok {
+				a.fixed[r] = append(a.fixed[r], s)
+				delete(open, r)
+			}
+		}
+		for _, inst := range b.Insts {
+			for _, r := range inst.Uses() {
+				switch {
+				case r.IsVirtual():
+					a.extend(r, pos)
+				case r.IsPhysical():
+					s, ok := open[r]
+					if !ok {
+						s.start = start
+					}
+					s.end = pos
+					open[r] = s
+				}
+			}
+			for _, r := range inst.Defs() {
+				switch {
+				case r.IsVirtual():
+					a.extend(r, pos+1)
+				case r.IsPhysical():
+					closeSpan(r)
+					open[r] = span{pos + 1, pos + 1}
+				}
+			}
+			for _, r := range inst.Clobbers {
+				if _, ok := open[r]; !ok || open[r].end < pos+1 {
+					closeSpan(r)
+					open[r] = span{pos + 1, pos + 1}
+				}
+			}
+			if inst.IsCopy() {
+				dst, src := inst.Operands[0].Reg, inst.Operands[1].Reg
+				if dst.IsVirtual() {
+					a.interval(dst).hints = append(a.interval(dst).hints, src)
+				}
+				if src.IsVirtual() {
+					a.interval(src).hints = append(a.interval(src).hints, dst)
+				}
+			}
+			pos += 2
+		}
+		for r := range open {
+			closeSpan(r)
+		}
+	}
+}
+
+// ============================================================================
+// Linear scan
+// ============================================================================
+
+// blocked reports whether the physical register r is live somewhere in it
+func (a *allocator) blocked(r Reg, it *interval) bool {
+	for _, s := range a.fixed[r] {
+		if s.start <= it.end && it.start <= s.end {
+			return true
+		}
+	}
+	return false
+}
+
+// scan assigns registers to the intervals in order of their start and
+// returns those that have to be spilled
+func (a *allocator) scan() ([]*interval, error) {
+	var list []*interval
+	for _, it := range a.intervals {
+		if it != nil {
+			list = append(list, it)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].start != list[j].start {
+			return list[i].start < list[j].start
+		}
+		return list[i].reg < list[j].reg
+	})
+
+	var active, spilled []*interval
+	busy := make(map[Reg]*interval)
+	for _, cur := range list {
+		kept := active[:0]
+		for _, it := range active {
+			if it.end >= cur.start {
+				kept = append(kept, it)
+			} else {
+				delete(busy, it.assigned)
+			}
+		}
+		active = kept
+
+		if r := a.choose(cur, busy); r != NoReg {
+			cur.assigned = r
+			busy[r] = cur
+			active = append(active, cur)
+			continue
+		}
+
+		// Spill whichever of cur and the intervals holding a register cur
+		// could use lives longest
+		var victim *interval
+		if cur.spillable {
+			victim = cur
+		}
+		for _, it := range active {
+			if it.class == cur.class && it.spillable && !a.blocked(it.assigned, cur) && (victim == nil || it.end > victim.end) {
+				victim = it
+			}
+		}
+		switch victim {
+		case nil:
+			return nil, &Error{Func: a.f, Msg: fmt.Sprintf("no register left for %s", cur.reg)}
+		case cur:
+			spilled = append(spilled, cur)
+			continue
+		}
+		cur.assigned, victim.assigned = victim.assigned, NoReg
+		busy[cur.assigned] = cur
+		for i, it := range active {
+			if it == victim {
+				active[i] = cur
+				break
+			}
+		}
+		spilled = append(spilled, victim)
+	}
+	return spilled, nil
+}
+
+// choose returns a free register for it, preferring the registers of its
+// copy-related registers, or NoReg
+func (a *allocator) choose(it *interval, busy map[Reg]*interval) Reg {
+	free := func(r Reg) bool {
+		return busy[r] == nil && !a.blocked(r, it)
+	}
+	regs := a.t.Registers[it.class]
+	allocatable := func(r Reg) bool {
+		for _, x := range regs {
+			if x == r {
+				return true
+			}
+		}
+		return false
+	}
+	for _, h := range it.hints {
+		if h.IsVirtual() {
+			h = a.intervals[h-FirstVirtual].assigned
+		}
+		if h != NoReg && allocatable(h) && free(h) {
+			return h
+		}
+	}
+	for _, r := range regs {
+		if free(r) {
+			return r
+		}
+	}
+	return NoReg
+}
+
+// ============================================================================
+// Rewriting
+// ============================================================================
+
+// spill gives every spilled register a stack slot and replaces it by a new
+// register around each instruction using it
+func (a *allocator) spill(spilled []*interval) {
+	f := a.f
+	slots := make(map[Reg]int, len(spilled))
+	for _, it := range spilled {
+		size := a.t.SpillSize[it.class]
+		slots[it.reg] = f.NewSlot(size, size)
+	}
+	for _, b := range f.Blocks {
+		var insts []*Inst
+		for _, inst := range b.Insts {
+			var stores []*Inst
+			tmps := make(map[Reg]Reg)
+			reloaded := make(map[Reg]bool)
+			for k := range inst.Operands {
+				o := &inst.Operands[k]
+				if o.Kind != RegOperand {
+					continue
+				}
+				slot, ok := slots[o.Reg]
+				if !ok {
+					continue
+				}
+				tmp, ok := tmps[o.Reg]
+				if !ok {
+					tmp = f.NewVReg(f.RegClass(o.Reg))
+					a.temps[tmp] = true
+					tmps[o.Reg] = tmp
+				}
+				if o.Def {
+					stores = append(stores, NewInst(OpSpill, UseReg(tmp), SlotOp(slot)))
+				} else if !reloaded[o.Reg] {
+					insts = append(insts, NewInst(OpReload, DefReg(tmp), SlotOp(slot)))
+					reloaded[o.Reg] = true
+				}
+				o.Reg = tmp
+			}
+			insts = append(insts, inst)
+			insts = append(insts, stores...)
+		}
+		b.Insts = insts
+	}
+}
+
+// rewrite replaces virtual registers by their assigned registers and drops
+// copies that have become no-ops
+func (a *allocator) rewrite() {
+	used := make(map[Reg]bool)
+	for _, b := range a.f.Blocks {
+		insts := b.Insts[:0]
+		for _, inst := range b.Insts {
+			for k := range inst.Operands {
+				o := &inst.Operands[k]
+				if o.Kind == RegOperand && o.Reg.IsVirtual() {
+					o.Reg = a.intervals[o.Reg-FirstVirtual].assigned
+					used[o.Reg] = true
+				}
+			}
+			if inst.IsCopy() && inst.Operands[0].Reg == inst.Operands[1].Reg {
+				continue
+			}
+			insts = append(insts, inst)
+		}
+		b.Insts = insts
+	}
+	a.f.UsedRegs = a.f.UsedRegs[:0]
+	for r := range used {
+		a.f.UsedRegs = append(a.f.UsedRegs, r)
+	}
+	sort.Slice(a.f.UsedRegs, func(i, j int) bool { return a.f.UsedRegs[i] < a.f.UsedRegs[j] })
+}
@@ -0,0 +1,256 @@
+package mir
+
+import (
+	"strings"
+	"testing"
+)
+
+// Opcodes of a toy target
+const (
+	opConst      Opcode = FirstTargetOp + iota // def, imm
+	opAdd                                      // def, uses: sums its uses
+	opCall                                     // clobbers the caller-saved registers
+	opJump                                     // block
+	opBranchLess                               // use, use, block, block: branches to the first block if a < b
+	opRet                                      // use
+)
+
+// Physical registers of the toy target; r1 is caller-saved
+const (
+	r1 Reg = iota + 1
+	r2
+	r3
+)
+
+func target(regs ...Reg) *Target {
+	return &Target{Registers: [][]Reg{regs}, SpillSize: []int64{8}}
+}
+
+// run interprets f and returns the value it returns. Clobbered registers
+// are overwritten with garbage so that values wrongly kept in them show up.
+func run(t *testing.T, f *Func) int64 {
+	t.Helper()
+	regs := make(map[Reg]int64)
+	slots := make(map[int]int64)
+	b := f.Blocks[0]
+	for steps := 0; steps < 10000; steps++ {
+		for _, inst := range b.Insts {
+			ops := inst.Operands
+			switch inst.Op {
+			case OpCopy:
+				regs[ops[0].Reg] = regs[ops[1].Reg]
+			case OpSpill:
+				slots[ops[1].Slot] = regs[ops[0].Reg]
+			case OpReload:
+				regs[ops[0].Reg] = slots[ops[1].Slot]
+			case opConst:
+				regs[ops[0].Reg] = ops[1].Imm
+			case opAdd:
+				var sum int64
+				for _, o := range ops[1:] {
+					sum += regs[o.Reg]
+				}
+				regs[ops[0].Reg] = sum
+			case opCall:
+				for _, r := range inst.Clobbers {
+					regs[r] = -999
+				}
+			case opJump:
+				b = ops[0].Block
+			case opBranchLess:
+				if regs[ops[0].Reg] < regs[ops[1].Reg] {
+					b = ops[2].Block
+				} else {
+					b = ops[3].Block
+				}
+			case opRet:
+				return regs[ops[0].Reg]
+			default:
+				t.Fatalf("unknown instruction %s", inst)
+			}
+		}
+	}
+	t.Fatal("the function does not return")
+	return 0
+}
+
+// checkAllocated fails unless every register operand of f is one of regs
+func checkAllocated(t *testing.T, f *Func, regs ...Reg) {
+	t.Helper()
+	for _, b := range f.Blocks {
+		for _, inst := range b.Insts {
+			for _, r := range append(inst.Uses(), inst.Defs()...) {
+				ok := false
+				for _, x := range regs {
+					ok = ok || r == x
+				}
+				if !ok {
+					t.Errorf("%s uses %s", inst, r)
+				}
+			}
+		}
+	}
+}
+
+// count returns the number of instructions of f with opcode op
+func count(f *Func, op Opcode) int {
+	n := 0
+	for _, b := range f.Blocks {
+		for _, inst := range b.Insts {
+			if inst.Op == op {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// allLive defines the constants 1 to n and sums them afterwards, so all n
+// values are live at once
+func allLive(n int) *Func {
+	f := &Func{Name: "sum"}
+	b := f.NewBlock("entry")
+	var vals []Reg
+	for i := 1; i <= n; i++ {
+		v := f.NewVReg(0)
+		b.Append(NewInst(opConst, DefReg(v), ImmOp(int64(i))))
+		vals = append(vals, v)
+	}
+	sum := vals[0]
+	for _, v := range vals[1:] {
+		s := f.NewVReg(0)
+		b.Append(NewInst(opAdd, DefReg(s), UseReg(sum), UseReg(v)))
+		sum = s
+	}
+	b.Append(NewInst(opRet, UseReg(sum)))
+	return f
+}
+
+func TestNoPressure(t *testing.T) {
+	f := allLive(3)
+	if err := Allocate(f, target(r1, r2, r3)); err != nil {
+		t.Fatal(err)
+	}
+	checkAllocated(t, f, r1, r2, r3)
+	if len(f.Slots) != 0 || count(f, OpSpill) != 0 {
+		t.Errorf("spilled with enough registers:\n%s", f)
+	}
+	if got := run(t, f); got != 6 {
+		t.Errorf("function returns %d, want 6", got)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	f := allLive(6)
+	if err := Allocate(f, target(r1, r2)); err != nil {
+		t.Fatal(err)
+	}
+	checkAllocated(t, f, r1, r2)
+	if len(f.Slots) == 0 || count(f, OpSpill) == 0 || count(f, OpReload) == 0 {
+		t.Errorf("six live values in two registers did not spill:\n%s", f)
+	}
+	for _, s := range f.Slots {
+		if s.Size != 8 || s.Align != 8 {
+			t.Errorf("spill slot has size %d and alignment %d, want 8", s.Size, s.Align)
+		}
+	}
+	if got := run(t, f); got != 21 {
+		t.Errorf("function returns %d, want 21:\n%s", got, f)
+	}
+	if len(f.UsedRegs) != 2 {
+		t.Errorf("used registers %v, want r1 and r2", f.UsedRegs)
+	}
+}
+
+// TestSpillLoop keeps four values live around a loop summing 1 to 10
+func TestSpillLoop(t *testing.T) {
+	f := &Func{Name: "loop"}
+	entry, loop, exit := f.NewBlock("entry"), f.NewBlock("loop"), f.NewBlock("exit")
+	f.AddEdge(entry, loop)
+	f.AddEdge(loop, loop)
+	f.AddEdge(loop, exit)
+	i, sum, one, limit := f.NewVReg(0), f.NewVReg(0), f.NewVReg(0), f.NewVReg(0)
+	entry.Append(NewInst(opConst, DefReg(i), ImmOp(1)))
+	entry.Append(NewInst(opConst, DefReg(sum), ImmOp(0)))
+	entry.Append(NewInst(opConst, DefReg(one), ImmOp(1)))
+	entry.Append(NewInst(opConst, DefReg(limit), ImmOp(11)))
+	entry.Append(NewInst(opJump, BlockOp(loop)))
+	next, i1 := f.NewVReg(0), f.NewVReg(0)
+	loop.Append(NewInst(opAdd, DefReg(next), UseReg(sum), UseReg(i)))
+	loop.Append(Copy(sum, next))
+	loop.Append(NewInst(opAdd, DefReg(i1), UseReg(i), UseReg(one)))
+	loop.Append(Copy(i, i1))
+	loop.Append(NewInst(opBranchLess, UseReg(i), UseReg(limit), BlockOp(loop), BlockOp(exit)))
+	exit.Append(NewInst(opRet, UseReg(sum)))
+
+	if got := run(t, f); got != 55 {
+		t.Fatalf("before allocation the function returns %d, want 55", got)
+	}
+	if err := Allocate(f, target(r1, r2)); err != nil {
+		t.Fatal(err)
+	}
+	checkAllocated(t, f, r1, r2)
+	if count(f, OpSpill) == 0 {
+		t.Errorf("four loop-carried values in two registers did not spill:\n%s", f)
+	}
+	if got := run(t, f); got != 55 {
+		t.Errorf("function returns %d, want 55:\n%s", got, f)
+	}
+}
+
+func TestClobbers(t *testing.T) {
+	f := &Func{Name: "call"}
+	b := f.NewBlock("entry")
+	x, y, s := f.NewVReg(0), f.NewVReg(0), f.NewVReg(0)
+	b.Append(NewInst(opConst, DefReg(x), ImmOp(20)))
+	b.Append(NewInst(opConst, DefReg(y), ImmOp(22)))
+	b.Append(&Inst{Op: opCall, Clobbers: []Reg{r1}})
+	b.Append(NewInst(opAdd, DefReg(s), UseReg(x), UseReg(y)))
+	b.Append(NewInst(opRet, UseReg(s)))
+
+	// Only r2 survives the call, so one of x and y goes to the stack
+	if err := Allocate(f, target(r1, r2)); err != nil {
+		t.Fatal(err)
+	}
+	if count(f, OpSpill) != 1 {
+		t.Errorf("got %d spills, want 1:\n%s", count(f, OpSpill), f)
+	}
+	if got := run(t, f); got != 42 {
+		t.Errorf("function returns %d, want 42:\n%s", got, f)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	f := &Func{Name: "copy"}
+	b := f.NewBlock("entry")
+	x, y := f.NewVReg(0), f.NewVReg(0)
+	b.Append(NewInst(opConst, DefReg(x), ImmOp(7)))
+	b.Append(Copy(y, x))
+	b.Append(NewInst(opRet, UseReg(y)))
+	if err := Allocate(f, target(r1, r2)); err != nil {
+		t.Fatal(err)
+	}
+	if count(f, OpCopy) != 0 {
+		t.Errorf("copy not coalesced:\n%s", f)
+	}
+	if got := run(t, f); got != 7 {
+		t.Errorf("function returns %d, want 7", got)
+	}
+}
+
+func TestNoRegisterLeft(t *testing.T) {
+	// An add reading three registers cannot be allocated with two,
+	// spilling or not
+	f := &Func{Name: "tight"}
+	b := f.NewBlock("entry")
+	x, y, z, s := f.NewVReg(0), f.NewVReg(0), f.NewVReg(0), f.NewVReg(0)
+	b.Append(NewInst(opConst, DefReg(x), ImmOp(1)))
+	b.Append(NewInst(opConst, DefReg(y), ImmOp(2)))
+	b.Append(NewInst(opConst, DefReg(z), ImmOp(3)))
+	b.Append(NewInst(opAdd, DefReg(s), UseReg(x), UseReg(y), UseReg(z)))
+	b.Append(NewInst(opRet, UseReg(s)))
+	err := Allocate(f, target(r1, r2))
+	if err == nil || !strings.Contains(err.Error(), "no register left") {
+		t.Errorf("got error %v, want no register left", err)
+	}
+}
@@ -0,0 +1,282 @@
+package riscv64
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/codegen/internal/gas"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// reg is an integer register by its ABI name. Code uses a0-a7 for
+// arguments and the syscall number, t0-t3 as scratch and t6 to form
+// addresses; none of them is callee-saved.
+type reg string
+
+const (
+	a0 reg = "a0"
+	a7 reg = "a7"
+	t0 reg = "t0"
+	t1 reg = "t1"
+	t2 reg = "t2"
+	t3 reg = "t3"
+	t6 reg = "t6"
+)
+
+// argReg returns the integer argument register a<n>
+func argReg(n int) reg { return reg(fmt.Sprintf("a%d", n)) }
+
+// LP64D passes the first eight integer and eight floating point arguments
+// in registers
+const (
+	intArgRegs   = 8
+	floatArgRegs = 8
+)
+
+// frameRecord is the size of the saved s0/ra pair at the bottom of the frame
+const frameRecord = 16
+
+// funcGen holds the state of one function being lowered
+type funcGen struct {
+	*gen
+	fn      *ir.Function
+	index   int
+	slots   map[ir.Value]int64       // s0-relative offset of each value
+	temps   map[*ir.PhiInst]int64    // staging slot for each phi's edge copy
+	allocas map[*ir.AllocaInst]int64 // storage of fixed-size entry allocas
+	labels  map[*ir.BasicBlock]string
+	frame   int64
+}
+
+func (g *gen) Function(fn *ir.Function, index int) error {
+	f := &funcGen{
+		gen:     g,
+		fn:      fn,
+		index:   index,
+		slots:   make(map[ir.Value]int64),
+		temps:   make(map[*ir.PhiInst]int64),
+		allocas: make(map[*ir.AllocaInst]int64),
+		labels:  make(map[*ir.BasicBlock]string),
+	}
+	if err := f.layout(); err != nil {
+		return err
+	}
+
+	name := fn.Name()
+	g.FunctionHeader(name, fn.Linkage, 2)
+	f.adjustSP(-f.frame)
+	f.emit("sd ra, 8(sp)")
+	f.emit("sd s0, 0(sp)")
+	f.emit("mv s0, sp")
+	f.storeArguments()
+
+	for _, b := range fn.Blocks {
+		g.Line("%s:\t\t\t\t# %%%s", f.labels[b], b.Name())
+		for _, inst := range b.Instructions {
+			if err := f.instruction(inst); err != nil {
+				return err
+			}
+		}
+	}
+	g.Line("\t.size %s,.-%s", name, name)
+	return nil
+}
+
+func (f *funcGen) emit(format string, args ...interface{}) {
+	f.Line("\t"+format, args...)
+}
+
+func (f *funcGen) errorf(inst ir.Instruction, format string, args ...interface{}) error {
+	return &Error{Func: f.fn, Inst: inst, Msg: fmt.Sprintf(format, args...)}
+}
+
+// adjustSP adds n to sp, going through t0 when n does not fit in 12 bits
+func (f *funcGen) adjustSP(n int64) {
+	switch {
+	case n == 0:
+	case fitsImm12(n):
+		f.emit("addi sp, sp, %d", n)
+	default:
+		f.emit("li t0, %d", n)
+		f.emit("add sp, sp, t0")
+	}
+}
+
+// layout assigns a frame slot to every argument and value. The frame record
+// sits at the bottom of the frame, where s0 points, and slots are above it.
+// Arguments passed on the stack keep their slot in the caller's frame.
+func (f *funcGen) layout() error {
+	size := int64(frameRecord)
+	alloc := func(n, align int64) int64 {
+		off := gas.AlignTo(size, max(align, 8))
+		size = off + n
+		return off
+	}
+
+	if t := f.fn.FuncType.ReturnType; t.Kind() != types.VoidKind {
+		if err := gas.CheckScalar(t); err != nil {
+			return &Error{Func: f.fn, Msg: "return type: " + err.Error()}
+		}
+	}
+	var stackArgs []*ir.Argument
+	nint, nfloat := 0, 0
+	for _, a := range f.fn.Arguments {
+		if err := gas.CheckScalar(a.Type()); err != nil {
+			return &Error{Func: f.fn, Msg: fmt.Sprintf("argument %d: %v", a.Index, err)}
+		}
+		switch {
+		case types.IsFloat(a.Type()) && nfloat < floatArgRegs:
+			nfloat++
+		case nint < intArgRegs:
+			// Floats left over once the float registers run out are
+			// passed in integer registers
+			nint++
+		default:
+			stackArgs = append(stackArgs, a)
+			continue
+		}
+		f.slots[a] = alloc(8, 8)
+	}
+
+	for i, b := range f.fn.Blocks {
+		f.labels[b] = fmt.Sprintf(".LBB%d_%d", f.index, i)
+		for _, inst := range b.Instructions {
+			if a, ok := inst.(*ir.AllocaInst); ok && i == 0 {
+				if n, ok := gas.StaticCount(a); ok {
+					elem := f.DL.SizeOf(a.AllocatedType)
+					align := max(f.DL.AlignOf(a.AllocatedType), int64(a.Alignment))
+					if align > 16 {
+						return f.errorf(inst, "alignment %d exceeds the 16-byte stack alignment", align)
+					}
+					f.allocas[a] = alloc(max(elem*n, 1), align)
+					continue
+				}
+			}
+			t := inst.Type()
+			if t == nil || t.Kind() == types.VoidKind {
+				continue
+			}
+			if err := gas.CheckType(t); err != nil {
+				return f.errorf(inst, "%v", err)
+			}
+			n := gas.AlignTo(f.DL.SizeOf(t), 8)
+			f.slots[inst] = alloc(n, f.DL.AlignOf(t))
+			if phi, ok := inst.(*ir.PhiInst); ok {
+				f.temps[phi] = alloc(n, f.DL.AlignOf(t))
+			}
+		}
+	}
+	f.frame = gas.AlignTo(size, 16)
+	for k, a := range stackArgs {
+		f.slots[a] = f.frame + int64(k)*8
+	}
+	return nil
+}
+
+// storeArguments moves the register arguments to their slots
+func (f *funcGen) storeArguments() {
+	nint, nfloat := 0, 0
+	for _, a := range f.fn.Arguments {
+		if f.slots[a] >= f.frame {
+			continue
+		}
+		if types.IsFloat(a.Type()) && nfloat < floatArgRegs {
+			f.emit("fsd fa%d, %s", nfloat, f.mem(f.slots[a]))
+			nfloat++
+		} else {
+			f.emit("sd %s, %s", argReg(nint), f.mem(f.slots[a]))
+			nint++
+		}
+	}
+}
+
+// ============================================================================
+// Control flow
+// ============================================================================
+
+// Conditional branches reach only 4KiB, so they skip over unconditional
+// jumps rather than targeting blocks directly.
+
+func (f *funcGen) terminator(inst ir.Instruction) error {
+	b := inst.Parent()
+	switch i := inst.(type) {
+	case *ir.RetInst:
+		if len(i.Ops) > 0 && i.Ops[0] != nil {
+			v := i.Ops[0]
+			if types.IsFloat(v.Type()) {
+				f.loadFloat("fa0", v)
+			} else {
+				f.loadArg(a0, v)
+			}
+		}
+		f.emit("mv sp, s0")
+		f.emit("ld ra, 8(sp)")
+		f.emit("ld s0, 0(sp)")
+		f.adjustSP(f.frame)
+		f.emit("ret")
+	case *ir.BrInst:
+		gas.EdgeCopies(f, b, i.Target)
+		f.emit("j %s", f.labels[i.Target])
+	case *ir.CondBrInst:
+		var stubs []func()
+		t := f.EdgeLabel(f, b, i.TrueBlock, &stubs)
+		fl := f.EdgeLabel(f, b, i.FalseBlock, &stubs)
+		if c, ok := i.Condition.(*ir.ConstantInt); ok {
+			if c.Value&1 != 0 {
+				f.emit("j %s", t)
+			} else {
+				f.emit("j %s", fl)
+			}
+		} else {
+			skip := f.NewLabel()
+			f.emit("lbu t0, %s", f.mem(f.slots[i.Condition]))
+			f.emit("andi t0, t0, 1")
+			f.emit("beqz t0, %s", skip)
+			f.emit("j %s", t)
+			f.Line("%s:", skip)
+			f.emit("j %s", fl)
+		}
+		for _, stub := range stubs {
+			stub()
+		}
+	case *ir.SwitchInst:
+		var stubs []func()
+		width := intWidth(i.Condition.Type())
+		f.loadInt(t0, i.Condition, false)
+		for _, c := range i.Cases {
+			target := f.EdgeLabel(f, b, c.Block, &stubs)
+			next := f.NewLabel()
+			f.emit("li t1, %d", int64(truncate(uint64(c.Value.Value), width)))
+			f.emit("bne t0, t1, %s", next)
+			f.emit("j %s", target)
+			f.Line("%s:", next)
+		}
+		f.emit("j %s", f.EdgeLabel(f, b, i.DefaultBlock, &stubs))
+		for _, stub := range stubs {
+			stub()
+		}
+	case *ir.UnreachableInst:
+		f.emit("unimp")
+	}
+	return nil
+}
+
+// Label, Jump, Stage and Commit implement gas.Edges
+
+func (f *funcGen) Label(b *ir.BasicBlock) string { return f.labels[b] }
+
+func (f *funcGen) Jump(label string) { f.emit("j %s", label) }
+
+func (f *funcGen) Stage(phi *ir.PhiInst, v ir.Value) { f.copyValue(f.temps[phi], v) }
+
+func (f *funcGen) Commit(phi *ir.PhiInst) {
+	n := gas.AlignTo(f.DL.SizeOf(phi.Type()), 8)
+	for off := int64(0); off < n; off += 8 {
+		f.emit("ld t0, %s", f.mem(f.temps[phi]+off))
+		f.emit("sd t0, %s", f.mem(f.slots[phi]+off))
+	}
+}
+
+func fitsImm12(n int64) bool {
+	return n >= -2048 && n < 2048
+}
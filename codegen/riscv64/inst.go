@@ -0,0 +1,758 @@
+package riscv64
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/codegen/internal/gas"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// ============================================================================
+// Operands
+// ============================================================================
+
+// mem returns the operand addressing the frame location at off. Offsets out
+// of reach of the 12-bit immediate are computed into t6.
+func (f *funcGen) mem(off int64) string {
+	if fitsImm12(off) {
+		return fmt.Sprintf("%d(s0)", off)
+	}
+	f.frameAddr(t6, off)
+	return "0(t6)"
+}
+
+// frameAddr loads the address of the frame location at off into r
+func (f *funcGen) frameAddr(r reg, off int64) {
+	if fitsImm12(off) {
+		f.emit("addi %s, s0, %d", r, off)
+		return
+	}
+	f.emit("li %s, %d", r, off)
+	f.emit("add %s, s0, %s", r, r)
+}
+
+// loadRaw loads the 64 bits holding v into r. Only the low bits that
+// belong to v's type are meaningful.
+func (f *funcGen) loadRaw(r reg, v ir.Value) {
+	switch c := v.(type) {
+	case *ir.ConstantInt:
+		f.emit("li %s, %d", r, c.Value)
+	case *ir.ConstantFloat:
+		f.emit("li %s, %d", r, int64(gas.FloatBits(c)))
+	case *ir.ConstantNull, *ir.ConstantUndef, *ir.ConstantZero:
+		f.emit("li %s, 0", r)
+	case *ir.Global:
+		f.symbol(r, c.Name())
+	case *ir.Function:
+		f.symbol(r, c.Name())
+	case *ir.AllocaInst:
+		if off, ok := f.allocas[c]; ok {
+			f.frameAddr(r, off)
+			return
+		}
+		f.emit("ld %s, %s", r, f.mem(f.slots[v]))
+	default:
+		f.emit("ld %s, %s", r, f.mem(f.slots[v]))
+	}
+}
+
+// symbol loads the address of a global or function into r. Symbols defined
+// elsewhere are reached through the GOT so the code links into PIEs.
+func (f *funcGen) symbol(r reg, name string) {
+	if f.Defined[name] {
+		f.emit("lla %s, %s", r, name)
+	} else {
+		f.emit("la %s, %s", r, name)
+	}
+}
+
+// loadInt loads integer or pointer v into r, sign- or zero-extended from its
+// width to 64 bits
+func (f *funcGen) loadInt(r reg, v ir.Value, signed bool) {
+	width := intWidth(v.Type())
+	if c, ok := v.(*ir.ConstantInt); ok {
+		bits := truncate(uint64(c.Value), width)
+		if signed {
+			bits = uint64(signExtend(bits, width))
+		}
+		f.emit("li %s, %d", r, int64(bits))
+		return
+	}
+	if _, ok := f.slots[v]; !ok || width == 64 {
+		f.loadRaw(r, v)
+		return
+	}
+	f.loadInd(r, f.mem(f.slots[v]), width, signed)
+}
+
+// loadArg loads integer or pointer v into r extended as the psABI requires
+// of arguments and return values: 32-bit values are always sign-extended,
+// narrower ones according to their signedness.
+func (f *funcGen) loadArg(r reg, v ir.Value) {
+	f.loadInt(r, v, isSigned(v.Type()) || intWidth(v.Type()) == 32)
+}
+
+// loadInd loads a width-bit integer from the memory operand addr into r
+func (f *funcGen) loadInd(r reg, addr string, width int, signed bool) {
+	switch width {
+	case 64:
+		f.emit("ld %s, %s", r, addr)
+	case 32:
+		if signed {
+			f.emit("lw %s, %s", r, addr)
+		} else {
+			f.emit("lwu %s, %s", r, addr)
+		}
+	case 16:
+		if signed {
+			f.emit("lh %s, %s", r, addr)
+		} else {
+			f.emit("lhu %s, %s", r, addr)
+		}
+	case 8:
+		if signed {
+			f.emit("lb %s, %s", r, addr)
+		} else {
+			f.emit("lbu %s, %s", r, addr)
+		}
+	case 1:
+		f.emit("lbu %s, %s", r, addr)
+		if signed {
+			f.emit("neg %s, %s", r, r)
+		}
+	}
+}
+
+// storeInd stores the low size bytes of r to the memory operand addr
+func (f *funcGen) storeInd(r reg, addr string, size int64) {
+	switch size {
+	case 1:
+		f.emit("sb %s, %s", r, addr)
+	case 2:
+		f.emit("sh %s, %s", r, addr)
+	case 4:
+		f.emit("sw %s, %s", r, addr)
+	default:
+		f.emit("sd %s, %s", r, addr)
+	}
+}
+
+// loadFloat loads f32 or f64 v into the float register fr
+func (f *funcGen) loadFloat(fr string, v ir.Value) {
+	p := fp(v.Type())
+	switch v.(type) {
+	case *ir.ConstantFloat, *ir.ConstantInt, *ir.ConstantUndef, *ir.ConstantZero:
+		f.loadRaw(t0, v)
+		f.emit("fmv.%s.x %s, t0", wd(p), fr)
+	default:
+		f.emit("fl%s %s, %s", wd(p), fr, f.mem(f.slots[v]))
+	}
+}
+
+func (f *funcGen) storeFloat(fr string, inst ir.Instruction) {
+	f.emit("fs%s %s, %s", wd(fp(inst.Type())), fr, f.mem(f.slots[inst]))
+}
+
+func (f *funcGen) store(r reg, inst ir.Instruction) {
+	f.emit("sd %s, %s", r, f.mem(f.slots[inst]))
+}
+
+// copyValue copies v to the frame slot at off
+func (f *funcGen) copyValue(off int64, v ir.Value) {
+	if types.IsAggregate(v.Type()) {
+		f.frameAddr(t1, off)
+		f.copyAggregate(v)
+		return
+	}
+	f.loadRaw(t0, v)
+	f.emit("sd t0, %s", f.mem(off))
+}
+
+// copyAggregate copies aggregate v to the address in t1. Undef and zero
+// constants have no bytes to copy from and are stored as zeros.
+func (f *funcGen) copyAggregate(v ir.Value) {
+	size := f.DL.SizeOf(v.Type())
+	if size == 0 {
+		return
+	}
+	switch c := v.(type) {
+	case *ir.ConstantUndef, *ir.ConstantZero:
+		f.memset(size)
+		return
+	case ir.Constant:
+		f.emit("lla t2, %s", f.ConstLabel(c))
+	default:
+		f.frameAddr(t2, f.slots[v])
+	}
+	f.memcpy(size)
+}
+
+// memcpy copies size bytes from t2 to t1, clobbering t0-t3
+func (f *funcGen) memcpy(size int64) {
+	loop := f.NewLabel()
+	f.emit("li t3, %d", size)
+	f.Line("%s:", loop)
+	f.emit("lbu t0, 0(t2)")
+	f.emit("sb t0, 0(t1)")
+	f.emit("addi t2, t2, 1")
+	f.emit("addi t1, t1, 1")
+	f.emit("addi t3, t3, -1")
+	f.emit("bnez t3, %s", loop)
+}
+
+// memset zeroes size bytes at t1, clobbering t1 and t3
+func (f *funcGen) memset(size int64) {
+	loop := f.NewLabel()
+	f.emit("li t3, %d", size)
+	f.Line("%s:", loop)
+	f.emit("sb zero, 0(t1)")
+	f.emit("addi t1, t1, 1")
+	f.emit("addi t3, t3, -1")
+	f.emit("bnez t3, %s", loop)
+}
+
+// ============================================================================
+// Instructions
+// ============================================================================
+
+func (f *funcGen) instruction(inst ir.Instruction) error {
+	if inst.IsTerminator() {
+		return f.terminator(inst)
+	}
+	switch i := inst.(type) {
+	case *ir.PhiInst:
+		// Assigned by the copies on the incoming edges
+	case *ir.BinaryInst:
+		f.binary(i)
+	case *ir.ICmpInst:
+		f.icmp(i)
+	case *ir.FCmpInst:
+		f.fcmp(i)
+	case *ir.CastInst:
+		return f.cast(i)
+	case *ir.SelectInst:
+		f.selectInst(i)
+	case *ir.AllocaInst:
+		f.alloca(i)
+	case *ir.LoadInst:
+		f.load(i)
+	case *ir.StoreInst:
+		f.storeInst(i)
+	case *ir.GetElementPtrInst:
+		f.gep(i)
+	case *ir.ExtractValueInst:
+		f.extractValue(i)
+	case *ir.InsertValueInst:
+		f.insertValue(i)
+	case *ir.CallInst:
+		return f.call(i)
+	case *ir.SyscallInst:
+		return f.syscall(i)
+	default:
+		return f.errorf(inst, "unsupported instruction")
+	}
+	return nil
+}
+
+func (f *funcGen) binary(i *ir.BinaryInst) {
+	lhs, rhs := i.Ops[0], i.Ops[1]
+	if types.IsFloat(i.Type()) {
+		p := fp(i.Type())
+		if i.Op == ir.OpFRem {
+			f.loadFloat("fa0", lhs)
+			f.loadFloat("fa1", rhs)
+			name := "fmod"
+			if p == "s" {
+				name = "fmodf"
+			}
+			f.emit("call %s", name)
+			f.storeFloat("fa0", i)
+			return
+		}
+		ops := map[ir.Opcode]string{ir.OpFAdd: "fadd", ir.OpFSub: "fsub", ir.OpFMul: "fmul", ir.OpFDiv: "fdiv"}
+		f.loadFloat("ft0", lhs)
+		f.loadFloat("ft1", rhs)
+		f.emit("%s.%s ft0, ft0, ft1", ops[i.Op], p)
+		f.storeFloat("ft0", i)
+		return
+	}
+
+	switch i.Op {
+	case ir.OpUDiv, ir.OpURem, ir.OpLShr:
+		f.loadInt(t0, lhs, false)
+		f.loadInt(t1, rhs, false)
+	case ir.OpSDiv, ir.OpSRem, ir.OpAShr:
+		f.loadInt(t0, lhs, true)
+		f.loadInt(t1, rhs, true)
+	default:
+		f.loadRaw(t0, lhs)
+		f.loadRaw(t1, rhs)
+	}
+	ops := map[ir.Opcode]string{
+		ir.OpAdd: "add", ir.OpSub: "sub", ir.OpMul: "mul",
+		ir.OpAnd: "and", ir.OpOr: "or", ir.OpXor: "xor",
+		ir.OpShl: "sll", ir.OpLShr: "srl", ir.OpAShr: "sra",
+		ir.OpUDiv: "divu", ir.OpSDiv: "div", ir.OpURem: "remu", ir.OpSRem: "rem",
+	}
+	f.emit("%s t0, t0, t1", ops[i.Op])
+	if intWidth(i.Type()) == 1 {
+		f.emit("andi t0, t0, 1")
+	}
+	f.store(t0, i)
+}
+
+func (f *funcGen) icmp(i *ir.ICmpInst) {
+	signed := false
+	switch i.Predicate {
+	case ir.ICmpSGT, ir.ICmpSGE, ir.ICmpSLT, ir.ICmpSLE:
+		signed = true
+	}
+	f.loadInt(t0, i.Ops[0], signed)
+	f.loadInt(t1, i.Ops[1], signed)
+	slt := "sltu"
+	if signed {
+		slt = "slt"
+	}
+	switch i.Predicate {
+	case ir.ICmpEQ:
+		f.emit("sub t0, t0, t1")
+		f.emit("seqz t0, t0")
+	case ir.ICmpNE:
+		f.emit("sub t0, t0, t1")
+		f.emit("snez t0, t0")
+	case ir.ICmpULT, ir.ICmpSLT:
+		f.emit("%s t0, t0, t1", slt)
+	case ir.ICmpUGT, ir.ICmpSGT:
+		f.emit("%s t0, t1, t0", slt)
+	case ir.ICmpUGE, ir.ICmpSGE:
+		f.emit("%s t0, t0, t1", slt)
+		f.emit("xori t0, t0, 1")
+	case ir.ICmpULE, ir.ICmpSLE:
+		f.emit("%s t0, t1, t0", slt)
+		f.emit("xori t0, t0, 1")
+	}
+	f.store(t0, i)
+}
+
+// unorderedInverse maps each unordered predicate to the ordered one it
+// negates
+var unorderedInverse = map[ir.FCmpPredicate]ir.FCmpPredicate{
+	ir.FCmpUEQ: ir.FCmpONE, ir.FCmpUNE: ir.FCmpOEQ,
+	ir.FCmpUGT: ir.FCmpOLE, ir.FCmpUGE: ir.FCmpOLT,
+	ir.FCmpULT: ir.FCmpOGE, ir.FCmpULE: ir.FCmpOGT,
+	ir.FCmpUNO: ir.FCmpORD,
+}
+
+// fcmp uses feq, flt and fle, which are false when either operand is NaN
+func (f *funcGen) fcmp(i *ir.FCmpInst) {
+	p := fp(i.Ops[0].Type())
+	f.loadFloat("ft0", i.Ops[0])
+	f.loadFloat("ft1", i.Ops[1])
+	pred, negate := i.Predicate, false
+	if inv, ok := unorderedInverse[pred]; ok {
+		pred, negate = inv, true
+	}
+	switch pred {
+	case ir.FCmpFalse:
+		f.emit("li t0, 0")
+	case ir.FCmpTrue:
+		f.emit("li t0, 1")
+	case ir.FCmpOEQ:
+		f.emit("feq.%s t0, ft0, ft1", p)
+	case ir.FCmpOLT:
+		f.emit("flt.%s t0, ft0, ft1", p)
+	case ir.FCmpOLE:
+		f.emit("fle.%s t0, ft0, ft1", p)
+	case ir.FCmpOGT:
+		f.emit("flt.%s t0, ft1, ft0", p)
+	case ir.FCmpOGE:
+		f.emit("fle.%s t0, ft1, ft0", p)
+	case ir.FCmpONE:
+		f.emit("flt.%s t0, ft0, ft1", p)
+		f.emit("flt.%s t1, ft1, ft0", p)
+		f.emit("or t0, t0, t1")
+	case ir.FCmpORD:
+		f.emit("feq.%s t0, ft0, ft0", p)
+		f.emit("feq.%s t1, ft1, ft1", p)
+		f.emit("and t0, t0, t1")
+	}
+	if negate {
+		f.emit("xori t0, t0, 1")
+	}
+	f.store(t0, i)
+}
+
+func (f *funcGen) cast(i *ir.CastInst) error {
+	v := i.Ops[0]
+	src, dst := v.Type(), i.Type()
+	switch i.Op {
+	case ir.OpTrunc:
+		f.loadRaw(t0, v)
+		if intWidth(dst) == 1 {
+			f.emit("andi t0, t0, 1")
+		}
+		f.store(t0, i)
+	case ir.OpZExt:
+		f.loadInt(t0, v, false)
+		f.store(t0, i)
+	case ir.OpSExt:
+		f.loadInt(t0, v, true)
+		f.store(t0, i)
+	case ir.OpFPTrunc, ir.OpFPExt:
+		f.loadFloat("ft0", v)
+		if fp(src) != fp(dst) {
+			f.emit("fcvt.%s.%s ft0, ft0", fp(dst), fp(src))
+		}
+		f.storeFloat("ft0", i)
+	case ir.OpFPToSI:
+		f.loadFloat("ft0", v)
+		f.emit("fcvt.l.%s t0, ft0, rtz", fp(src))
+		f.store(t0, i)
+	case ir.OpFPToUI:
+		f.loadFloat("ft0", v)
+		f.emit("fcvt.lu.%s t0, ft0, rtz", fp(src))
+		f.store(t0, i)
+	case ir.OpSIToFP:
+		f.loadInt(t0, v, true)
+		f.emit("fcvt.%s.l ft0, t0", fp(dst))
+		f.storeFloat("ft0", i)
+	case ir.OpUIToFP:
+		f.loadInt(t0, v, false)
+		f.emit("fcvt.%s.lu ft0, t0", fp(dst))
+		f.storeFloat("ft0", i)
+	case ir.OpPtrToInt, ir.OpIntToPtr, ir.OpBitcast:
+		if types.IsAggregate(src) || types.IsAggregate(dst) {
+			return f.errorf(i, "cannot bitcast aggregate values")
+		}
+		f.loadRaw(t0, v)
+		f.store(t0, i)
+	default:
+		return f.errorf(i, "unsupported cast")
+	}
+	return nil
+}
+
+func (f *funcGen) selectInst(i *ir.SelectInst) {
+	cond, t, fv := i.Ops[0], i.Ops[1], i.Ops[2]
+	other, done := f.NewLabel(), f.NewLabel()
+	f.loadRaw(t0, cond)
+	f.emit("andi t0, t0, 1")
+	f.emit("beqz t0, %s", other)
+	f.copyValue(f.slots[i], t)
+	f.emit("j %s", done)
+	f.Line("%s:", other)
+	f.copyValue(f.slots[i], fv)
+	f.Line("%s:", done)
+}
+
+// alloca handles the allocas the frame layout could not place: those with
+// a variable count or outside the entry block. They grow the stack in
+// 16-byte steps, keeping sp aligned.
+func (f *funcGen) alloca(i *ir.AllocaInst) {
+	if _, ok := f.allocas[i]; ok {
+		return
+	}
+	elem := f.DL.SizeOf(i.AllocatedType)
+	if i.NumElements != nil {
+		f.loadInt(t0, i.NumElements, false)
+		f.emit("li t1, %d", elem)
+		f.emit("mul t0, t0, t1")
+	} else {
+		f.emit("li t0, %d", elem)
+	}
+	f.emit("addi t0, t0, 15")
+	f.emit("andi t0, t0, -16")
+	f.emit("sub sp, sp, t0")
+	f.emit("sd sp, %s", f.mem(f.slots[i]))
+}
+
+func (f *funcGen) load(i *ir.LoadInst) {
+	t := i.Type()
+	if types.IsAggregate(t) {
+		f.loadRaw(t2, i.Ops[0])
+		f.frameAddr(t1, f.slots[i])
+		f.memcpy(f.DL.SizeOf(t))
+		return
+	}
+	f.loadRaw(t1, i.Ops[0])
+	f.loadInd(t0, "0(t1)", int(f.DL.StoreSize(t)*8), false)
+	if intWidth(t) == 1 {
+		f.emit("andi t0, t0, 1")
+	}
+	f.store(t0, i)
+}
+
+func (f *funcGen) storeInst(i *ir.StoreInst) {
+	val, ptr := i.Ops[0], i.Ops[1]
+	if types.IsAggregate(val.Type()) {
+		f.loadRaw(t1, ptr)
+		f.copyAggregate(val)
+		return
+	}
+	f.loadRaw(t0, val)
+	f.loadRaw(t1, ptr)
+	f.storeInd(t0, "0(t1)", f.DL.StoreSize(val.Type()))
+}
+
+func (f *funcGen) gep(i *ir.GetElementPtrInst) {
+	f.loadRaw(t0, i.Ops[0])
+	t := i.SourceElementType
+	var off int64
+	for n, idx := range i.Ops[1:] {
+		var stride int64
+		if n == 0 {
+			stride = f.DL.SizeOf(t)
+		} else {
+			switch ty := t.(type) {
+			case *types.StructType:
+				field := idx.(*ir.ConstantInt).Value
+				off += f.DL.FieldOffset(ty, int(field))
+				t = ty.Fields[field]
+				continue
+			case *types.ArrayType:
+				t = ty.ElementType
+			case *types.VectorType:
+				t = ty.ElementType
+			}
+			stride = f.DL.SizeOf(t)
+		}
+		if c, ok := idx.(*ir.ConstantInt); ok {
+			off += signExtend(truncate(uint64(c.Value), intWidth(c.Type())), intWidth(c.Type())) * stride
+			continue
+		}
+		f.loadInt(t1, idx, true)
+		f.emit("li t2, %d", stride)
+		f.emit("mul t1, t1, t2")
+		f.emit("add t0, t0, t1")
+	}
+	if off != 0 {
+		if fitsImm12(off) {
+			f.emit("addi t0, t0, %d", off)
+		} else {
+			f.emit("li t1, %d", off)
+			f.emit("add t0, t0, t1")
+		}
+	}
+	f.store(t0, i)
+}
+
+// memberOffset returns the byte offset and type of the member of an
+// aggregate of type t selected by indices
+func (f *funcGen) memberOffset(t types.Type, indices []int) (int64, types.Type) {
+	var off int64
+	for _, idx := range indices {
+		switch ty := t.(type) {
+		case *types.StructType:
+			off += f.DL.FieldOffset(ty, idx)
+			t = ty.Fields[idx]
+		case *types.ArrayType:
+			t = ty.ElementType
+			off += int64(idx) * f.DL.SizeOf(t)
+		}
+	}
+	return off, t
+}
+
+func (f *funcGen) extractValue(i *ir.ExtractValueInst) {
+	agg := i.Ops[0]
+	if c, ok := agg.(ir.Constant); ok {
+		// Constant aggregates are folded to the selected member
+		f.copyValue(f.slots[i], memberConstant(c, i.Indices, i.Type()))
+		return
+	}
+	off, t := f.memberOffset(agg.Type(), i.Indices)
+	src := f.slots[agg] + off
+	if types.IsAggregate(t) {
+		f.frameAddr(t2, src)
+		f.frameAddr(t1, f.slots[i])
+		f.memcpy(f.DL.SizeOf(t))
+		return
+	}
+	f.loadInd(t0, f.mem(src), int(f.DL.StoreSize(t)*8), false)
+	f.store(t0, i)
+}
+
+func (f *funcGen) insertValue(i *ir.InsertValueInst) {
+	agg, val := i.Ops[0], i.Ops[1]
+	f.copyValue(f.slots[i], agg)
+	off, t := f.memberOffset(agg.Type(), i.Indices)
+	dst := f.slots[i] + off
+	if types.IsAggregate(t) {
+		f.frameAddr(t1, dst)
+		f.copyAggregate(val)
+		return
+	}
+	f.loadRaw(t0, val)
+	f.storeInd(t0, f.mem(dst), f.DL.StoreSize(t))
+}
+
+// memberConstant returns the member of constant c selected by indices
+func memberConstant(c ir.Constant, indices []int, t types.Type) ir.Value {
+	for _, idx := range indices {
+		switch cc := c.(type) {
+		case *ir.ConstantStruct:
+			c = cc.Fields[idx]
+		case *ir.ConstantArray:
+			c = cc.Elements[idx]
+		default:
+			z := &ir.ConstantZero{}
+			z.SetType(t)
+			return z
+		}
+	}
+	return c
+}
+
+// ============================================================================
+// Calls
+// ============================================================================
+
+func (f *funcGen) call(i *ir.CallInst) error {
+	callee := i.Callee
+	name := i.CalleeName
+	if callee != nil {
+		name = callee.Name()
+	} else {
+		callee = f.M.GetFunction(name)
+	}
+	var args []ir.Value
+	for _, a := range i.Ops {
+		if a != nil {
+			args = append(args, a)
+		}
+	}
+	// Variadic arguments are passed like integers, floats included
+	fixed := len(args)
+	if callee != nil && callee.FuncType.Variadic {
+		fixed = len(callee.FuncType.ParamTypes)
+	}
+
+	// Classify the arguments: floats go in fa0-fa7, integers and the
+	// floats that do not fit in a0-a7, the rest on the stack in 8-byte
+	// slots
+	type intArg struct {
+		v     ir.Value
+		float bool
+	}
+	var ints []intArg
+	var floats, stack []ir.Value
+	for n, a := range args {
+		if err := gas.CheckScalar(a.Type()); err != nil {
+			return f.errorf(i, "%v", err)
+		}
+		isFloat := types.IsFloat(a.Type())
+		switch {
+		case isFloat && n < fixed && len(floats) < floatArgRegs:
+			floats = append(floats, a)
+		case len(ints) < intArgRegs:
+			ints = append(ints, intArg{a, isFloat})
+		default:
+			stack = append(stack, a)
+		}
+	}
+	if t := i.Type(); t != nil && t.Kind() != types.VoidKind {
+		if err := gas.CheckScalar(t); err != nil {
+			return f.errorf(i, "%v", err)
+		}
+	}
+
+	stackSize := gas.AlignTo(int64(len(stack))*8, 16)
+	if stackSize > 0 {
+		f.adjustSP(-stackSize)
+		for n, a := range stack {
+			if types.IsFloat(a.Type()) {
+				f.loadRaw(t0, a)
+			} else {
+				f.loadArg(t0, a)
+			}
+			f.emit("sd t0, %d(sp)", n*8)
+		}
+	}
+	for n, a := range floats {
+		f.loadFloat(fmt.Sprintf("fa%d", n), a)
+	}
+	for n, a := range ints {
+		if a.float {
+			f.loadRaw(argReg(n), a.v)
+		} else {
+			f.loadArg(argReg(n), a.v)
+		}
+	}
+	f.emit("call %s", name)
+	if stackSize > 0 {
+		f.adjustSP(stackSize)
+	}
+
+	if t := i.Type(); t != nil && t.Kind() != types.VoidKind {
+		if types.IsFloat(t) {
+			f.storeFloat("fa0", i)
+		} else {
+			f.store(a0, i)
+		}
+	}
+	return nil
+}
+
+// syscall passes the number in a7 and up to six arguments in a0-a5, the
+// Linux convention for ecall
+func (f *funcGen) syscall(i *ir.SyscallInst) error {
+	if len(i.Ops) == 0 || len(i.Ops) > 7 {
+		return f.errorf(i, "syscall takes a number and up to 6 arguments")
+	}
+	f.loadInt(a7, i.Ops[0], false)
+	for n, a := range i.Ops[1:] {
+		f.loadArg(argReg(n), a)
+	}
+	f.emit("ecall")
+	f.store(a0, i)
+	return nil
+}
+
+// ============================================================================
+// Helpers
+// ============================================================================
+
+// intWidth returns the bit width of an integer type; pointers are 64 bits
+func intWidth(t types.Type) int {
+	if it, ok := t.(*types.IntType); ok {
+		return it.BitWidth
+	}
+	return 64
+}
+
+// isSigned reports whether values of t are sign-extended when widened for
+// calls and returns. Booleans are zero-extended.
+func isSigned(t types.Type) bool {
+	it, ok := t.(*types.IntType)
+	return ok && it.Signed && it.BitWidth > 1
+}
+
+// fp returns the instruction suffix for a float type: s for f32, d for f64
+func fp(t types.Type) string {
+	if ft, ok := t.(*types.FloatType); ok && ft.BitWidth == 32 {
+		return "s"
+	}
+	return "d"
+}
+
+// wd returns the width letter of the float moves flw/fsw, fmv.w.x and
+// fld/fsd, fmv.d.x for a float type prefix
+func wd(p string) string {
+	if p == "s" {
+		return "w"
+	}
+	return "d"
+}
+
+func truncate(bits uint64, width int) uint64 {
+	if width >= 64 {
+		return bits
+	}
+	return bits & (1<<uint(width) - 1)
+}
+
+func signExtend(bits uint64, width int) int64 {
+	shift := uint(64 - width)
+	return int64(bits<<shift) >> shift
+}
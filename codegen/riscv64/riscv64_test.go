@@ -0,0 +1,33 @@
+package riscv64
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/codegen/internal/golden"
+)
+
+// TestGolden compares the assembly for each example module in
+// ../../testdata with testdata/<name>.s
+func TestGolden(t *testing.T) {
+	golden.Run(t, ".s", Generate)
+}
+
+func TestUnsupported(t *testing.T) {
+	m, err := asm.Parse("vec", `
+define external <4 x i32> @vadd(<4 x i32> %a, <4 x i32> %b) {
+entry:
+  %s = add <4 x i32> %a, %b
+  ret <4 x i32> %s
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	if err := Generate(&bytes.Buffer{}, m); !errors.As(err, &e) || e.Func == nil || e.Func.Name() != "vadd" {
+		t.Errorf("got error %v, want an *Error for @vadd", err)
+	}
+}
@@ -0,0 +1,50 @@
+	.text
+
+	.globl factorial
+	.p2align 2
+	.type factorial,@function
+factorial:
+	addi sp, sp, -64
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+	sd a0, 16(s0)
+.LBB0_0:				# %entry
+	lw t0, 16(s0)
+	li t1, 1
+	slt t0, t1, t0
+	xori t0, t0, 1
+	sd t0, 24(s0)
+	lbu t0, 24(s0)
+	andi t0, t0, 1
+	beqz t0, .Ltmp1
+	j .LBB0_1
+.Ltmp1:
+	j .LBB0_2
+.LBB0_1:				# %then
+	li a0, 1
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 64
+	ret
+.LBB0_2:				# %else
+	ld t0, 16(s0)
+	li t1, 1
+	sub t0, t0, t1
+	sd t0, 32(s0)
+	lw a0, 32(s0)
+	call factorial
+	sd a0, 40(s0)
+	ld t0, 16(s0)
+	ld t1, 40(s0)
+	mul t0, t0, t1
+	sd t0, 48(s0)
+	lw a0, 48(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 64
+	ret
+	.size factorial,.-factorial
+	.section .note.GNU-stack,"",@progbits
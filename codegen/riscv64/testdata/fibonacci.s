@@ -0,0 +1,56 @@
+	.text
+
+	.globl fib
+	.p2align 2
+	.type fib,@function
+fib:
+	addi sp, sp, -80
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+	sd a0, 16(s0)
+.LBB0_0:				# %entry
+	lw t0, 16(s0)
+	li t1, 2
+	slt t0, t0, t1
+	sd t0, 24(s0)
+	lbu t0, 24(s0)
+	andi t0, t0, 1
+	beqz t0, .Ltmp1
+	j .LBB0_1
+.Ltmp1:
+	j .LBB0_2
+.LBB0_1:				# %base_case
+	lw a0, 16(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 80
+	ret
+.LBB0_2:				# %recurse
+	ld t0, 16(s0)
+	li t1, 1
+	sub t0, t0, t1
+	sd t0, 32(s0)
+	lw a0, 32(s0)
+	call fib
+	sd a0, 40(s0)
+	ld t0, 16(s0)
+	li t1, 2
+	sub t0, t0, t1
+	sd t0, 48(s0)
+	lw a0, 48(s0)
+	call fib
+	sd a0, 56(s0)
+	ld t0, 40(s0)
+	ld t1, 56(s0)
+	add t0, t0, t1
+	sd t0, 64(s0)
+	lw a0, 64(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 80
+	ret
+	.size fib,.-fib
+	.section .note.GNU-stack,"",@progbits
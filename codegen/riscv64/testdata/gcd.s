@@ -0,0 +1,57 @@
+	.text
+
+	.globl gcd
+	.p2align 2
+	.type gcd,@function
+gcd:
+	addi sp, sp, -80
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+	sd a0, 16(s0)
+	sd a1, 24(s0)
+.LBB0_0:				# %entry
+	ld t0, 16(s0)
+	sd t0, 40(s0)
+	ld t0, 24(s0)
+	sd t0, 56(s0)
+	ld t0, 40(s0)
+	sd t0, 32(s0)
+	ld t0, 56(s0)
+	sd t0, 48(s0)
+	j .LBB0_1
+.LBB0_1:				# %loop.head
+	lwu t0, 48(s0)
+	li t1, 0
+	sub t0, t0, t1
+	snez t0, t0
+	sd t0, 64(s0)
+	lbu t0, 64(s0)
+	andi t0, t0, 1
+	beqz t0, .Ltmp1
+	j .LBB0_2
+.Ltmp1:
+	j .LBB0_3
+.LBB0_2:				# %loop.body
+	lw t0, 32(s0)
+	lw t1, 48(s0)
+	rem t0, t0, t1
+	sd t0, 72(s0)
+	ld t0, 48(s0)
+	sd t0, 40(s0)
+	ld t0, 72(s0)
+	sd t0, 56(s0)
+	ld t0, 40(s0)
+	sd t0, 32(s0)
+	ld t0, 56(s0)
+	sd t0, 48(s0)
+	j .LBB0_1
+.LBB0_3:				# %exit
+	lw a0, 32(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 80
+	ret
+	.size gcd,.-gcd
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,34 @@
+	.text
+
+	.globl main
+	.p2align 2
+	.type main,@function
+main:
+	addi sp, sp, -48
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+.LBB0_0:				# %entry
+	lla t1, g_val
+	lwu t0, 0(t1)
+	sd t0, 16(s0)
+	addi t0, s0, 24
+	sd t0, 32(s0)
+	ld t0, 16(s0)
+	ld t1, 32(s0)
+	sw t0, 0(t1)
+	lw a0, 16(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 48
+	ret
+	.size main,.-main
+	.data
+	.globl g_val
+	.p2align 2
+	.type g_val,@object
+	.size g_val,4
+g_val:
+	.long 42
+	.section .note.GNU-stack,"",@progbits
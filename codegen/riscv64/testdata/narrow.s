@@ -0,0 +1,75 @@
+	.text
+
+	.globl wrap
+	.p2align 2
+	.type wrap,@function
+wrap:
+	addi sp, sp, -48
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+	sd a0, 16(s0)
+	sd a1, 24(s0)
+.LBB0_0:				# %entry
+	ld t0, 16(s0)
+	li t1, 3
+	mul t0, t0, t1
+	sd t0, 32(s0)
+	ld t0, 32(s0)
+	ld t1, 24(s0)
+	add t0, t0, t1
+	sd t0, 40(s0)
+	lb a0, 40(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 48
+	ret
+	.size wrap,.-wrap
+
+	.globl twice
+	.p2align 2
+	.type twice,@function
+twice:
+	addi sp, sp, -32
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+	sd a0, 16(s0)
+.LBB1_0:				# %entry
+	ld t0, 16(s0)
+	ld t1, 16(s0)
+	add t0, t0, t1
+	sd t0, 24(s0)
+	lbu a0, 24(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 32
+	ret
+	.size twice,.-twice
+
+	.globl widen
+	.p2align 2
+	.type widen,@function
+widen:
+	addi sp, sp, -48
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+	sd a0, 16(s0)
+.LBB2_0:				# %entry
+	lb a0, 16(s0)
+	li a1, 1
+	call wrap
+	sd a0, 24(s0)
+	lb t0, 24(s0)
+	sd t0, 32(s0)
+	lw a0, 32(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 48
+	ret
+	.size widen,.-widen
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,26 @@
+	.text
+
+	.globl update_y
+	.p2align 2
+	.type update_y,@function
+update_y:
+	addi sp, sp, -48
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+	sd a0, 16(s0)
+	sd a1, 24(s0)
+.LBB0_0:				# %entry
+	ld t0, 16(s0)
+	addi t0, t0, 4
+	sd t0, 32(s0)
+	ld t0, 24(s0)
+	ld t1, 32(s0)
+	sw t0, 0(t1)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 48
+	ret
+	.size update_y,.-update_y
+	.section .note.GNU-stack,"",@progbits
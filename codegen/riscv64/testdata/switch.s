@@ -0,0 +1,49 @@
+	.text
+
+	.globl classify
+	.p2align 2
+	.type classify,@function
+classify:
+	addi sp, sp, -48
+	sd ra, 8(sp)
+	sd s0, 0(sp)
+	mv s0, sp
+	sd a0, 16(s0)
+.LBB0_0:				# %entry
+	lwu t0, 16(s0)
+	li t1, 0
+	bne t0, t1, .Ltmp1
+	j .LBB0_1
+.Ltmp1:
+	li t1, 1
+	bne t0, t1, .Ltmp2
+	j .LBB0_2
+.Ltmp2:
+	j .LBB0_3
+.LBB0_1:				# %case_zero
+	li t0, 100
+	sd t0, 32(s0)
+	ld t0, 32(s0)
+	sd t0, 24(s0)
+	j .LBB0_4
+.LBB0_2:				# %case_one
+	li t0, 200
+	sd t0, 32(s0)
+	ld t0, 32(s0)
+	sd t0, 24(s0)
+	j .LBB0_4
+.LBB0_3:				# %default
+	li t0, -1
+	sd t0, 32(s0)
+	ld t0, 32(s0)
+	sd t0, 24(s0)
+	j .LBB0_4
+.LBB0_4:				# %merge
+	lw a0, 24(s0)
+	mv sp, s0
+	ld ra, 8(sp)
+	ld s0, 0(sp)
+	addi sp, sp, 48
+	ret
+	.size classify,.-classify
+	.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,154 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Value types
+const (
+	i32     byte = 0x7f
+	i64     byte = 0x7e
+	f32     byte = 0x7d
+	f64     byte = 0x7c
+	funcref byte = 0x70
+)
+
+// Section ids
+const (
+	secType     = 1
+	secImport   = 2
+	secFunction = 3
+	secTable    = 4
+	secMemory   = 5
+	secGlobal   = 6
+	secExport   = 7
+	secElem     = 9
+	secCode     = 10
+	secData     = 11
+)
+
+// Export and import kinds
+const (
+	kindFunc   = 0
+	kindMemory = 2
+	kindGlobal = 3
+)
+
+// Opcodes. The numeric instructions are named after the i32 variant; the
+// i64, f32 and f64 variants are found with the tables in inst.go.
+const (
+	opUnreachable = 0x00
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opElse        = 0x05
+	opEnd         = 0x0b
+	opBr          = 0x0c
+	opReturn      = 0x0f
+	opCall        = 0x10
+	opSelect      = 0x1b
+	opLocalGet    = 0x20
+	opLocalSet    = 0x21
+	opLocalTee    = 0x22
+	opGlobalGet   = 0x23
+	opGlobalSet   = 0x24
+	opI32Load     = 0x28
+	opI64Load     = 0x29
+	opF32Load     = 0x2a
+	opF64Load     = 0x2b
+	opI32Load8U   = 0x2d
+	opI32Load16U  = 0x2f
+	opI32Store    = 0x36
+	opI64Store    = 0x37
+	opF32Store    = 0x38
+	opF64Store    = 0x39
+	opI32Store8   = 0x3a
+	opI32Store16  = 0x3b
+	opI32Const    = 0x41
+	opI64Const    = 0x42
+	opF32Const    = 0x43
+	opF64Const    = 0x44
+	opI32Eqz      = 0x45
+	opI32Add      = 0x6a
+	opI32Sub      = 0x6b
+	opI32Mul      = 0x6c
+	opI32And      = 0x71
+	opI32Or       = 0x72
+	opI32Shl      = 0x74
+	opI32ShrS     = 0x75
+	opI32WrapI64  = 0xa7
+	opI64ExtendS  = 0xac // i64.extend_i32_s
+	opI64ExtendU  = 0xad // i64.extend_i32_u
+	opF32Demote   = 0xb6
+	opF64Promote  = 0xbb
+	opI32Ext8S    = 0xc0
+	opI32Ext16S   = 0xc1
+	opPrefixFC    = 0xfc
+	opMemoryCopy  = 10 // after opPrefixFC
+	opMemoryFill  = 11 // after opPrefixFC
+	blockTypeNone = 0x40
+)
+
+// buffer accumulates the bytes of a module or of one of its parts
+type buffer struct {
+	b []byte
+}
+
+func (w *buffer) byte(b ...byte) { w.b = append(w.b, b...) }
+
+func (w *buffer) u32(v uint32) {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		w.b = append(w.b, c)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func (w *buffer) s64(v int64) {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		done := v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0
+		if !done {
+			c |= 0x80
+		}
+		w.b = append(w.b, c)
+		if done {
+			return
+		}
+	}
+}
+
+func (w *buffer) name(s string) {
+	w.u32(uint32(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *buffer) f32(v float32) {
+	w.b = binary.LittleEndian.AppendUint32(w.b, math.Float32bits(v))
+}
+
+func (w *buffer) f64(v float64) {
+	w.b = binary.LittleEndian.AppendUint64(w.b, math.Float64bits(v))
+}
+
+// section appends a section with the given id and contents
+func (w *buffer) section(id byte, contents *buffer) {
+	w.byte(id)
+	w.u32(uint32(len(contents.b)))
+	w.byte(contents.b...)
+}
+
+// i32Const appends the constant expression of a global or segment offset
+func (w *buffer) i32Const(v int64) {
+	w.byte(opI32Const)
+	w.s64(int64(int32(v)))
+	w.byte(opEnd)
+}
@@ -0,0 +1,426 @@
+package wasm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/arc-language/core-builder/analysis"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// funcGen holds the state of one function being lowered
+type funcGen struct {
+	*gen
+	fn   *ir.Function
+	code buffer
+
+	locals     map[ir.Value]uint32
+	localTypes []byte // types of the locals declared after the parameters
+	nparams    int
+	fp         uint32 // local holding the frame address
+
+	frame   int64
+	slots   map[ir.Value]int64    // frame offset of allocas and aggregate values
+	temps   map[*ir.PhiInst]int64 // staging slot of aggregate phis
+	varargs int64                 // frame offset of the variadic call area
+	dynamic bool                  // whether the function has dynamic allocas
+
+	rpo   map[*ir.BasicBlock]int
+	merge map[*ir.BasicBlock]bool
+	loop  map[*ir.BasicBlock]bool
+	dom   *analysis.DomTree
+	ctx   []label
+}
+
+// label is an enclosing block, loop or if that branches can target
+type label struct {
+	kind  byte // opBlock, opLoop or opIf
+	block *ir.BasicBlock
+}
+
+func (g *gen) function(fn *ir.Function) (*buffer, error) {
+	f := &funcGen{
+		gen:    g,
+		fn:     fn,
+		locals: make(map[ir.Value]uint32),
+		slots:  make(map[ir.Value]int64),
+		temps:  make(map[*ir.PhiInst]int64),
+	}
+	for i, a := range fn.Arguments {
+		f.locals[a] = uint32(i)
+	}
+	f.nparams = len(fn.Arguments)
+	if fn.FuncType.Variadic {
+		f.nparams++
+	}
+	if err := f.layout(); err != nil {
+		return nil, err
+	}
+	if err := f.structure(); err != nil {
+		return nil, err
+	}
+
+	if f.frame > 0 || f.dynamic {
+		f.fp = f.newLocal(i32)
+		f.code.byte(opGlobalGet)
+		f.code.u32(stackPointer)
+		f.i32Const(f.frame)
+		f.code.byte(opI32Sub, opLocalTee)
+		f.code.u32(f.fp)
+		f.code.byte(opGlobalSet)
+		f.code.u32(stackPointer)
+	}
+	if err := f.doTree(fn.EntryBlock()); err != nil {
+		return nil, err
+	}
+	// Every path has returned or trapped; this satisfies the validator when
+	// the function's last construct is an if or a block
+	f.code.byte(opUnreachable, opEnd)
+
+	body := &buffer{}
+	var groups [][2]int
+	for _, t := range f.localTypes {
+		if n := len(groups); n > 0 && groups[n-1][1] == int(t) {
+			groups[n-1][0]++
+		} else {
+			groups = append(groups, [2]int{1, int(t)})
+		}
+	}
+	body.u32(uint32(len(groups)))
+	for _, grp := range groups {
+		body.u32(uint32(grp[0]))
+		body.byte(byte(grp[1]))
+	}
+	body.byte(f.code.b...)
+	return body, nil
+}
+
+func (f *funcGen) errorf(inst ir.Instruction, format string, args ...interface{}) error {
+	return &Error{Func: f.fn, Inst: inst, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (f *funcGen) newLocal(t byte) uint32 {
+	f.localTypes = append(f.localTypes, t)
+	return uint32(f.nparams + len(f.localTypes) - 1)
+}
+
+// layout gives every value a local and reserves frame space for fixed-size
+// entry allocas, aggregate values and the arguments of variadic calls
+func (f *funcGen) layout() error {
+	alloc := func(n, align int64) int64 {
+		off := alignTo(f.frame, align)
+		f.frame = off + n
+		return off
+	}
+	for _, a := range f.fn.Arguments {
+		if _, err := f.scalarType(a.Type()); err != nil {
+			return &Error{Func: f.fn, Msg: fmt.Sprintf("argument %d: %v", a.Index, err)}
+		}
+	}
+
+	var varargs int64
+	for i, b := range f.fn.Blocks {
+		for _, inst := range b.Instructions {
+			if call, ok := inst.(*ir.CallInst); ok {
+				if callee := f.callee(call); callee != nil && callee.FuncType.Variadic {
+					extra := len(call.Ops) - len(callee.FuncType.ParamTypes)
+					varargs = max(varargs, int64(extra)*8)
+				}
+			}
+			t := inst.Type()
+			if t == nil || t.Kind() == types.VoidKind {
+				continue
+			}
+			vt, err := f.valType(t)
+			if err != nil {
+				return f.errorf(inst, "%v", err)
+			}
+			f.locals[inst] = f.newLocal(vt)
+
+			switch v := inst.(type) {
+			case *ir.AllocaInst:
+				if n, ok := staticCount(v); ok && i == 0 {
+					align := max(f.dl.AlignOf(v.AllocatedType), int64(v.Alignment), 1)
+					f.slots[v] = alloc(f.dl.SizeOf(v.AllocatedType)*n, align)
+				} else {
+					f.dynamic = true
+				}
+			default:
+				if types.IsAggregate(t) {
+					f.slots[inst] = alloc(f.dl.SizeOf(t), f.dl.AlignOf(t))
+					if phi, ok := inst.(*ir.PhiInst); ok {
+						f.temps[phi] = alloc(f.dl.SizeOf(t), f.dl.AlignOf(t))
+					}
+				}
+			}
+		}
+	}
+	if varargs > 0 {
+		f.varargs = alloc(varargs, 8)
+	}
+	f.frame = alignTo(f.frame, 16)
+	return nil
+}
+
+// staticCount returns the element count of an alloca if it is a constant
+func staticCount(a *ir.AllocaInst) (int64, bool) {
+	if a.NumElements == nil {
+		return 1, true
+	}
+	if c, ok := a.NumElements.(*ir.ConstantInt); ok && c.Value >= 0 {
+		return c.Value, true
+	}
+	return 0, false
+}
+
+// ============================================================================
+// Control flow
+// ============================================================================
+
+// The structured control flow is recovered with the algorithm of Ramsey,
+// "Beyond Relooper" (2022). Each block is emitted where its dominator tree
+// parent places it: blocks with a single forward predecessor are inlined at
+// the branch to them, blocks with several are emitted after a wasm block
+// that forward branches exit, and loop headers open a wasm loop that back
+// edges continue.
+
+// structure classifies the blocks of the function by their edges
+func (f *funcGen) structure() error {
+	f.dom = analysis.Dominators(f.fn)
+	f.rpo = make(map[*ir.BasicBlock]int)
+	f.merge = make(map[*ir.BasicBlock]bool)
+	f.loop = make(map[*ir.BasicBlock]bool)
+	for i, b := range f.dom.Blocks() {
+		f.rpo[b] = i
+	}
+	preds := make(map[*ir.BasicBlock]int)
+	for _, b := range f.dom.Blocks() {
+		term := b.Terminator()
+		if term == nil {
+			return &Error{Func: f.fn, Msg: fmt.Sprintf("block %%%s has no terminator", b.Name())}
+		}
+		for _, s := range targets(term) {
+			if f.rpo[s] > f.rpo[b] {
+				preds[s]++
+				continue
+			}
+			if !f.dom.Dominates(s, b) {
+				return &Error{Func: f.fn, Msg: fmt.Sprintf("irreducible control flow at %%%s", s.Name())}
+			}
+			f.loop[s] = true
+		}
+	}
+	for b, n := range preds {
+		f.merge[b] = n > 1
+	}
+	return nil
+}
+
+// targets returns the branch targets of a terminator, once per edge
+func targets(term ir.Instruction) []*ir.BasicBlock {
+	switch t := term.(type) {
+	case *ir.BrInst:
+		return []*ir.BasicBlock{t.Target}
+	case *ir.CondBrInst:
+		return []*ir.BasicBlock{t.TrueBlock, t.FalseBlock}
+	case *ir.SwitchInst:
+		list := []*ir.BasicBlock{t.DefaultBlock}
+		for _, c := range t.Cases {
+			list = append(list, c.Block)
+		}
+		return list
+	}
+	return nil
+}
+
+func (f *funcGen) doTree(x *ir.BasicBlock) error {
+	var merges []*ir.BasicBlock
+	for _, c := range f.dom.Children(x) {
+		if f.merge[c] {
+			merges = append(merges, c)
+		}
+	}
+	// The merge block placed last is wrapped outermost
+	sort.Slice(merges, func(i, j int) bool { return f.rpo[merges[i]] > f.rpo[merges[j]] })
+	if !f.loop[x] {
+		return f.nodeWithin(x, merges)
+	}
+	f.open(opLoop, x)
+	if err := f.nodeWithin(x, merges); err != nil {
+		return err
+	}
+	f.close()
+	return nil
+}
+
+func (f *funcGen) nodeWithin(x *ir.BasicBlock, merges []*ir.BasicBlock) error {
+	if len(merges) > 0 {
+		f.open(opBlock, merges[0])
+		if err := f.nodeWithin(x, merges[1:]); err != nil {
+			return err
+		}
+		f.close()
+		return f.doTree(merges[0])
+	}
+	for _, inst := range x.Instructions {
+		if inst.IsTerminator() {
+			return f.terminator(inst)
+		}
+		if err := f.instruction(inst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *funcGen) open(kind byte, b *ir.BasicBlock) {
+	f.code.byte(kind, blockTypeNone)
+	f.ctx = append(f.ctx, label{kind, b})
+}
+
+func (f *funcGen) close() {
+	f.code.byte(opEnd)
+	f.ctx = f.ctx[:len(f.ctx)-1]
+}
+
+// br branches to the enclosing label of the given kind for b
+func (f *funcGen) br(kind byte, b *ir.BasicBlock) {
+	for i := len(f.ctx) - 1; i >= 0; i-- {
+		if f.ctx[i].kind == kind && f.ctx[i].block == b {
+			f.code.byte(opBr)
+			f.code.u32(uint32(len(f.ctx) - 1 - i))
+			return
+		}
+	}
+	panic("wasm: branch target is not in scope")
+}
+
+// doBranch transfers control from src to dst
+func (f *funcGen) doBranch(src, dst *ir.BasicBlock) error {
+	if err := f.phiCopies(src, dst); err != nil {
+		return err
+	}
+	switch {
+	case f.rpo[dst] <= f.rpo[src]:
+		f.br(opLoop, dst)
+	case f.merge[dst]:
+		f.br(opBlock, dst)
+	default:
+		return f.doTree(dst)
+	}
+	return nil
+}
+
+func (f *funcGen) terminator(inst ir.Instruction) error {
+	b := inst.Parent()
+	switch i := inst.(type) {
+	case *ir.RetInst:
+		// The caller may be the host, so narrow integers are extended
+		if v := i.Ops; len(v) > 0 && v[0] != nil {
+			if err := f.extend(v[0], isSigned(v[0].Type())); err != nil {
+				return err
+			}
+		}
+		if f.frame > 0 || f.dynamic {
+			f.code.byte(opLocalGet)
+			f.code.u32(f.fp)
+			f.i32Const(f.frame)
+			f.code.byte(opI32Add, opGlobalSet)
+			f.code.u32(stackPointer)
+		}
+		f.code.byte(opReturn)
+	case *ir.BrInst:
+		return f.doBranch(b, i.Target)
+	case *ir.CondBrInst:
+		if err := f.extend(i.Condition, false); err != nil {
+			return err
+		}
+		f.open(opIf, nil)
+		if err := f.doBranch(b, i.TrueBlock); err != nil {
+			return err
+		}
+		f.code.byte(opElse)
+		if err := f.doBranch(b, i.FalseBlock); err != nil {
+			return err
+		}
+		f.close()
+	case *ir.SwitchInst:
+		vt, _ := f.valType(i.Condition.Type())
+		for _, c := range i.Cases {
+			if err := f.extend(i.Condition, false); err != nil {
+				return err
+			}
+			v := truncate(uint64(c.Value.Value), intWidth(c.Value.Type()))
+			f.constant(vt, v)
+			f.code.byte(op(vt, opI32Eq))
+			f.open(opIf, nil)
+			if err := f.doBranch(b, c.Block); err != nil {
+				return err
+			}
+			f.code.byte(opElse)
+		}
+		if err := f.doBranch(b, i.DefaultBlock); err != nil {
+			return err
+		}
+		for range i.Cases {
+			f.close()
+		}
+	case *ir.UnreachableInst:
+		f.code.byte(opUnreachable)
+	default:
+		return f.errorf(inst, "unsupported terminator")
+	}
+	return nil
+}
+
+// phiCopies assigns the phis of dst their values for the edge from src. All
+// values are read before any phi is written, since a phi may read another
+// phi of the same block.
+func (f *funcGen) phiCopies(src, dst *ir.BasicBlock) error {
+	var scalars []*ir.PhiInst
+	var aggregates []*ir.PhiInst
+	for _, inst := range dst.Instructions {
+		phi, ok := inst.(*ir.PhiInst)
+		if !ok {
+			break
+		}
+		for _, inc := range phi.Incoming {
+			if inc.Block != src {
+				continue
+			}
+			if types.IsAggregate(phi.Type()) {
+				f.frameAddr(f.temps[phi])
+				if err := f.value(inc.Value); err != nil {
+					return err
+				}
+				f.memoryCopy(f.dl.SizeOf(phi.Type()))
+				aggregates = append(aggregates, phi)
+			} else {
+				if err := f.value(inc.Value); err != nil {
+					return err
+				}
+				scalars = append(scalars, phi)
+			}
+			break
+		}
+	}
+	for k := len(scalars) - 1; k >= 0; k-- {
+		f.code.byte(opLocalSet)
+		f.code.u32(f.locals[scalars[k]])
+	}
+	for _, phi := range aggregates {
+		f.frameAddr(f.slots[phi])
+		f.frameAddr(f.temps[phi])
+		f.memoryCopy(f.dl.SizeOf(phi.Type()))
+		f.setAddr(phi)
+	}
+	return nil
+}
+
+func (f *funcGen) callee(call *ir.CallInst) *ir.Function {
+	if call.Callee != nil {
+		return call.Callee
+	}
+	return f.m.GetFunction(call.CalleeName)
+}
@@ -0,0 +1,809 @@
+package wasm
+
+import (
+	"math"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Opcodes of the numeric instructions, named after their i32 or f32
+// variant; op maps them to the variant of another type
+const (
+	opI32Eq    = 0x46
+	opI32Ne    = 0x47
+	opI32LtS   = 0x48
+	opI32LtU   = 0x49
+	opI32GtS   = 0x4a
+	opI32GtU   = 0x4b
+	opI32LeS   = 0x4c
+	opI32LeU   = 0x4d
+	opI32GeS   = 0x4e
+	opI32GeU   = 0x4f
+	opF32Eq    = 0x5b
+	opF32Ne    = 0x5c
+	opF32Lt    = 0x5d
+	opF32Gt    = 0x5e
+	opF32Le    = 0x5f
+	opF32Ge    = 0x60
+	opI32DivS  = 0x6d
+	opI32DivU  = 0x6e
+	opI32RemS  = 0x6f
+	opI32RemU  = 0x70
+	opI32Xor   = 0x73
+	opI32ShrU  = 0x76
+	opF32Trunc = 0x8f
+	opF32Add   = 0x92
+	opF32Sub   = 0x93
+	opF32Mul   = 0x94
+	opF32Div   = 0x95
+)
+
+// op returns the variant for values of type vt of an i32 or f32 opcode
+func op(vt byte, code byte) byte {
+	switch vt {
+	case i64:
+		if code <= opI32GeU {
+			return code + 0x0b
+		}
+		return code + 0x12
+	case f64:
+		if code <= opF32Ge {
+			return code + 6
+		}
+		return code + 0x0e
+	}
+	return code
+}
+
+// ============================================================================
+// Operands
+// ============================================================================
+
+// value pushes v. Aggregates are pushed as their address.
+func (f *funcGen) value(v ir.Value) error {
+	vt, err := f.valType(v.Type())
+	if err != nil {
+		return err
+	}
+	switch c := v.(type) {
+	case *ir.ConstantInt:
+		f.constant(vt, uint64(c.Value))
+	case *ir.ConstantFloat:
+		if vt == f32 {
+			f.code.byte(opF32Const)
+			f.code.f32(float32(c.Value))
+		} else {
+			f.code.byte(opF64Const)
+			f.code.f64(c.Value)
+		}
+	case *ir.ConstantNull:
+		f.i32Const(0)
+	case *ir.ConstantUndef, *ir.ConstantZero, *ir.ConstantArray, *ir.ConstantStruct:
+		if !types.IsAggregate(v.Type()) {
+			f.constant(vt, 0)
+			return nil
+		}
+		addr, err := f.constAddr(c.(ir.Constant))
+		if err != nil {
+			return err
+		}
+		f.i32Const(addr)
+	case *ir.Global:
+		f.i32Const(f.globals[c])
+	case *ir.Function:
+		f.i32Const(int64(f.tableIndex(c)))
+	default:
+		idx, ok := f.locals[v]
+		if !ok {
+			return &Error{Func: f.fn, Msg: "use of unavailable value " + v.String()}
+		}
+		f.code.byte(opLocalGet)
+		f.code.u32(idx)
+	}
+	return nil
+}
+
+// extend pushes integer v sign- or zero-extended from its width to the
+// width of its wasm type. Narrow integers are kept in i32 locals whose
+// upper bits are unspecified.
+func (f *funcGen) extend(v ir.Value, signed bool) error {
+	if err := f.value(v); err != nil {
+		return err
+	}
+	switch w := intWidth(v.Type()); {
+	case w == 32 || w == 64:
+	case !signed:
+		f.i32Const(int64(1)<<uint(w) - 1)
+		f.code.byte(opI32And)
+	case w == 8:
+		f.code.byte(opI32Ext8S)
+	case w == 16:
+		f.code.byte(opI32Ext16S)
+	default:
+		f.i32Const(int64(32 - w))
+		f.code.byte(opI32Shl)
+		f.i32Const(int64(32 - w))
+		f.code.byte(opI32ShrS)
+	}
+	return nil
+}
+
+// constant pushes a constant of type vt with the given bits
+func (f *funcGen) constant(vt byte, bits uint64) {
+	switch vt {
+	case i64:
+		f.code.byte(opI64Const)
+		f.code.s64(int64(bits))
+	case f32:
+		f.code.byte(opF32Const)
+		f.code.f32(math.Float32frombits(uint32(bits)))
+	case f64:
+		f.code.byte(opF64Const)
+		f.code.f64(math.Float64frombits(bits))
+	default:
+		f.i32Const(int64(int32(bits)))
+	}
+}
+
+func (f *funcGen) i32Const(v int64) {
+	f.code.byte(opI32Const)
+	f.code.s64(int64(int32(v)))
+}
+
+// frameAddr pushes the address of the frame location at off
+func (f *funcGen) frameAddr(off int64) {
+	f.code.byte(opLocalGet)
+	f.code.u32(f.fp)
+	if off != 0 {
+		f.i32Const(off)
+		f.code.byte(opI32Add)
+	}
+}
+
+// setAddr points the local of inst at its frame slot
+func (f *funcGen) setAddr(inst ir.Value) {
+	f.frameAddr(f.slots[inst])
+	f.set(inst)
+}
+
+func (f *funcGen) set(inst ir.Value) {
+	f.code.byte(opLocalSet)
+	f.code.u32(f.locals[inst])
+}
+
+// memoryCopy copies n bytes between the destination and source addresses
+// on the stack
+func (f *funcGen) memoryCopy(n int64) {
+	f.i32Const(n)
+	f.code.byte(opPrefixFC)
+	f.code.u32(opMemoryCopy)
+	f.code.byte(0, 0)
+}
+
+// load loads a scalar of type t from the address on the stack
+func (f *funcGen) load(t types.Type) {
+	vt, _ := f.valType(t)
+	switch {
+	case vt == i32 && intWidth(t) <= 8:
+		f.code.byte(opI32Load8U)
+	case vt == i32 && intWidth(t) == 16:
+		f.code.byte(opI32Load16U)
+	default:
+		f.code.byte(map[byte]byte{i32: opI32Load, i64: opI64Load, f32: opF32Load, f64: opF64Load}[vt])
+	}
+	f.code.byte(0, 0) // byte aligned, no offset
+}
+
+// store stores a scalar of type t to the address below it on the stack
+func (f *funcGen) store(t types.Type) {
+	vt, _ := f.valType(t)
+	switch {
+	case vt == i32 && intWidth(t) <= 8:
+		f.code.byte(opI32Store8)
+	case vt == i32 && intWidth(t) == 16:
+		f.code.byte(opI32Store16)
+	default:
+		f.code.byte(map[byte]byte{i32: opI32Store, i64: opI64Store, f32: opF32Store, f64: opF64Store}[vt])
+	}
+	f.code.byte(0, 0)
+}
+
+// ============================================================================
+// Instructions
+// ============================================================================
+
+func (f *funcGen) instruction(inst ir.Instruction) error {
+	switch i := inst.(type) {
+	case *ir.PhiInst:
+		// Assigned on the incoming edges
+		return nil
+	case *ir.BinaryInst:
+		return f.binary(i)
+	case *ir.ICmpInst:
+		return f.icmp(i)
+	case *ir.FCmpInst:
+		return f.fcmp(i)
+	case *ir.CastInst:
+		return f.cast(i)
+	case *ir.SelectInst:
+		return f.selectInst(i)
+	case *ir.AllocaInst:
+		return f.alloca(i)
+	case *ir.LoadInst:
+		return f.loadInst(i)
+	case *ir.StoreInst:
+		return f.storeInst(i)
+	case *ir.GetElementPtrInst:
+		return f.gep(i)
+	case *ir.ExtractValueInst:
+		return f.extractValue(i)
+	case *ir.InsertValueInst:
+		return f.insertValue(i)
+	case *ir.CallInst:
+		return f.call(i)
+	case *ir.SyscallInst:
+		return f.syscallInst(i)
+	}
+	return f.errorf(inst, "unsupported instruction")
+}
+
+// push pushes each of vals
+func (f *funcGen) push(vals ...ir.Value) error {
+	for _, v := range vals {
+		if err := f.value(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *funcGen) binary(i *ir.BinaryInst) error {
+	lhs, rhs := i.Ops[0], i.Ops[1]
+	vt, _ := f.valType(i.Type())
+	if types.IsFloat(i.Type()) {
+		if i.Op == ir.OpFRem {
+			if err := f.push(lhs, lhs, rhs); err != nil {
+				return err
+			}
+			f.code.byte(op(vt, opF32Div), op(vt, opF32Trunc))
+			if err := f.push(rhs); err != nil {
+				return err
+			}
+			f.code.byte(op(vt, opF32Mul), op(vt, opF32Sub))
+		} else {
+			if err := f.push(lhs, rhs); err != nil {
+				return err
+			}
+			codes := map[ir.Opcode]byte{ir.OpFAdd: opF32Add, ir.OpFSub: opF32Sub, ir.OpFMul: opF32Mul, ir.OpFDiv: opF32Div}
+			f.code.byte(op(vt, codes[i.Op]))
+		}
+		f.set(i)
+		return nil
+	}
+
+	var err error
+	switch i.Op {
+	case ir.OpUDiv, ir.OpURem, ir.OpLShr:
+		if err = f.extend(lhs, false); err == nil {
+			err = f.extend(rhs, false)
+		}
+	case ir.OpSDiv, ir.OpSRem:
+		if err = f.extend(lhs, true); err == nil {
+			err = f.extend(rhs, true)
+		}
+	case ir.OpAShr:
+		if err = f.extend(lhs, true); err == nil {
+			err = f.extend(rhs, false)
+		}
+	case ir.OpShl:
+		if err = f.value(lhs); err == nil {
+			err = f.extend(rhs, false)
+		}
+	default:
+		err = f.push(lhs, rhs)
+	}
+	if err != nil {
+		return err
+	}
+	codes := map[ir.Opcode]byte{
+		ir.OpAdd: opI32Add, ir.OpSub: opI32Sub, ir.OpMul: opI32Mul,
+		ir.OpAnd: opI32And, ir.OpOr: opI32Or, ir.OpXor: opI32Xor,
+		ir.OpShl: opI32Shl, ir.OpLShr: opI32ShrU, ir.OpAShr: opI32ShrS,
+		ir.OpUDiv: opI32DivU, ir.OpSDiv: opI32DivS, ir.OpURem: opI32RemU, ir.OpSRem: opI32RemS,
+	}
+	code, ok := codes[i.Op]
+	if !ok {
+		return f.errorf(i, "unsupported operation")
+	}
+	f.code.byte(op(vt, code))
+	f.set(i)
+	return nil
+}
+
+var icmpCodes = map[ir.ICmpPredicate]byte{
+	ir.ICmpEQ: opI32Eq, ir.ICmpNE: opI32Ne,
+	ir.ICmpUGT: opI32GtU, ir.ICmpUGE: opI32GeU, ir.ICmpULT: opI32LtU, ir.ICmpULE: opI32LeU,
+	ir.ICmpSGT: opI32GtS, ir.ICmpSGE: opI32GeS, ir.ICmpSLT: opI32LtS, ir.ICmpSLE: opI32LeS,
+}
+
+func (f *funcGen) icmp(i *ir.ICmpInst) error {
+	signed := false
+	switch i.Predicate {
+	case ir.ICmpSGT, ir.ICmpSGE, ir.ICmpSLT, ir.ICmpSLE:
+		signed = true
+	}
+	if err := f.extend(i.Ops[0], signed); err != nil {
+		return err
+	}
+	if err := f.extend(i.Ops[1], signed); err != nil {
+		return err
+	}
+	vt, _ := f.valType(i.Ops[0].Type())
+	f.code.byte(op(vt, icmpCodes[i.Predicate]))
+	f.set(i)
+	return nil
+}
+
+// unorderedInverse maps each unordered predicate to the ordered one it
+// negates
+var unorderedInverse = map[ir.FCmpPredicate]ir.FCmpPredicate{
+	ir.FCmpUEQ: ir.FCmpONE, ir.FCmpUNE: ir.FCmpOEQ,
+	ir.FCmpUGT: ir.FCmpOLE, ir.FCmpUGE: ir.FCmpOLT,
+	ir.FCmpULT: ir.FCmpOGE, ir.FCmpULE: ir.FCmpOGT,
+	ir.FCmpUNO: ir.FCmpORD,
+}
+
+// fcmp relies on the wasm comparisons other than ne being false for NaN
+func (f *funcGen) fcmp(i *ir.FCmpInst) error {
+	x, y := i.Ops[0], i.Ops[1]
+	vt, _ := f.valType(x.Type())
+	pred, negate := i.Predicate, false
+	if inv, ok := unorderedInverse[pred]; ok {
+		pred, negate = inv, true
+	}
+	cmp := func(a, b ir.Value, code byte) error {
+		if err := f.push(a, b); err != nil {
+			return err
+		}
+		f.code.byte(op(vt, code))
+		return nil
+	}
+	var err error
+	switch pred {
+	case ir.FCmpFalse:
+		f.i32Const(0)
+	case ir.FCmpTrue:
+		f.i32Const(1)
+	case ir.FCmpOEQ:
+		err = cmp(x, y, opF32Eq)
+	case ir.FCmpOGT:
+		err = cmp(x, y, opF32Gt)
+	case ir.FCmpOGE:
+		err = cmp(x, y, opF32Ge)
+	case ir.FCmpOLT:
+		err = cmp(x, y, opF32Lt)
+	case ir.FCmpOLE:
+		err = cmp(x, y, opF32Le)
+	case ir.FCmpONE:
+		if err = cmp(x, y, opF32Lt); err == nil {
+			err = cmp(x, y, opF32Gt)
+		}
+		f.code.byte(opI32Or)
+	case ir.FCmpORD:
+		if err = cmp(x, x, opF32Eq); err == nil {
+			err = cmp(y, y, opF32Eq)
+		}
+		f.code.byte(opI32And)
+	}
+	if err != nil {
+		return err
+	}
+	if negate {
+		f.code.byte(opI32Eqz)
+	}
+	f.set(i)
+	return nil
+}
+
+func (f *funcGen) cast(i *ir.CastInst) error {
+	v := i.Ops[0]
+	src, _ := f.valType(v.Type())
+	dst, err := f.valType(i.Type())
+	if err != nil {
+		return f.errorf(i, "%v", err)
+	}
+	unsigned := 0
+	switch i.Op {
+	case ir.OpTrunc:
+		if err := f.value(v); err != nil {
+			return err
+		}
+		if src == i64 && dst == i32 {
+			f.code.byte(opI32WrapI64)
+		}
+	case ir.OpZExt, ir.OpSExt, ir.OpPtrToInt, ir.OpIntToPtr:
+		signed := i.Op == ir.OpSExt
+		if err := f.extend(v, signed); err != nil {
+			return err
+		}
+		switch {
+		case src == i32 && dst == i64 && signed:
+			f.code.byte(opI64ExtendS)
+		case src == i32 && dst == i64:
+			f.code.byte(opI64ExtendU)
+		case src == i64 && dst == i32:
+			f.code.byte(opI32WrapI64)
+		}
+	case ir.OpFPTrunc, ir.OpFPExt:
+		if err := f.value(v); err != nil {
+			return err
+		}
+		switch {
+		case src == f64 && dst == f32:
+			f.code.byte(opF32Demote)
+		case src == f32 && dst == f64:
+			f.code.byte(opF64Promote)
+		}
+	case ir.OpFPToUI, ir.OpFPToSI:
+		if i.Op == ir.OpFPToUI {
+			unsigned = 1
+		}
+		if err := f.value(v); err != nil {
+			return err
+		}
+		// The saturating conversions do not trap on out of range values,
+		// whose results are undefined in the IR
+		sub := unsigned
+		if src == f64 {
+			sub += 2
+		}
+		if dst == i64 {
+			sub += 4
+		}
+		f.code.byte(opPrefixFC)
+		f.code.u32(uint32(sub))
+	case ir.OpUIToFP, ir.OpSIToFP:
+		if i.Op == ir.OpUIToFP {
+			unsigned = 1
+		}
+		if err := f.extend(v, unsigned == 0); err != nil {
+			return err
+		}
+		code := byte(0xb2) // f32.convert_i32_s
+		if dst == f64 {
+			code = 0xb7
+		}
+		if src == i64 {
+			code += 2
+		}
+		f.code.byte(code + byte(unsigned))
+	case ir.OpBitcast:
+		if types.IsAggregate(v.Type()) || types.IsAggregate(i.Type()) {
+			return f.errorf(i, "cannot bitcast aggregate values")
+		}
+		if err := f.value(v); err != nil {
+			return err
+		}
+		if src != dst {
+			reinterpret := map[[2]byte]byte{{f32, i32}: 0xbc, {f64, i64}: 0xbd, {i32, f32}: 0xbe, {i64, f64}: 0xbf}
+			code, ok := reinterpret[[2]byte{src, dst}]
+			if !ok {
+				return f.errorf(i, "bitcast between types of different sizes")
+			}
+			f.code.byte(code)
+		}
+	default:
+		return f.errorf(i, "unsupported cast")
+	}
+	f.set(i)
+	return nil
+}
+
+func (f *funcGen) selectInst(i *ir.SelectInst) error {
+	aggregate := types.IsAggregate(i.Type())
+	if aggregate {
+		f.frameAddr(f.slots[i])
+	}
+	if err := f.push(i.Ops[1], i.Ops[2]); err != nil {
+		return err
+	}
+	if err := f.extend(i.Ops[0], false); err != nil {
+		return err
+	}
+	f.code.byte(opSelect)
+	if aggregate {
+		f.memoryCopy(f.dl.SizeOf(i.Type()))
+		f.setAddr(i)
+		return nil
+	}
+	f.set(i)
+	return nil
+}
+
+// alloca points fixed-size entry allocas at their frame slot; the others
+// are carved from the shadow stack, which the frame restores on return
+func (f *funcGen) alloca(i *ir.AllocaInst) error {
+	if _, ok := f.slots[i]; ok {
+		f.setAddr(i)
+		return nil
+	}
+	f.code.byte(opGlobalGet)
+	f.code.u32(stackPointer)
+	if i.NumElements != nil {
+		if err := f.extend(i.NumElements, false); err != nil {
+			return err
+		}
+		if vt, _ := f.valType(i.NumElements.Type()); vt == i64 {
+			f.code.byte(opI32WrapI64)
+		}
+		f.i32Const(f.dl.SizeOf(i.AllocatedType))
+		f.code.byte(opI32Mul)
+	} else {
+		f.i32Const(f.dl.SizeOf(i.AllocatedType))
+	}
+	f.code.byte(opI32Sub)
+	f.i32Const(-16)
+	f.code.byte(opI32And, opLocalTee)
+	f.code.u32(f.locals[i])
+	f.code.byte(opGlobalSet)
+	f.code.u32(stackPointer)
+	return nil
+}
+
+func (f *funcGen) loadInst(i *ir.LoadInst) error {
+	t := i.Type()
+	if types.IsAggregate(t) {
+		f.frameAddr(f.slots[i])
+		if err := f.value(i.Ops[0]); err != nil {
+			return err
+		}
+		f.memoryCopy(f.dl.SizeOf(t))
+		f.setAddr(i)
+		return nil
+	}
+	if err := f.value(i.Ops[0]); err != nil {
+		return err
+	}
+	f.load(t)
+	f.set(i)
+	return nil
+}
+
+func (f *funcGen) storeInst(i *ir.StoreInst) error {
+	val, ptr := i.Ops[0], i.Ops[1]
+	if err := f.push(ptr, val); err != nil {
+		return err
+	}
+	if types.IsAggregate(val.Type()) {
+		f.memoryCopy(f.dl.SizeOf(val.Type()))
+	} else {
+		f.store(val.Type())
+	}
+	return nil
+}
+
+func (f *funcGen) gep(i *ir.GetElementPtrInst) error {
+	if err := f.value(i.Ops[0]); err != nil {
+		return err
+	}
+	t := i.SourceElementType
+	var off int64
+	for n, idx := range i.Ops[1:] {
+		var stride int64
+		if n == 0 {
+			stride = f.dl.SizeOf(t)
+		} else {
+			switch ty := t.(type) {
+			case *types.StructType:
+				field := idx.(*ir.ConstantInt).Value
+				off += f.dl.FieldOffset(ty, int(field))
+				t = ty.Fields[field]
+				continue
+			case *types.ArrayType:
+				t = ty.ElementType
+			case *types.VectorType:
+				t = ty.ElementType
+			}
+			stride = f.dl.SizeOf(t)
+		}
+		if c, ok := idx.(*ir.ConstantInt); ok {
+			w := intWidth(c.Type())
+			off += signExtend(truncate(uint64(c.Value), w), w) * stride
+			continue
+		}
+		if err := f.extend(idx, true); err != nil {
+			return err
+		}
+		if vt, _ := f.valType(idx.Type()); vt == i64 {
+			f.code.byte(opI32WrapI64)
+		}
+		f.i32Const(stride)
+		f.code.byte(opI32Mul, opI32Add)
+	}
+	if off != 0 {
+		f.i32Const(off)
+		f.code.byte(opI32Add)
+	}
+	f.set(i)
+	return nil
+}
+
+// memberOffset returns the byte offset and type of the member of an
+// aggregate of type t selected by indices
+func (f *funcGen) memberOffset(t types.Type, indices []int) (int64, types.Type) {
+	var off int64
+	for _, idx := range indices {
+		switch ty := t.(type) {
+		case *types.StructType:
+			off += f.dl.FieldOffset(ty, idx)
+			t = ty.Fields[idx]
+		case *types.ArrayType:
+			t = ty.ElementType
+			off += int64(idx) * f.dl.SizeOf(t)
+		}
+	}
+	return off, t
+}
+
+func (f *funcGen) extractValue(i *ir.ExtractValueInst) error {
+	agg := i.Ops[0]
+	off, t := f.memberOffset(agg.Type(), i.Indices)
+	aggregate := types.IsAggregate(t)
+	if aggregate {
+		f.frameAddr(f.slots[i])
+	}
+	if err := f.value(agg); err != nil {
+		return err
+	}
+	if off != 0 {
+		f.i32Const(off)
+		f.code.byte(opI32Add)
+	}
+	if aggregate {
+		f.memoryCopy(f.dl.SizeOf(t))
+		f.setAddr(i)
+		return nil
+	}
+	f.load(t)
+	f.set(i)
+	return nil
+}
+
+func (f *funcGen) insertValue(i *ir.InsertValueInst) error {
+	agg, val := i.Ops[0], i.Ops[1]
+	f.frameAddr(f.slots[i])
+	if err := f.value(agg); err != nil {
+		return err
+	}
+	f.memoryCopy(f.dl.SizeOf(agg.Type()))
+	off, t := f.memberOffset(agg.Type(), i.Indices)
+	f.frameAddr(f.slots[i] + off)
+	if err := f.value(val); err != nil {
+		return err
+	}
+	if types.IsAggregate(t) {
+		f.memoryCopy(f.dl.SizeOf(t))
+	} else {
+		f.store(t)
+	}
+	f.setAddr(i)
+	return nil
+}
+
+// ============================================================================
+// Calls
+// ============================================================================
+
+func (f *funcGen) call(i *ir.CallInst) error {
+	callee := f.callee(i)
+	if callee == nil {
+		return f.errorf(i, "call to unknown function @%s", i.CalleeName)
+	}
+	var args []ir.Value
+	for _, a := range i.Ops {
+		if a != nil {
+			args = append(args, a)
+		}
+	}
+	fixed := len(callee.FuncType.ParamTypes)
+	if len(args) < fixed || len(args) > fixed && !callee.FuncType.Variadic {
+		return f.errorf(i, "wrong number of arguments")
+	}
+	// Narrow integers live in i32 locals with undefined upper bits, which
+	// the callee may be a host function relying on
+	for _, a := range args[:fixed] {
+		if err := f.extend(a, isSigned(a.Type())); err != nil {
+			return err
+		}
+	}
+	if callee.FuncType.Variadic {
+		for k, a := range args[fixed:] {
+			if types.IsAggregate(a.Type()) {
+				return f.errorf(i, "%s values cannot be passed", a.Type())
+			}
+			f.frameAddr(f.varargs + int64(k)*8)
+			if err := f.value(a); err != nil {
+				return err
+			}
+			f.store(a.Type())
+		}
+		f.frameAddr(f.varargs)
+	}
+	f.code.byte(opCall)
+	f.code.u32(f.funcs[callee.Name()])
+	if t := callee.FuncType.ReturnType; t.Kind() != types.VoidKind {
+		if _, ok := f.locals[i]; ok {
+			f.set(i)
+		} else {
+			f.code.byte(0x1a) // drop
+		}
+	}
+	return nil
+}
+
+// syscallInst calls the __syscall import with the number and six arguments
+// widened to i64
+func (f *funcGen) syscallInst(i *ir.SyscallInst) error {
+	if len(i.Ops) == 0 || len(i.Ops) > 7 {
+		return f.errorf(i, "syscall takes a number and up to 6 arguments")
+	}
+	for k := 0; k < 7; k++ {
+		if k >= len(i.Ops) {
+			f.constant(i64, 0)
+			continue
+		}
+		a := i.Ops[k]
+		signed := isSigned(a.Type())
+		if err := f.extend(a, signed); err != nil {
+			return err
+		}
+		if vt, _ := f.valType(a.Type()); vt == i32 {
+			if signed {
+				f.code.byte(opI64ExtendS)
+			} else {
+				f.code.byte(opI64ExtendU)
+			}
+		} else if vt != i64 {
+			return f.errorf(i, "syscall argument of type %s", a.Type())
+		}
+	}
+	f.code.byte(opCall)
+	f.code.u32(f.syscall)
+	if vt, _ := f.valType(i.Type()); vt == i32 {
+		f.code.byte(opI32WrapI64)
+	}
+	f.set(i)
+	return nil
+}
+
+// ============================================================================
+// Helpers
+// ============================================================================
+
+// intWidth returns the bit width of an integer type; pointers are 32 bits
+func intWidth(t types.Type) int {
+	if it, ok := t.(*types.IntType); ok {
+		return it.BitWidth
+	}
+	return 32
+}
+
+// isSigned reports whether values of t are sign-extended when widened.
+// Booleans are zero-extended.
+func isSigned(t types.Type) bool {
+	it, ok := t.(*types.IntType)
+	return ok && it.Signed && it.BitWidth > 1
+}
+
+func truncate(bits uint64, width int) uint64 {
+	if width >= 64 {
+		return bits
+	}
+	return bits & (1<<uint(width) - 1)
+}
+
+func signExtend(bits uint64, width int) int64 {
+	shift := uint(64 - width)
+	return int64(bits<<shift) >> shift
+}
@@ -0,0 +1,471 @@
+// Package wasm lowers IR modules to WebAssembly binary modules.
+//
+// Memory is a single linear memory with 32-bit addresses, so pointers are 4
+// bytes whatever the module's data layout says. Globals and the aggregate
+// constants used by code are placed in data segments from address 1024 up,
+// followed by a 64KiB shadow stack growing down from its top. Allocas and
+// aggregate values live in frames on the shadow stack; scalar values are
+// wasm locals. The control flow graph is turned back into blocks, loops and
+// ifs from its dominator tree, which requires it to be reducible.
+//
+// Declared functions are imported from the "env" module by name, as is
+// "__syscall", which receives the syscall number and six arguments as i64
+// and returns an i64. Variadic functions take a trailing i32 parameter
+// pointing to their variadic arguments, each stored in its own 8-byte slot.
+// The module exports its memory as "memory", the end of the stack as the
+// global "__heap_base" and every function with external linkage.
+//
+// Integers of 1, 8, 16, 32 and 64 bits, pointers, f32 and f64 are supported, as are
+// struct and array values in memory. Aggregate arguments and return values,
+// vectors, indirect calls and va_start/va_arg are not. frem is computed as
+// x - trunc(x/y)*y.
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Error reports an instruction or function the backend cannot lower
+type Error struct {
+	Func *ir.Function
+	Inst ir.Instruction
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Inst != nil {
+		return fmt.Sprintf("wasm: @%s: %s: %s", e.Func.Name(), e.Inst, e.Msg)
+	}
+	if e.Func != nil {
+		return fmt.Sprintf("wasm: @%s: %s", e.Func.Name(), e.Msg)
+	}
+	return "wasm: " + e.Msg
+}
+
+const (
+	dataBase  = 1024    // address of the first data segment
+	stackSize = 1 << 16 // size of the shadow stack
+	pageSize  = 1 << 16
+)
+
+// Globals of the generated module
+const (
+	stackPointer = 0
+	heapBase     = 1
+)
+
+// Generate writes the WebAssembly binary for m to w
+func Generate(w io.Writer, m *ir.Module) error {
+	spec := "p:32:32"
+	if m.DataLayout != "" {
+		spec = m.DataLayout + "-" + spec
+	}
+	dl, err := types.ParseDataLayout(spec)
+	if err != nil {
+		return &Error{Msg: err.Error()}
+	}
+	if dl.BigEndian {
+		return &Error{Msg: "big-endian data layouts are not supported"}
+	}
+
+	g := &gen{
+		m:       m,
+		dl:      dl,
+		types:   make(map[string]uint32),
+		funcs:   make(map[string]uint32),
+		globals: make(map[*ir.Global]int64),
+		pool:    make(map[ir.Constant]int64),
+		table:   make(map[*ir.Function]uint32),
+		end:     dataBase,
+	}
+	if err := g.declare(); err != nil {
+		return err
+	}
+
+	var bodies []*buffer
+	for _, f := range m.Functions {
+		if len(f.Blocks) == 0 {
+			continue
+		}
+		body, err := g.function(f)
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, body)
+	}
+	_, err = w.Write(g.module(bodies))
+	return err
+}
+
+type gen struct {
+	m  *ir.Module
+	dl *types.DataLayout
+
+	typeSec buffer
+	types   map[string]uint32 // signature to type index
+	ntypes  uint32
+
+	imports []importEntry
+	funcs   map[string]uint32 // function index by name
+	defined []uint32          // type index of each defined function
+	syscall uint32            // index of the __syscall import
+
+	globals map[*ir.Global]int64 // address of each global
+	pool    map[ir.Constant]int64
+	data    []segment
+	end     int64 // end of the data placed so far
+
+	table    map[*ir.Function]uint32 // table slot of functions used as values
+	elements []uint32
+}
+
+type importEntry struct {
+	name string
+	typ  uint32
+}
+
+type segment struct {
+	addr  int64
+	bytes []byte
+}
+
+// declare assigns indices to the functions and addresses to the globals
+func (g *gen) declare() error {
+	// Imports come first in the function index space
+	usesSyscall := false
+	for _, f := range g.m.Functions {
+		for _, b := range f.Blocks {
+			for _, inst := range b.Instructions {
+				if _, ok := inst.(*ir.SyscallInst); ok {
+					usesSyscall = true
+				}
+			}
+		}
+	}
+	if usesSyscall {
+		params := []byte{i64, i64, i64, i64, i64, i64, i64}
+		g.syscall = uint32(len(g.imports))
+		g.imports = append(g.imports, importEntry{"__syscall", g.typeIndex(params, []byte{i64})})
+	}
+	for _, f := range g.m.Functions {
+		if len(f.Blocks) > 0 {
+			continue
+		}
+		typ, err := g.signature(f)
+		if err != nil {
+			return err
+		}
+		g.funcs[f.Name()] = uint32(len(g.imports))
+		g.imports = append(g.imports, importEntry{f.Name(), typ})
+	}
+	for _, f := range g.m.Functions {
+		if len(f.Blocks) == 0 {
+			continue
+		}
+		typ, err := g.signature(f)
+		if err != nil {
+			return err
+		}
+		g.funcs[f.Name()] = uint32(len(g.imports) + len(g.defined))
+		g.defined = append(g.defined, typ)
+	}
+
+	for _, gv := range g.m.Globals {
+		t := gv.Type()
+		if pt, ok := t.(*types.PointerType); ok {
+			t = pt.ElementType
+		}
+		if gv.Initializer == nil {
+			// There is nothing to import a variable from, so declared
+			// globals get zeroed storage of their own
+			g.globals[gv] = g.place(g.dl.PrefAlignOf(t), make([]byte, g.dl.SizeOf(t)))
+			continue
+		}
+		bytes, err := g.bytes(gv.Initializer, t)
+		if err != nil {
+			return &Error{Msg: fmt.Sprintf("@%s: %v", gv.Name(), err)}
+		}
+		g.globals[gv] = g.place(g.dl.PrefAlignOf(t), bytes)
+	}
+	return nil
+}
+
+// signature returns the type index of a function
+func (g *gen) signature(f *ir.Function) (uint32, error) {
+	var params, results []byte
+	for i, t := range f.FuncType.ParamTypes {
+		vt, err := g.scalarType(t)
+		if err != nil {
+			return 0, &Error{Func: f, Msg: fmt.Sprintf("parameter %d: %v", i, err)}
+		}
+		params = append(params, vt)
+	}
+	if f.FuncType.Variadic {
+		params = append(params, i32)
+	}
+	if t := f.FuncType.ReturnType; t.Kind() != types.VoidKind {
+		vt, err := g.scalarType(t)
+		if err != nil {
+			return 0, &Error{Func: f, Msg: "return type: " + err.Error()}
+		}
+		results = append(results, vt)
+	}
+	return g.typeIndex(params, results), nil
+}
+
+func (g *gen) typeIndex(params, results []byte) uint32 {
+	key := string(params) + ":" + string(results)
+	if idx, ok := g.types[key]; ok {
+		return idx
+	}
+	g.typeSec.byte(0x60)
+	g.typeSec.u32(uint32(len(params)))
+	g.typeSec.byte(params...)
+	g.typeSec.u32(uint32(len(results)))
+	g.typeSec.byte(results...)
+	g.types[key] = g.ntypes
+	g.ntypes++
+	return g.types[key]
+}
+
+// valType returns the wasm type holding values of t. Aggregates are
+// represented by their address.
+func (g *gen) valType(t types.Type) (byte, error) {
+	switch t := t.(type) {
+	case *types.IntType:
+		switch t.BitWidth {
+		case 1, 8, 16, 32:
+			return i32, nil
+		case 64:
+			return i64, nil
+		}
+	case *types.FloatType:
+		switch t.BitWidth {
+		case 32:
+			return f32, nil
+		case 64:
+			return f64, nil
+		}
+	case *types.PointerType:
+		return i32, nil
+	case *types.ArrayType:
+		if _, err := g.valType(t.ElementType); err != nil {
+			return 0, err
+		}
+		return i32, nil
+	case *types.StructType:
+		for _, ft := range t.Fields {
+			if _, err := g.valType(ft); err != nil {
+				return 0, err
+			}
+		}
+		return i32, nil
+	}
+	return 0, fmt.Errorf("unsupported type %s", t)
+}
+
+// scalarType is valType for values passed to and returned from functions
+func (g *gen) scalarType(t types.Type) (byte, error) {
+	if types.IsAggregate(t) {
+		return 0, fmt.Errorf("%s values cannot be passed or returned", t)
+	}
+	return g.valType(t)
+}
+
+// place reserves memory for bytes and returns its address
+func (g *gen) place(align int64, bytes []byte) int64 {
+	addr := alignTo(g.end, align)
+	g.end = addr + int64(len(bytes))
+	g.data = append(g.data, segment{addr, bytes})
+	return addr
+}
+
+// constAddr returns the address of a copy of aggregate constant c
+func (g *gen) constAddr(c ir.Constant) (int64, error) {
+	if addr, ok := g.pool[c]; ok {
+		return addr, nil
+	}
+	bytes, err := g.bytes(c, c.Type())
+	if err != nil {
+		return 0, err
+	}
+	g.pool[c] = g.place(g.dl.AlignOf(c.Type()), bytes)
+	return g.pool[c], nil
+}
+
+// tableIndex returns the table slot standing for the address of f. Slot 0
+// is left empty so no function compares equal to null.
+func (g *gen) tableIndex(f *ir.Function) uint32 {
+	if idx, ok := g.table[f]; ok {
+		return idx
+	}
+	g.elements = append(g.elements, g.funcs[f.Name()])
+	g.table[f] = uint32(len(g.elements))
+	return g.table[f]
+}
+
+// bytes returns the memory image of constant c laid out as type t
+func (g *gen) bytes(c ir.Constant, t types.Type) ([]byte, error) {
+	out := make([]byte, g.dl.SizeOf(t))
+	if err := g.writeConst(out, c, t); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (g *gen) writeConst(out []byte, c ir.Constant, t types.Type) error {
+	switch c := c.(type) {
+	case *ir.ConstantInt:
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], uint64(c.Value))
+		copy(out, buf[:g.dl.StoreSize(t)])
+	case *ir.ConstantFloat:
+		if g.dl.StoreSize(t) == 4 {
+			binary.LittleEndian.PutUint32(out, math.Float32bits(float32(c.Value)))
+		} else {
+			binary.LittleEndian.PutUint64(out, math.Float64bits(c.Value))
+		}
+	case *ir.ConstantNull, *ir.ConstantZero, *ir.ConstantUndef:
+	case *ir.ConstantArray:
+		at, ok := t.(*types.ArrayType)
+		if !ok {
+			return fmt.Errorf("array constant of type %s", t)
+		}
+		size := g.dl.SizeOf(at.ElementType)
+		for i, e := range c.Elements {
+			if err := g.writeConst(out[int64(i)*size:], e, at.ElementType); err != nil {
+				return err
+			}
+		}
+	case *ir.ConstantStruct:
+		st, ok := t.(*types.StructType)
+		if !ok || len(st.Fields) != len(c.Fields) {
+			return fmt.Errorf("struct constant of type %s", t)
+		}
+		l := g.dl.StructLayout(st)
+		for i, f := range c.Fields {
+			if err := g.writeConst(out[l.Offsets[i]:], f, st.Fields[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported constant %s", c)
+	}
+	return nil
+}
+
+// module assembles the sections of the binary
+func (g *gen) module(bodies []*buffer) []byte {
+	stackTop := alignTo(g.end, 16) + stackSize
+	pages := (stackTop + pageSize - 1) / pageSize
+
+	var out buffer
+	out.byte(0x00, 'a', 's', 'm', 1, 0, 0, 0)
+	var sec buffer
+	sec.u32(g.ntypes)
+	sec.byte(g.typeSec.b...)
+	out.section(secType, &sec)
+
+	if len(g.imports) > 0 {
+		sec = buffer{}
+		sec.u32(uint32(len(g.imports)))
+		for _, imp := range g.imports {
+			sec.name("env")
+			sec.name(imp.name)
+			sec.byte(kindFunc)
+			sec.u32(imp.typ)
+		}
+		out.section(secImport, &sec)
+	}
+
+	sec = buffer{}
+	sec.u32(uint32(len(g.defined)))
+	for _, t := range g.defined {
+		sec.u32(t)
+	}
+	out.section(secFunction, &sec)
+
+	if len(g.elements) > 0 {
+		sec = buffer{}
+		sec.u32(1)
+		sec.byte(funcref, 0x00)
+		sec.u32(uint32(len(g.elements) + 1))
+		out.section(secTable, &sec)
+	}
+
+	sec = buffer{}
+	sec.u32(1)
+	sec.byte(0x00)
+	sec.u32(uint32(pages))
+	out.section(secMemory, &sec)
+
+	sec = buffer{}
+	sec.u32(2)
+	sec.byte(i32, 1)
+	sec.i32Const(stackTop)
+	sec.byte(i32, 0)
+	sec.i32Const(stackTop)
+	out.section(secGlobal, &sec)
+
+	sec = buffer{}
+	var exports buffer
+	n := 2
+	exports.name("memory")
+	exports.byte(kindMemory)
+	exports.u32(0)
+	exports.name("__heap_base")
+	exports.byte(kindGlobal)
+	exports.u32(heapBase)
+	for _, f := range g.m.Functions {
+		if len(f.Blocks) > 0 && f.Linkage == ir.ExternalLinkage {
+			exports.name(f.Name())
+			exports.byte(kindFunc)
+			exports.u32(g.funcs[f.Name()])
+			n++
+		}
+	}
+	sec.u32(uint32(n))
+	sec.byte(exports.b...)
+	out.section(secExport, &sec)
+
+	if len(g.elements) > 0 {
+		sec = buffer{}
+		sec.u32(1)
+		sec.u32(0)
+		sec.i32Const(1)
+		sec.u32(uint32(len(g.elements)))
+		for _, idx := range g.elements {
+			sec.u32(idx)
+		}
+		out.section(secElem, &sec)
+	}
+
+	sec = buffer{}
+	sec.u32(uint32(len(bodies)))
+	for _, body := range bodies {
+		sec.u32(uint32(len(body.b)))
+		sec.byte(body.b...)
+	}
+	out.section(secCode, &sec)
+
+	sec = buffer{}
+	sec.u32(uint32(len(g.data)))
+	for _, d := range g.data {
+		sec.u32(0)
+		sec.i32Const(d.addr)
+		sec.u32(uint32(len(d.bytes)))
+		sec.byte(d.bytes...)
+	}
+	out.section(secData, &sec)
+	return out.b
+}
+
+func alignTo(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
@@ -0,0 +1,94 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/codegen/internal/golden"
+)
+
+// TestGolden compares the binary for each example module in
+// ../../testdata with testdata/<name>.wasm
+func TestGolden(t *testing.T) {
+	golden.Run(t, ".wasm", Generate)
+}
+
+func TestHeader(t *testing.T) {
+	m, err := asm.Parse("empty", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Generate(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Bytes(); len(got) < 8 || !bytes.Equal(got[:8], []byte("\x00asm\x01\x00\x00\x00")) {
+		t.Errorf("module starts with % x, want the wasm magic and version 1", got)
+	}
+}
+
+func TestIrreducible(t *testing.T) {
+	// The loop between a and b has two entries
+	m, err := asm.Parse("irreducible", `
+define external i32 @f(i1 %c) {
+entry:
+  br i1 %c, label %a, label %b
+a:
+  br i1 %c, label %b, label %exit
+b:
+  br label %a
+exit:
+  ret i32 0
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	if err := Generate(&bytes.Buffer{}, m); !errors.As(err, &e) || e.Func == nil || e.Func.Name() != "f" {
+		t.Errorf("got error %v, want an *Error for @f", err)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	m, err := asm.Parse("vec", `
+define external <4 x i32> @vadd(<4 x i32> %a, <4 x i32> %b) {
+entry:
+  %s = add <4 x i32> %a, %b
+  ret <4 x i32> %s
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	if err := Generate(&bytes.Buffer{}, m); !errors.As(err, &e) || e.Func == nil || e.Func.Name() != "vadd" {
+		t.Errorf("got error %v, want an *Error for @vadd", err)
+	}
+}
+
+// TestRun runs the narrow example in node. Narrow integers must be
+// extended where they cross into the host, or the upper bits of their
+// locals leak out.
+func TestRun(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("no node")
+	}
+	script := `
+const m = new WebAssembly.Module(require("fs").readFileSync(process.argv[1]));
+const e = new WebAssembly.Instance(m, {}).exports;
+console.log(e.wrap(100, 35), e.wrap(-100, 0), e.twice(200), e.widen(100));
+`
+	out, err := exec.Command(node, "-e", script, filepath.Join("testdata", "narrow.wasm")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	if got, want := string(out), "79 -44 144 45\n"; got != want {
+		t.Errorf("node printed %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,186 @@
+// Package driver turns IR modules into programs that run without a C
+// library.
+//
+// Executable compiles a module that defines main to x86-64 code and links
+// it, together with a generated entry point, into a static Linux ELF
+// executable. The entry point _start hands the initial stack pointer to a
+// start routine built in IR, which reads argc, argv and envp from the
+// stack, calls main and ends the process with the exit_group syscall,
+// passing main's result as the exit status. Programs talk to the system
+// through Builder.CreateSyscall; calls to functions the module does not
+// define are link errors.
+package driver
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/codegen/amd64"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/obj/elf"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Error reports a module that cannot become an executable
+type Error struct {
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return "driver: " + e.Msg
+}
+
+// Linux x86-64 syscall numbers
+const sysExitGroup = 231
+
+// startName is the start routine called by _start
+const startName = "__arc_start"
+
+// entry passes the stack pointer at process entry, where argc, the argv
+// pointers and the envp pointers are stored, to the start routine
+const entry = `
+	.text
+	.globl _start
+	.p2align 4
+	.type _start,@function
+_start:
+	xorl %ebp, %ebp
+	movq %rsp, %rdi
+	andq $-16, %rsp
+	call ` + startName + `
+	ud2
+	.size _start,.-_start
+`
+
+// Executable writes a static executable running m's main function to w.
+// main takes no parameters, (argc, argv) or (argc, argv, envp) with an
+// integer argc and pointer argv and envp, and returns void or an integer.
+func Executable(w io.Writer, m *ir.Module) error {
+	main := m.GetFunction("main")
+	if main == nil || len(main.Blocks) == 0 {
+		return &Error{Msg: "the module does not define main"}
+	}
+	if err := checkMain(main); err != nil {
+		return err
+	}
+	prog, err := elf.Compile(m)
+	if err != nil {
+		return err
+	}
+	start, err := startObject(m, main)
+	if err != nil {
+		return err
+	}
+	return elf.Link(w, "_start", prog, start)
+}
+
+// WriteExecutable writes a static executable running m's main function to
+// the named file and makes it executable
+func WriteExecutable(path string, m *ir.Module) error {
+	var buf bytes.Buffer
+	if err := Executable(&buf, m); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0755); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(path, 0755)
+}
+
+func checkMain(main *ir.Function) error {
+	ft := main.FuncType
+	if main.Linkage != ir.ExternalLinkage {
+		return &Error{Msg: "main must have external linkage"}
+	}
+	if ft.Variadic {
+		return &Error{Msg: "main must not be variadic"}
+	}
+	switch t := ft.ReturnType.(type) {
+	case *types.VoidType:
+	case *types.IntType:
+		if t.BitWidth > 64 {
+			return &Error{Msg: "main returns " + t.String()}
+		}
+	default:
+		return &Error{Msg: "main returns " + t.String()}
+	}
+	switch len(ft.ParamTypes) {
+	case 0, 2, 3:
+	default:
+		return &Error{Msg: "main takes no parameters, (argc, argv) or (argc, argv, envp)"}
+	}
+	for i, t := range ft.ParamTypes {
+		_, isInt := t.(*types.IntType)
+		_, isPtr := t.(*types.PointerType)
+		if i == 0 && !isInt || i > 0 && !isPtr {
+			return &Error{Msg: "main takes no parameters, (argc, argv) or (argc, argv, envp)"}
+		}
+	}
+	return nil
+}
+
+// startObject builds the start routine for main and assembles it with the
+// entry point
+func startObject(m *ir.Module, main *ir.Function) (*elf.File, error) {
+	b := builder.New()
+	sm := b.CreateModule("start")
+	sm.DataLayout, sm.TargetTriple = m.DataLayout, m.TargetTriple
+	ft := main.FuncType
+	callee := b.DeclareFunction("main", ft.ReturnType, ft.ParamTypes, false)
+
+	slots := types.NewPointer(types.I64)
+	fn := b.CreateFunction(startName, types.Void, []types.Type{slots}, false)
+	fn.Linkage = ir.InternalLinkage
+	sp := fn.Arguments[0]
+	sp.SetName("sp")
+	b.SetInsertPoint(b.CreateBlock("entry"))
+
+	// The stack holds argc, the argv pointers, a null pointer and the envp
+	// pointers
+	argc := b.CreateLoad(types.I64, sp, "argc")
+	argv := b.CreateGEP(types.I64, sp, []ir.Value{b.ConstInt(types.I64, 1)}, "argv")
+	envIndex := b.CreateAdd(argc, b.ConstInt(types.I64, 2), "env.index")
+	envp := b.CreateGEP(types.I64, sp, []ir.Value{envIndex}, "envp")
+
+	var args []ir.Value
+	for i, t := range ft.ParamTypes {
+		switch i {
+		case 0:
+			if it := t.(*types.IntType); it.BitWidth < 64 {
+				args = append(args, b.CreateTrunc(argc, t, "argc.arg"))
+			} else {
+				args = append(args, argc)
+			}
+		case 1:
+			args = append(args, b.CreateBitCast(argv, t, "argv.arg"))
+		case 2:
+			args = append(args, b.CreateBitCast(envp, t, "envp.arg"))
+		}
+	}
+	var status ir.Value = b.ConstInt(types.I64, 0)
+	call := b.CreateCall(callee, args, "")
+	switch t := ft.ReturnType.(type) {
+	case *types.IntType:
+		status = call
+		switch {
+		case t.BitWidth == 64:
+		case t.Signed && t.BitWidth > 1:
+			status = b.CreateSExt(call, types.I64, "status")
+		default:
+			status = b.CreateZExt(call, types.I64, "status")
+		}
+	}
+	b.CreateSyscall([]ir.Value{b.ConstInt(types.I64, sysExitGroup), status})
+	b.CreateUnreachable()
+
+	var text strings.Builder
+	if err := amd64.Generate(&text, sm); err != nil {
+		return nil, err
+	}
+	text.WriteString(entry)
+	return elf.Assemble(strings.NewReader(text.String()))
+}
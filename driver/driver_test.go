@@ -0,0 +1,148 @@
+package driver
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// hello writes "hi\n" and exits with factorial(argc + 3)
+const hello = `
+@msg = private constant [3 x i8] [i8 104, i8 105, i8 10]
+
+define internal i32 @factorial(i32 %n) {
+entry:
+  %cmp = icmp sle i32 %n, 1
+  br i1 %cmp, label %then, label %else
+then:
+  ret i32 1
+else:
+  %sub = sub i32 %n, 1
+  %call = call i32 @factorial(i32 %sub)
+  %mul = mul i32 %n, %call
+  ret i32 %mul
+}
+
+define external i32 @main(i32 %argc, ptr<ptr<i8>> %argv) {
+entry:
+  %written = syscall i64 1, i64 1, ptr<[3 x i8]> %msg, i64 3
+  %n = add i32 %argc, 3
+  %code = call i32 @factorial(i32 %n)
+  ret i32 %code
+}
+`
+
+func parse(t *testing.T, name, src string) *ir.Module {
+	t.Helper()
+	m, err := asm.Parse(name, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestExecutableHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Executable(&buf, parse(t, "hello", hello)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != elf.ET_EXEC || f.Machine != elf.EM_X86_64 {
+		t.Errorf("file is %v for %v, want an x86-64 executable", f.Type, f.Machine)
+	}
+	var load int
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			load++
+			if p.Flags&elf.PF_W != 0 && p.Flags&elf.PF_X != 0 {
+				t.Errorf("segment at %#x is writable and executable", p.Vaddr)
+			}
+		}
+		if p.Type == elf.PT_INTERP || p.Type == elf.PT_DYNAMIC {
+			t.Errorf("static executable has a %v segment", p.Type)
+		}
+	}
+	if load == 0 {
+		t.Error("no loadable segments")
+	}
+}
+
+// run writes m as an executable, runs it with args and returns its output
+// and exit status
+func run(t *testing.T, m *ir.Module, args ...string) (string, int) {
+	t.Helper()
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs linux/amd64")
+	}
+	path := filepath.Join(t.TempDir(), m.Name)
+	if err := WriteExecutable(path, m); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = &out
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatal(err)
+	}
+	return out.String(), cmd.ProcessState.ExitCode()
+}
+
+func TestRun(t *testing.T) {
+	out, code := run(t, parse(t, "hello", hello), "a")
+	if out != "hi\n" || code != 120 {
+		t.Errorf("program printed %q and exited with %d, want \"hi\\n\" and 120", out, code)
+	}
+}
+
+func TestRunExample(t *testing.T) {
+	src, err := os.ReadFile("../testdata/global_array.ll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, code := run(t, parse(t, "global_array", string(src))); code != 42 {
+		t.Errorf("program exited with %d, want 42", code)
+	}
+}
+
+func TestInvalidMain(t *testing.T) {
+	tests := []struct {
+		src string
+		msg string
+	}{
+		{"define external i32 @other() {\nentry:\n  ret i32 0\n}\n", "does not define main"},
+		{"declare external i32 @main()\n", "does not define main"},
+		{"define internal i32 @main() {\nentry:\n  ret i32 0\n}\n", "external linkage"},
+		{"define external f64 @main() {\nentry:\n  ret f64 0.0\n}\n", "main returns f64"},
+		{"define external i32 @main(i32 %argc) {\nentry:\n  ret i32 0\n}\n", "main takes no parameters"},
+		{"define external i32 @main(ptr<i8> %a, ptr<i8> %b) {\nentry:\n  ret i32 0\n}\n", "main takes no parameters"},
+	}
+	for _, tt := range tests {
+		err := Executable(&bytes.Buffer{}, parse(t, "bad", tt.src))
+		var e *Error
+		if !errors.As(err, &e) || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%q: got error %v, want an *Error mentioning %q", tt.src, err, tt.msg)
+		}
+	}
+}
+
+func TestUndefinedFunction(t *testing.T) {
+	src := "declare external i32 @missing()\n\ndefine external i32 @main() {\nentry:\n  %r = call i32 @missing()\n  ret i32 %r\n}\n"
+	err := Executable(&bytes.Buffer{}, parse(t, "undefined", src))
+	if err == nil || !strings.Contains(err.Error(), "undefined symbol missing") {
+		t.Errorf("got error %v, want an undefined symbol error", err)
+	}
+}
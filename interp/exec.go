@@ -0,0 +1,592 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// frame holds the state of one function activation
+type frame struct {
+	fn      *ir.Function
+	values  map[ir.Value]Value
+	allocas []uint64
+	varargs []Value
+}
+
+// call dispatches to a defined function or to a registered callback
+func (in *Interpreter) call(f *ir.Function, name string, args []Value) (Value, error) {
+	if f == nil || len(f.Blocks) == 0 {
+		ext, ok := in.externals[name]
+		if !ok {
+			return Value{}, fmt.Errorf("call to undefined function @%s", name)
+		}
+		res, err := ext(in, args)
+		if err != nil {
+			return Value{}, fmt.Errorf("@%s: %v", name, err)
+		}
+		if f != nil && f.FuncType.ReturnType.Kind() != types.VoidKind {
+			return coerce(res, f.FuncType.ReturnType)
+		}
+		return res, nil
+	}
+
+	fr := &frame{fn: f, values: make(map[ir.Value]Value)}
+	for i, a := range f.Arguments {
+		fr.values[a] = args[i]
+	}
+	fr.varargs = args[len(f.Arguments):]
+	defer func() {
+		for _, addr := range fr.allocas {
+			in.mem.free(addr)
+		}
+	}()
+
+	var prev *ir.BasicBlock
+	block := f.EntryBlock()
+	for {
+		next, ret, done, err := in.runBlock(fr, prev, block)
+		if err != nil {
+			return Value{}, err
+		}
+		if done {
+			return ret, nil
+		}
+		prev, block = block, next
+	}
+}
+
+// runBlock executes block, entered from prev. It returns the successor to
+// continue with, or the return value once the function returns.
+func (in *Interpreter) runBlock(fr *frame, prev, block *ir.BasicBlock) (next *ir.BasicBlock, ret Value, done bool, err error) {
+	fail := func(inst ir.Instruction, err error) error {
+		var e *Error
+		if errors.As(err, &e) {
+			return err
+		}
+		return &Error{Func: fr.fn, Inst: inst, Msg: err.Error()}
+	}
+
+	// Phis read their operands simultaneously on block entry
+	idx := 0
+	var phiVals []Value
+	for ; idx < len(block.Instructions); idx++ {
+		phi, ok := block.Instructions[idx].(*ir.PhiInst)
+		if !ok {
+			break
+		}
+		v, err := in.phi(fr, phi, prev)
+		if err != nil {
+			return nil, Value{}, false, fail(phi, err)
+		}
+		phiVals = append(phiVals, v)
+	}
+	for i, v := range phiVals {
+		fr.values[block.Instructions[i]] = v
+	}
+
+	for ; idx < len(block.Instructions); idx++ {
+		inst := block.Instructions[idx]
+		switch i := inst.(type) {
+		case *ir.RetInst:
+			if len(i.Ops) == 0 || i.Ops[0] == nil {
+				return nil, Value{Type: types.Void}, true, nil
+			}
+			v, err := in.operand(fr, i.Ops[0])
+			if err != nil {
+				return nil, Value{}, false, fail(inst, err)
+			}
+			return nil, v, true, nil
+		case *ir.BrInst:
+			return i.Target, Value{}, false, nil
+		case *ir.CondBrInst:
+			c, err := in.operand(fr, i.Condition)
+			if err != nil {
+				return nil, Value{}, false, fail(inst, err)
+			}
+			if c.bits&1 != 0 {
+				return i.TrueBlock, Value{}, false, nil
+			}
+			return i.FalseBlock, Value{}, false, nil
+		case *ir.SwitchInst:
+			c, err := in.operand(fr, i.Condition)
+			if err != nil {
+				return nil, Value{}, false, fail(inst, err)
+			}
+			for _, sc := range i.Cases {
+				if truncate(uint64(sc.Value.Value), intWidth(c.Type)) == c.bits {
+					return sc.Block, Value{}, false, nil
+				}
+			}
+			return i.DefaultBlock, Value{}, false, nil
+		case *ir.UnreachableInst:
+			return nil, Value{}, false, fail(inst, fmt.Errorf("reached unreachable"))
+		}
+		v, err := in.exec(fr, inst)
+		if err != nil {
+			return nil, Value{}, false, fail(inst, err)
+		}
+		fr.values[inst] = v
+	}
+	return nil, Value{}, false, &Error{Func: fr.fn, Msg: fmt.Sprintf("block %%%s has no terminator", block.Name())}
+}
+
+func (in *Interpreter) phi(fr *frame, phi *ir.PhiInst, prev *ir.BasicBlock) (Value, error) {
+	for _, inc := range phi.Incoming {
+		if inc.Block == prev {
+			return in.operand(fr, inc.Value)
+		}
+	}
+	if prev == nil {
+		return Value{}, fmt.Errorf("phi in entry block")
+	}
+	return Value{}, fmt.Errorf("no incoming value for %%%s", prev.Name())
+}
+
+// operand evaluates an instruction operand
+func (in *Interpreter) operand(fr *frame, v ir.Value) (Value, error) {
+	switch val := v.(type) {
+	case nil:
+		return Value{}, fmt.Errorf("missing operand")
+	case *ir.Global:
+		return Value{Type: val.Type(), bits: in.globals[val]}, nil
+	case *ir.Function:
+		return Value{Type: types.NewPointer(val.FuncType), bits: in.funcAddrs[val]}, nil
+	case ir.Constant:
+		return in.constant(val)
+	}
+	if r, ok := fr.values[v]; ok {
+		return r, nil
+	}
+	return Value{}, fmt.Errorf("use of %%%s before its definition", v.Name())
+}
+
+func (in *Interpreter) constant(c ir.Constant) (Value, error) {
+	t := c.Type()
+	switch k := c.(type) {
+	case *ir.ConstantInt:
+		if intWidth(t) > 64 {
+			return Value{}, fmt.Errorf("unsupported integer type %s", t)
+		}
+		return Value{Type: t, bits: truncate(uint64(k.Value), intWidth(t))}, nil
+	case *ir.ConstantFloat:
+		return Value{Type: t, float: roundFloat(k.Value, t)}, nil
+	case *ir.ConstantNull:
+		return Value{Type: t}, nil
+	case *ir.ConstantUndef, *ir.ConstantZero:
+		return zeroValue(t), nil
+	case *ir.ConstantArray:
+		return in.constants(t, k.Elements)
+	case *ir.ConstantStruct:
+		return in.constants(t, k.Fields)
+	}
+	return Value{}, fmt.Errorf("unsupported constant %s", c)
+}
+
+func (in *Interpreter) constants(t types.Type, list []ir.Constant) (Value, error) {
+	v := Value{Type: t, elems: make([]Value, len(list))}
+	for i, c := range list {
+		e, err := in.constant(c)
+		if err != nil {
+			return Value{}, err
+		}
+		v.elems[i] = e
+	}
+	return v, nil
+}
+
+// exec evaluates a non-terminator instruction
+func (in *Interpreter) exec(fr *frame, inst ir.Instruction) (Value, error) {
+	ops := make([]Value, 0, len(inst.Operands()))
+	for _, op := range inst.Operands() {
+		if op == nil {
+			continue
+		}
+		v, err := in.operand(fr, op)
+		if err != nil {
+			return Value{}, err
+		}
+		ops = append(ops, v)
+	}
+
+	switch i := inst.(type) {
+	case *ir.BinaryInst:
+		return arith(i.Op, i.Type(), ops[0], ops[1])
+	case *ir.CastInst:
+		return cast(i.Op, ops[0], i.DestType)
+	case *ir.ICmpInst:
+		return compare(ops[0], ops[1], func(a, b Value) (bool, error) { return icmp(i.Predicate, a, b) }, i.Type())
+	case *ir.FCmpInst:
+		return compare(ops[0], ops[1], func(a, b Value) (bool, error) { return fcmp(i.Predicate, a, b), nil }, i.Type())
+	case *ir.SelectInst:
+		if ops[0].bits&1 != 0 {
+			return ops[1], nil
+		}
+		return ops[2], nil
+	case *ir.AllocaInst:
+		n := uint64(1)
+		if i.NumElements != nil {
+			c, err := in.operand(fr, i.NumElements)
+			if err != nil {
+				return Value{}, err
+			}
+			n = c.bits
+		}
+		addr := in.mem.alloc(sizeOf(i.AllocatedType) * n)
+		fr.allocas = append(fr.allocas, addr)
+		return Value{Type: i.Type(), bits: addr}, nil
+	case *ir.LoadInst:
+		return in.mem.load(ops[0].bits, i.Type())
+	case *ir.StoreInst:
+		return Value{Type: types.Void}, in.mem.store(ops[1].bits, i.Ops[0].Type(), ops[0])
+	case *ir.GetElementPtrInst:
+		addr, err := gep(i.SourceElementType, ops[0].bits, ops[1:])
+		return Value{Type: i.Type(), bits: addr}, err
+	case *ir.CallInst:
+		name := i.CalleeName
+		f := i.Callee
+		if f != nil {
+			name = f.Name()
+		} else {
+			f = in.Module.GetFunction(name)
+		}
+		if f != nil && len(ops) < len(f.Arguments) {
+			return Value{}, fmt.Errorf("too few arguments to @%s", name)
+		}
+		res, err := in.call(f, name, ops)
+		if err != nil || i.Type() == nil || i.Type().Kind() == types.VoidKind {
+			return Value{Type: types.Void}, err
+		}
+		return res, nil
+	case *ir.SyscallInst:
+		if in.Syscall == nil {
+			return Value{}, fmt.Errorf("no syscall handler")
+		}
+		args := make([]uint64, len(ops))
+		for j, a := range ops {
+			args[j] = a.bits
+		}
+		r, err := in.Syscall(args)
+		return Value{Type: i.Type(), bits: truncate(r, intWidth(i.Type()))}, err
+	case *ir.ExtractValueInst:
+		v := ops[0]
+		for _, idx := range i.Indices {
+			if idx < 0 || idx >= len(v.elems) {
+				return Value{}, fmt.Errorf("index %d out of range", idx)
+			}
+			v = v.elems[idx]
+		}
+		return v, nil
+	case *ir.InsertValueInst:
+		return insert(ops[0], i.Indices, ops[1])
+	case *ir.VaStartInst:
+		return Value{Type: types.Void}, in.vaStart(fr, ops[0].bits)
+	case *ir.VaArgInst:
+		return in.vaArg(ops[0].bits, i.ArgType)
+	case *ir.VaEndInst:
+		return Value{Type: types.Void}, nil
+	}
+	return Value{}, fmt.Errorf("unsupported instruction %s", inst.Opcode())
+}
+
+// ============================================================================
+// Arithmetic
+// ============================================================================
+
+func arith(op ir.Opcode, t types.Type, a, b Value) (Value, error) {
+	if vt, ok := t.(*types.VectorType); ok {
+		out := Value{Type: t, elems: make([]Value, len(a.elems))}
+		for i := range a.elems {
+			v, err := arith(op, vt.ElementType, a.elems[i], b.elems[i])
+			if err != nil {
+				return Value{}, err
+			}
+			out.elems[i] = v
+		}
+		return out, nil
+	}
+
+	switch op {
+	case ir.OpFAdd:
+		return Value{Type: t, float: roundFloat(a.float+b.float, t)}, nil
+	case ir.OpFSub:
+		return Value{Type: t, float: roundFloat(a.float-b.float, t)}, nil
+	case ir.OpFMul:
+		return Value{Type: t, float: roundFloat(a.float*b.float, t)}, nil
+	case ir.OpFDiv:
+		return Value{Type: t, float: roundFloat(a.float/b.float, t)}, nil
+	case ir.OpFRem:
+		return Value{Type: t, float: roundFloat(math.Mod(a.float, b.float), t)}, nil
+	}
+
+	w := intWidth(t)
+	if w > 64 {
+		return Value{}, fmt.Errorf("unsupported integer type %s", t)
+	}
+	x, y := a.bits, b.bits
+	sx, sy := signExtend(x, w), signExtend(y, w)
+	var r uint64
+	switch op {
+	case ir.OpAdd:
+		r = x + y
+	case ir.OpSub:
+		r = x - y
+	case ir.OpMul:
+		r = x * y
+	case ir.OpUDiv, ir.OpURem, ir.OpSDiv, ir.OpSRem:
+		if y == 0 {
+			return Value{}, fmt.Errorf("division by zero")
+		}
+		if (op == ir.OpSDiv || op == ir.OpSRem) && sy == -1 && sx == signExtend(1<<uint(w-1), w) {
+			return Value{}, fmt.Errorf("signed division overflow")
+		}
+		switch op {
+		case ir.OpUDiv:
+			r = x / y
+		case ir.OpURem:
+			r = x % y
+		case ir.OpSDiv:
+			r = uint64(sx / sy)
+		case ir.OpSRem:
+			r = uint64(sx % sy)
+		}
+	case ir.OpShl, ir.OpLShr, ir.OpAShr:
+		if y >= uint64(w) {
+			return Value{}, fmt.Errorf("shift amount %d out of range for %s", y, t)
+		}
+		switch op {
+		case ir.OpShl:
+			r = x << y
+		case ir.OpLShr:
+			r = x >> y
+		case ir.OpAShr:
+			r = uint64(sx >> y)
+		}
+	case ir.OpAnd:
+		r = x & y
+	case ir.OpOr:
+		r = x | y
+	case ir.OpXor:
+		r = x ^ y
+	default:
+		return Value{}, fmt.Errorf("unsupported binary operation %s", op)
+	}
+	return Value{Type: t, bits: truncate(r, w)}, nil
+}
+
+func cast(op ir.Opcode, v Value, dest types.Type) (Value, error) {
+	src := v.Type
+	dw := intWidth(dest)
+	if types.IsInteger(dest) && dw > 64 {
+		return Value{}, fmt.Errorf("unsupported integer type %s", dest)
+	}
+	switch op {
+	case ir.OpTrunc, ir.OpZExt, ir.OpPtrToInt, ir.OpIntToPtr:
+		return Value{Type: dest, bits: truncate(v.bits, dw)}, nil
+	case ir.OpSExt:
+		return Value{Type: dest, bits: truncate(uint64(signExtend(v.bits, intWidth(src))), dw)}, nil
+	case ir.OpFPTrunc, ir.OpFPExt:
+		return Value{Type: dest, float: roundFloat(v.float, dest)}, nil
+	case ir.OpFPToUI:
+		return Value{Type: dest, bits: truncate(uint64(v.float), dw)}, nil
+	case ir.OpFPToSI:
+		return Value{Type: dest, bits: truncate(uint64(int64(v.float)), dw)}, nil
+	case ir.OpUIToFP:
+		return Value{Type: dest, float: roundFloat(float64(v.bits), dest)}, nil
+	case ir.OpSIToFP:
+		return Value{Type: dest, float: roundFloat(float64(signExtend(v.bits, intWidth(src))), dest)}, nil
+	case ir.OpBitcast:
+		switch {
+		case types.IsFloat(src) && !types.IsFloat(dest):
+			return Value{Type: dest, bits: truncate(floatBits(v.float, src), dw)}, nil
+		case !types.IsFloat(src) && types.IsFloat(dest):
+			return Value{Type: dest, float: bitsFloat(v.bits, dest)}, nil
+		}
+		v.Type = dest
+		return v, nil
+	}
+	return Value{}, fmt.Errorf("unsupported cast %s", op)
+}
+
+// compare applies a scalar comparison, elementwise for vectors
+func compare(a, b Value, cmp func(a, b Value) (bool, error), t types.Type) (Value, error) {
+	if vt, ok := t.(*types.VectorType); ok {
+		out := Value{Type: t, elems: make([]Value, len(a.elems))}
+		for i := range a.elems {
+			v, err := compare(a.elems[i], b.elems[i], cmp, vt.ElementType)
+			if err != nil {
+				return Value{}, err
+			}
+			out.elems[i] = v
+		}
+		return out, nil
+	}
+	r, err := cmp(a, b)
+	if err != nil {
+		return Value{}, err
+	}
+	if r {
+		return Value{Type: t, bits: 1}, nil
+	}
+	return Value{Type: t}, nil
+}
+
+func icmp(p ir.ICmpPredicate, a, b Value) (bool, error) {
+	w := intWidth(a.Type)
+	x, y := a.bits, b.bits
+	sx, sy := signExtend(x, w), signExtend(y, w)
+	switch p {
+	case ir.ICmpEQ:
+		return x == y, nil
+	case ir.ICmpNE:
+		return x != y, nil
+	case ir.ICmpUGT:
+		return x > y, nil
+	case ir.ICmpUGE:
+		return x >= y, nil
+	case ir.ICmpULT:
+		return x < y, nil
+	case ir.ICmpULE:
+		return x <= y, nil
+	case ir.ICmpSGT:
+		return sx > sy, nil
+	case ir.ICmpSGE:
+		return sx >= sy, nil
+	case ir.ICmpSLT:
+		return sx < sy, nil
+	case ir.ICmpSLE:
+		return sx <= sy, nil
+	}
+	return false, fmt.Errorf("unknown icmp predicate %d", p)
+}
+
+func fcmp(p ir.FCmpPredicate, a, b Value) bool {
+	x, y := a.float, b.float
+	uno := math.IsNaN(x) || math.IsNaN(y)
+	switch p {
+	case ir.FCmpFalse:
+		return false
+	case ir.FCmpOEQ:
+		return !uno && x == y
+	case ir.FCmpOGT:
+		return !uno && x > y
+	case ir.FCmpOGE:
+		return !uno && x >= y
+	case ir.FCmpOLT:
+		return !uno && x < y
+	case ir.FCmpOLE:
+		return !uno && x <= y
+	case ir.FCmpONE:
+		return !uno && x != y
+	case ir.FCmpORD:
+		return !uno
+	case ir.FCmpUNO:
+		return uno
+	case ir.FCmpUEQ:
+		return uno || x == y
+	case ir.FCmpUGT:
+		return uno || x > y
+	case ir.FCmpUGE:
+		return uno || x >= y
+	case ir.FCmpULT:
+		return uno || x < y
+	case ir.FCmpULE:
+		return uno || x <= y
+	case ir.FCmpUNE:
+		return uno || x != y
+	}
+	return true
+}
+
+// ============================================================================
+// Memory and aggregates
+// ============================================================================
+
+// gep computes the address selected by indices into a value of type t at
+// base. The first index steps over whole values of type t.
+func gep(t types.Type, base uint64, indices []Value) (uint64, error) {
+	addr := base
+	for n, idx := range indices {
+		i := signExtend(idx.bits, intWidth(idx.Type))
+		if n == 0 {
+			addr += uint64(i) * sizeOf(t)
+			continue
+		}
+		switch ty := t.(type) {
+		case *types.StructType:
+			if i < 0 || int(i) >= len(ty.Fields) {
+				return 0, fmt.Errorf("field index %d out of range for %s", i, t)
+			}
+			addr += elementOffset(t, int(i))
+			t = ty.Fields[i]
+		case *types.ArrayType:
+			addr += uint64(i) * sizeOf(ty.ElementType)
+			t = ty.ElementType
+		case *types.VectorType:
+			addr += uint64(i) * sizeOf(ty.ElementType)
+			t = ty.ElementType
+		default:
+			return 0, fmt.Errorf("cannot index into %s", t)
+		}
+	}
+	return addr, nil
+}
+
+// insert returns a copy of agg with the element at path replaced by v
+func insert(agg Value, path []int, v Value) (Value, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	idx := path[0]
+	if idx < 0 || idx >= len(agg.elems) {
+		return Value{}, fmt.Errorf("index %d out of range", idx)
+	}
+	elem, err := insert(agg.elems[idx], path[1:], v)
+	if err != nil {
+		return Value{}, err
+	}
+	out := agg
+	out.elems = append([]Value(nil), agg.elems...)
+	out.elems[idx] = elem
+	return out, nil
+}
+
+// vaStart stores in the va_list at addr a pointer to a buffer holding the
+// variadic arguments in 8-byte slots
+func (in *Interpreter) vaStart(fr *frame, addr uint64) error {
+	buf := in.mem.alloc(8 * uint64(len(fr.varargs)))
+	fr.allocas = append(fr.allocas, buf)
+	for i, a := range fr.varargs {
+		bits := a.bits
+		if a.Type != nil && types.IsFloat(a.Type) {
+			bits = math.Float64bits(a.float)
+		}
+		if err := in.mem.store(buf+8*uint64(i), types.I64, Value{bits: bits}); err != nil {
+			return err
+		}
+	}
+	return in.mem.store(addr, types.NewPointer(types.I8), Value{bits: buf})
+}
+
+// vaArg reads the next variadic argument as type t and advances the va_list
+func (in *Interpreter) vaArg(addr uint64, t types.Type) (Value, error) {
+	ptrTy := types.NewPointer(types.I8)
+	cur, err := in.mem.load(addr, ptrTy)
+	if err != nil {
+		return Value{}, err
+	}
+	slot, err := in.mem.load(cur.bits, types.I64)
+	if err != nil {
+		return Value{}, fmt.Errorf("va_arg past the last argument")
+	}
+	if err := in.mem.store(addr, ptrTy, Value{bits: cur.bits + 8}); err != nil {
+		return Value{}, err
+	}
+	if types.IsFloat(t) {
+		return Value{Type: t, float: roundFloat(math.Float64frombits(slot.bits), t)}, nil
+	}
+	return Value{Type: t, bits: truncate(slot.bits, intWidth(t))}, nil
+}
@@ -0,0 +1,182 @@
+// Package interp is a reference interpreter for IR modules.
+//
+// It executes functions directly from their IR so that frontend output
+// can be tested without a native toolchain. Integers of up to 64 bits wrap
+// at their type's width, f32 arithmetic is rounded to single precision and
+// memory is simulated with bounds-checked blocks. Functions that are only
+// declared in the module are dispatched to Go callbacks registered with
+// Register.
+package interp
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Func is a Go implementation of a declared function
+type Func func(in *Interpreter, args []Value) (Value, error)
+
+// Interpreter executes the functions of a module
+type Interpreter struct {
+	Module *ir.Module
+
+	// Syscall handles syscall instructions; the first argument is the
+	// system call number. Executing a syscall with no handler is an error.
+	Syscall func(args []uint64) (uint64, error)
+
+	mem       *memory
+	globals   map[*ir.Global]uint64
+	funcAddrs map[*ir.Function]uint64
+	externals map[string]Func
+}
+
+// Error describes a failure while executing an instruction
+type Error struct {
+	Func *ir.Function
+	Inst ir.Instruction
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Inst != nil {
+		return fmt.Sprintf("interp: @%s: %s: %s", e.Func.Name(), e.Inst, e.Msg)
+	}
+	return fmt.Sprintf("interp: @%s: %s", e.Func.Name(), e.Msg)
+}
+
+// New creates an interpreter for m, allocating and initializing its globals
+func New(m *ir.Module) (*Interpreter, error) {
+	in := &Interpreter{
+		Module:    m,
+		mem:       newMemory(),
+		globals:   make(map[*ir.Global]uint64),
+		funcAddrs: make(map[*ir.Function]uint64),
+		externals: make(map[string]Func),
+	}
+	for _, g := range m.Globals {
+		in.globals[g] = in.mem.alloc(sizeOf(globalType(g)))
+	}
+	for _, g := range m.Globals {
+		if g.Initializer == nil {
+			continue
+		}
+		v, err := in.constant(g.Initializer)
+		if err != nil {
+			return nil, fmt.Errorf("interp: global @%s: %v", g.Name(), err)
+		}
+		if err := in.mem.store(in.globals[g], globalType(g), v); err != nil {
+			return nil, fmt.Errorf("interp: global @%s: %v", g.Name(), err)
+		}
+	}
+	for _, f := range m.Functions {
+		in.funcAddrs[f] = in.mem.alloc(0)
+	}
+	return in, nil
+}
+
+// globalType returns the type of the value a global holds; the global
+// itself is a pointer to it
+func globalType(g *ir.Global) types.Type {
+	if pt, ok := g.Type().(*types.PointerType); ok {
+		return pt.ElementType
+	}
+	return g.Type()
+}
+
+// Register provides the implementation of a declared function
+func (in *Interpreter) Register(name string, fn Func) {
+	in.externals[name] = fn
+}
+
+// Call runs the named function. Arguments are converted to the types of
+// the function's parameters.
+func (in *Interpreter) Call(name string, args ...Value) (Value, error) {
+	f := in.Module.GetFunction(name)
+	if f == nil {
+		return Value{}, fmt.Errorf("interp: no function @%s", name)
+	}
+	return in.CallFunction(f, args...)
+}
+
+// CallFunction runs f with the given arguments
+func (in *Interpreter) CallFunction(f *ir.Function, args ...Value) (Value, error) {
+	params := f.FuncType.ParamTypes
+	if len(args) < len(params) || len(args) > len(params) && !f.FuncType.Variadic {
+		return Value{}, &Error{Func: f, Msg: fmt.Sprintf("called with %d arguments, expects %d", len(args), len(params))}
+	}
+	conv := make([]Value, len(args))
+	for i, a := range args {
+		if i >= len(params) {
+			conv[i] = a
+			continue
+		}
+		v, err := coerce(a, params[i])
+		if err != nil {
+			return Value{}, &Error{Func: f, Msg: fmt.Sprintf("argument %d: %v", i, err)}
+		}
+		conv[i] = v
+	}
+	res, err := in.call(f, f.Name(), conv)
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			err = &Error{Func: f, Msg: err.Error()}
+		}
+	}
+	return res, err
+}
+
+// GlobalAddress returns the address of a global variable
+func (in *Interpreter) GlobalAddress(name string) (uint64, bool) {
+	g := in.Module.GetGlobal(name)
+	if g == nil {
+		return 0, false
+	}
+	return in.globals[g], true
+}
+
+// Alloc allocates size zeroed bytes of memory
+func (in *Interpreter) Alloc(size uint64) uint64 {
+	return in.mem.alloc(size)
+}
+
+// Free releases memory returned by Alloc
+func (in *Interpreter) Free(addr uint64) error {
+	return in.mem.free(addr)
+}
+
+// ReadMemory returns a copy of the n bytes at addr
+func (in *Interpreter) ReadMemory(addr, n uint64) ([]byte, error) {
+	buf, err := in.mem.slice(addr, n)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf...), nil
+}
+
+// WriteMemory copies data to addr
+func (in *Interpreter) WriteMemory(addr uint64, data []byte) error {
+	buf, err := in.mem.slice(addr, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	copy(buf, data)
+	return nil
+}
+
+// ReadString returns the NUL-terminated string at addr
+func (in *Interpreter) ReadString(addr uint64) (string, error) {
+	var s []byte
+	for {
+		buf, err := in.mem.slice(addr, 1)
+		if err != nil {
+			return "", err
+		}
+		if buf[0] == 0 {
+			return string(s), nil
+		}
+		s = append(s, buf[0])
+		addr++
+	}
+}
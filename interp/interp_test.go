@@ -0,0 +1,178 @@
+package interp
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// load parses an example module from ../testdata and creates an
+// interpreter for it
+func load(t *testing.T, name string) *Interpreter {
+	t.Helper()
+	src, err := os.ReadFile("../testdata/" + name + ".ll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parse(t, string(src))
+}
+
+func parse(t *testing.T, src string) *Interpreter {
+	t.Helper()
+	m, err := asm.Parse("test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func TestExamples(t *testing.T) {
+	tests := []struct {
+		module, fn string
+		args       []Value
+		want       int64
+	}{
+		{"factorial", "factorial", []Value{Int(5)}, 120},
+		{"factorial", "factorial", []Value{Int(1)}, 1},
+		{"fibonacci", "fib", []Value{Int(10)}, 55},
+		{"gcd", "gcd", []Value{Int(48), Int(18)}, 6},
+		{"gcd", "gcd", []Value{Int(17), Int(5)}, 1},
+		{"switch", "classify", []Value{Int(0)}, 100},
+		{"switch", "classify", []Value{Int(1)}, 200},
+		{"switch", "classify", []Value{Int(7)}, -1},
+		{"global_array", "main", nil, 42},
+	}
+	for _, tt := range tests {
+		in := load(t, tt.module)
+		got, err := in.Call(tt.fn, tt.args...)
+		if err != nil {
+			t.Errorf("@%s: %v", tt.fn, err)
+			continue
+		}
+		if got.Int() != tt.want {
+			t.Errorf("@%s%v = %d, want %d", tt.fn, tt.args, got.Int(), tt.want)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	in := load(t, "struct")
+	p := in.Alloc(8)
+	if _, err := in.Call("update_y", Pointer(p), Int(-7)); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := in.ReadMemory(p, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, y := int32(binary.LittleEndian.Uint32(buf)), int32(binary.LittleEndian.Uint32(buf[4:])); x != 0 || y != -7 {
+		t.Errorf("point is {%d, %d}, want {0, -7}", x, y)
+	}
+
+	in = load(t, "global_array")
+	addr, ok := in.GlobalAddress("g_val")
+	if !ok {
+		t.Fatal("no address for @g_val")
+	}
+	if err := in.WriteMemory(addr, []byte{9, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := in.Call("main"); err != nil || v.Int() != 9 {
+		t.Errorf("@main after writing @g_val = %v, %v, want 9", v, err)
+	}
+}
+
+const arithSrc = `
+declare external i32 @twice(i32)
+
+define external i8 @wrap(i8 %x) {
+entry:
+  %s = add i8 %x, 100
+  ret i8 %s
+}
+
+define external f32 @single(f32 %x) {
+entry:
+  %s = fadd f32 %x, 0.1
+  ret f32 %s
+}
+
+define external i32 @div(i32 %x, i32 %y) {
+entry:
+  %d = sdiv i32 %x, %y
+  ret i32 %d
+}
+
+define external i32 @callext(i32 %x) {
+entry:
+  %c = call i32 @twice(i32 %x)
+  ret i32 %c
+}
+
+define external i32 @deref(ptr<i32> %p) {
+entry:
+  %v = load i32, ptr<i32> %p
+  ret i32 %v
+}
+`
+
+func TestArithmetic(t *testing.T) {
+	in := parse(t, arithSrc)
+	if v, err := in.Call("wrap", Int(100)); err != nil || v.Int() != -56 {
+		t.Errorf("@wrap(100) = %v, %v, want -56", v, err)
+	}
+	if v, err := in.Call("single", Float(0.2)); err != nil || v.Float() != float64(float32(0.2)+float32(0.1)) {
+		t.Errorf("@single(0.2) = %v, %v, want the f32 sum", v, err)
+	}
+	if v, err := in.Call("div", Int(-7), Int(2)); err != nil || v.Int() != -3 {
+		t.Errorf("@div(-7, 2) = %v, %v, want -3", v, err)
+	}
+}
+
+func TestExternals(t *testing.T) {
+	in := parse(t, arithSrc)
+	if _, err := in.Call("callext", Int(4)); err == nil || !strings.Contains(err.Error(), "call to undefined function @twice") {
+		t.Errorf("unregistered external: got error %v", err)
+	}
+	in.Register("twice", func(in *Interpreter, args []Value) (Value, error) {
+		return Int(2 * args[0].Int()), nil
+	})
+	if v, err := in.Call("callext", Int(21)); err != nil || v.Int() != 42 {
+		t.Errorf("@callext(21) = %v, %v, want 42", v, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	in := parse(t, arithSrc)
+	tests := []struct {
+		fn   string
+		args []Value
+		want string
+	}{
+		{"div", []Value{Int(1), Int(0)}, "division by zero"},
+		{"div", []Value{Int(-2147483648), Int(-1)}, "signed division overflow"},
+		{"deref", []Value{Pointer(in.Alloc(2))}, "out-of-bounds access of 4 bytes"},
+		{"div", []Value{Int(1)}, "called with 1 arguments, expects 2"},
+		{"nosuch", nil, "no function @nosuch"},
+	}
+	for _, tt := range tests {
+		_, err := in.Call(tt.fn, tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("@%s%v: got error %v, want %q", tt.fn, tt.args, err, tt.want)
+		}
+	}
+
+	// Errors from executing an instruction name it
+	_, err := in.Call("div", Int(1), Int(0))
+	if e, ok := err.(*Error); !ok || e.Inst == nil || e.Inst.Opcode() != ir.OpSDiv {
+		t.Errorf("division by zero reported as %#v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package interp
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/arc-language/core-builder/types"
+)
+
+// Addresses are made of a block number in the high 32 bits and an offset
+// into the block in the low 32 bits, so every access can be bounds
+// checked and the null pointer never refers to a live block.
+const blockShift = 32
+
+type memBlock struct {
+	data  []byte
+	freed bool
+}
+
+type memory struct {
+	blocks []*memBlock // index 0 is reserved for null
+}
+
+func newMemory() *memory {
+	return &memory{blocks: []*memBlock{nil}}
+}
+
+func (m *memory) alloc(size uint64) uint64 {
+	m.blocks = append(m.blocks, &memBlock{data: make([]byte, size)})
+	return uint64(len(m.blocks)-1) << blockShift
+}
+
+func (m *memory) free(addr uint64) error {
+	b, err := m.block(addr)
+	if err != nil {
+		return err
+	}
+	if addr&(1<<blockShift-1) != 0 {
+		return fmt.Errorf("free of interior pointer 0x%x", addr)
+	}
+	b.freed = true
+	b.data = nil
+	return nil
+}
+
+func (m *memory) block(addr uint64) (*memBlock, error) {
+	id := addr >> blockShift
+	if id == 0 || id >= uint64(len(m.blocks)) {
+		return nil, fmt.Errorf("invalid pointer 0x%x", addr)
+	}
+	b := m.blocks[id]
+	if b.freed {
+		return nil, fmt.Errorf("use of freed memory at 0x%x", addr)
+	}
+	return b, nil
+}
+
+// slice returns the n bytes starting at addr
+func (m *memory) slice(addr, n uint64) ([]byte, error) {
+	b, err := m.block(addr)
+	if err != nil {
+		return nil, err
+	}
+	off := addr & (1<<blockShift - 1)
+	if off+n > uint64(len(b.data)) {
+		return nil, fmt.Errorf("out-of-bounds access of %d bytes at 0x%x", n, addr)
+	}
+	return b.data[off : off+n], nil
+}
+
+// load reads a value of type t from addr
+func (m *memory) load(addr uint64, t types.Type) (Value, error) {
+	switch ty := t.(type) {
+	case *types.IntType, *types.PointerType, *types.FloatType:
+		n := uint64(layout.StoreSize(t))
+		buf, err := m.slice(addr, n)
+		if err != nil {
+			return Value{}, err
+		}
+		var tmp [8]byte
+		copy(tmp[:], buf)
+		bits := binary.LittleEndian.Uint64(tmp[:])
+		if _, ok := ty.(*types.FloatType); ok {
+			return Value{Type: t, float: bitsFloat(bits, t)}, nil
+		}
+		return Value{Type: t, bits: truncate(bits, intWidth(t))}, nil
+	case *types.ArrayType, *types.StructType, *types.VectorType:
+		v := zeroValue(t)
+		for i := range v.elems {
+			et, _ := elementType(t, i)
+			e, err := m.load(addr+elementOffset(t, i), et)
+			if err != nil {
+				return Value{}, err
+			}
+			v.elems[i] = e
+		}
+		return v, nil
+	}
+	return Value{}, fmt.Errorf("cannot load a value of type %s", t)
+}
+
+// store writes v, of type t, to addr
+func (m *memory) store(addr uint64, t types.Type, v Value) error {
+	switch t.(type) {
+	case *types.IntType, *types.PointerType, *types.FloatType:
+		n := uint64(layout.StoreSize(t))
+		buf, err := m.slice(addr, n)
+		if err != nil {
+			return err
+		}
+		bits := v.bits
+		if types.IsFloat(t) {
+			bits = floatBits(v.float, t)
+		}
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], bits)
+		copy(buf, tmp[:])
+		return nil
+	case *types.ArrayType, *types.StructType, *types.VectorType:
+		for i := range v.elems {
+			et, err := elementType(t, i)
+			if err != nil {
+				return err
+			}
+			if err := m.store(addr+elementOffset(t, i), et, v.elems[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot store a value of type %s", t)
+}
+
+// ============================================================================
+// Layout
+// ============================================================================
+
+// layout is used for every module: addresses are 64 bits wide whatever the
+// module's target, so the interpreter keeps to the default layout with its
+// 8-byte pointers
+var layout = types.DefaultDataLayout()
+
+// sizeOf returns the allocation size of t in bytes
+func sizeOf(t types.Type) uint64 {
+	return uint64(layout.SizeOf(t))
+}
+
+// elementOffset returns the byte offset of element i of an aggregate
+func elementOffset(t types.Type, i int) uint64 {
+	switch ty := t.(type) {
+	case *types.ArrayType:
+		return uint64(i) * sizeOf(ty.ElementType)
+	case *types.VectorType:
+		return uint64(i) * sizeOf(ty.ElementType)
+	case *types.StructType:
+		return uint64(layout.FieldOffset(ty, i))
+	}
+	return 0
+}
@@ -0,0 +1,200 @@
+package interp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/arc-language/core-builder/types"
+)
+
+// Value is a runtime value. Integers and pointers are held as raw bits
+// truncated to their type's width, floats as float64 and aggregates as a
+// list of element values.
+type Value struct {
+	Type  types.Type
+	bits  uint64
+	float float64
+	elems []Value
+}
+
+// Int returns an integer argument; it takes the width of the parameter it
+// is passed to
+func Int(v int64) Value { return Value{bits: uint64(v)} }
+
+// Float returns a floating point argument
+func Float(f float64) Value { return Value{float: f} }
+
+// Pointer returns a pointer argument
+func Pointer(addr uint64) Value { return Value{bits: addr} }
+
+// Aggregate returns a struct, array or vector argument
+func Aggregate(elems ...Value) Value { return Value{elems: elems} }
+
+// Int returns the value as a sign-extended integer
+func (v Value) Int() int64 {
+	return signExtend(v.bits, intWidth(v.Type))
+}
+
+// Uint returns the value as a zero-extended integer
+func (v Value) Uint() uint64 { return v.bits }
+
+// Bool reports whether an integer value is non-zero
+func (v Value) Bool() bool { return v.bits != 0 }
+
+// Float returns the value of a floating point value
+func (v Value) Float() float64 { return v.float }
+
+// Pointer returns the address held by a pointer value
+func (v Value) Pointer() uint64 { return v.bits }
+
+// Elems returns the elements of an aggregate value
+func (v Value) Elems() []Value { return v.elems }
+
+func (v Value) String() string {
+	switch t := v.Type.(type) {
+	case *types.IntType:
+		if t.Signed {
+			return fmt.Sprint(v.Int())
+		}
+		return fmt.Sprint(v.Uint())
+	case *types.FloatType:
+		return fmt.Sprint(v.float)
+	case *types.PointerType:
+		return fmt.Sprintf("0x%x", v.bits)
+	case *types.VoidType, nil:
+		if v.elems == nil {
+			return "void"
+		}
+	}
+	elems := make([]string, len(v.elems))
+	for i, e := range v.elems {
+		elems[i] = e.String()
+	}
+	return "{ " + strings.Join(elems, ", ") + " }"
+}
+
+func intWidth(t types.Type) int {
+	switch t := t.(type) {
+	case *types.IntType:
+		return t.BitWidth
+	case *types.PointerType:
+		return 64
+	}
+	return 64
+}
+
+// truncate masks bits to the low width bits
+func truncate(bits uint64, width int) uint64 {
+	if width >= 64 {
+		return bits
+	}
+	return bits & (1<<uint(width) - 1)
+}
+
+// signExtend interprets the low width bits as a two's complement integer
+func signExtend(bits uint64, width int) int64 {
+	if width >= 64 {
+		return int64(bits)
+	}
+	shift := uint(64 - width)
+	return int64(bits<<shift) >> shift
+}
+
+// roundFloat rounds f to the precision of the float type
+func roundFloat(f float64, t types.Type) float64 {
+	if ft, ok := t.(*types.FloatType); ok && ft.BitWidth == 32 {
+		return float64(float32(f))
+	}
+	return f
+}
+
+// coerce converts a caller-supplied value to the type t
+func coerce(v Value, t types.Type) (Value, error) {
+	switch ty := t.(type) {
+	case *types.IntType:
+		if ty.BitWidth > 64 {
+			return Value{}, fmt.Errorf("unsupported integer type %s", t)
+		}
+		return Value{Type: t, bits: truncate(v.bits, ty.BitWidth)}, nil
+	case *types.PointerType:
+		return Value{Type: t, bits: v.bits}, nil
+	case *types.FloatType:
+		return Value{Type: t, float: roundFloat(v.float, t)}, nil
+	case *types.ArrayType, *types.StructType, *types.VectorType:
+		out := Value{Type: t, elems: make([]Value, len(v.elems))}
+		for i, e := range v.elems {
+			et, err := elementType(t, i)
+			if err != nil {
+				return Value{}, err
+			}
+			if out.elems[i], err = coerce(e, et); err != nil {
+				return Value{}, err
+			}
+		}
+		return out, nil
+	}
+	return Value{}, fmt.Errorf("cannot pass a value of type %s", t)
+}
+
+// elementType returns the type of element i of an aggregate type
+func elementType(t types.Type, i int) (types.Type, error) {
+	switch ty := t.(type) {
+	case *types.ArrayType:
+		if int64(i) < ty.Length {
+			return ty.ElementType, nil
+		}
+	case *types.VectorType:
+		if i < ty.Length {
+			return ty.ElementType, nil
+		}
+	case *types.StructType:
+		if i < len(ty.Fields) {
+			return ty.Fields[i], nil
+		}
+	default:
+		return nil, fmt.Errorf("%s is not an aggregate type", t)
+	}
+	return nil, fmt.Errorf("index %d out of range for %s", i, t)
+}
+
+// zeroValue returns the all-zero value of type t
+func zeroValue(t types.Type) Value {
+	switch ty := t.(type) {
+	case *types.ArrayType:
+		v := Value{Type: t, elems: make([]Value, ty.Length)}
+		for i := range v.elems {
+			v.elems[i] = zeroValue(ty.ElementType)
+		}
+		return v
+	case *types.VectorType:
+		v := Value{Type: t, elems: make([]Value, ty.Length)}
+		for i := range v.elems {
+			v.elems[i] = zeroValue(ty.ElementType)
+		}
+		return v
+	case *types.StructType:
+		v := Value{Type: t, elems: make([]Value, len(ty.Fields))}
+		for i, f := range ty.Fields {
+			v.elems[i] = zeroValue(f)
+		}
+		return v
+	}
+	return Value{Type: t}
+}
+
+// floatBits returns the IEEE encoding of a float value of type t
+func floatBits(f float64, t types.Type) uint64 {
+	if ft, ok := t.(*types.FloatType); ok && ft.BitWidth == 32 {
+		return uint64(math.Float32bits(float32(f)))
+	}
+	return math.Float64bits(f)
+}
+
+// bitsFloat decodes the IEEE encoding of a float of type t
+func bitsFloat(bits uint64, t types.Type) float64 {
+	if ft, ok := t.(*types.FloatType); ok && ft.BitWidth == 32 {
+		return float64(math.Float32frombits(uint32(bits)))
+	}
+	return math.Float64frombits(bits)
+}
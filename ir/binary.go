@@ -0,0 +1,1072 @@
+// Package ir - compact binary serialization
+package ir
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/arc-language/core-builder/types"
+)
+
+// The binary format starts with a magic number and a format version,
+// followed by an interned type table and the module contents. Integers
+// are LEB128 varints. Values are referenced by index: globals and
+// functions in module-wide tables, arguments and instructions in a
+// per-function table numbered in block order.
+const (
+	binaryMagic   = "ARCB"
+	BinaryVersion = 1
+)
+
+// Type table entry kinds
+const (
+	btVoid byte = iota
+	btLabel
+	btInt
+	btFloat
+	btPointer
+	btArray
+	btStruct
+	btFunction
+	btVector
+)
+
+// Operand reference tags
+const (
+	brNil byte = iota
+	brLocal
+	brGlobal
+	brFunction
+	brConstant
+)
+
+// Constant kinds
+const (
+	bcInt byte = iota
+	bcFloat
+	bcNull
+	bcUndef
+	bcZero
+	bcArray
+	bcStruct
+)
+
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// ============================================================================
+// Writer
+// ============================================================================
+
+// WriteBinary encodes a module in the compact binary format
+func WriteBinary(w io.Writer, m *Module) error {
+	e := &binEncoder{
+		typeIndex:   make(map[string]int),
+		globalIndex: make(map[*Global]int),
+		funcIndex:   make(map[*Function]int),
+	}
+	for i, g := range m.Globals {
+		e.globalIndex[g] = i
+	}
+	for i, f := range m.Functions {
+		e.funcIndex[f] = i
+	}
+	if err := e.module(m); err != nil {
+		return err
+	}
+
+	// The type table is only complete once the body has been encoded
+	var out []byte
+	out = append(out, binaryMagic...)
+	out = binary.AppendUvarint(out, BinaryVersion)
+	out = binary.AppendUvarint(out, uint64(len(e.types)))
+	out = append(out, e.typeBuf...)
+	for _, fields := range e.structFields {
+		for _, f := range fields {
+			out = binary.AppendUvarint(out, uint64(f))
+		}
+	}
+	out = append(out, e.buf...)
+	_, err := w.Write(out)
+	return err
+}
+
+type binEncoder struct {
+	buf []byte
+
+	// Type table. Struct field lists are written after all entries since
+	// they may refer to types interned later.
+	typeBuf      []byte
+	types        []types.Type
+	structFields [][]int
+	typeIndex    map[string]int
+
+	globalIndex map[*Global]int
+	funcIndex   map[*Function]int
+
+	// Per-function state
+	localIndex map[Value]int
+	blockIndex map[*BasicBlock]int
+}
+
+func (e *binEncoder) uint(v uint64) { e.buf = binary.AppendUvarint(e.buf, v) }
+func (e *binEncoder) int(v int64)   { e.buf = binary.AppendVarint(e.buf, v) }
+func (e *binEncoder) byte(v byte)   { e.buf = append(e.buf, v) }
+func (e *binEncoder) bool(v bool)   { e.buf = append(e.buf, boolByte(v)) }
+func (e *binEncoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// typeRef writes a type table reference, 0 meaning no type
+func (e *binEncoder) typeRef(t types.Type) error {
+	if t == nil {
+		e.uint(0)
+		return nil
+	}
+	idx, err := e.intern(t)
+	if err != nil {
+		return err
+	}
+	e.uint(uint64(idx) + 1)
+	return nil
+}
+
+// intern adds a type to the table and returns its index. Types are keyed
+// by their printed form, which names structs instead of expanding them.
+// Element types are interned first, so non-struct entries only refer to
+// earlier entries; structs reserve their slot before their fields so that
+// recursive types terminate.
+func (e *binEncoder) intern(t types.Type) (int, error) {
+	key := t.String()
+	if idx, ok := e.typeIndex[key]; ok {
+		return idx, nil
+	}
+
+	if st, ok := t.(*types.StructType); ok {
+		idx := e.addType(key, t, nil)
+		e.typeBuf = append(e.typeBuf, btStruct)
+		e.typeBuf = binary.AppendUvarint(e.typeBuf, uint64(len(st.Name)))
+		e.typeBuf = append(e.typeBuf, st.Name...)
+		e.typeBuf = append(e.typeBuf, boolByte(st.Packed))
+		e.typeBuf = binary.AppendUvarint(e.typeBuf, uint64(len(st.Fields)))
+		fields := make([]int, len(st.Fields))
+		e.structFields = append(e.structFields, fields)
+		for i, f := range st.Fields {
+			fi, err := e.intern(f)
+			if err != nil {
+				return 0, err
+			}
+			fields[i] = fi
+		}
+		return idx, nil
+	}
+
+	var entry []byte
+	var err error
+	ref := func(t types.Type) {
+		if err != nil {
+			return
+		}
+		var idx int
+		idx, err = e.intern(t)
+		entry = binary.AppendUvarint(entry, uint64(idx))
+	}
+	switch ty := t.(type) {
+	case *types.VoidType:
+		entry = append(entry, btVoid)
+	case *types.LabelType:
+		entry = append(entry, btLabel)
+	case *types.IntType:
+		entry = append(entry, btInt)
+		entry = binary.AppendUvarint(entry, uint64(ty.BitWidth))
+		entry = append(entry, boolByte(ty.Signed))
+	case *types.FloatType:
+		entry = append(entry, btFloat)
+		entry = binary.AppendUvarint(entry, uint64(ty.BitWidth))
+	case *types.PointerType:
+		entry = append(entry, btPointer)
+		entry = binary.AppendUvarint(entry, uint64(ty.AddressSpace))
+		ref(ty.ElementType)
+	case *types.ArrayType:
+		entry = append(entry, btArray)
+		entry = binary.AppendUvarint(entry, uint64(ty.Length))
+		ref(ty.ElementType)
+	case *types.FunctionType:
+		entry = append(entry, btFunction)
+		entry = append(entry, boolByte(ty.Variadic))
+		ref(ty.ReturnType)
+		entry = binary.AppendUvarint(entry, uint64(len(ty.ParamTypes)))
+		for _, p := range ty.ParamTypes {
+			ref(p)
+		}
+	case *types.VectorType:
+		entry = append(entry, btVector)
+		entry = binary.AppendUvarint(entry, uint64(ty.Length))
+		entry = append(entry, boolByte(ty.Scalable))
+		ref(ty.ElementType)
+	default:
+		return 0, fmt.Errorf("ir: cannot encode type %s", t)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// A recursive struct reached through this type may already have added it
+	if idx, ok := e.typeIndex[key]; ok {
+		return idx, nil
+	}
+	return e.addType(key, t, entry), nil
+}
+
+func (e *binEncoder) addType(key string, t types.Type, entry []byte) int {
+	idx := len(e.types)
+	e.typeIndex[key] = idx
+	e.types = append(e.types, t)
+	e.typeBuf = append(e.typeBuf, entry...)
+	return idx
+}
+
+func (e *binEncoder) module(m *Module) error {
+	e.string(m.Name)
+	e.string(m.DataLayout)
+	e.string(m.TargetTriple)
+
+	// Named types, sorted for a deterministic encoding
+	names := sortedTypeNames(m)
+	e.uint(uint64(len(names)))
+	for _, name := range names {
+		e.string(name)
+		if err := e.typeRef(m.Types[name]); err != nil {
+			return err
+		}
+	}
+
+	e.uint(uint64(len(m.Globals)))
+	for _, g := range m.Globals {
+		e.string(g.ValName)
+		if err := e.typeRef(g.ValType); err != nil {
+			return err
+		}
+		e.uint(uint64(g.Linkage))
+		e.bool(g.IsConstant)
+		e.uint(uint64(g.AddressSpace))
+		if g.Initializer == nil {
+			e.bool(false)
+		} else {
+			e.bool(true)
+			if err := e.constant(g.Initializer); err != nil {
+				return fmt.Errorf("ir: global @%s: %v", g.ValName, err)
+			}
+		}
+	}
+
+	// Function headers come first so that bodies can reference any function
+	e.uint(uint64(len(m.Functions)))
+	for _, f := range m.Functions {
+		e.string(f.ValName)
+		if err := e.typeRef(f.FuncType); err != nil {
+			return err
+		}
+		e.uint(uint64(f.Linkage))
+		e.uint(uint64(len(f.Attributes)))
+		for _, a := range f.Attributes {
+			e.uint(uint64(a))
+		}
+		for _, arg := range f.Arguments {
+			e.string(arg.ValName)
+		}
+	}
+	for _, f := range m.Functions {
+		if err := e.function(f); err != nil {
+			return fmt.Errorf("ir: function @%s: %v", f.ValName, err)
+		}
+	}
+	return nil
+}
+
+func (e *binEncoder) constant(c Constant) error {
+	switch k := c.(type) {
+	case *ConstantInt:
+		e.byte(bcInt)
+		if err := e.typeRef(k.ValType); err != nil {
+			return err
+		}
+		e.int(k.Value)
+	case *ConstantFloat:
+		e.byte(bcFloat)
+		if err := e.typeRef(k.ValType); err != nil {
+			return err
+		}
+		e.uint(math.Float64bits(k.Value))
+	case *ConstantNull:
+		e.byte(bcNull)
+		return e.typeRef(k.ValType)
+	case *ConstantUndef:
+		e.byte(bcUndef)
+		return e.typeRef(k.ValType)
+	case *ConstantZero:
+		e.byte(bcZero)
+		return e.typeRef(k.ValType)
+	case *ConstantArray:
+		e.byte(bcArray)
+		if err := e.typeRef(k.ValType); err != nil {
+			return err
+		}
+		return e.constants(k.Elements)
+	case *ConstantStruct:
+		e.byte(bcStruct)
+		if err := e.typeRef(k.ValType); err != nil {
+			return err
+		}
+		return e.constants(k.Fields)
+	default:
+		return fmt.Errorf("cannot encode constant %T", c)
+	}
+	return nil
+}
+
+func (e *binEncoder) constants(list []Constant) error {
+	e.uint(uint64(len(list)))
+	for _, c := range list {
+		if err := e.constant(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// value writes an operand reference
+func (e *binEncoder) value(v Value) error {
+	switch val := v.(type) {
+	case nil:
+		e.byte(brNil)
+	case *Global:
+		idx, ok := e.globalIndex[val]
+		if !ok {
+			return fmt.Errorf("global @%s is not in the module", val.ValName)
+		}
+		e.byte(brGlobal)
+		e.uint(uint64(idx))
+	case *Function:
+		idx, ok := e.funcIndex[val]
+		if !ok {
+			return fmt.Errorf("function @%s is not in the module", val.ValName)
+		}
+		e.byte(brFunction)
+		e.uint(uint64(idx))
+	case Constant:
+		e.byte(brConstant)
+		return e.constant(val)
+	default:
+		idx, ok := e.localIndex[v]
+		if !ok {
+			return fmt.Errorf("operand %%%s is not defined in this function", v.Name())
+		}
+		e.byte(brLocal)
+		e.uint(uint64(idx))
+	}
+	return nil
+}
+
+func (e *binEncoder) block(b *BasicBlock) error {
+	idx, ok := e.blockIndex[b]
+	if !ok {
+		name := "<nil>"
+		if b != nil {
+			name = b.ValName
+		}
+		return fmt.Errorf("block %%%s is not in this function", name)
+	}
+	e.uint(uint64(idx))
+	return nil
+}
+
+func (e *binEncoder) blockList(list []*BasicBlock) error {
+	e.uint(uint64(len(list)))
+	for _, b := range list {
+		if err := e.block(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *binEncoder) function(f *Function) error {
+	e.localIndex = make(map[Value]int)
+	e.blockIndex = make(map[*BasicBlock]int)
+	for _, arg := range f.Arguments {
+		e.localIndex[arg] = len(e.localIndex)
+	}
+	for i, b := range f.Blocks {
+		e.blockIndex[b] = i
+		for _, inst := range b.Instructions {
+			e.localIndex[inst] = len(e.localIndex)
+		}
+	}
+
+	e.uint(uint64(len(f.Blocks)))
+	for _, b := range f.Blocks {
+		e.string(b.ValName)
+		e.uint(uint64(len(b.Instructions)))
+	}
+	for _, b := range f.Blocks {
+		if err := e.blockList(b.Predecessors); err != nil {
+			return err
+		}
+		if err := e.blockList(b.Successors); err != nil {
+			return err
+		}
+		for _, inst := range b.Instructions {
+			if err := e.instruction(inst); err != nil {
+				return fmt.Errorf("%%%s: %v", b.ValName, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *binEncoder) instruction(inst Instruction) error {
+	e.uint(uint64(inst.Opcode()))
+	e.string(inst.Name())
+	if err := e.typeRef(inst.Type()); err != nil {
+		return err
+	}
+	ops := inst.Operands()
+	if fieldOperands(inst) {
+		ops = nil
+	}
+	e.uint(uint64(len(ops)))
+	for _, op := range ops {
+		if err := e.value(op); err != nil {
+			return err
+		}
+	}
+
+	switch i := inst.(type) {
+	case *BrInst:
+		return e.block(i.Target)
+	case *CondBrInst:
+		if err := e.value(i.Condition); err != nil {
+			return err
+		}
+		if err := e.block(i.TrueBlock); err != nil {
+			return err
+		}
+		return e.block(i.FalseBlock)
+	case *SwitchInst:
+		if err := e.value(i.Condition); err != nil {
+			return err
+		}
+		if err := e.block(i.DefaultBlock); err != nil {
+			return err
+		}
+		e.uint(uint64(len(i.Cases)))
+		for _, c := range i.Cases {
+			if err := e.constant(c.Value); err != nil {
+				return err
+			}
+			if err := e.block(c.Block); err != nil {
+				return err
+			}
+		}
+	case *BinaryInst:
+		e.bool(i.NoSignedWrap)
+		e.bool(i.NoUnsignedWrap)
+		e.bool(i.Exact)
+	case *AllocaInst:
+		if err := e.typeRef(i.AllocatedType); err != nil {
+			return err
+		}
+		if err := e.value(i.NumElements); err != nil {
+			return err
+		}
+		e.uint(uint64(i.Alignment))
+	case *LoadInst:
+		e.bool(i.Volatile)
+		e.uint(uint64(i.Alignment))
+	case *StoreInst:
+		e.bool(i.Volatile)
+		e.uint(uint64(i.Alignment))
+	case *GetElementPtrInst:
+		if err := e.typeRef(i.SourceElementType); err != nil {
+			return err
+		}
+		e.bool(i.InBounds)
+	case *CastInst:
+		return e.typeRef(i.DestType)
+	case *ICmpInst:
+		e.uint(uint64(i.Predicate))
+	case *FCmpInst:
+		e.uint(uint64(i.Predicate))
+	case *PhiInst:
+		e.uint(uint64(len(i.Incoming)))
+		for _, inc := range i.Incoming {
+			if err := e.value(inc.Value); err != nil {
+				return err
+			}
+			if err := e.block(inc.Block); err != nil {
+				return err
+			}
+		}
+	case *CallInst:
+		if i.Callee != nil {
+			if err := e.value(i.Callee); err != nil {
+				return err
+			}
+		} else {
+			e.byte(brNil)
+		}
+		e.string(i.CalleeName)
+		e.bool(i.IsTailCall)
+	case *ExtractValueInst:
+		e.indices(i.Indices)
+	case *InsertValueInst:
+		e.indices(i.Indices)
+	case *VaArgInst:
+		return e.typeRef(i.ArgType)
+	case *RetInst, *UnreachableInst, *SelectInst, *SyscallInst, *VaStartInst, *VaEndInst:
+	default:
+		return fmt.Errorf("cannot encode instruction %T", inst)
+	}
+	return nil
+}
+
+// sortedTypeNames returns the module's named types in a stable order
+func sortedTypeNames(m *Module) []string {
+	names := make([]string, 0, len(m.Types))
+	for name := range m.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *binEncoder) indices(list []int) {
+	e.uint(uint64(len(list)))
+	for _, idx := range list {
+		e.uint(uint64(idx))
+	}
+}
+
+// ============================================================================
+// Reader
+// ============================================================================
+
+// ReadBinary decodes a module written by WriteBinary
+func ReadBinary(r io.Reader) (*Module, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(binaryMagic) || string(data[:len(binaryMagic)]) != binaryMagic {
+		return nil, errors.New("ir: not a binary IR module")
+	}
+	d := &binDecoder{data: data, pos: len(binaryMagic)}
+	if v := d.uint(); d.err == nil && v != BinaryVersion {
+		return nil, fmt.Errorf("ir: unsupported binary IR version %d", v)
+	}
+	m := d.module()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
+
+var errTruncated = errors.New("ir: truncated binary IR")
+
+// binDecoder reads from an in-memory buffer. The first error is sticky;
+// later reads return zero values so callers only check at loop boundaries.
+type binDecoder struct {
+	data []byte
+	pos  int
+	err  error
+
+	types   []types.Type
+	globals []*Global
+	funcs   []*Function
+
+	// Per-function state
+	locals  []Value
+	blocks  []*BasicBlock
+	patches []func()
+}
+
+func (d *binDecoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("ir: "+format, args...)
+	}
+}
+
+func (d *binDecoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *binDecoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *binDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.pos >= len(d.data) {
+		d.err = errTruncated
+		return 0
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *binDecoder) bool() bool { return d.byte() != 0 }
+
+func (d *binDecoder) string() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.data[d.pos : d.pos+n])
+	d.pos += n
+	return s
+}
+
+// count reads a length prefix, rejecting lengths larger than the rest of
+// the input so corrupt data cannot trigger huge allocations
+func (d *binDecoder) count() int {
+	n := d.uint()
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail("length %d exceeds input size", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *binDecoder) index(n int, what string) int {
+	idx := d.uint()
+	if d.err == nil && idx >= uint64(n) {
+		d.fail("%s index %d out of range", what, idx)
+		return 0
+	}
+	return int(idx)
+}
+
+func (d *binDecoder) typeRef() types.Type {
+	idx := d.uint()
+	if idx == 0 || d.err != nil {
+		return nil
+	}
+	if idx > uint64(len(d.types)) {
+		d.fail("type index %d out of range", idx-1)
+		return nil
+	}
+	return d.types[idx-1]
+}
+
+func (d *binDecoder) typeTable() {
+	n := d.count()
+	var structs []*types.StructType
+	var fieldCounts []int
+	ref := func() types.Type {
+		idx := d.index(len(d.types), "type")
+		if d.err != nil {
+			return nil
+		}
+		return d.types[idx]
+	}
+	for i := 0; i < n && d.err == nil; i++ {
+		var t types.Type
+		switch kind := d.byte(); kind {
+		case btVoid:
+			t = types.Void
+		case btLabel:
+			t = types.Label
+		case btInt:
+			width := int(d.uint())
+			t = types.NewInt(width, d.bool())
+		case btFloat:
+			t = types.NewFloat(int(d.uint()))
+		case btPointer:
+			space := int(d.uint())
+			t = types.NewPointerWithAddressSpace(ref(), space)
+		case btArray:
+			length := int64(d.uint())
+			t = types.NewArray(ref(), length)
+		case btStruct:
+			st := types.NewStruct(d.string(), nil, d.bool())
+			fieldCounts = append(fieldCounts, d.count())
+			structs = append(structs, st)
+			t = st
+		case btFunction:
+			variadic := d.bool()
+			ret := ref()
+			params := make([]types.Type, d.count())
+			for j := range params {
+				params[j] = ref()
+			}
+			t = types.NewFunction(ret, params, variadic)
+		case btVector:
+			length := int(d.uint())
+			scalable := d.bool()
+			vec := types.NewVector(ref(), length)
+			vec.Scalable = scalable
+			t = vec
+		default:
+			d.fail("unknown type kind %d", kind)
+		}
+		d.types = append(d.types, t)
+	}
+	for i, st := range structs {
+		st.Fields = make([]types.Type, fieldCounts[i])
+		for j := range st.Fields {
+			st.Fields[j] = ref()
+		}
+	}
+}
+
+func (d *binDecoder) module() *Module {
+	d.typeTable()
+	m := NewModule(d.string())
+	m.DataLayout = d.string()
+	m.TargetTriple = d.string()
+
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		name := d.string()
+		st, ok := d.typeRef().(*types.StructType)
+		if !ok {
+			d.fail("named type %%%s is not a struct", name)
+			break
+		}
+		m.Types[name] = st
+	}
+
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		g := &Global{}
+		g.ValName = d.string()
+		g.ValType = d.typeRef()
+		g.Linkage = Linkage(d.uint())
+		g.IsConstant = d.bool()
+		g.AddressSpace = int(d.uint())
+		if d.bool() {
+			g.Initializer = d.constant()
+		}
+		m.AddGlobal(g)
+		d.globals = append(d.globals, g)
+	}
+
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		name := d.string()
+		ft, ok := d.typeRef().(*types.FunctionType)
+		if !ok {
+			d.fail("function @%s does not have a function type", name)
+			break
+		}
+		f := NewFunction(name, ft)
+		f.Linkage = Linkage(d.uint())
+		for a := d.count(); a > 0; a-- {
+			f.Attributes = append(f.Attributes, FuncAttribute(d.uint()))
+		}
+		for _, arg := range f.Arguments {
+			arg.ValName = d.string()
+		}
+		m.AddFunction(f)
+		d.funcs = append(d.funcs, f)
+	}
+	for _, f := range d.funcs {
+		if d.err != nil {
+			break
+		}
+		d.function(f)
+	}
+	return m
+}
+
+func (d *binDecoder) constant() Constant {
+	kind := d.byte()
+	typ := d.typeRef()
+	if d.err != nil {
+		return nil
+	}
+	var c Constant
+	switch kind {
+	case bcInt:
+		c = &ConstantInt{BaseValue: BaseValue{ValType: typ}, Value: d.int()}
+	case bcFloat:
+		c = &ConstantFloat{BaseValue: BaseValue{ValType: typ}, Value: math.Float64frombits(d.uint())}
+	case bcNull:
+		c = &ConstantNull{BaseValue: BaseValue{ValType: typ}}
+	case bcUndef:
+		c = &ConstantUndef{BaseValue: BaseValue{ValType: typ}}
+	case bcZero:
+		c = &ConstantZero{BaseValue: BaseValue{ValType: typ}}
+	case bcArray:
+		c = &ConstantArray{BaseValue: BaseValue{ValType: typ}, Elements: d.constants()}
+	case bcStruct:
+		c = &ConstantStruct{BaseValue: BaseValue{ValType: typ}, Fields: d.constants()}
+	default:
+		d.fail("unknown constant kind %d", kind)
+	}
+	return c
+}
+
+func (d *binDecoder) constants() []Constant {
+	var list []Constant
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		list = append(list, d.constant())
+	}
+	return list
+}
+
+// value reads an operand reference. Local values may be defined later in
+// the function, so they are applied through set once all exist.
+func (d *binDecoder) value(set func(Value)) {
+	switch tag := d.byte(); tag {
+	case brNil:
+		set(nil)
+	case brLocal:
+		idx := d.uint()
+		d.patches = append(d.patches, func() {
+			if idx >= uint64(len(d.locals)) {
+				d.fail("local value index %d out of range", idx)
+				return
+			}
+			set(d.locals[idx])
+		})
+	case brGlobal:
+		if idx := d.index(len(d.globals), "global"); d.err == nil {
+			set(d.globals[idx])
+		}
+	case brFunction:
+		if idx := d.index(len(d.funcs), "function"); d.err == nil {
+			set(d.funcs[idx])
+		}
+	case brConstant:
+		set(d.constant())
+	default:
+		d.fail("unknown operand tag %d", tag)
+	}
+}
+
+func (d *binDecoder) block() *BasicBlock {
+	idx := d.index(len(d.blocks), "block")
+	if d.err != nil {
+		return nil
+	}
+	return d.blocks[idx]
+}
+
+func (d *binDecoder) blockList() []*BasicBlock {
+	var list []*BasicBlock
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		list = append(list, d.block())
+	}
+	return list
+}
+
+func (d *binDecoder) function(f *Function) {
+	d.locals = d.locals[:0]
+	d.blocks = d.blocks[:0]
+	d.patches = nil
+	for _, arg := range f.Arguments {
+		d.locals = append(d.locals, arg)
+	}
+
+	nblocks := d.count()
+	sizes := make([]int, nblocks)
+	for i := range sizes {
+		b := NewBasicBlock(d.string())
+		sizes[i] = d.count()
+		f.AddBlock(b)
+		d.blocks = append(d.blocks, b)
+	}
+	for i, b := range f.Blocks {
+		b.Predecessors = d.blockList()
+		b.Successors = d.blockList()
+		for j := 0; j < sizes[i] && d.err == nil; j++ {
+			inst := d.instruction()
+			if inst == nil {
+				break
+			}
+			b.AddInstruction(inst)
+			d.locals = append(d.locals, inst)
+		}
+	}
+	for _, patch := range d.patches {
+		patch()
+	}
+}
+
+// newInstruction allocates the concrete instruction type for an opcode
+func newInstruction(op Opcode) Instruction {
+	switch op {
+	case OpRet:
+		return &RetInst{}
+	case OpBr:
+		return &BrInst{}
+	case OpCondBr:
+		return &CondBrInst{}
+	case OpSwitch:
+		return &SwitchInst{}
+	case OpUnreachable:
+		return &UnreachableInst{}
+	case OpAdd, OpSub, OpMul, OpUDiv, OpSDiv, OpURem, OpSRem,
+		OpFAdd, OpFSub, OpFMul, OpFDiv, OpFRem,
+		OpShl, OpLShr, OpAShr, OpAnd, OpOr, OpXor:
+		return &BinaryInst{}
+	case OpAlloca:
+		return &AllocaInst{}
+	case OpLoad:
+		return &LoadInst{}
+	case OpStore:
+		return &StoreInst{}
+	case OpGetElementPtr:
+		return &GetElementPtrInst{}
+	case OpTrunc, OpZExt, OpSExt, OpFPTrunc, OpFPExt, OpFPToUI, OpFPToSI,
+		OpUIToFP, OpSIToFP, OpPtrToInt, OpIntToPtr, OpBitcast:
+		return &CastInst{}
+	case OpICmp:
+		return &ICmpInst{}
+	case OpFCmp:
+		return &FCmpInst{}
+	case OpPhi:
+		return &PhiInst{}
+	case OpSelect:
+		return &SelectInst{}
+	case OpCall:
+		return &CallInst{}
+	case OpSyscall:
+		return &SyscallInst{}
+	case OpExtractValue:
+		return &ExtractValueInst{}
+	case OpInsertValue:
+		return &InsertValueInst{}
+	case OpVaStart:
+		return &VaStartInst{}
+	case OpVaArg:
+		return &VaArgInst{}
+	case OpVaEnd:
+		return &VaEndInst{}
+	}
+	return nil
+}
+
+func (d *binDecoder) instruction() Instruction {
+	op := Opcode(d.uint())
+	inst := newInstruction(op)
+	if inst == nil {
+		d.fail("unknown opcode %d", op)
+		return nil
+	}
+	bi := inst.(interface{ base() *BaseInstruction }).base()
+	bi.Op = op
+	bi.ValName = d.string()
+	bi.ValType = d.typeRef()
+	nops := d.count()
+	for i := 0; i < nops && d.err == nil; i++ {
+		idx := i
+		d.value(func(v Value) { inst.SetOperand(idx, v) })
+	}
+
+	switch i := inst.(type) {
+	case *BrInst:
+		i.Target = d.block()
+	case *CondBrInst:
+		d.value(func(v Value) { i.SetOperand(0, v) })
+		i.TrueBlock = d.block()
+		i.FalseBlock = d.block()
+	case *SwitchInst:
+		d.value(func(v Value) { i.SetOperand(0, v) })
+		i.DefaultBlock = d.block()
+		for n := d.count(); n > 0 && d.err == nil; n-- {
+			c, ok := d.constant().(*ConstantInt)
+			if !ok {
+				d.fail("switch case is not an integer constant")
+				break
+			}
+			i.Cases = append(i.Cases, SwitchCase{Value: c, Block: d.block()})
+		}
+	case *BinaryInst:
+		i.NoSignedWrap = d.bool()
+		i.NoUnsignedWrap = d.bool()
+		i.Exact = d.bool()
+	case *AllocaInst:
+		i.AllocatedType = d.typeRef()
+		d.value(func(v Value) {
+			if v != nil {
+				i.SetOperand(0, v)
+			}
+		})
+		i.Alignment = int(d.uint())
+	case *LoadInst:
+		i.Volatile = d.bool()
+		i.Alignment = int(d.uint())
+	case *StoreInst:
+		i.Volatile = d.bool()
+		i.Alignment = int(d.uint())
+	case *GetElementPtrInst:
+		i.SourceElementType = d.typeRef()
+		i.InBounds = d.bool()
+	case *CastInst:
+		i.DestType = d.typeRef()
+	case *ICmpInst:
+		i.Predicate = ICmpPredicate(d.uint())
+	case *FCmpInst:
+		i.Predicate = FCmpPredicate(d.uint())
+	case *PhiInst:
+		n := d.count()
+		i.Incoming = make([]PhiIncoming, n)
+		for j := 0; j < n && d.err == nil; j++ {
+			idx := j
+			d.value(func(v Value) { i.SetOperand(idx, v) })
+			i.Incoming[j].Block = d.block()
+		}
+	case *CallInst:
+		d.value(func(v Value) {
+			if fn, ok := v.(*Function); ok {
+				i.SetCallee(fn)
+			}
+		})
+		i.CalleeName = d.string()
+		i.IsTailCall = d.bool()
+	case *ExtractValueInst:
+		i.Indices = d.indices()
+	case *InsertValueInst:
+		i.Indices = d.indices()
+	case *VaArgInst:
+		i.ArgType = d.typeRef()
+	}
+	return inst
+}
+
+func (d *binDecoder) indices() []int {
+	var list []int
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		list = append(list, int(d.uint()))
+	}
+	return list
+}
@@ -0,0 +1,114 @@
+package ir_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// loadExamples parses the example modules in ../testdata
+func loadExamples(t *testing.T) map[string]*ir.Module {
+	t.Helper()
+	files, err := filepath.Glob("../testdata/*.ll")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no example modules: %v", err)
+	}
+	mods := make(map[string]*ir.Module)
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".ll")
+		m, err := asm.Parse(name, string(src))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		mods[name] = m
+	}
+	return mods
+}
+
+// sameCFG fails unless the blocks of every function of got have the same
+// predecessors and successors as those of want
+func sameCFG(t *testing.T, got, want *ir.Module) {
+	t.Helper()
+	names := func(list []*ir.BasicBlock) string {
+		var s []string
+		for _, b := range list {
+			s = append(s, b.Name())
+		}
+		return strings.Join(s, ",")
+	}
+	for i, fn := range want.Functions {
+		for j, b := range fn.Blocks {
+			gb := got.Functions[i].Blocks[j]
+			if names(gb.Predecessors) != names(b.Predecessors) || names(gb.Successors) != names(b.Successors) {
+				t.Errorf("@%s %%%s: edges %s -> %s, want %s -> %s", fn.Name(), b.Name(),
+					names(gb.Predecessors), names(gb.Successors), names(b.Predecessors), names(b.Successors))
+			}
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for name, m := range loadExamples(t) {
+		var buf bytes.Buffer
+		if err := ir.WriteBinary(&buf, m); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := ir.ReadBinary(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.String() != m.String() {
+			t.Errorf("%s: decoded module differs:\n%s\nwant:\n%s", name, got, m)
+		}
+		sameCFG(t, got, m)
+	}
+}
+
+func TestBinaryUses(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ir.WriteBinary(&buf, loadExamples(t)["factorial"]); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ir.ReadBinary(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := m.GetFunction("factorial")
+	if fn.NumUses() != 1 {
+		t.Errorf("@factorial has %d uses after decoding, want 1", fn.NumUses())
+	}
+	if n := fn.Arguments[0]; n.NumUses() != 3 {
+		t.Errorf("%%n has %d uses after decoding, want 3", n.NumUses())
+	}
+}
+
+func TestBinaryErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ir.WriteBinary(&buf, loadExamples(t)["gcd"]); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if _, err := ir.ReadBinary(strings.NewReader("LLVM")); err == nil || !strings.Contains(err.Error(), "not a binary IR module") {
+		t.Errorf("bad magic: got error %v", err)
+	}
+	future := append([]byte("ARCB"), byte(ir.BinaryVersion+1))
+	if _, err := ir.ReadBinary(bytes.NewReader(future)); err == nil || !strings.Contains(err.Error(), "unsupported binary IR version") {
+		t.Errorf("bad version: got error %v", err)
+	}
+	// Every proper prefix is rejected without a panic
+	for n := 4; n < len(data); n++ {
+		if _, err := ir.ReadBinary(bytes.NewReader(data[:n])); err == nil {
+			t.Errorf("prefix of %d of %d bytes decoded without error", n, len(data))
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package ir
+
+import "fmt"
+
+// Editing keeps three things consistent: the Instructions of each block,
+// the Predecessors and Successors lists, and the phi incoming lists of the
+// blocks whose predecessors change. The methods panic on misuse, like the
+// builder does.
+
+// EraseFromParent removes the instruction from its block and drops its
+// operands. The instruction must not have uses left. Erasing a terminator
+// removes the block's outgoing edges along with the matching phi entries
+// in the successors.
+func (i *BaseInstruction) EraseFromParent() {
+	b := i.Parent_
+	if b == nil || i.self == nil {
+		panic("instruction is not in a block")
+	}
+	if n := i.NumUses(); n > 0 {
+		panic(fmt.Sprintf("cannot erase %%%s: it still has %d uses", i.ValName, n))
+	}
+	b.remove(i.self)
+	dropOperands(i.self)
+	if i.self.IsTerminator() {
+		for _, s := range b.Successors {
+			s.removePredecessor(b)
+		}
+		b.Successors = nil
+	}
+}
+
+// MoveBefore moves the instruction to just before pos, which may be in
+// another block. Terminators cannot be moved, and phis must stay at the top
+// of their block. Moving an instruction before itself does nothing.
+func (i *BaseInstruction) MoveBefore(pos Instruction) {
+	i.move(pos, 0)
+}
+
+// MoveAfter moves the instruction to just after pos, which may be in
+// another block. Terminators cannot be moved, and phis must stay at the top
+// of their block. Moving an instruction after itself does nothing.
+func (i *BaseInstruction) MoveAfter(pos Instruction) {
+	i.move(pos, 1)
+}
+
+// move moves the instruction to offset places after pos. Everything is
+// checked before the instruction is unlinked, so a failed move leaves the
+// function as it was.
+func (i *BaseInstruction) move(pos Instruction, offset int) {
+	if i.Parent_ == nil || i.self == nil {
+		panic("instruction is not in a block")
+	}
+	if i.self.IsTerminator() {
+		panic("cannot move a terminator")
+	}
+	if pos == i.self {
+		return
+	}
+	dest := pos.Parent()
+	if dest == nil {
+		panic(fmt.Sprintf("%%%s is not in a block", pos.Name()))
+	}
+	if offset > 0 && pos.IsTerminator() {
+		panic("cannot move an instruction after a terminator")
+	}
+
+	// The instructions the moved one will sit between
+	at := dest.indexOf(pos) + offset
+	var prev, next Instruction
+	for j := at - 1; j >= 0 && prev == nil; j-- {
+		if dest.Instructions[j] != i.self {
+			prev = dest.Instructions[j]
+		}
+	}
+	for j := at; j < len(dest.Instructions) && next == nil; j++ {
+		if dest.Instructions[j] != i.self {
+			next = dest.Instructions[j]
+		}
+	}
+	_, isPhi := i.self.(*PhiInst)
+	if _, prevPhi := prev.(*PhiInst); isPhi && prev != nil && !prevPhi {
+		panic("cannot move a phi after a non-phi instruction")
+	}
+	if _, nextPhi := next.(*PhiInst); !isPhi && nextPhi {
+		panic("cannot move an instruction in front of a phi")
+	}
+
+	i.Parent_.remove(i.self)
+	dest.InsertInstruction(dest.indexOf(pos)+offset, i.self)
+}
+
+// RemoveIncoming removes the first entry for block b and returns its value,
+// or nil if the phi has no entry for b
+func (i *PhiInst) RemoveIncoming(b *BasicBlock) Value {
+	for idx, inc := range i.Incoming {
+		if inc.Block != b {
+			continue
+		}
+		n := len(i.Incoming)
+		for j := idx; j < n-1; j++ {
+			i.BaseInstruction.SetOperand(j, i.Ops[j+1])
+		}
+		i.BaseInstruction.SetOperand(n-1, nil)
+		i.Ops = i.Ops[:n-1]
+		copy(i.Incoming[idx:], i.Incoming[idx+1:])
+		i.Incoming = i.Incoming[:n-1]
+		return inc.Value
+	}
+	return nil
+}
+
+// SplitAt splits the block before inst. Inst and the instructions after it
+// move to a new block placed right after this one, which takes over the
+// outgoing edges; this block then ends in a branch to the new block. The
+// new block is returned.
+func (b *BasicBlock) SplitAt(inst Instruction) *BasicBlock {
+	if inst.Parent() != b {
+		panic(fmt.Sprintf("%%%s is not in block %%%s", inst.Name(), b.ValName))
+	}
+	if _, isPhi := inst.(*PhiInst); isPhi {
+		panic("cannot split a block at a phi")
+	}
+	idx := b.indexOf(inst)
+
+	name := b.ValName + ".split"
+	if b.Parent != nil {
+		name = b.Parent.uniqueBlockName(name)
+	}
+	nb := NewBasicBlock(name)
+	if f := b.Parent; f != nil {
+		nb.Parent = f
+		pos := f.blockIndex(b) + 1
+		f.Blocks = append(f.Blocks, nil)
+		copy(f.Blocks[pos+1:], f.Blocks[pos:])
+		f.Blocks[pos] = nb
+	}
+
+	for _, moved := range b.Instructions[idx:] {
+		moved.SetParent(nb)
+		nb.Instructions = append(nb.Instructions, moved)
+	}
+	for j := idx; j < len(b.Instructions); j++ {
+		b.Instructions[j] = nil
+	}
+	b.Instructions = b.Instructions[:idx]
+
+	nb.Successors = b.Successors
+	done := make(map[*BasicBlock]bool)
+	for _, s := range nb.Successors {
+		if !done[s] {
+			done[s] = true
+			s.replacePredecessor(b, nb)
+		}
+	}
+
+	br := &BrInst{Target: nb}
+	br.Op = OpBr
+	b.AddInstruction(br)
+	b.Successors = []*BasicBlock{nb}
+	nb.Predecessors = []*BasicBlock{b}
+	return nb
+}
+
+// RemoveBlock removes b from the function. Every predecessor of b other
+// than b itself must already have been redirected. The block's outgoing
+// edges and the matching phi entries in its successors are removed, and
+// uses of its instructions elsewhere in the function become undef.
+func (f *Function) RemoveBlock(b *BasicBlock) {
+	if b.Parent != f {
+		panic(fmt.Sprintf("block %%%s is not in @%s", b.ValName, f.Name()))
+	}
+	for _, p := range b.Predecessors {
+		if p != b {
+			panic(fmt.Sprintf("block %%%s still has predecessor %%%s", b.ValName, p.ValName))
+		}
+	}
+
+	for _, inst := range b.Instructions {
+		for _, u := range append([]*Use(nil), inst.Uses()...) {
+			if user := u.User(); user == nil || user.Parent() != b {
+				u.Set(&ConstantUndef{BaseValue: BaseValue{ValType: inst.Type()}})
+			}
+		}
+	}
+	for _, inst := range b.Instructions {
+		dropOperands(inst)
+	}
+	for _, s := range b.Successors {
+		if s != b {
+			s.removePredecessor(b)
+		}
+	}
+	b.Successors = nil
+	b.Predecessors = nil
+
+	idx := f.blockIndex(b)
+	copy(f.Blocks[idx:], f.Blocks[idx+1:])
+	f.Blocks[len(f.Blocks)-1] = nil
+	f.Blocks = f.Blocks[:len(f.Blocks)-1]
+	b.Parent = nil
+}
+
+// indexOf returns the position of inst in the block
+func (b *BasicBlock) indexOf(inst Instruction) int {
+	for idx, x := range b.Instructions {
+		if x == inst {
+			return idx
+		}
+	}
+	panic(fmt.Sprintf("%%%s is not in block %%%s", inst.Name(), b.ValName))
+}
+
+// remove unlinks inst from the block without touching its operands
+func (b *BasicBlock) remove(inst Instruction) {
+	idx := b.indexOf(inst)
+	copy(b.Instructions[idx:], b.Instructions[idx+1:])
+	b.Instructions[len(b.Instructions)-1] = nil
+	b.Instructions = b.Instructions[:len(b.Instructions)-1]
+	inst.SetParent(nil)
+}
+
+// removePredecessor drops one edge from p along with its phi entries
+func (b *BasicBlock) removePredecessor(p *BasicBlock) {
+	for idx, x := range b.Predecessors {
+		if x == p {
+			b.Predecessors = append(b.Predecessors[:idx], b.Predecessors[idx+1:]...)
+			break
+		}
+	}
+	for _, inst := range b.Instructions {
+		phi, ok := inst.(*PhiInst)
+		if !ok {
+			break
+		}
+		phi.RemoveIncoming(p)
+	}
+}
+
+// replacePredecessor redirects every edge from old to come from repl
+func (b *BasicBlock) replacePredecessor(old, repl *BasicBlock) {
+	for idx, x := range b.Predecessors {
+		if x == old {
+			b.Predecessors[idx] = repl
+		}
+	}
+	for _, inst := range b.Instructions {
+		phi, ok := inst.(*PhiInst)
+		if !ok {
+			break
+		}
+		for idx := range phi.Incoming {
+			if phi.Incoming[idx].Block == old {
+				phi.Incoming[idx].Block = repl
+			}
+		}
+	}
+}
+
+func (f *Function) blockIndex(b *BasicBlock) int {
+	for idx, x := range f.Blocks {
+		if x == b {
+			return idx
+		}
+	}
+	panic(fmt.Sprintf("block %%%s is not in @%s", b.ValName, f.Name()))
+}
+
+// uniqueBlockName returns base, or base with the first numeric suffix that
+// no block of f uses
+func (f *Function) uniqueBlockName(base string) string {
+	used := make(map[string]bool, len(f.Blocks))
+	for _, b := range f.Blocks {
+		used[b.ValName] = true
+	}
+	name := base
+	for n := 1; used[name]; n++ {
+		name = fmt.Sprintf("%s.%d", base, n)
+	}
+	return name
+}
+
+// dropOperands clears the operands of inst, removing it from the use lists
+// of the values it referenced
+func dropOperands(inst Instruction) {
+	for idx := range inst.Operands() {
+		inst.SetOperand(idx, nil)
+	}
+	if call, ok := inst.(*CallInst); ok {
+		call.SetCallee(nil)
+	}
+}
@@ -0,0 +1,252 @@
+package ir_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/verify"
+)
+
+// parseFunc parses src and returns its first function
+func parseFunc(t *testing.T, src string) *ir.Function {
+	t.Helper()
+	m, err := asm.Parse("test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Functions[0]
+}
+
+// checkValid fails if fn does not verify
+func checkValid(t *testing.T, fn *ir.Function) {
+	t.Helper()
+	if errs := verify.Function(fn); len(errs) > 0 {
+		t.Fatalf("%v\n%s", errs, fn)
+	}
+}
+
+func blockNamed(fn *ir.Function, name string) *ir.BasicBlock {
+	for _, b := range fn.Blocks {
+		if b.Name() == name {
+			return b
+		}
+	}
+	return nil
+}
+
+const editSrc = `
+define external i32 @f(i32 %x) {
+entry:
+  %c = icmp slt i32 %x, 0
+  br i1 %c, label %neg, label %join
+neg:
+  %n = sub i32 0, %x
+  %m = mul i32 %n, 2
+  br label %join
+dead:
+  %d = add i32 %x, 1
+  br label %join
+join:
+  %r = phi i32 [ %x, %entry ], [ %m, %neg ], [ %d, %dead ]
+  ret i32 %r
+}
+`
+
+func TestSplitAtRewiresPhis(t *testing.T) {
+	fn := parseFunc(t, editSrc)
+	neg := blockNamed(fn, "neg")
+	join := blockNamed(fn, "join")
+	tail := neg.SplitAt(neg.Instructions[1])
+	checkValid(t, fn)
+
+	if tail.Name() != "neg.split" || fn.Blocks[2] != tail {
+		t.Errorf("new block %%%s at position %d", tail.Name(), 2)
+	}
+	if len(neg.Instructions) != 2 || len(tail.Instructions) != 2 {
+		t.Errorf("split left %d and %d instructions, want 2 and 2", len(neg.Instructions), len(tail.Instructions))
+	}
+	if len(neg.Successors) != 1 || neg.Successors[0] != tail || tail.Predecessors[0] != neg {
+		t.Errorf("neg does not branch to the new block")
+	}
+	phi := join.Instructions[0].(*ir.PhiInst)
+	if phi.Incoming[1].Block != tail || join.Predecessors[1] != tail {
+		t.Errorf("join still expects %%m from %%%s", phi.Incoming[1].Block.Name())
+	}
+	if tail.Instructions[0].Parent() != tail {
+		t.Errorf("moved instruction has the wrong parent")
+	}
+
+	// A second split of the same block gets a fresh name
+	if again := neg.SplitAt(neg.Instructions[0]); again.Name() != "neg.split.1" {
+		t.Errorf("second split named %%%s", again.Name())
+	}
+	checkValid(t, fn)
+}
+
+func TestRemoveBlockRewiresPhis(t *testing.T) {
+	fn := parseFunc(t, editSrc)
+	dead := blockNamed(fn, "dead")
+	join := blockNamed(fn, "join")
+	x := fn.Arguments[0]
+	uses := x.NumUses()
+
+	fn.RemoveBlock(dead)
+	checkValid(t, fn)
+	phi := join.Instructions[0].(*ir.PhiInst)
+	if len(phi.Incoming) != 2 || len(join.Predecessors) != 2 {
+		t.Errorf("join keeps %d phi entries and %d predecessors, want 2 and 2", len(phi.Incoming), len(join.Predecessors))
+	}
+	if x.NumUses() != uses-1 {
+		t.Errorf("%%x has %d uses, want %d after removing %%d", x.NumUses(), uses-1)
+	}
+	if blockNamed(fn, "dead") != nil || dead.Parent != nil {
+		t.Errorf("dead block still in the function")
+	}
+}
+
+func TestRemoveBlockWithPredecessorPanics(t *testing.T) {
+	fn := parseFunc(t, editSrc)
+	defer func() {
+		if recover() == nil {
+			t.Error("removing a block with a predecessor did not panic")
+		}
+	}()
+	fn.RemoveBlock(blockNamed(fn, "neg"))
+}
+
+func TestEraseAndMove(t *testing.T) {
+	fn := parseFunc(t, editSrc)
+	neg := blockNamed(fn, "neg")
+	join := blockNamed(fn, "join")
+	n, m := neg.Instructions[0], neg.Instructions[1]
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("erasing an instruction with uses did not panic")
+			}
+		}()
+		n.EraseFromParent()
+	}()
+
+	// Move %n into entry, after the compare
+	entry := fn.EntryBlock()
+	n.MoveAfter(entry.Instructions[0])
+	if n.Parent() != entry || entry.Instructions[1] != n || neg.Instructions[0] != m {
+		t.Errorf("MoveAfter left %%n in %%%s", n.Parent().Name())
+	}
+	n.MoveBefore(m)
+	if n.Parent() != neg || neg.Instructions[0] != n {
+		t.Errorf("MoveBefore left %%n in %%%s", n.Parent().Name())
+	}
+	checkValid(t, fn)
+
+	// Erasing the terminator of entry drops its edges and phi entries
+	entry.Terminator().EraseFromParent()
+	phi := join.Instructions[0].(*ir.PhiInst)
+	if len(entry.Successors) != 0 || len(neg.Predecessors) != 0 || len(phi.Incoming) != 2 {
+		t.Errorf("edges of entry not removed: %d successors, %d phi entries", len(entry.Successors), len(phi.Incoming))
+	}
+	if c := entry.Instructions[0]; c.NumUses() != 0 {
+		t.Errorf("%%c still has %d uses after erasing the branch", c.NumUses())
+	}
+}
+
+const moveSrc = `
+define external i32 @g(i1 %c, i32 %x) {
+entry:
+  %a = add i32 %x, 1
+  %b = mul i32 %a, 2
+  br i1 %c, label %then, label %join
+then:
+  br label %join
+join:
+  %p = phi i32 [ %a, %entry ], [ %x, %then ]
+  %q = phi i32 [ %b, %entry ], [ %x, %then ]
+  %s = add i32 %p, %q
+  ret i32 %s
+}
+`
+
+// instNames returns the names of the instructions of b
+func instNames(b *ir.BasicBlock) string {
+	var list []string
+	for _, inst := range b.Instructions {
+		list = append(list, inst.Name())
+	}
+	return strings.Join(list, ",")
+}
+
+func TestMoveToItself(t *testing.T) {
+	fn := parseFunc(t, moveSrc)
+	entry := fn.EntryBlock()
+	a := entry.Instructions[0]
+	a.MoveAfter(a)
+	a.MoveBefore(a)
+	if got := instNames(entry); got != "a,b," {
+		t.Errorf("entry holds %s after moving %%a to itself", got)
+	}
+	checkValid(t, fn)
+}
+
+func TestMovePhis(t *testing.T) {
+	fn := parseFunc(t, moveSrc)
+	join := blockNamed(fn, "join")
+	p, q, s := join.Instructions[0], join.Instructions[1], join.Instructions[2]
+
+	// Phis may be reordered among themselves
+	p.MoveAfter(q)
+	if got := instNames(join); got != "q,p,s," {
+		t.Errorf("join holds %s after moving %%p after %%q", got)
+	}
+	p.MoveBefore(q)
+	q.MoveBefore(s)
+	if got := instNames(join); got != "p,q,s," {
+		t.Errorf("join holds %s after moving %%q before %%s", got)
+	}
+	checkValid(t, fn)
+}
+
+func TestInvalidMoves(t *testing.T) {
+	fn := parseFunc(t, moveSrc)
+	entry, join := fn.EntryBlock(), blockNamed(fn, "join")
+	a, b := entry.Instructions[0], entry.Instructions[1]
+	p, q, s := join.Instructions[0], join.Instructions[1], join.Instructions[2]
+	loose := &ir.BinaryInst{}
+	loose.Op = ir.OpAdd
+	loose.SetName("loose")
+
+	tests := []struct {
+		name string
+		move func()
+	}{
+		{"before an instruction outside any block", func() { a.MoveBefore(loose) }},
+		{"after an instruction outside any block", func() { a.MoveAfter(loose) }},
+		{"after a terminator", func() { a.MoveAfter(entry.Terminator()) }},
+		{"a non-phi before a phi", func() { b.MoveBefore(p) }},
+		{"a non-phi between phis", func() { s.MoveAfter(p) }},
+		{"a phi after a non-phi", func() { q.MoveAfter(s) }},
+		{"a phi into another block", func() { p.MoveAfter(a) }},
+		{"a terminator", func() { entry.Terminator().MoveBefore(a) }},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("moving %s did not panic", tt.name)
+				}
+			}()
+			tt.move()
+		}()
+		// A rejected move leaves the function alone
+		if got := instNames(entry); got != "a,b," {
+			t.Errorf("after moving %s entry holds %s", tt.name, got)
+		}
+		if got := instNames(join); got != "p,q,s," {
+			t.Errorf("after moving %s join holds %s", tt.name, got)
+		}
+	}
+	checkValid(t, fn)
+}
@@ -0,0 +1,125 @@
+package ir_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// record is { i32, [4 x { i8, <4 x float> }] }
+var (
+	inner  = types.NewStruct("", []types.Type{types.I8, types.NewVector(types.F32, 4)}, false)
+	record = types.NewStruct("", []types.Type{types.I32, types.NewArray(inner, 4)}, false)
+)
+
+func index(v int64) ir.Value {
+	return &ir.ConstantInt{Value: v}
+}
+
+func TestGEPIndexedType(t *testing.T) {
+	tests := []struct {
+		indices []ir.Value
+		want    types.Type
+	}{
+		{nil, record},
+		{[]ir.Value{index(3)}, record},
+		{[]ir.Value{index(0), index(0)}, types.I32},
+		{[]ir.Value{index(0), index(1)}, types.NewArray(inner, 4)},
+		{[]ir.Value{index(0), index(1), index(2)}, inner},
+		{[]ir.Value{index(0), index(1), index(2), index(1)}, types.NewVector(types.F32, 4)},
+		{[]ir.Value{index(0), index(1), index(2), index(1), index(3)}, types.F32},
+	}
+	for _, tt := range tests {
+		got, err := ir.GEPIndexedType(record, tt.indices)
+		if err != nil {
+			t.Errorf("%v: %v", tt.indices, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%v: got %s, want %s", tt.indices, got, tt.want)
+		}
+	}
+}
+
+func TestGEPIndexedTypeErrors(t *testing.T) {
+	b := builder.New()
+	b.CreateModule("test")
+	fn := b.CreateFunction("f", types.Void, []types.Type{types.I32}, false)
+
+	tests := []struct {
+		indices []ir.Value
+		msg     string
+	}{
+		{[]ir.Value{index(0), fn.Arguments[0]}, "is not a constant"},
+		{[]ir.Value{index(0), index(2)}, "field index 2 out of range"},
+		{[]ir.Value{index(0), index(-1)}, "field index -1 out of range"},
+		{[]ir.Value{index(0), index(0), index(0)}, "cannot index into i32"},
+	}
+	for _, tt := range tests {
+		_, err := ir.GEPIndexedType(record, tt.indices)
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%v: got error %v, want %q", tt.indices, err, tt.msg)
+		}
+	}
+}
+
+func TestAggregateIndexedType(t *testing.T) {
+	got, err := ir.AggregateIndexedType(record, []int{1, 3, 1, 0})
+	if err != nil || !got.Equal(types.F32) {
+		t.Errorf("got %v, %v, want float", got, err)
+	}
+
+	tests := []struct {
+		indices []int
+		msg     string
+	}{
+		{[]int{2}, "field index 2 out of range"},
+		{[]int{1, 4}, "element index 4 out of range"},
+		{[]int{1, 0, 1, 4}, "element index 4 out of range"},
+		{[]int{0, 0}, "cannot index into i32"},
+	}
+	for _, tt := range tests {
+		_, err := ir.AggregateIndexedType(record, tt.indices)
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%v: got error %v, want %q", tt.indices, err, tt.msg)
+		}
+	}
+}
+
+func TestBuilderGEPTypes(t *testing.T) {
+	b := builder.New()
+	b.CreateModule("test")
+	fn := b.CreateFunction("f", types.Void, []types.Type{types.NewPointer(record)}, false)
+	p := fn.Arguments[0]
+	b.SetInsertPoint(b.CreateBlock("entry"))
+
+	field := b.CreateStructGEP(record, p, 1, "field")
+	if want := types.NewPointer(types.NewArray(inner, 4)); !field.Type().Equal(want) {
+		t.Errorf("struct gep has type %s, want %s", field.Type(), want)
+	}
+	elem := b.CreateGEP(record, p, []ir.Value{b.ConstInt(types.I64, 0), b.ConstInt(types.I32, 1),
+		b.ConstInt(types.I64, 2), b.ConstInt(types.I32, 0)}, "elem")
+	if want := types.NewPointer(types.I8); !elem.Type().Equal(want) {
+		t.Errorf("gep has type %s, want %s", elem.Type(), want)
+	}
+
+	agg := b.CreateLoad(record, p, "agg")
+	ext := b.CreateExtractValue(agg, []int{1, 2}, "ext")
+	if !ext.Type().Equal(inner) {
+		t.Errorf("extractvalue has type %s, want %s", ext.Type(), inner)
+	}
+	ins := b.CreateInsertValue(agg, b.ConstInt(types.I32, 7), []int{0}, "ins")
+	if !ins.Type().Equal(record) {
+		t.Errorf("insertvalue has type %s, want %s", ins.Type(), record)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "invalid getelementptr") {
+			t.Errorf("out of range struct gep: got panic %v", r)
+		}
+	}()
+	b.CreateStructGEP(record, p, 2, "bad")
+}
@@ -220,6 +220,65 @@ func (i *GetElementPtrInst) String() string {
 		strings.Join(indices, ", "))
 }
 
+// GEPIndexedType returns the type a getelementptr with the given source
+// element type and indices points to. The first index steps over whole
+// values of the source type; each later one selects a struct field, which
+// must be a constant in range, or an array or vector element.
+func GEPIndexedType(source types.Type, indices []Value) (types.Type, error) {
+	t := source
+	for n, idx := range indices {
+		if n == 0 {
+			continue
+		}
+		switch ty := t.(type) {
+		case *types.StructType:
+			c, ok := idx.(*ConstantInt)
+			if !ok {
+				return nil, fmt.Errorf("struct index into %s is not a constant", t)
+			}
+			if c.Value < 0 || c.Value >= int64(len(ty.Fields)) {
+				return nil, fmt.Errorf("field index %d out of range for %s", c.Value, t)
+			}
+			t = ty.Fields[c.Value]
+		case *types.ArrayType:
+			t = ty.ElementType
+		case *types.VectorType:
+			t = ty.ElementType
+		default:
+			return nil, fmt.Errorf("cannot index into %s", t)
+		}
+	}
+	return t, nil
+}
+
+// AggregateIndexedType returns the type of the member of agg selected by
+// the constant indices of an extractvalue or insertvalue
+func AggregateIndexedType(agg types.Type, indices []int) (types.Type, error) {
+	t := agg
+	for _, idx := range indices {
+		switch ty := t.(type) {
+		case *types.StructType:
+			if idx < 0 || idx >= len(ty.Fields) {
+				return nil, fmt.Errorf("field index %d out of range for %s", idx, t)
+			}
+			t = ty.Fields[idx]
+		case *types.ArrayType:
+			if idx < 0 || int64(idx) >= ty.Length {
+				return nil, fmt.Errorf("element index %d out of range for %s", idx, t)
+			}
+			t = ty.ElementType
+		case *types.VectorType:
+			if idx < 0 || idx >= ty.Length {
+				return nil, fmt.Errorf("element index %d out of range for %s", idx, t)
+			}
+			t = ty.ElementType
+		default:
+			return nil, fmt.Errorf("cannot index into %s", t)
+		}
+	}
+	return t, nil
+}
+
 // CastInst represents type conversion operations
 type CastInst struct {
 	BaseInstruction
@@ -281,6 +340,7 @@ func (i *PhiInst) String() string {
 
 func (i *PhiInst) AddIncoming(v Value, b *BasicBlock) {
 	i.Incoming = append(i.Incoming, PhiIncoming{Value: v, Block: b})
+	i.BaseInstruction.SetOperand(len(i.Incoming)-1, v)
 }
 
 // SelectInst represents a select (ternary) operation
@@ -301,7 +361,7 @@ func (i *SelectInst) String() string {
 // CallInst represents a function call
 type CallInst struct {
 	BaseInstruction
-	Callee     *Function
+	Callee     *Function // set through SetCallee, which records the use
 	CalleeName string
 	// For indirect calls or declarations
 	IsTailCall bool
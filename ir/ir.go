@@ -15,6 +15,9 @@ type Value interface {
 	Name() string
 	SetName(string)
 	String() string
+	Uses() []*Use
+	NumUses() int
+	ReplaceAllUsesWith(Value)
 }
 
 // User is a Value that references other Values
@@ -32,6 +35,9 @@ type Instruction interface {
 	Parent() *BasicBlock
 	SetParent(*BasicBlock)
 	IsTerminator() bool
+	EraseFromParent()
+	MoveBefore(Instruction)
+	MoveAfter(Instruction)
 }
 
 // Opcode represents the operation type
@@ -222,6 +228,7 @@ func (p FCmpPredicate) String() string { return fcmpNames[p] }
 type BaseValue struct {
 	ValName string
 	ValType types.Type
+	uses    []*Use
 }
 
 func (v *BaseValue) Name() string       { return v.ValName }
@@ -235,6 +242,7 @@ type BaseInstruction struct {
 	Ops    []Value
 	Parent_ *BasicBlock
 	Op     Opcode
+	self   Instruction // the concrete instruction, bound when added to a block
 }
 
 func (i *BaseInstruction) Opcode() Opcode           { return i.Op }
@@ -247,8 +255,17 @@ func (i *BaseInstruction) SetOperand(idx int, v Value) {
 	for len(i.Ops) <= idx {
 		i.Ops = append(i.Ops, nil)
 	}
+	if old := i.Ops[idx]; old != nil {
+		removeUse(old, i, idx)
+	}
 	i.Ops[idx] = v
+	if v != nil {
+		addUse(v, &Use{user: i, Index: idx})
+	}
 }
+// base gives package code access to the embedded BaseInstruction of any
+// concrete instruction type
+func (i *BaseInstruction) base() *BaseInstruction { return i }
 func (i *BaseInstruction) IsTerminator() bool {
 	switch i.Op {
 	case OpRet, OpBr, OpCondBr, OpSwitch, OpUnreachable:
@@ -449,10 +466,20 @@ func (b *BasicBlock) String() string {
 }
 
 func (b *BasicBlock) AddInstruction(inst Instruction) {
+	bind(inst)
 	inst.SetParent(b)
 	b.Instructions = append(b.Instructions, inst)
 }
 
+// InsertInstruction inserts inst before position idx of the block
+func (b *BasicBlock) InsertInstruction(idx int, inst Instruction) {
+	bind(inst)
+	inst.SetParent(b)
+	b.Instructions = append(b.Instructions, nil)
+	copy(b.Instructions[idx+1:], b.Instructions[idx:])
+	b.Instructions[idx] = inst
+}
+
 func (b *BasicBlock) Terminator() Instruction {
 	if len(b.Instructions) == 0 {
 		return nil
@@ -486,6 +513,24 @@ const (
 	AttrNoInline
 )
 
+func (a FuncAttribute) String() string {
+	switch a {
+	case AttrNoReturn:
+		return "noreturn"
+	case AttrNoUnwind:
+		return "nounwind"
+	case AttrReadOnly:
+		return "readonly"
+	case AttrReadNone:
+		return "readnone"
+	case AttrAlwaysInline:
+		return "alwaysinline"
+	case AttrNoInline:
+		return "noinline"
+	}
+	return ""
+}
+
 func NewFunction(name string, fnType *types.FunctionType) *Function {
 	f := &Function{
 		BaseValue: BaseValue{ValName: name, ValType: fnType},
@@ -552,20 +597,7 @@ func (f *Function) String() string {
 	// Attributes
 	for _, attr := range f.Attributes {
 		sb.WriteString(" ")
-		switch attr {
-		case AttrNoReturn:
-			sb.WriteString("noreturn")
-		case AttrNoUnwind:
-			sb.WriteString("nounwind")
-		case AttrReadOnly:
-			sb.WriteString("readonly")
-		case AttrReadNone:
-			sb.WriteString("readnone")
-		case AttrAlwaysInline:
-			sb.WriteString("alwaysinline")
-		case AttrNoInline:
-			sb.WriteString("noinline")
-		}
+		sb.WriteString(attr.String())
 	}
 	
 	if len(f.Blocks) > 0 {
@@ -596,6 +628,12 @@ func NewModule(name string) *Module {
 	}
 }
 
+// Layout parses the module's DataLayout. A module without one uses
+// types.DefaultDataLayout.
+func (m *Module) Layout() (*types.DataLayout, error) {
+	return types.ParseDataLayout(m.DataLayout)
+}
+
 func (m *Module) AddFunction(f *Function) {
 	f.Parent = m
 	m.Functions = append(m.Functions, f)
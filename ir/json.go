@@ -0,0 +1,1004 @@
+// Package ir - JSON export and import
+package ir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/arc-language/core-builder/types"
+)
+
+// JSONVersion is the version of the JSON schema written by WriteJSON.
+//
+// A module is an object with "version", "name", "datalayout", "triple",
+// "types" (named struct definitions), "globals" and "functions".
+//
+// Types are objects tagged by "kind": void, label, int (bits, signed),
+// float (bits), ptr (elem, addrspace), array (elem, length), struct
+// (name for a reference to a named type, otherwise fields and packed),
+// fn (ret, params, variadic) and vector (elem, length, scalable).
+//
+// Function arguments and instructions carry an "id" unique within their
+// function; arguments are numbered first, then instructions in block
+// order. Operands are objects tagged by "ref": local (id), global (name),
+// function (name) or const (const). Constants are tagged by "kind": int,
+// float, null, undef, zero, array and struct, and always carry their
+// type. Non-finite floats are encoded as the strings "NaN", "+Inf" and
+// "-Inf". Blocks are referenced by their index in the function.
+//
+// Instructions carry "opcode" (the textual opcode, with "condbr" for
+// conditional branches), "name", "type" and "operands", plus the
+// opcode-specific fields of jsonInst.
+const JSONVersion = 1
+
+type jsonModule struct {
+	Version    int            `json:"version"`
+	Name       string         `json:"name"`
+	DataLayout string         `json:"datalayout,omitempty"`
+	Triple     string         `json:"triple,omitempty"`
+	Types      []jsonTypeDef  `json:"types"`
+	Globals    []jsonGlobal   `json:"globals"`
+	Functions  []jsonFunction `json:"functions"`
+}
+
+type jsonTypeDef struct {
+	Name   string      `json:"name"`
+	Fields []*jsonType `json:"fields"`
+	Packed bool        `json:"packed,omitempty"`
+}
+
+type jsonType struct {
+	Kind      string      `json:"kind"`
+	Bits      int         `json:"bits,omitempty"`
+	Signed    bool        `json:"signed,omitempty"`
+	Elem      *jsonType   `json:"elem,omitempty"`
+	AddrSpace int         `json:"addrspace,omitempty"`
+	Length    int64       `json:"length,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Fields    []*jsonType `json:"fields,omitempty"`
+	Packed    bool        `json:"packed,omitempty"`
+	Ret       *jsonType   `json:"ret,omitempty"`
+	Params    []*jsonType `json:"params,omitempty"`
+	Variadic  bool        `json:"variadic,omitempty"`
+	Scalable  bool        `json:"scalable,omitempty"`
+}
+
+type jsonGlobal struct {
+	Name      string        `json:"name"`
+	Type      *jsonType     `json:"type"`
+	Linkage   string        `json:"linkage"`
+	Constant  bool          `json:"constant,omitempty"`
+	AddrSpace int           `json:"addrspace,omitempty"`
+	Init      *jsonConstant `json:"init,omitempty"`
+}
+
+type jsonFunction struct {
+	Name       string      `json:"name"`
+	Type       *jsonType   `json:"type"`
+	Linkage    string      `json:"linkage"`
+	Attributes []string    `json:"attributes,omitempty"`
+	Args       []jsonArg   `json:"args"`
+	Blocks     []jsonBlock `json:"blocks"`
+}
+
+type jsonArg struct {
+	ID   int       `json:"id"`
+	Name string    `json:"name,omitempty"`
+	Type *jsonType `json:"type"`
+}
+
+type jsonBlock struct {
+	Name         string     `json:"name"`
+	Preds        []int      `json:"preds"`
+	Succs        []int      `json:"succs"`
+	Instructions []jsonInst `json:"instructions"`
+}
+
+type jsonInst struct {
+	ID       int          `json:"id"`
+	Opcode   string       `json:"opcode"`
+	Name     string       `json:"name,omitempty"`
+	Type     *jsonType    `json:"type,omitempty"`
+	Operands []*jsonValue `json:"operands"`
+
+	// Branches and switches
+	Target    *int         `json:"target,omitempty"`
+	Condition *jsonValue   `json:"condition,omitempty"`
+	True      *int         `json:"true,omitempty"`
+	False     *int         `json:"false,omitempty"`
+	Default   *int         `json:"default,omitempty"`
+	Cases     []jsonCase   `json:"cases,omitempty"`
+	Incoming  []jsonPhiArm `json:"incoming,omitempty"`
+
+	// Binary operation flags
+	NSW   bool `json:"nsw,omitempty"`
+	NUW   bool `json:"nuw,omitempty"`
+	Exact bool `json:"exact,omitempty"`
+
+	// Memory operations
+	AllocatedType *jsonType  `json:"allocated_type,omitempty"`
+	Count         *jsonValue `json:"count,omitempty"`
+	Align         int        `json:"align,omitempty"`
+	Volatile      bool       `json:"volatile,omitempty"`
+	SourceType    *jsonType  `json:"source_type,omitempty"`
+	InBounds      bool       `json:"inbounds,omitempty"`
+
+	DestType   *jsonType `json:"dest_type,omitempty"`
+	ArgType    *jsonType `json:"arg_type,omitempty"`
+	Predicate  string    `json:"predicate,omitempty"`
+	Callee     string    `json:"callee,omitempty"`
+	CalleeName string    `json:"callee_name,omitempty"`
+	Tail       bool      `json:"tail,omitempty"`
+	Indices    []int     `json:"indices,omitempty"`
+}
+
+type jsonCase struct {
+	Value *jsonConstant `json:"value"`
+	Block int           `json:"block"`
+}
+
+type jsonPhiArm struct {
+	Value *jsonValue `json:"value"`
+	Block int        `json:"block"`
+}
+
+type jsonValue struct {
+	Ref   string        `json:"ref"`
+	ID    int           `json:"id,omitempty"`
+	Name  string        `json:"name,omitempty"`
+	Const *jsonConstant `json:"const,omitempty"`
+}
+
+type jsonConstant struct {
+	Kind     string          `json:"kind"`
+	Type     *jsonType       `json:"type"`
+	Int      int64           `json:"int,omitempty"`
+	Float    *jsonFloat      `json:"float,omitempty"`
+	Elements []*jsonConstant `json:"elements,omitempty"`
+}
+
+// jsonFloat encodes non-finite values as strings, which JSON numbers
+// cannot represent
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+}
+
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*f = jsonFloat(v)
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// jsonOpcodeName returns the schema name of an opcode, which unlike the
+// textual form distinguishes conditional from unconditional branches
+func jsonOpcodeName(op Opcode) string {
+	if op == OpCondBr {
+		return "condbr"
+	}
+	return op.String()
+}
+
+var linkageNames = map[string]Linkage{
+	"external":     ExternalLinkage,
+	"internal":     InternalLinkage,
+	"private":      PrivateLinkage,
+	"linkonce_odr": LinkOnceODRLinkage,
+	"weak_odr":     WeakODRLinkage,
+	"common":       CommonLinkage,
+}
+
+// ============================================================================
+// Export
+// ============================================================================
+
+// WriteJSON encodes a module using the schema described at JSONVersion
+func WriteJSON(w io.Writer, m *Module) error {
+	jm, err := moduleToJSON(m)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jm)
+}
+
+func typeToJSON(t types.Type) *jsonType {
+	switch ty := t.(type) {
+	case nil:
+		return nil
+	case *types.VoidType:
+		return &jsonType{Kind: "void"}
+	case *types.LabelType:
+		return &jsonType{Kind: "label"}
+	case *types.IntType:
+		return &jsonType{Kind: "int", Bits: ty.BitWidth, Signed: ty.Signed}
+	case *types.FloatType:
+		return &jsonType{Kind: "float", Bits: ty.BitWidth}
+	case *types.PointerType:
+		return &jsonType{Kind: "ptr", Elem: typeToJSON(ty.ElementType), AddrSpace: ty.AddressSpace}
+	case *types.ArrayType:
+		return &jsonType{Kind: "array", Elem: typeToJSON(ty.ElementType), Length: ty.Length}
+	case *types.StructType:
+		if ty.Name != "" {
+			return &jsonType{Kind: "struct", Name: ty.Name}
+		}
+		return &jsonType{Kind: "struct", Fields: typesToJSON(ty.Fields), Packed: ty.Packed}
+	case *types.FunctionType:
+		return &jsonType{Kind: "fn", Ret: typeToJSON(ty.ReturnType),
+			Params: typesToJSON(ty.ParamTypes), Variadic: ty.Variadic}
+	case *types.VectorType:
+		return &jsonType{Kind: "vector", Elem: typeToJSON(ty.ElementType),
+			Length: int64(ty.Length), Scalable: ty.Scalable}
+	}
+	return &jsonType{Kind: t.String()}
+}
+
+func typesToJSON(list []types.Type) []*jsonType {
+	out := make([]*jsonType, len(list))
+	for i, t := range list {
+		out[i] = typeToJSON(t)
+	}
+	return out
+}
+
+func constantToJSON(c Constant) (*jsonConstant, error) {
+	jc := &jsonConstant{Type: typeToJSON(c.Type())}
+	switch k := c.(type) {
+	case *ConstantInt:
+		jc.Kind = "int"
+		jc.Int = k.Value
+	case *ConstantFloat:
+		jc.Kind = "float"
+		f := jsonFloat(k.Value)
+		jc.Float = &f
+	case *ConstantNull:
+		jc.Kind = "null"
+	case *ConstantUndef:
+		jc.Kind = "undef"
+	case *ConstantZero:
+		jc.Kind = "zero"
+	case *ConstantArray:
+		jc.Kind = "array"
+		return jc, constantsToJSON(jc, k.Elements)
+	case *ConstantStruct:
+		jc.Kind = "struct"
+		return jc, constantsToJSON(jc, k.Fields)
+	default:
+		return nil, fmt.Errorf("ir: cannot export constant %T", c)
+	}
+	return jc, nil
+}
+
+func constantsToJSON(jc *jsonConstant, list []Constant) error {
+	jc.Elements = []*jsonConstant{}
+	for _, c := range list {
+		e, err := constantToJSON(c)
+		if err != nil {
+			return err
+		}
+		jc.Elements = append(jc.Elements, e)
+	}
+	return nil
+}
+
+func moduleToJSON(m *Module) (*jsonModule, error) {
+	jm := &jsonModule{
+		Version:    JSONVersion,
+		Name:       m.Name,
+		DataLayout: m.DataLayout,
+		Triple:     m.TargetTriple,
+		Types:      []jsonTypeDef{},
+		Globals:    []jsonGlobal{},
+		Functions:  []jsonFunction{},
+	}
+	for _, name := range sortedTypeNames(m) {
+		st := m.Types[name]
+		jm.Types = append(jm.Types, jsonTypeDef{Name: name, Fields: typesToJSON(st.Fields), Packed: st.Packed})
+	}
+	for _, g := range m.Globals {
+		jg := jsonGlobal{
+			Name:      g.ValName,
+			Type:      typeToJSON(g.ValType),
+			Linkage:   g.Linkage.String(),
+			Constant:  g.IsConstant,
+			AddrSpace: g.AddressSpace,
+		}
+		if g.Initializer != nil {
+			init, err := constantToJSON(g.Initializer)
+			if err != nil {
+				return nil, err
+			}
+			jg.Init = init
+		}
+		jm.Globals = append(jm.Globals, jg)
+	}
+	for _, f := range m.Functions {
+		jf, err := functionToJSON(f)
+		if err != nil {
+			return nil, fmt.Errorf("ir: function @%s: %v", f.ValName, err)
+		}
+		jm.Functions = append(jm.Functions, jf)
+	}
+	return jm, nil
+}
+
+type jsonExporter struct {
+	ids    map[Value]int
+	blocks map[*BasicBlock]int
+}
+
+func functionToJSON(f *Function) (jsonFunction, error) {
+	x := &jsonExporter{ids: make(map[Value]int), blocks: make(map[*BasicBlock]int)}
+	jf := jsonFunction{
+		Name:    f.ValName,
+		Type:    typeToJSON(f.FuncType),
+		Linkage: f.Linkage.String(),
+		Args:    []jsonArg{},
+		Blocks:  []jsonBlock{},
+	}
+	for _, a := range f.Attributes {
+		jf.Attributes = append(jf.Attributes, a.String())
+	}
+	for _, arg := range f.Arguments {
+		x.ids[arg] = len(x.ids)
+		jf.Args = append(jf.Args, jsonArg{ID: x.ids[arg], Name: arg.ValName, Type: typeToJSON(arg.ValType)})
+	}
+	for i, b := range f.Blocks {
+		x.blocks[b] = i
+		for _, inst := range b.Instructions {
+			x.ids[inst] = len(x.ids)
+		}
+	}
+	for _, b := range f.Blocks {
+		jb := jsonBlock{Name: b.ValName, Instructions: []jsonInst{}}
+		var err error
+		if jb.Preds, err = x.blockList(b.Predecessors); err != nil {
+			return jf, err
+		}
+		if jb.Succs, err = x.blockList(b.Successors); err != nil {
+			return jf, err
+		}
+		for _, inst := range b.Instructions {
+			ji, err := x.instruction(inst)
+			if err != nil {
+				return jf, fmt.Errorf("%%%s: %v", b.ValName, err)
+			}
+			jb.Instructions = append(jb.Instructions, ji)
+		}
+		jf.Blocks = append(jf.Blocks, jb)
+	}
+	return jf, nil
+}
+
+func (x *jsonExporter) block(b *BasicBlock) (int, error) {
+	idx, ok := x.blocks[b]
+	if !ok {
+		return 0, fmt.Errorf("branch to a block outside the function")
+	}
+	return idx, nil
+}
+
+func (x *jsonExporter) blockRef(b *BasicBlock) (*int, error) {
+	idx, err := x.block(b)
+	return &idx, err
+}
+
+func (x *jsonExporter) blockList(list []*BasicBlock) ([]int, error) {
+	out := []int{}
+	for _, b := range list {
+		idx, err := x.block(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, idx)
+	}
+	return out, nil
+}
+
+func (x *jsonExporter) value(v Value) (*jsonValue, error) {
+	switch val := v.(type) {
+	case nil:
+		return nil, nil
+	case *Global:
+		return &jsonValue{Ref: "global", Name: val.ValName}, nil
+	case *Function:
+		return &jsonValue{Ref: "function", Name: val.ValName}, nil
+	case Constant:
+		c, err := constantToJSON(val)
+		return &jsonValue{Ref: "const", Const: c}, err
+	}
+	id, ok := x.ids[v]
+	if !ok {
+		return nil, fmt.Errorf("operand %%%s is not defined in this function", v.Name())
+	}
+	return &jsonValue{Ref: "local", ID: id}, nil
+}
+
+func (x *jsonExporter) instruction(inst Instruction) (jsonInst, error) {
+	ji := jsonInst{
+		ID:       x.ids[inst],
+		Opcode:   jsonOpcodeName(inst.Opcode()),
+		Name:     inst.Name(),
+		Type:     typeToJSON(inst.Type()),
+		Operands: []*jsonValue{},
+	}
+	ops := inst.Operands()
+	if fieldOperands(inst) {
+		ops = nil
+	}
+	for _, op := range ops {
+		jv, err := x.value(op)
+		if err != nil {
+			return ji, err
+		}
+		ji.Operands = append(ji.Operands, jv)
+	}
+
+	var err error
+	switch i := inst.(type) {
+	case *BrInst:
+		ji.Target, err = x.blockRef(i.Target)
+	case *CondBrInst:
+		if ji.Condition, err = x.value(i.Condition); err != nil {
+			return ji, err
+		}
+		if ji.True, err = x.blockRef(i.TrueBlock); err != nil {
+			return ji, err
+		}
+		ji.False, err = x.blockRef(i.FalseBlock)
+	case *SwitchInst:
+		if ji.Condition, err = x.value(i.Condition); err != nil {
+			return ji, err
+		}
+		if ji.Default, err = x.blockRef(i.DefaultBlock); err != nil {
+			return ji, err
+		}
+		ji.Cases = []jsonCase{}
+		for _, c := range i.Cases {
+			jc := jsonCase{}
+			if jc.Value, err = constantToJSON(c.Value); err != nil {
+				return ji, err
+			}
+			if jc.Block, err = x.block(c.Block); err != nil {
+				return ji, err
+			}
+			ji.Cases = append(ji.Cases, jc)
+		}
+	case *BinaryInst:
+		ji.NSW, ji.NUW, ji.Exact = i.NoSignedWrap, i.NoUnsignedWrap, i.Exact
+	case *AllocaInst:
+		ji.AllocatedType = typeToJSON(i.AllocatedType)
+		ji.Align = i.Alignment
+		ji.Count, err = x.value(i.NumElements)
+	case *LoadInst:
+		ji.Volatile, ji.Align = i.Volatile, i.Alignment
+	case *StoreInst:
+		ji.Volatile, ji.Align = i.Volatile, i.Alignment
+	case *GetElementPtrInst:
+		ji.SourceType = typeToJSON(i.SourceElementType)
+		ji.InBounds = i.InBounds
+	case *CastInst:
+		ji.DestType = typeToJSON(i.DestType)
+	case *ICmpInst:
+		ji.Predicate = i.Predicate.String()
+	case *FCmpInst:
+		ji.Predicate = i.Predicate.String()
+	case *PhiInst:
+		ji.Incoming = []jsonPhiArm{}
+		for _, inc := range i.Incoming {
+			arm := jsonPhiArm{}
+			if arm.Value, err = x.value(inc.Value); err != nil {
+				return ji, err
+			}
+			if arm.Block, err = x.block(inc.Block); err != nil {
+				return ji, err
+			}
+			ji.Incoming = append(ji.Incoming, arm)
+		}
+	case *CallInst:
+		if i.Callee != nil {
+			ji.Callee = i.Callee.ValName
+		}
+		ji.CalleeName = i.CalleeName
+		ji.Tail = i.IsTailCall
+	case *ExtractValueInst:
+		ji.Indices = i.Indices
+	case *InsertValueInst:
+		ji.Indices = i.Indices
+	case *VaArgInst:
+		ji.ArgType = typeToJSON(i.ArgType)
+	}
+	return ji, err
+}
+
+// ============================================================================
+// Import
+// ============================================================================
+
+// ReadJSON decodes a module written by WriteJSON
+func ReadJSON(r io.Reader) (*Module, error) {
+	var jm jsonModule
+	if err := json.NewDecoder(r).Decode(&jm); err != nil {
+		return nil, err
+	}
+	if jm.Version != JSONVersion {
+		return nil, fmt.Errorf("ir: unsupported JSON IR version %d", jm.Version)
+	}
+	im := &jsonImporter{mod: NewModule(jm.Name)}
+	if err := im.module(&jm); err != nil {
+		return nil, err
+	}
+	return im.mod, nil
+}
+
+type jsonImporter struct {
+	mod *Module
+
+	// Per-function state
+	locals map[int]Value
+	blocks []*BasicBlock
+}
+
+func (im *jsonImporter) namedType(name string) *types.StructType {
+	if st, ok := im.mod.Types[name]; ok {
+		return st
+	}
+	st := types.NewStruct(name, []types.Type{}, false)
+	im.mod.Types[name] = st
+	return st
+}
+
+func (im *jsonImporter) typ(jt *jsonType) (types.Type, error) {
+	if jt == nil {
+		return nil, nil
+	}
+	switch jt.Kind {
+	case "void":
+		return types.Void, nil
+	case "label":
+		return types.Label, nil
+	case "int":
+		return types.NewInt(jt.Bits, jt.Signed), nil
+	case "float":
+		return types.NewFloat(jt.Bits), nil
+	case "ptr":
+		elem, err := im.requiredType(jt.Elem)
+		return types.NewPointerWithAddressSpace(elem, jt.AddrSpace), err
+	case "array":
+		elem, err := im.requiredType(jt.Elem)
+		return types.NewArray(elem, jt.Length), err
+	case "struct":
+		if jt.Name != "" {
+			return im.namedType(jt.Name), nil
+		}
+		fields, err := im.typeList(jt.Fields)
+		return types.NewStruct("", fields, jt.Packed), err
+	case "fn":
+		ret, err := im.requiredType(jt.Ret)
+		if err != nil {
+			return nil, err
+		}
+		params, err := im.typeList(jt.Params)
+		return types.NewFunction(ret, params, jt.Variadic), err
+	case "vector":
+		elem, err := im.requiredType(jt.Elem)
+		vec := types.NewVector(elem, int(jt.Length))
+		vec.Scalable = jt.Scalable
+		return vec, err
+	}
+	return nil, fmt.Errorf("ir: unknown type kind %q", jt.Kind)
+}
+
+func (im *jsonImporter) requiredType(jt *jsonType) (types.Type, error) {
+	if jt == nil {
+		return nil, fmt.Errorf("ir: missing type")
+	}
+	return im.typ(jt)
+}
+
+func (im *jsonImporter) typeList(list []*jsonType) ([]types.Type, error) {
+	out := []types.Type{}
+	for _, jt := range list {
+		t, err := im.requiredType(jt)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func (im *jsonImporter) constant(jc *jsonConstant) (Constant, error) {
+	if jc == nil {
+		return nil, fmt.Errorf("ir: missing constant")
+	}
+	typ, err := im.requiredType(jc.Type)
+	if err != nil {
+		return nil, err
+	}
+	base := BaseValue{ValType: typ}
+	switch jc.Kind {
+	case "int":
+		return &ConstantInt{BaseValue: base, Value: jc.Int}, nil
+	case "float":
+		c := &ConstantFloat{BaseValue: base}
+		if jc.Float != nil {
+			c.Value = float64(*jc.Float)
+		}
+		return c, nil
+	case "null":
+		return &ConstantNull{BaseValue: base}, nil
+	case "undef":
+		return &ConstantUndef{BaseValue: base}, nil
+	case "zero":
+		return &ConstantZero{BaseValue: base}, nil
+	case "array", "struct":
+		var elems []Constant
+		for _, e := range jc.Elements {
+			c, err := im.constant(e)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, c)
+		}
+		if jc.Kind == "array" {
+			return &ConstantArray{BaseValue: base, Elements: elems}, nil
+		}
+		return &ConstantStruct{BaseValue: base, Fields: elems}, nil
+	}
+	return nil, fmt.Errorf("ir: unknown constant kind %q", jc.Kind)
+}
+
+func (im *jsonImporter) module(jm *jsonModule) error {
+	m := im.mod
+	m.DataLayout = jm.DataLayout
+	m.TargetTriple = jm.Triple
+	for _, td := range jm.Types {
+		st := im.namedType(td.Name)
+		fields, err := im.typeList(td.Fields)
+		if err != nil {
+			return err
+		}
+		st.Fields = fields
+		st.Packed = td.Packed
+	}
+
+	for _, jg := range jm.Globals {
+		g := &Global{IsConstant: jg.Constant, AddressSpace: jg.AddrSpace}
+		g.ValName = jg.Name
+		typ, err := im.requiredType(jg.Type)
+		if err != nil {
+			return err
+		}
+		g.ValType = typ
+		if g.Linkage, err = linkageFromJSON(jg.Linkage); err != nil {
+			return err
+		}
+		if jg.Init != nil {
+			if g.Initializer, err = im.constant(jg.Init); err != nil {
+				return err
+			}
+		}
+		m.AddGlobal(g)
+	}
+
+	for _, jf := range jm.Functions {
+		typ, err := im.requiredType(jf.Type)
+		if err != nil {
+			return err
+		}
+		ft, ok := typ.(*types.FunctionType)
+		if !ok {
+			return fmt.Errorf("ir: function @%s does not have a function type", jf.Name)
+		}
+		f := NewFunction(jf.Name, ft)
+		if f.Linkage, err = linkageFromJSON(jf.Linkage); err != nil {
+			return err
+		}
+		for _, name := range jf.Attributes {
+			attr, ok := attributeFromJSON(name)
+			if !ok {
+				return fmt.Errorf("ir: unknown function attribute %q", name)
+			}
+			f.Attributes = append(f.Attributes, attr)
+		}
+		if len(jf.Args) != len(f.Arguments) {
+			return fmt.Errorf("ir: function @%s has %d args, its type has %d",
+				jf.Name, len(jf.Args), len(f.Arguments))
+		}
+		for i, ja := range jf.Args {
+			f.Arguments[i].ValName = ja.Name
+		}
+		m.AddFunction(f)
+	}
+	for i, jf := range jm.Functions {
+		if err := im.function(m.Functions[i], &jf); err != nil {
+			return fmt.Errorf("ir: function @%s: %v", jf.Name, err)
+		}
+	}
+	return nil
+}
+
+func linkageFromJSON(name string) (Linkage, error) {
+	if l, ok := linkageNames[name]; ok {
+		return l, nil
+	}
+	return 0, fmt.Errorf("ir: unknown linkage %q", name)
+}
+
+func attributeFromJSON(name string) (FuncAttribute, bool) {
+	for a := AttrNoReturn; a <= AttrNoInline; a++ {
+		if a.String() == name {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+func opcodeFromJSON(name string) (Opcode, bool) {
+	if name == "condbr" {
+		return OpCondBr, true
+	}
+	for op, n := range opcodeNames {
+		if n == name && op != OpCondBr {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+func (im *jsonImporter) function(f *Function, jf *jsonFunction) error {
+	im.locals = make(map[int]Value)
+	im.blocks = nil
+	for i, ja := range jf.Args {
+		im.locals[ja.ID] = f.Arguments[i]
+	}
+
+	// Create every block and instruction first so operands can refer forward
+	insts := make([][]Instruction, len(jf.Blocks))
+	for i, jb := range jf.Blocks {
+		b := NewBasicBlock(jb.Name)
+		f.AddBlock(b)
+		im.blocks = append(im.blocks, b)
+		for _, ji := range jb.Instructions {
+			op, ok := opcodeFromJSON(ji.Opcode)
+			if !ok {
+				return fmt.Errorf("unknown opcode %q", ji.Opcode)
+			}
+			inst := newInstruction(op)
+			inst.(interface{ base() *BaseInstruction }).base().Op = op
+			if _, dup := im.locals[ji.ID]; dup {
+				return fmt.Errorf("duplicate value id %d", ji.ID)
+			}
+			im.locals[ji.ID] = inst
+			insts[i] = append(insts[i], inst)
+		}
+	}
+
+	for i, jb := range jf.Blocks {
+		b := im.blocks[i]
+		var err error
+		if b.Predecessors, err = im.blockList(jb.Preds); err != nil {
+			return err
+		}
+		if b.Successors, err = im.blockList(jb.Succs); err != nil {
+			return err
+		}
+		for j := range jb.Instructions {
+			inst := insts[i][j]
+			if err := im.instruction(inst, &jb.Instructions[j]); err != nil {
+				return fmt.Errorf("%%%s: %v", jb.Name, err)
+			}
+			b.AddInstruction(inst)
+		}
+	}
+	return nil
+}
+
+func (im *jsonImporter) block(idx int) (*BasicBlock, error) {
+	if idx < 0 || idx >= len(im.blocks) {
+		return nil, fmt.Errorf("block index %d out of range", idx)
+	}
+	return im.blocks[idx], nil
+}
+
+func (im *jsonImporter) blockRef(idx *int) (*BasicBlock, error) {
+	if idx == nil {
+		return nil, fmt.Errorf("missing block reference")
+	}
+	return im.block(*idx)
+}
+
+func (im *jsonImporter) blockList(list []int) ([]*BasicBlock, error) {
+	var out []*BasicBlock
+	for _, idx := range list {
+		b, err := im.block(idx)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+func (im *jsonImporter) value(jv *jsonValue) (Value, error) {
+	if jv == nil {
+		return nil, nil
+	}
+	switch jv.Ref {
+	case "local":
+		if v, ok := im.locals[jv.ID]; ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("undefined value id %d", jv.ID)
+	case "global":
+		if g := im.mod.GetGlobal(jv.Name); g != nil {
+			return g, nil
+		}
+		return nil, fmt.Errorf("undefined global @%s", jv.Name)
+	case "function":
+		if f := im.mod.GetFunction(jv.Name); f != nil {
+			return f, nil
+		}
+		return nil, fmt.Errorf("undefined function @%s", jv.Name)
+	case "const":
+		return im.constant(jv.Const)
+	}
+	return nil, fmt.Errorf("unknown value reference %q", jv.Ref)
+}
+
+func (im *jsonImporter) instruction(inst Instruction, ji *jsonInst) error {
+	bi := inst.(interface{ base() *BaseInstruction }).base()
+	bi.ValName = ji.Name
+	var err error
+	if bi.ValType, err = im.typ(ji.Type); err != nil {
+		return err
+	}
+	for idx, jv := range ji.Operands {
+		v, err := im.value(jv)
+		if err != nil {
+			return err
+		}
+		inst.SetOperand(idx, v)
+	}
+
+	switch i := inst.(type) {
+	case *BrInst:
+		i.Target, err = im.blockRef(ji.Target)
+	case *CondBrInst:
+		var cond Value
+		if cond, err = im.value(ji.Condition); err != nil {
+			return err
+		}
+		i.SetOperand(0, cond)
+		if i.TrueBlock, err = im.blockRef(ji.True); err != nil {
+			return err
+		}
+		i.FalseBlock, err = im.blockRef(ji.False)
+	case *SwitchInst:
+		var cond Value
+		if cond, err = im.value(ji.Condition); err != nil {
+			return err
+		}
+		i.SetOperand(0, cond)
+		if i.DefaultBlock, err = im.blockRef(ji.Default); err != nil {
+			return err
+		}
+		for _, jc := range ji.Cases {
+			c, err := im.constant(jc.Value)
+			if err != nil {
+				return err
+			}
+			ci, ok := c.(*ConstantInt)
+			if !ok {
+				return fmt.Errorf("switch case is not an integer constant")
+			}
+			b, err := im.block(jc.Block)
+			if err != nil {
+				return err
+			}
+			i.Cases = append(i.Cases, SwitchCase{Value: ci, Block: b})
+		}
+	case *BinaryInst:
+		i.NoSignedWrap, i.NoUnsignedWrap, i.Exact = ji.NSW, ji.NUW, ji.Exact
+	case *AllocaInst:
+		if i.AllocatedType, err = im.typ(ji.AllocatedType); err != nil {
+			return err
+		}
+		i.Alignment = ji.Align
+		var count Value
+		if count, err = im.value(ji.Count); err != nil {
+			return err
+		}
+		if count != nil {
+			i.SetOperand(0, count)
+		}
+	case *LoadInst:
+		i.Volatile, i.Alignment = ji.Volatile, ji.Align
+	case *StoreInst:
+		i.Volatile, i.Alignment = ji.Volatile, ji.Align
+	case *GetElementPtrInst:
+		i.InBounds = ji.InBounds
+		i.SourceElementType, err = im.typ(ji.SourceType)
+	case *CastInst:
+		i.DestType, err = im.typ(ji.DestType)
+	case *ICmpInst:
+		pred, ok := lookupPredicate(icmpNames, ji.Predicate)
+		if !ok {
+			return fmt.Errorf("unknown icmp predicate %q", ji.Predicate)
+		}
+		i.Predicate = pred
+	case *FCmpInst:
+		pred, ok := lookupPredicate(fcmpNames, ji.Predicate)
+		if !ok {
+			return fmt.Errorf("unknown fcmp predicate %q", ji.Predicate)
+		}
+		i.Predicate = pred
+	case *PhiInst:
+		for _, arm := range ji.Incoming {
+			v, err := im.value(arm.Value)
+			if err != nil {
+				return err
+			}
+			b, err := im.block(arm.Block)
+			if err != nil {
+				return err
+			}
+			i.AddIncoming(v, b)
+		}
+	case *CallInst:
+		if ji.Callee != "" {
+			fn := im.mod.GetFunction(ji.Callee)
+			if fn == nil {
+				return fmt.Errorf("undefined function @%s", ji.Callee)
+			}
+			i.SetCallee(fn)
+		}
+		i.CalleeName = ji.CalleeName
+		i.IsTailCall = ji.Tail
+	case *ExtractValueInst:
+		i.Indices = ji.Indices
+	case *InsertValueInst:
+		i.Indices = ji.Indices
+	case *VaArgInst:
+		i.ArgType, err = im.typ(ji.ArgType)
+	}
+	return err
+}
+
+// lookupPredicate inverts a predicate name table
+func lookupPredicate[P comparable](names map[P]string, name string) (P, bool) {
+	for p, n := range names {
+		if n == name {
+			return p, true
+		}
+	}
+	var zero P
+	return zero, false
+}
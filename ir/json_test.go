@@ -0,0 +1,102 @@
+package ir_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/ir"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for name, m := range loadExamples(t) {
+		var buf bytes.Buffer
+		if err := ir.WriteJSON(&buf, m); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := ir.ReadJSON(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.String() != m.String() {
+			t.Errorf("%s: decoded module differs:\n%s\nwant:\n%s", name, got, m)
+		}
+		sameCFG(t, got, m)
+	}
+}
+
+// exportGCD returns the gcd example as generic JSON
+func exportGCD(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := ir.WriteJSON(&buf, loadExamples(t)["gcd"]); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// instruction returns instruction i of block b of the first function
+func instruction(doc map[string]interface{}, b, i int) map[string]interface{} {
+	fn := doc["functions"].([]interface{})[0].(map[string]interface{})
+	blk := fn["blocks"].([]interface{})[b].(map[string]interface{})
+	return blk["instructions"].([]interface{})[i].(map[string]interface{})
+}
+
+func TestJSONSchema(t *testing.T) {
+	doc := exportGCD(t)
+	if doc["version"] != float64(ir.JSONVersion) || doc["name"] != "gcd" {
+		t.Errorf("module header %v %v", doc["version"], doc["name"])
+	}
+	// Arguments take ids 0 and 1 and the branch of entry 2, so the first
+	// phi of loop.head is 3
+	phi := instruction(doc, 1, 0)
+	if phi["opcode"] != "phi" || phi["id"] != float64(3) || phi["name"] != "curr_a" {
+		t.Errorf("phi exported as %v", phi)
+	}
+	arm := phi["incoming"].([]interface{})[0].(map[string]interface{})
+	if arm["block"] != float64(0) {
+		t.Errorf("phi arm exported as %v", arm)
+	}
+	if br := instruction(doc, 1, 3); br["opcode"] != "condbr" {
+		t.Errorf("conditional branch exported with opcode %v", br["opcode"])
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(doc map[string]interface{})
+		want string
+	}{
+		{"version", func(doc map[string]interface{}) { doc["version"] = 99 }, "unsupported JSON IR version 99"},
+		{"opcode", func(doc map[string]interface{}) { instruction(doc, 2, 0)["opcode"] = "frob" }, "unknown opcode \"frob\""},
+		{"value", func(doc map[string]interface{}) {
+			ops := instruction(doc, 2, 0)["operands"].([]interface{})
+			ops[0].(map[string]interface{})["id"] = 77
+		}, "undefined value id 77"},
+		{"block", func(doc map[string]interface{}) { instruction(doc, 0, 0)["target"] = 9 }, "block index 9 out of range"},
+		{"type", func(doc map[string]interface{}) {
+			instruction(doc, 2, 0)["type"] = map[string]interface{}{"kind": "quux"}
+		}, "unknown type kind \"quux\""},
+	}
+	for _, tt := range tests {
+		doc := exportGCD(t)
+		tt.edit(doc)
+		data, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ir.ReadJSON(bytes.NewReader(data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err := ir.ReadJSON(strings.NewReader("{")); err == nil {
+		t.Errorf("truncated JSON decoded without error")
+	}
+}
@@ -0,0 +1,155 @@
+package ir
+
+// Use is an operand slot of an instruction that refers to a value. Every
+// value keeps the list of its uses up to date as operands are set through
+// SetOperand, PhiInst.AddIncoming, CallInst.SetCallee and the builder.
+type Use struct {
+	user  *BaseInstruction
+	Index int // position in the user's Operands, or CalleeIndex
+
+	call *CallInst // set for the use of a callee
+}
+
+// CalleeIndex is the Index of the use a call makes of the function it
+// calls. The callee is not one of the call's Operands, which are its
+// arguments.
+const CalleeIndex = -1
+
+// User returns the instruction holding the use. Instructions are bound to
+// their uses once they are added to a block; before that User returns nil.
+func (u *Use) User() Instruction { return u.user.self }
+
+// Value returns the value being used
+func (u *Use) Value() Value {
+	if u.call != nil {
+		return u.call.Callee
+	}
+	return u.user.Ops[u.Index]
+}
+
+// Set makes the operand refer to v instead. The callee of a call can only
+// be replaced by another function.
+func (u *Use) Set(v Value) {
+	if u.call != nil {
+		fn, ok := v.(*Function)
+		if !ok {
+			panic("the callee of a call must be a function")
+		}
+		u.call.SetCallee(fn)
+		return
+	}
+	if u.user.self != nil {
+		u.user.self.SetOperand(u.Index, v)
+	} else {
+		u.user.SetOperand(u.Index, v)
+	}
+}
+
+// Uses returns the operand slots referring to v, in the order they were set
+func (v *BaseValue) Uses() []*Use { return v.uses }
+
+// NumUses returns the number of operand slots referring to v
+func (v *BaseValue) NumUses() int { return len(v.uses) }
+
+// ReplaceAllUsesWith makes every operand referring to v refer to repl
+func (v *BaseValue) ReplaceAllUsesWith(repl Value) {
+	if r, ok := repl.(interface{ useList() *BaseValue }); ok && r.useList() == v {
+		return
+	}
+	for _, u := range append([]*Use(nil), v.uses...) {
+		u.Set(repl)
+	}
+}
+
+func (v *BaseValue) useList() *BaseValue { return v }
+
+func addUse(v Value, u *Use) {
+	if l, ok := v.(interface{ useList() *BaseValue }); ok {
+		bv := l.useList()
+		bv.uses = append(bv.uses, u)
+	}
+}
+
+func removeUse(v Value, user *BaseInstruction, idx int) {
+	l, ok := v.(interface{ useList() *BaseValue })
+	if !ok {
+		return
+	}
+	bv := l.useList()
+	for j, u := range bv.uses {
+		if u.user == user && u.Index == idx {
+			copy(bv.uses[j:], bv.uses[j+1:])
+			bv.uses[len(bv.uses)-1] = nil
+			bv.uses = bv.uses[:len(bv.uses)-1]
+			return
+		}
+	}
+}
+
+// bind records inst as the concrete instruction behind its uses
+func bind(inst Instruction) {
+	if b, ok := inst.(interface{ base() *BaseInstruction }); ok {
+		b.base().self = inst
+	}
+}
+
+// SetCallee sets the function called and records the call as a use of it
+func (i *CallInst) SetCallee(fn *Function) {
+	if i.Callee != nil {
+		removeUse(i.Callee, &i.BaseInstruction, CalleeIndex)
+	}
+	i.Callee = fn
+	if fn != nil {
+		addUse(fn, &Use{user: &i.BaseInstruction, Index: CalleeIndex, call: i})
+	}
+}
+
+// ============================================================================
+// Operands stored outside of Ops
+// ============================================================================
+
+// The branch and switch conditions, the alloca element count and the phi
+// incoming values are also operands: Ops holds them so that they have uses,
+// and SetOperand keeps the named fields in step.
+
+// fieldOperands reports whether every operand of inst is also held in a
+// named field. The serializers encode those fields and skip Ops.
+func fieldOperands(inst Instruction) bool {
+	switch inst.(type) {
+	case *CondBrInst, *SwitchInst, *AllocaInst, *PhiInst:
+		return true
+	}
+	return false
+}
+
+// SetOperand sets operand idx; operand 0 is the condition
+func (i *CondBrInst) SetOperand(idx int, v Value) {
+	i.BaseInstruction.SetOperand(idx, v)
+	if idx == 0 {
+		i.Condition = v
+	}
+}
+
+// SetOperand sets operand idx; operand 0 is the condition
+func (i *SwitchInst) SetOperand(idx int, v Value) {
+	i.BaseInstruction.SetOperand(idx, v)
+	if idx == 0 {
+		i.Condition = v
+	}
+}
+
+// SetOperand sets operand idx; operand 0 is the element count
+func (i *AllocaInst) SetOperand(idx int, v Value) {
+	i.BaseInstruction.SetOperand(idx, v)
+	if idx == 0 {
+		i.NumElements = v
+	}
+}
+
+// SetOperand sets operand idx, the value incoming from Incoming[idx].Block
+func (i *PhiInst) SetOperand(idx int, v Value) {
+	i.BaseInstruction.SetOperand(idx, v)
+	if idx < len(i.Incoming) {
+		i.Incoming[idx].Value = v
+	}
+}
@@ -0,0 +1,132 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// users returns the instructions holding the uses of v, in order
+func users(v ir.Value) []ir.Instruction {
+	var list []ir.Instruction
+	for _, u := range v.Uses() {
+		list = append(list, u.User())
+	}
+	return list
+}
+
+func TestUsesFollowSetOperand(t *testing.T) {
+	b := builder.New()
+	b.CreateModule("test")
+	fn := b.CreateFunction("f", types.I32, []types.Type{types.I32, types.I32}, false)
+	x, y := fn.Arguments[0], fn.Arguments[1]
+	b.SetInsertPoint(b.CreateBlock("entry"))
+	add := b.CreateAdd(x, x, "add")
+	ret := b.CreateRet(add)
+
+	if got := x.NumUses(); got != 2 {
+		t.Fatalf("x has %d uses, want 2", got)
+	}
+	add.SetOperand(1, y)
+	if got := x.NumUses(); got != 1 {
+		t.Errorf("after SetOperand x has %d uses, want 1", got)
+	}
+	if u := y.Uses(); len(u) != 1 || u[0].User() != add || u[0].Index != 1 || u[0].Value() != y {
+		t.Errorf("y has uses %v, want operand 1 of %%add", u)
+	}
+	if got := users(add); len(got) != 1 || got[0] != ret {
+		t.Errorf("add is used by %v, want the ret", got)
+	}
+}
+
+func TestReplaceAllUsesWith(t *testing.T) {
+	b := builder.New()
+	b.CreateModule("test")
+	fn := b.CreateFunction("f", types.I32, []types.Type{types.I32, types.I32}, false)
+	x, y := fn.Arguments[0], fn.Arguments[1]
+	b.SetInsertPoint(b.CreateBlock("entry"))
+	add := b.CreateAdd(x, x, "add")
+	mul := b.CreateMul(x, add, "mul")
+	b.CreateRet(mul)
+
+	x.ReplaceAllUsesWith(y)
+	if x.NumUses() != 0 || y.NumUses() != 3 {
+		t.Errorf("x has %d uses and y %d, want 0 and 3", x.NumUses(), y.NumUses())
+	}
+	if add.Ops[0] != y || add.Ops[1] != y || mul.Ops[0] != y || mul.Ops[1] != add {
+		t.Errorf("operands not replaced: add %v, mul %v", add.Ops, mul.Ops)
+	}
+
+	// Replacing a value by itself leaves its uses alone
+	y.ReplaceAllUsesWith(y)
+	if y.NumUses() != 3 {
+		t.Errorf("y has %d uses after replacing it by itself, want 3", y.NumUses())
+	}
+}
+
+func TestPhiUses(t *testing.T) {
+	b := builder.New()
+	b.CreateModule("test")
+	fn := b.CreateFunction("f", types.I32, []types.Type{types.I1, types.I32, types.I32}, false)
+	c, x, y := fn.Arguments[0], fn.Arguments[1], fn.Arguments[2]
+	entry := b.CreateBlock("entry")
+	then := b.CreateBlock("then")
+	join := b.CreateBlock("join")
+	b.SetInsertPoint(entry)
+	b.CreateCondBr(c, then, join)
+	b.SetInsertPoint(then)
+	b.CreateBr(join)
+	b.SetInsertPoint(join)
+	phi := b.CreatePhi(types.I32, "p")
+	phi.AddIncoming(x, entry)
+	phi.AddIncoming(x, then)
+	b.CreateRet(phi)
+
+	if got := users(x); len(got) != 2 || got[0] != phi || got[1] != phi {
+		t.Fatalf("x is used by %v, want the phi twice", got)
+	}
+	x.ReplaceAllUsesWith(y)
+	if phi.Incoming[0].Value != y || phi.Incoming[1].Value != y {
+		t.Errorf("incoming values not replaced: %v", phi.Incoming)
+	}
+	if phi.RemoveIncoming(entry) != y || y.NumUses() != 1 || y.Uses()[0].Index != 0 {
+		t.Errorf("after RemoveIncoming y has uses %v, want operand 0 of the phi", y.Uses())
+	}
+}
+
+func TestCalleeUses(t *testing.T) {
+	b := builder.New()
+	b.CreateModule("test")
+	callee := b.DeclareFunction("g", types.I32, []types.Type{types.I32}, false)
+	other := b.DeclareFunction("h", types.I32, []types.Type{types.I32}, false)
+	fn := b.CreateFunction("f", types.I32, []types.Type{types.I32}, false)
+	x := fn.Arguments[0]
+	b.SetInsertPoint(b.CreateBlock("entry"))
+	first := b.CreateCall(callee, []ir.Value{x}, "a")
+	second := b.CreateCall(callee, []ir.Value{first}, "b")
+	b.CreateRet(second)
+
+	if got := users(callee); len(got) != 2 || got[0] != first || got[1] != second {
+		t.Fatalf("g is used by %v, want both calls", got)
+	}
+	if u := callee.Uses()[0]; u.Index != ir.CalleeIndex || u.Value() != callee {
+		t.Errorf("callee use has index %d and value %v", u.Index, u.Value())
+	}
+	// The arguments are the operands; the callee is not among them
+	if x.NumUses() != 1 || len(first.Operands()) != 1 {
+		t.Errorf("x has %d uses and the call %d operands, want 1 and 1", x.NumUses(), len(first.Operands()))
+	}
+
+	callee.ReplaceAllUsesWith(other)
+	if callee.NumUses() != 0 || other.NumUses() != 2 || first.Callee != other || second.Callee != other {
+		t.Errorf("calls not redirected: g has %d uses, h %d", callee.NumUses(), other.NumUses())
+	}
+
+	second.ReplaceAllUsesWith(x)
+	second.EraseFromParent()
+	if other.NumUses() != 1 {
+		t.Errorf("h has %d uses after erasing a call, want 1", other.NumUses())
+	}
+}
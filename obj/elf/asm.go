@@ -0,0 +1,602 @@
+package elf
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/arc-language/core-builder/codegen/amd64"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// Generate writes m to w as an x86-64 relocatable object
+func Generate(w io.Writer, m *ir.Module) error {
+	f, err := Compile(m)
+	if err != nil {
+		return err
+	}
+	return Write(w, f)
+}
+
+// Compile lowers m to an x86-64 object file
+func Compile(m *ir.Module) (*File, error) {
+	var buf bytes.Buffer
+	if err := amd64.Generate(&buf, m); err != nil {
+		return nil, err
+	}
+	return Assemble(&buf)
+}
+
+// Assemble assembles x86-64 code in the subset of GNU assembler syntax the
+// amd64 backend produces: the section, symbol, alignment and data
+// directives it uses, labels, and AT&T instructions with register,
+// immediate, base plus displacement and %rip-relative operands. Branches
+// always use 32-bit displacements. Labels starting with .L stay out of the
+// symbol table.
+func Assemble(r io.Reader) (*File, error) {
+	a := &assembler{
+		file:     &File{Machine: elf.EM_X86_64},
+		sections: make(map[string]*Section),
+		symbols:  make(map[string]*Symbol),
+		labels:   make(map[string]label),
+		binding:  make(map[string]elf.SymBind),
+		secSyms:  make(map[*Section]*Symbol),
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		a.line++
+		a.text = strings.TrimSpace(sc.Text())
+		if err := a.statement(a.text); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	a.line = 0
+	if err := a.resolve(); err != nil {
+		return nil, err
+	}
+	return a.file, nil
+}
+
+type assembler struct {
+	file     *File
+	sec      *Section
+	sections map[string]*Section
+	symbols  map[string]*Symbol
+	labels   map[string]label       // every label defined so far
+	binding  map[string]elf.SymBind // set by .globl and .weak
+	secSyms  map[*Section]*Symbol
+	fixups   []fixup
+
+	line int
+	text string
+}
+
+type label struct {
+	sec *Section
+	off int64
+}
+
+// fixup is a 32-bit field computed from the address of a symbol plus
+// addend as relocation typ describes, patched or relocated once all labels
+// are known
+type fixup struct {
+	sec    *Section
+	off    int64
+	sym    string
+	addend int64
+	typ    elf.R_X86_64
+	line   int
+	text   string
+}
+
+func (a *assembler) errorf(format string, args ...interface{}) error {
+	return &Error{Line: a.line, Text: a.text, Msg: fmt.Sprintf(format, args...)}
+}
+
+// ============================================================================
+// Statements and directives
+// ============================================================================
+
+func (a *assembler) statement(s string) error {
+	s = stripComment(s)
+	if s == "" {
+		return nil
+	}
+	if i := strings.IndexByte(s, ':'); i > 0 && isSymbol(s[:i]) {
+		if err := a.define(s[:i]); err != nil {
+			return err
+		}
+		return a.statement(s[i+1:])
+	}
+	name, rest := s, ""
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		name, rest = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	if strings.HasPrefix(name, ".") {
+		return a.directive(name, splitOperands(rest))
+	}
+	if a.sec == nil {
+		return a.errorf("instruction outside a section")
+	}
+	if name == "rep" {
+		name, rest = "rep "+rest, ""
+	}
+	var ops []operand
+	for _, o := range splitOperands(rest) {
+		op, err := a.operand(o)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, op)
+	}
+	return a.instruction(name, ops)
+}
+
+// stripComment removes a # comment outside of string literals
+func stripComment(s string) string {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == '#' && !quoted:
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// splitOperands splits s at the commas outside parentheses and strings
+func splitOperands(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var list []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			list = append(list, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(list, strings.TrimSpace(s[start:]))
+}
+
+func isSymbol(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '_' || r == '.' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// isTemp reports whether name is an assembler-local label, which gets no
+// symbol of its own
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, ".L")
+}
+
+// section switches to the named section, creating it on first use
+func (a *assembler) section(name string, typ elf.SectionType, flags elf.SectionFlag) {
+	if s, ok := a.sections[name]; ok {
+		a.sec = s
+		return
+	}
+	s := &Section{Name: name, Type: typ, Flags: flags, Align: 1}
+	a.sections[name] = s
+	a.file.Sections = append(a.file.Sections, s)
+	a.sec = s
+}
+
+var knownSections = map[string]struct {
+	typ   elf.SectionType
+	flags elf.SectionFlag
+}{
+	".text":   {elf.SHT_PROGBITS, elf.SHF_ALLOC | elf.SHF_EXECINSTR},
+	".data":   {elf.SHT_PROGBITS, elf.SHF_ALLOC | elf.SHF_WRITE},
+	".rodata": {elf.SHT_PROGBITS, elf.SHF_ALLOC},
+	".bss":    {elf.SHT_NOBITS, elf.SHF_ALLOC | elf.SHF_WRITE},
+}
+
+// symbol returns the symbol called name, creating an undefined one
+func (a *assembler) symbol(name string) *Symbol {
+	s, ok := a.symbols[name]
+	if !ok {
+		s = &Symbol{Name: name}
+		a.symbols[name] = s
+		a.file.Symbols = append(a.file.Symbols, s)
+	}
+	return s
+}
+
+// sectionSymbol returns the symbol standing for the start of sec
+func (a *assembler) sectionSymbol(sec *Section) *Symbol {
+	s, ok := a.secSyms[sec]
+	if !ok {
+		s = &Symbol{Section: sec, Type: elf.STT_SECTION, Bind: elf.STB_LOCAL}
+		a.secSyms[sec] = s
+		a.file.Symbols = append(a.file.Symbols, s)
+	}
+	return s
+}
+
+func (a *assembler) define(name string) error {
+	if a.sec == nil {
+		return a.errorf("label outside a section")
+	}
+	if _, ok := a.labels[name]; ok {
+		return a.errorf("%s is already defined", name)
+	}
+	a.labels[name] = label{a.sec, a.sec.Len()}
+	if !isTemp(name) {
+		s := a.symbol(name)
+		s.Section, s.Value = a.sec, a.sec.Len()
+	}
+	return nil
+}
+
+func (a *assembler) directive(name string, args []string) error {
+	switch name {
+	case ".text", ".data", ".bss":
+		k := knownSections[name]
+		a.section(name, k.typ, k.flags)
+	case ".section":
+		if len(args) == 0 {
+			return a.errorf("missing section name")
+		}
+		k, ok := knownSections[args[0]]
+		if !ok {
+			k.typ = elf.SHT_PROGBITS
+		}
+		if len(args) > 1 {
+			k.flags = 0
+			for _, c := range strings.Trim(args[1], "\"") {
+				switch c {
+				case 'a':
+					k.flags |= elf.SHF_ALLOC
+				case 'w':
+					k.flags |= elf.SHF_WRITE
+				case 'x':
+					k.flags |= elf.SHF_EXECINSTR
+				default:
+					return a.errorf("unsupported section flag %q", c)
+				}
+			}
+		}
+		if len(args) > 2 {
+			switch args[2] {
+			case "@progbits":
+				k.typ = elf.SHT_PROGBITS
+			case "@nobits":
+				k.typ = elf.SHT_NOBITS
+			default:
+				return a.errorf("unsupported section type %s", args[2])
+			}
+		}
+		a.section(args[0], k.typ, k.flags)
+	case ".globl", ".global", ".weak", ".local":
+		bind := map[string]elf.SymBind{".globl": elf.STB_GLOBAL, ".global": elf.STB_GLOBAL, ".weak": elf.STB_WEAK, ".local": elf.STB_LOCAL}[name]
+		for _, s := range args {
+			if !isSymbol(s) {
+				return a.errorf("bad symbol name %q", s)
+			}
+			a.binding[s] = bind
+			a.symbol(s)
+		}
+	case ".type":
+		if len(args) != 2 {
+			return a.errorf("expected a symbol and a type")
+		}
+		switch args[1] {
+		case "@function":
+			a.symbol(args[0]).Type = elf.STT_FUNC
+		case "@object":
+			a.symbol(args[0]).Type = elf.STT_OBJECT
+		default:
+			return a.errorf("unsupported symbol type %s", args[1])
+		}
+	case ".size":
+		if len(args) != 2 {
+			return a.errorf("expected a symbol and a size")
+		}
+		n, err := a.expr(args[1])
+		if err != nil {
+			return err
+		}
+		a.symbol(args[0]).Size = n
+	case ".comm":
+		if len(args) < 2 {
+			return a.errorf("expected a symbol, a size and an alignment")
+		}
+		size, err := a.expr(args[1])
+		if err != nil {
+			return err
+		}
+		align := int64(1)
+		if len(args) > 2 {
+			if align, err = a.expr(args[2]); err != nil {
+				return err
+			}
+		}
+		s := a.symbol(args[0])
+		s.Common, s.Size, s.Value, s.Type = true, size, align, elf.STT_OBJECT
+		if _, ok := a.binding[args[0]]; !ok {
+			a.binding[args[0]] = elf.STB_GLOBAL
+		}
+	case ".p2align", ".balign":
+		if a.sec == nil || len(args) == 0 {
+			return a.errorf("misplaced alignment")
+		}
+		n, err := a.expr(args[0])
+		if err != nil {
+			return err
+		}
+		if name == ".p2align" {
+			n = 1 << uint(n)
+		}
+		a.align(n)
+	case ".byte", ".short", ".value", ".long", ".quad":
+		size := map[string]int{".byte": 1, ".short": 2, ".value": 2, ".long": 4, ".quad": 8}[name]
+		for _, s := range args {
+			v, err := a.expr(s)
+			if err != nil {
+				return err
+			}
+			var buf [8]byte
+			for i := range buf {
+				buf[i] = byte(uint64(v) >> (8 * uint(i)))
+			}
+			if err := a.data(buf[:size]); err != nil {
+				return err
+			}
+		}
+	case ".zero":
+		if len(args) != 1 {
+			return a.errorf("expected a size")
+		}
+		n, err := a.expr(args[0])
+		if err != nil {
+			return err
+		}
+		if a.sec != nil && a.sec.Type == elf.SHT_NOBITS {
+			a.sec.Size += n
+			return nil
+		}
+		return a.data(make([]byte, n))
+	case ".ascii", ".asciz", ".string":
+		for _, s := range args {
+			b, err := unquote(s)
+			if err != nil {
+				return a.errorf("%v", err)
+			}
+			if name != ".ascii" {
+				b = append(b, 0)
+			}
+			if err := a.data(b); err != nil {
+				return err
+			}
+		}
+	case ".file", ".ident":
+	default:
+		return a.errorf("unsupported directive %s", name)
+	}
+	return nil
+}
+
+// align pads the current section to a multiple of n bytes, with nops in
+// code
+func (a *assembler) align(n int64) {
+	a.sec.Align = max(a.sec.Align, n)
+	pad := (n - a.sec.Len()%n) % n
+	if a.sec.Type == elf.SHT_NOBITS {
+		a.sec.Size += pad
+		return
+	}
+	fill := byte(0)
+	if a.sec.Flags&elf.SHF_EXECINSTR != 0 {
+		fill = 0x90
+	}
+	for ; pad > 0; pad-- {
+		a.sec.Data = append(a.sec.Data, fill)
+	}
+}
+
+func (a *assembler) data(b []byte) error {
+	if a.sec == nil {
+		return a.errorf("data outside a section")
+	}
+	if a.sec.Type == elf.SHT_NOBITS {
+		for _, c := range b {
+			if c != 0 {
+				return a.errorf("nonzero data in %s", a.sec.Name)
+			}
+		}
+		a.sec.Size += int64(len(b))
+		return nil
+	}
+	a.sec.Data = append(a.sec.Data, b...)
+	return nil
+}
+
+// expr evaluates a sum of numbers and labels of the current section, where
+// "." is the current location. Differences of labels must be constant.
+func (a *assembler) expr(s string) (int64, error) {
+	var total int64
+	sign := int64(1)
+	if s = strings.TrimSpace(s); strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		end := strings.IndexAny(s[1:], "+-") + 1
+		if end == 0 {
+			end = len(s)
+		}
+		term := strings.TrimSpace(s[:end])
+		switch {
+		case term == "":
+		case term == ".":
+			total += sign * a.sec.Len()
+		case term[0] >= '0' && term[0] <= '9':
+			n, err := parseInt(term)
+			if err != nil {
+				return 0, a.errorf("bad number %s", term)
+			}
+			total += sign * n
+		default:
+			l, ok := a.labels[term]
+			if !ok || l.sec != a.sec {
+				return 0, a.errorf("%s is not a label of the current section", term)
+			}
+			total += sign * l.off
+		}
+		if end == len(s) {
+			break
+		}
+		sign = 1
+		if s[end] == '-' {
+			sign = -1
+		}
+		s = s[end+1:]
+	}
+	return total, nil
+}
+
+func parseInt(s string) (int64, error) {
+	if strings.HasPrefix(s, "-") {
+		n, err := strconv.ParseUint(s[1:], 0, 64)
+		return -int64(n), err
+	}
+	n, err := strconv.ParseUint(s, 0, 64)
+	return int64(n), err
+}
+
+// unquote decodes a string literal with the escapes of the GNU assembler
+func unquote(s string) ([]byte, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return nil, fmt.Errorf("bad string %s", s)
+	}
+	s = s[1 : len(s)-1]
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			out = append(out, c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			return nil, fmt.Errorf("bad escape at end of string")
+		}
+		switch c = s[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 't':
+			out = append(out, '\t')
+		case 'r':
+			out = append(out, '\r')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n := 0
+			for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; j++ {
+				n = n*8 + int(s[i]-'0')
+				i++
+			}
+			i--
+			out = append(out, byte(n))
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// ============================================================================
+// Symbols and relocations
+// ============================================================================
+
+// resolve patches the fixups against labels of their own section, turns the
+// rest into relocations and settles the binding of every symbol
+func (a *assembler) resolve() error {
+	for _, s := range a.file.Symbols {
+		if s.Type == elf.STT_SECTION {
+			continue
+		}
+		bind, ok := a.binding[s.Name]
+		switch {
+		case ok:
+			s.Bind = bind
+		case s.Section == nil && !s.Common:
+			s.Bind = elf.STB_GLOBAL
+		default:
+			s.Bind = elf.STB_LOCAL
+		}
+		if s.Bind == elf.STB_LOCAL && s.Section == nil {
+			return &Error{Msg: fmt.Sprintf("local symbol %s is not defined", s.Name)}
+		}
+	}
+
+	for _, fx := range a.fixups {
+		l, defined := a.labels[fx.sym]
+		local := isTemp(fx.sym) || defined && a.symbols[fx.sym].Bind == elf.STB_LOCAL
+		if isTemp(fx.sym) && !defined {
+			return &Error{Line: fx.line, Text: fx.text, Msg: fx.sym + " is not defined"}
+		}
+		pc := fx.typ == elf.R_X86_64_PC32 || fx.typ == elf.R_X86_64_PLT32
+		switch {
+		case local && pc && l.sec == fx.sec:
+			v := l.off + fx.addend - fx.off
+			putUint32(fx.sec.Data[fx.off:], uint32(v))
+		case local && fx.typ != elf.R_X86_64_GOTPCREL:
+			// Relocate against the section so the label can stay out of
+			// the symbol table
+			fx.sec.Relocs = append(fx.sec.Relocs, Reloc{
+				Offset: fx.off,
+				Symbol: a.sectionSymbol(l.sec),
+				Type:   uint32(elf.R_X86_64_PC32),
+				Addend: l.off + fx.addend,
+			})
+		default:
+			if isTemp(fx.sym) {
+				return &Error{Line: fx.line, Text: fx.text, Msg: "cannot reach " + fx.sym + " through the GOT"}
+			}
+			fx.sec.Relocs = append(fx.sec.Relocs, Reloc{
+				Offset: fx.off,
+				Symbol: a.symbols[fx.sym],
+				Type:   uint32(fx.typ),
+				Addend: fx.addend,
+			})
+		}
+	}
+	return nil
+}
+
+func putUint32(b []byte, v uint32) {
+	b[0], b[1], b[2], b[3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+}
@@ -0,0 +1,279 @@
+// Package elf writes IR modules as relocatable ELF64 object files, so they
+// can be handed to the system linker without going through an external
+// assembler.
+//
+// Generate lowers a module with the amd64 backend and assembles the result
+// in process: functions become machine code in .text, globals land in
+// .data, constants and pooled literals in .rodata and zero-initialized
+// globals in .bss. Globals with external linkage become global symbols,
+// linkonce_odr and weak_odr ones weak symbols, internal and private ones
+// local symbols, and common ones common symbols. Calls get R_X86_64_PLT32
+// relocations, references to globals R_X86_64_PC32 or, for symbols defined
+// elsewhere, R_X86_64_GOTPCREL relocations.
+//
+// The object model itself (File, Section, Symbol, Reloc) is independent of
+// the machine and is written by Write. Link combines x86-64 objects into a
+// static executable without the system linker.
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Error reports a line of assembly that cannot be encoded
+type Error struct {
+	Line int
+	Text string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("elf: line %d: %s: %s", e.Line, e.Text, e.Msg)
+	}
+	return "elf: " + e.Msg
+}
+
+// File is a relocatable object file
+type File struct {
+	Machine  elf.Machine
+	Sections []*Section
+	Symbols  []*Symbol
+}
+
+// Section is a section of an object file. NOBITS sections have a Size but
+// no Data.
+type Section struct {
+	Name   string
+	Type   elf.SectionType
+	Flags  elf.SectionFlag
+	Align  int64
+	Data   []byte
+	Size   int64
+	Relocs []Reloc
+}
+
+// Len returns the size of the section in bytes
+func (s *Section) Len() int64 {
+	if s.Type == elf.SHT_NOBITS {
+		return s.Size
+	}
+	return int64(len(s.Data))
+}
+
+// Symbol is an entry of the symbol table. Undefined symbols have no
+// Section; common symbols have none either and hold their alignment in
+// Value.
+type Symbol struct {
+	Name    string
+	Section *Section
+	Value   int64
+	Size    int64
+	Bind    elf.SymBind
+	Type    elf.SymType
+	Common  bool
+}
+
+// Reloc is a relocation of the bytes at Offset in its section
+type Reloc struct {
+	Offset int64
+	Symbol *Symbol
+	Type   uint32
+	Addend int64
+}
+
+// Write writes f to w as a little-endian ELF64 relocatable object
+func Write(w io.Writer, f *File) error {
+	var shstr, str strtab
+	shstr.add("")
+	str.add("")
+
+	// Local symbols must precede the others
+	syms := []*Symbol{nil}
+	for _, s := range f.Symbols {
+		if s.Bind == elf.STB_LOCAL {
+			syms = append(syms, s)
+		}
+	}
+	firstGlobal := len(syms)
+	for _, s := range f.Symbols {
+		if s.Bind != elf.STB_LOCAL {
+			syms = append(syms, s)
+		}
+	}
+	symIndex := make(map[*Symbol]int, len(syms))
+	for i, s := range syms {
+		if s != nil {
+			symIndex[s] = i
+		}
+	}
+	secIndex := make(map[*Section]int, len(f.Sections))
+	for i, s := range f.Sections {
+		secIndex[s] = i + 1
+	}
+
+	var headers []elf.Section64
+	var body bytes.Buffer
+	const dataStart = 64 // the file header
+	place := func(data []byte, align int64) uint64 {
+		for int64(body.Len()+dataStart)%max(align, 1) != 0 {
+			body.WriteByte(0)
+		}
+		off := uint64(body.Len() + dataStart)
+		body.Write(data)
+		return off
+	}
+	headers = append(headers, elf.Section64{})
+	for _, s := range f.Sections {
+		h := elf.Section64{
+			Name:      shstr.add(s.Name),
+			Type:      uint32(s.Type),
+			Flags:     uint64(s.Flags),
+			Size:      uint64(s.Len()),
+			Addralign: uint64(max(s.Align, 1)),
+		}
+		if s.Type == elf.SHT_NOBITS {
+			h.Off = uint64(body.Len() + dataStart)
+		} else {
+			h.Off = place(s.Data, s.Align)
+		}
+		headers = append(headers, h)
+	}
+
+	symtabIndex := len(f.Sections) + 1
+	for _, s := range relocSections(f) {
+		var data bytes.Buffer
+		for _, r := range s.Relocs {
+			idx, ok := symIndex[r.Symbol]
+			if !ok {
+				return &Error{Msg: fmt.Sprintf("%s: relocation against a symbol not in the symbol table", s.Name)}
+			}
+			binary.Write(&data, binary.LittleEndian, elf.Rela64{
+				Off:    uint64(r.Offset),
+				Info:   elf.R_INFO(uint32(idx), r.Type),
+				Addend: r.Addend,
+			})
+		}
+		symtabIndex++
+		headers = append(headers, elf.Section64{
+			Name:      shstr.add(".rela" + s.Name),
+			Type:      uint32(elf.SHT_RELA),
+			Flags:     uint64(elf.SHF_INFO_LINK),
+			Off:       place(data.Bytes(), 8),
+			Size:      uint64(data.Len()),
+			Info:      uint32(secIndex[s]),
+			Addralign: 8,
+			Entsize:   24,
+		})
+	}
+	// Now that the number of relocation sections is known, point them at
+	// the symbol table
+	for i := len(f.Sections) + 1; i < len(headers); i++ {
+		headers[i].Link = uint32(symtabIndex)
+	}
+
+	var symtab bytes.Buffer
+	for _, s := range syms {
+		var sym elf.Sym64
+		if s != nil {
+			sym = elf.Sym64{
+				Info:  elf.ST_INFO(s.Bind, s.Type),
+				Value: uint64(s.Value),
+				Size:  uint64(s.Size),
+			}
+			if s.Type != elf.STT_SECTION {
+				sym.Name = str.add(s.Name)
+			}
+			switch {
+			case s.Common:
+				sym.Shndx = uint16(elf.SHN_COMMON)
+			case s.Section != nil:
+				sym.Shndx = uint16(secIndex[s.Section])
+			}
+		}
+		binary.Write(&symtab, binary.LittleEndian, sym)
+	}
+	headers = append(headers, elf.Section64{
+		Name:      shstr.add(".symtab"),
+		Type:      uint32(elf.SHT_SYMTAB),
+		Off:       place(symtab.Bytes(), 8),
+		Size:      uint64(symtab.Len()),
+		Link:      uint32(symtabIndex + 1),
+		Info:      uint32(firstGlobal),
+		Addralign: 8,
+		Entsize:   24,
+	})
+	headers = append(headers, elf.Section64{
+		Name:      shstr.add(".strtab"),
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       place(str.data, 1),
+		Size:      uint64(len(str.data)),
+		Addralign: 1,
+	})
+	shstrName := shstr.add(".shstrtab")
+	headers = append(headers, elf.Section64{
+		Name:      shstrName,
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       place(shstr.data, 1),
+		Size:      uint64(len(shstr.data)),
+		Addralign: 1,
+	})
+	shoff := place(nil, 8)
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_REL),
+		Machine:   uint16(f.Machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shoff,
+		Ehsize:    dataStart,
+		Shentsize: 64,
+		Shnum:     uint16(len(headers)),
+		Shstrndx:  uint16(len(headers) - 1),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	hdr.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, hdr)
+	out.Write(body.Bytes())
+	binary.Write(&out, binary.LittleEndian, headers)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// relocSections returns the sections of f that have relocations
+func relocSections(f *File) []*Section {
+	var list []*Section
+	for _, s := range f.Sections {
+		if len(s.Relocs) > 0 {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// strtab builds a string table
+type strtab struct {
+	data  []byte
+	index map[string]uint32
+}
+
+func (t *strtab) add(s string) uint32 {
+	if off, ok := t.index[s]; ok {
+		return off
+	}
+	if t.index == nil {
+		t.index = make(map[string]uint32)
+	}
+	off := uint32(len(t.data))
+	t.data = append(append(t.data, s...), 0)
+	t.index[s] = off
+	return off
+}
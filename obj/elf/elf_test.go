@@ -0,0 +1,223 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// linkage exercises every section, symbol binding and relocation kind
+const linkage = `
+@counter = internal global i64 0
+@limit = external constant i64 10
+@seed = weak_odr global i64 7
+@shared = common global i64 0
+@extern_val = external global i64
+
+declare external i64 @helper(i64)
+
+define internal i64 @bump(i64 %n) {
+entry:
+  %c = load i64, ptr<i64> %counter
+  %c1 = add i64 %c, %n
+  store i64 %c1, ptr<i64> %counter
+  ret i64 %c1
+}
+
+define external i64 @run(i64 %n) {
+entry:
+  %a = call i64 @bump(i64 %n)
+  %b = call i64 @helper(i64 %a)
+  %l = load i64, ptr<i64> %limit
+  %e = load i64, ptr<i64> %extern_val
+  %s = load i64, ptr<i64> %seed
+  %r1 = add i64 %b, %l
+  %r2 = add i64 %r1, %e
+  %r3 = add i64 %r2, %s
+  ret i64 %r3
+}
+`
+
+func parse(t *testing.T, name, src string) *ir.Module {
+	t.Helper()
+	m, err := asm.Parse(name, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSymbols(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Generate(&buf, parse(t, "linkage", linkage)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Class != elf.ELFCLASS64 || f.Data != elf.ELFDATA2LSB || f.Type != elf.ET_REL || f.Machine != elf.EM_X86_64 {
+		t.Errorf("header is %v %v %v %v, want a little-endian ELF64 x86-64 relocatable", f.Class, f.Data, f.Type, f.Machine)
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		section string // "" for undefined, "COMMON" for common symbols
+		bind    elf.SymBind
+		typ     elf.SymType
+	}{
+		{"counter", ".bss", elf.STB_LOCAL, elf.STT_OBJECT},
+		{"limit", ".rodata", elf.STB_GLOBAL, elf.STT_OBJECT},
+		{"seed", ".data", elf.STB_WEAK, elf.STT_OBJECT},
+		{"shared", "COMMON", elf.STB_GLOBAL, elf.STT_OBJECT},
+		{"extern_val", "", elf.STB_GLOBAL, elf.STT_NOTYPE},
+		{"helper", "", elf.STB_GLOBAL, elf.STT_NOTYPE},
+		{"bump", ".text", elf.STB_LOCAL, elf.STT_FUNC},
+		{"run", ".text", elf.STB_GLOBAL, elf.STT_FUNC},
+	}
+	for _, tt := range tests {
+		var sym *elf.Symbol
+		for i := range syms {
+			if syms[i].Name == tt.name {
+				sym = &syms[i]
+			}
+		}
+		if sym == nil {
+			t.Errorf("no symbol %s", tt.name)
+			continue
+		}
+		section := ""
+		switch {
+		case sym.Section == elf.SHN_COMMON:
+			section = "COMMON"
+		case sym.Section != elf.SHN_UNDEF:
+			section = f.Sections[sym.Section].Name
+		}
+		if section != tt.section || elf.ST_BIND(sym.Info) != tt.bind || elf.ST_TYPE(sym.Info) != tt.typ {
+			t.Errorf("%s is in %q, %v, %v; want %q, %v, %v", tt.name, section,
+				elf.ST_BIND(sym.Info), elf.ST_TYPE(sym.Info), tt.section, tt.bind, tt.typ)
+		}
+	}
+	for _, s := range syms {
+		if strings.HasPrefix(s.Name, ".L") {
+			t.Errorf("local label %s is in the symbol table", s.Name)
+		}
+	}
+}
+
+func TestRelocations(t *testing.T) {
+	f, err := Compile(parse(t, "linkage", linkage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]elf.R_X86_64)
+	for _, s := range f.Sections {
+		if s.Name != ".text" {
+			continue
+		}
+		for _, r := range s.Relocs {
+			if r.Offset < 0 || r.Offset+4 > int64(len(s.Data)) {
+				t.Errorf("relocation against %s at %d is outside .text", r.Symbol.Name, r.Offset)
+			}
+			name := r.Symbol.Name
+			if name == "" && r.Symbol.Section != nil {
+				name = r.Symbol.Section.Name
+			}
+			kinds[name] = elf.R_X86_64(r.Type)
+		}
+	}
+	// The call to the local bump is resolved in .text, and references to
+	// the local counter go through the symbol of .bss
+	want := map[string]elf.R_X86_64{
+		"helper":     elf.R_X86_64_PLT32,
+		"limit":      elf.R_X86_64_PC32,
+		"seed":       elf.R_X86_64_PC32,
+		"extern_val": elf.R_X86_64_GOTPCREL,
+		".bss":       elf.R_X86_64_PC32,
+	}
+	for name, kind := range want {
+		if kinds[name] != kind {
+			t.Errorf("reference to %s has relocation %v, want %v", name, kinds[name], kind)
+		}
+	}
+	if _, ok := kinds["bump"]; ok {
+		t.Errorf("the call to the local bump has a relocation")
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		msg string
+	}{
+		{"\t.text\n\tfrobq %rax\n", "line 2"},
+		{"\t.text\n\tjmp .Lnowhere\n", ".Lnowhere"},
+		{"\t.text\nf:\nf:\n", "line 3"},
+	}
+	for _, tt := range tests {
+		_, err := Assemble(strings.NewReader(tt.src))
+		var e *Error
+		if !errors.As(err, &e) || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%q: got error %v, want an *Error mentioning %q", tt.src, err, tt.msg)
+		}
+	}
+}
+
+// TestSystemLinker links the example objects with the C compiler and runs
+// the result
+func TestSystemLinker(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs linux/amd64")
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	dir := t.TempDir()
+	args := []string{"-o", filepath.Join(dir, "prog"), filepath.Join(dir, "main.c")}
+	for _, name := range []string{"factorial", "gcd", "switch"} {
+		src, err := os.ReadFile("../../testdata/" + name + ".ll")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Generate(&buf, parse(t, name, string(src))); err != nil {
+			t.Fatal(err)
+		}
+		obj := filepath.Join(dir, name+".o")
+		if err := os.WriteFile(obj, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, obj)
+	}
+	main := `#include <stdio.h>
+int factorial(int); int gcd(int, int); int classify(int);
+int main(void) { printf("%d %d %d %d %d\n", factorial(5), gcd(48, 18), classify(0), classify(1), classify(7)); return 0; }
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.c"), []byte(main), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+		t.Fatalf("cc: %v\n%s", err, out)
+	}
+	out, err := exec.Command(filepath.Join(dir, "prog")).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "120 6 100 200 -1\n"; got != want {
+		t.Errorf("program printed %q, want %q", got, want)
+	}
+}
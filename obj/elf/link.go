@@ -0,0 +1,313 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Executables are linked at the traditional non-PIE base address
+const (
+	imageBase = 0x400000
+	pageSize  = 0x1000
+)
+
+// Link links x86-64 object files into a static executable that starts at
+// the symbol entry, and writes it to w. Code and read-only data share one
+// read-execute segment; data, the GOT and .bss a read-write one. Every
+// global symbol must be defined by one of the files, except weak ones,
+// which resolve to zero.
+func Link(w io.Writer, entry string, files ...*File) error {
+	l := &linker{
+		globals: make(map[string]*Symbol),
+		addr:    make(map[*Section]int64),
+		got:     make(map[*Symbol]int64),
+	}
+	if err := l.symbols(files); err != nil {
+		return err
+	}
+
+	var text, rodata, data, bss []*Section
+	for _, f := range files {
+		if f.Machine != elf.EM_X86_64 {
+			return &Error{Msg: fmt.Sprintf("cannot link %s objects", f.Machine)}
+		}
+		for _, s := range f.Sections {
+			switch {
+			case s.Flags&elf.SHF_ALLOC == 0:
+			case s.Type == elf.SHT_NOBITS:
+				bss = append(bss, s)
+			case s.Flags&elf.SHF_EXECINSTR != 0:
+				text = append(text, s)
+			case s.Flags&elf.SHF_WRITE != 0:
+				data = append(data, s)
+			default:
+				rodata = append(rodata, s)
+			}
+		}
+	}
+	bss = append(bss, l.commons...)
+	got := l.gotSection(text, rodata, data)
+	if got != nil {
+		data = append(data, got)
+	}
+
+	// Lay the sections out so that each address is the image base plus the
+	// file offset, which keeps both segments page-congruent
+	writable := len(data)+len(bss) > 0
+	phnum := 2
+	if writable {
+		phnum++
+	}
+	off := int64(64 + 56*phnum)
+	place := func(list []*Section) {
+		for _, s := range list {
+			off = alignTo(off, max(s.Align, 1))
+			l.addr[s] = imageBase + off
+			off += s.Len()
+		}
+	}
+	place(text)
+	place(rodata)
+	codeEnd := off
+	dataStart := alignTo(off, pageSize)
+	off = dataStart
+	place(data)
+	dataEnd := off
+	place(bss)
+	bssEnd := off
+
+	image := make([]byte, dataEnd)
+	for s, addr := range l.addr {
+		if s.Type != elf.SHT_NOBITS {
+			copy(image[addr-imageBase:], s.Data)
+		}
+	}
+	if got != nil {
+		for sym, off := range l.got {
+			v, err := l.value(sym)
+			if err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint64(image[l.addr[got]-imageBase+off:], uint64(v))
+		}
+	}
+	for _, list := range [][]*Section{text, rodata, data} {
+		for _, s := range list {
+			if err := l.relocate(image, s); err != nil {
+				return err
+			}
+		}
+	}
+
+	start, ok := l.globals[entry]
+	if !ok || start.Section == nil {
+		return &Error{Msg: fmt.Sprintf("entry point %s is not defined", entry)}
+	}
+	entryAddr, err := l.value(start)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     uint64(entryAddr),
+		Phoff:     64,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     uint16(phnum),
+		Shentsize: 64,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	hdr.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+	binary.Write(&out, binary.LittleEndian, hdr)
+
+	progs := []elf.Prog64{{
+		Type:   uint32(elf.PT_LOAD),
+		Flags:  uint32(elf.PF_R | elf.PF_X),
+		Vaddr:  imageBase,
+		Paddr:  imageBase,
+		Filesz: uint64(codeEnd),
+		Memsz:  uint64(codeEnd),
+		Align:  pageSize,
+	}}
+	if writable {
+		progs = append(progs, elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(elf.PF_R | elf.PF_W),
+			Off:    uint64(dataStart),
+			Vaddr:  uint64(imageBase + dataStart),
+			Paddr:  uint64(imageBase + dataStart),
+			Filesz: uint64(dataEnd - dataStart),
+			Memsz:  uint64(bssEnd - dataStart),
+			Align:  pageSize,
+		})
+	}
+	progs = append(progs, elf.Prog64{
+		Type:  uint32(elf.PT_GNU_STACK),
+		Flags: uint32(elf.PF_R | elf.PF_W),
+		Align: 16,
+	})
+	binary.Write(&out, binary.LittleEndian, progs)
+	out.Write(image[out.Len():])
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+type linker struct {
+	globals map[string]*Symbol // the definition chosen for each name
+	addr    map[*Section]int64 // address of every allocated section
+	got     map[*Symbol]int64  // offset of the GOT entry of a symbol
+	gotSec  *Section
+	commons []*Section // storage allocated for common symbols
+}
+
+// symbols picks the definition of every global symbol: strong definitions
+// win over weak ones, which win over common symbols
+func (l *linker) symbols(files []*File) error {
+	rank := func(s *Symbol) int {
+		switch {
+		case s == nil || s.Section == nil && !s.Common:
+			return 0
+		case s.Common:
+			return 1
+		case s.Bind == elf.STB_WEAK:
+			return 2
+		}
+		return 3
+	}
+	// The largest size and alignment of each common symbol
+	size, align := make(map[string]int64), make(map[string]int64)
+	for _, f := range files {
+		for _, s := range f.Symbols {
+			if s.Bind == elf.STB_LOCAL {
+				continue
+			}
+			if s.Common {
+				size[s.Name] = max(size[s.Name], s.Size)
+				align[s.Name] = max(align[s.Name], s.Value)
+			}
+			prev := l.globals[s.Name]
+			switch r, p := rank(s), rank(prev); {
+			case r == 3 && p == 3:
+				return &Error{Msg: fmt.Sprintf("%s is defined more than once", s.Name)}
+			case r > p || prev == nil:
+				l.globals[s.Name] = s
+			}
+		}
+	}
+
+	// Common symbols that nothing defines get zeroed storage of their own
+	for name, s := range l.globals {
+		if !s.Common {
+			continue
+		}
+		sec := &Section{Name: ".bss", Type: elf.SHT_NOBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Align: max(align[name], 1), Size: size[name]}
+		l.commons = append(l.commons, sec)
+		l.globals[name] = &Symbol{Name: name, Section: sec, Size: size[name], Bind: s.Bind, Type: s.Type}
+	}
+	return nil
+}
+
+// target returns the symbol a reference to s resolves to
+func (l *linker) target(s *Symbol) *Symbol {
+	if s.Bind == elf.STB_LOCAL {
+		return s
+	}
+	if def, ok := l.globals[s.Name]; ok {
+		return def
+	}
+	return s
+}
+
+// value returns the address of the symbol s resolves to
+func (l *linker) value(s *Symbol) (int64, error) {
+	def := l.target(s)
+	if def.Section == nil {
+		if def.Bind == elf.STB_WEAK {
+			return 0, nil
+		}
+		return 0, &Error{Msg: fmt.Sprintf("undefined symbol %s", def.Name)}
+	}
+	return l.addr[def.Section] + def.Value, nil
+}
+
+// gotSection allocates a GOT entry for every symbol reached through one and
+// returns the section holding them, if any
+func (l *linker) gotSection(lists ...[]*Section) *Section {
+	var n int64
+	for _, list := range lists {
+		for _, s := range list {
+			for _, r := range s.Relocs {
+				switch elf.R_X86_64(r.Type) {
+				case elf.R_X86_64_GOTPCREL, elf.R_X86_64_GOTPCRELX, elf.R_X86_64_REX_GOTPCRELX:
+					def := l.target(r.Symbol)
+					if _, ok := l.got[def]; !ok {
+						l.got[def] = n * 8
+						n++
+					}
+				}
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	l.gotSec = &Section{Name: ".got", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Align: 8, Data: make([]byte, n*8)}
+	return l.gotSec
+}
+
+// relocate applies the relocations of s to its bytes in image
+func (l *linker) relocate(image []byte, s *Section) error {
+	base := l.addr[s]
+	for _, r := range s.Relocs {
+		p := base + r.Offset
+		field := image[p-imageBase:]
+		var v int64
+		switch typ := elf.R_X86_64(r.Type); typ {
+		case elf.R_X86_64_64:
+			sv, err := l.value(r.Symbol)
+			if err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint64(field, uint64(sv+r.Addend))
+			continue
+		case elf.R_X86_64_PC32, elf.R_X86_64_PLT32:
+			sv, err := l.value(r.Symbol)
+			if err != nil {
+				return err
+			}
+			v = sv + r.Addend - p
+		case elf.R_X86_64_GOTPCREL, elf.R_X86_64_GOTPCRELX, elf.R_X86_64_REX_GOTPCRELX:
+			v = l.addr[l.gotSec] + l.got[l.target(r.Symbol)] + r.Addend - p
+		case elf.R_X86_64_32, elf.R_X86_64_32S:
+			sv, err := l.value(r.Symbol)
+			if err != nil {
+				return err
+			}
+			v = sv + r.Addend
+			if typ == elf.R_X86_64_32 && v != int64(uint32(v)) {
+				return &Error{Msg: fmt.Sprintf("%s: relocation against %s out of range", s.Name, r.Symbol.Name)}
+			}
+		default:
+			return &Error{Msg: fmt.Sprintf("%s: unsupported relocation %s", s.Name, typ)}
+		}
+		if v != int64(int32(v)) && elf.R_X86_64(r.Type) != elf.R_X86_64_32 {
+			return &Error{Msg: fmt.Sprintf("%s: relocation against %s out of range", s.Name, r.Symbol.Name)}
+		}
+		binary.LittleEndian.PutUint32(field, uint32(v))
+	}
+	return nil
+}
+
+func alignTo(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
@@ -0,0 +1,45 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"strings"
+	"testing"
+)
+
+func TestLinkErrors(t *testing.T) {
+	compile := func(name, src string) *File {
+		f, err := Compile(parse(t, name, src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	f := compile("f", "define external i32 @f() {\nentry:\n  ret i32 0\n}\n")
+	g := compile("g", "declare external i32 @f()\n\ndefine external i32 @g() {\nentry:\n  %r = call i32 @f()\n  ret i32 %r\n}\n")
+	h := compile("h", "declare external i32 @h2()\n\ndefine external i32 @h() {\nentry:\n  %r = call i32 @h2()\n  ret i32 %r\n}\n")
+
+	tests := []struct {
+		entry string
+		files []*File
+		msg   string
+	}{
+		{"g", []*File{f, g}, ""},
+		{"start", []*File{f, g}, "entry point start is not defined"},
+		{"f", []*File{f, f}, "f is defined more than once"},
+		{"h", []*File{h}, "undefined symbol h2"},
+		{"f", []*File{f, {Machine: elf.EM_AARCH64}}, "cannot link"},
+	}
+	for _, tt := range tests {
+		err := Link(&bytes.Buffer{}, tt.entry, tt.files...)
+		if tt.msg == "" {
+			if err != nil {
+				t.Errorf("linking %s: %v", tt.entry, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("linking %s: got error %v, want %q", tt.entry, err, tt.msg)
+		}
+	}
+}
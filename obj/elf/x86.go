@@ -0,0 +1,594 @@
+package elf
+
+import (
+	"debug/elf"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Operands
+// ============================================================================
+
+type operandKind int
+
+const (
+	opReg operandKind = iota // general purpose register
+	opXMM                    // SSE register
+	opImm                    // $immediate
+	opMem                    // disp(%base) or sym(%rip)
+	opSym                    // branch target
+)
+
+type operand struct {
+	kind operandKind
+	reg  int   // register number, or the base register of memory
+	size int   // size of a general purpose register in bytes
+	imm  int64 // immediate or displacement
+	rip  bool  // %rip-relative memory
+	sym  string
+	typ  elf.R_X86_64 // relocation of sym
+}
+
+type register struct {
+	num, size int
+}
+
+var registers = func() map[string]register {
+	m := make(map[string]register)
+	names := [16][4]string{
+		{"rax", "eax", "ax", "al"}, {"rcx", "ecx", "cx", "cl"},
+		{"rdx", "edx", "dx", "dl"}, {"rbx", "ebx", "bx", "bl"},
+		{"rsp", "esp", "sp", "spl"}, {"rbp", "ebp", "bp", "bpl"},
+		{"rsi", "esi", "si", "sil"}, {"rdi", "edi", "di", "dil"},
+	}
+	for i := 8; i < 16; i++ {
+		r := "r" + strconv.Itoa(i)
+		names[i] = [4]string{r, r + "d", r + "w", r + "b"}
+	}
+	for num, list := range names {
+		for i, name := range list {
+			m[name] = register{num, 8 >> uint(i)}
+		}
+	}
+	return m
+}()
+
+func (a *assembler) operand(s string) (operand, error) {
+	switch {
+	case strings.HasPrefix(s, "%xmm"):
+		n, err := parseInt(s[4:])
+		if err != nil || n < 0 || n > 15 {
+			return operand{}, a.errorf("bad register %s", s)
+		}
+		return operand{kind: opXMM, reg: int(n)}, nil
+	case strings.HasPrefix(s, "%"):
+		r, ok := registers[s[1:]]
+		if !ok {
+			return operand{}, a.errorf("unsupported register %s", s)
+		}
+		return operand{kind: opReg, reg: r.num, size: r.size}, nil
+	case strings.HasPrefix(s, "$"):
+		n, err := parseInt(s[1:])
+		if err != nil {
+			return operand{}, a.errorf("bad immediate %s", s)
+		}
+		return operand{kind: opImm, imm: n}, nil
+	}
+
+	op := operand{kind: opSym}
+	if i := strings.IndexByte(s, '('); i >= 0 {
+		if !strings.HasSuffix(s, ")") {
+			return operand{}, a.errorf("bad memory operand %s", s)
+		}
+		base := s[i+1 : len(s)-1]
+		s = s[:i]
+		op.kind = opMem
+		r, ok := registers[strings.TrimPrefix(base, "%")]
+		switch {
+		case base == "%rip":
+			op.rip = true
+		case ok && r.size == 8 && strings.HasPrefix(base, "%"):
+			op.reg = r.num
+		default:
+			return operand{}, a.errorf("unsupported memory operand (%s)", base)
+		}
+	}
+	if s == "" {
+		return op, nil
+	}
+
+	// A displacement, or a symbol with an optional modifier and offset
+	if s[0] >= '0' && s[0] <= '9' || s[0] == '-' {
+		n, err := parseInt(s)
+		if err != nil {
+			return operand{}, a.errorf("bad displacement %s", s)
+		}
+		if op.kind != opMem || op.rip {
+			return operand{}, a.errorf("unsupported operand %s", s)
+		}
+		op.imm = n
+		return op, nil
+	}
+	if i := strings.IndexAny(s, "+-"); i > 0 {
+		n, err := parseInt(strings.TrimPrefix(s[i:], "+"))
+		if err != nil {
+			return operand{}, a.errorf("bad offset %s", s)
+		}
+		s, op.imm = s[:i], n
+	}
+	op.typ = elf.R_X86_64_PC32
+	if name, mod, ok := strings.Cut(s, "@"); ok {
+		switch mod {
+		case "PLT":
+			op.typ = elf.R_X86_64_PLT32
+		case "GOTPCREL":
+			op.typ = elf.R_X86_64_GOTPCREL
+		default:
+			return operand{}, a.errorf("unsupported modifier @%s", mod)
+		}
+		s = name
+	}
+	if !isSymbol(s) || op.kind == opMem && !op.rip {
+		return operand{}, a.errorf("unsupported operand %s", s)
+	}
+	op.sym = s
+	if !isTemp(s) {
+		a.symbol(s)
+	}
+	return op, nil
+}
+
+// ============================================================================
+// Encoding
+// ============================================================================
+
+// enc describes one instruction: prefixes, an optional REX prefix, the
+// opcode, a ModRM byte with reg in its reg field and rm as the other
+// operand, and an immediate of immSize bytes
+type enc struct {
+	prefix  byte // 0x66, 0xf2 or 0xf3, or none
+	w       bool
+	opcode  []byte
+	reg     int
+	rm      operand
+	imm     int64
+	immSize int
+	byteReg bool // reg is an 8-bit register
+}
+
+func (a *assembler) emit(e enc) error {
+	var rex byte
+	if e.w {
+		rex |= 0x48
+	}
+	if e.reg >= 8 {
+		rex |= 0x44
+	}
+	if e.rm.kind == opReg || e.rm.kind == opXMM || e.rm.kind == opMem && !e.rm.rip {
+		if e.rm.reg >= 8 {
+			rex |= 0x41
+		}
+	}
+	// spl, bpl, sil and dil are only reachable with a REX prefix
+	if e.byteReg && e.reg >= 4 || e.rm.kind == opReg && e.rm.size == 1 && e.rm.reg >= 4 {
+		rex |= 0x40
+	}
+
+	var b []byte
+	if e.prefix != 0 {
+		b = append(b, e.prefix)
+	}
+	if rex != 0 {
+		b = append(b, rex)
+	}
+	b = append(b, e.opcode...)
+
+	reg := byte(e.reg&7) << 3
+	switch rm := e.rm; {
+	case rm.kind == opReg || rm.kind == opXMM:
+		b = append(b, 0xc0|reg|byte(rm.reg&7))
+	case rm.kind == opMem && rm.rip:
+		b = append(b, reg|5)
+		if rm.sym != "" {
+			a.fixups = append(a.fixups, fixup{
+				sec:    a.sec,
+				off:    a.sec.Len() + int64(len(b)),
+				sym:    rm.sym,
+				addend: rm.imm - 4 - int64(e.immSize),
+				typ:    rm.typ,
+				line:   a.line,
+				text:   a.text,
+			})
+			b = append(b, 0, 0, 0, 0)
+		} else {
+			b = appendInt(b, rm.imm, 4)
+		}
+	case rm.kind == opMem:
+		base := byte(rm.reg & 7)
+		var mod byte
+		switch {
+		case rm.imm == 0 && base != 5:
+		case rm.imm == int64(int8(rm.imm)):
+			mod = 0x40
+		case rm.imm == int64(int32(rm.imm)):
+			mod = 0x80
+		default:
+			return a.errorf("displacement out of range")
+		}
+		b = append(b, mod|reg|base)
+		if base == 4 {
+			b = append(b, 0x24) // SIB with no index
+		}
+		switch mod {
+		case 0x40:
+			b = appendInt(b, rm.imm, 1)
+		case 0x80:
+			b = appendInt(b, rm.imm, 4)
+		}
+	default:
+		return a.errorf("bad operand")
+	}
+	b = appendInt(b, e.imm, e.immSize)
+	a.sec.Data = append(a.sec.Data, b...)
+	return nil
+}
+
+func appendInt(b []byte, v int64, size int) []byte {
+	for i := 0; i < size; i++ {
+		b = append(b, byte(v>>(8*uint(i))))
+	}
+	return b
+}
+
+// branch emits opcode followed by a 32-bit displacement to target
+func (a *assembler) branch(opcode []byte, target operand) error {
+	if target.kind != opSym {
+		return a.errorf("indirect branches are not supported")
+	}
+	a.sec.Data = append(a.sec.Data, opcode...)
+	typ := target.typ
+	if !isTemp(target.sym) {
+		typ = elf.R_X86_64_PLT32
+	}
+	a.fixups = append(a.fixups, fixup{
+		sec:    a.sec,
+		off:    a.sec.Len(),
+		sym:    target.sym,
+		addend: target.imm - 4,
+		typ:    typ,
+		line:   a.line,
+		text:   a.text,
+	})
+	a.sec.Data = append(a.sec.Data, 0, 0, 0, 0)
+	return nil
+}
+
+// ============================================================================
+// Instructions
+// ============================================================================
+
+var conditions = map[string]byte{
+	"o": 0, "no": 1, "b": 2, "c": 2, "nae": 2, "ae": 3, "nb": 3, "nc": 3,
+	"e": 4, "z": 4, "ne": 5, "nz": 5, "be": 6, "na": 6, "a": 7, "nbe": 7,
+	"s": 8, "ns": 9, "p": 10, "pe": 10, "np": 11, "po": 11,
+	"l": 12, "nge": 12, "ge": 13, "nl": 13, "le": 14, "ng": 14, "g": 15, "nle": 15,
+}
+
+var fixed = map[string][]byte{
+	"ret": {0xc3}, "leave": {0xc9}, "nop": {0x90}, "hlt": {0xf4}, "int3": {0xcc},
+	"ud2": {0x0f, 0x0b}, "syscall": {0x0f, 0x05},
+	"cltq": {0x48, 0x98}, "cqto": {0x48, 0x99}, "cltd": {0x99},
+	"rep movsb": {0xf3, 0xa4}, "rep stosb": {0xf3, 0xaa},
+}
+
+// The group 1 arithmetic instructions and the value of their ModRM reg
+// field in the immediate forms
+var arith = map[string]int{"add": 0, "or": 1, "adc": 2, "sbb": 3, "and": 4, "sub": 5, "xor": 6, "cmp": 7}
+
+// Instructions on one operand encoded as F7 /n
+var unary = map[string]int{"not": 2, "neg": 3, "mul": 4, "div": 6, "idiv": 7}
+
+var shifts = map[string]int{"rol": 0, "ror": 1, "shl": 4, "sal": 4, "shr": 5, "sar": 7}
+
+var bitTests = map[string]int{"bt": 4, "bts": 5, "btr": 6, "btc": 7}
+
+// Sign and zero extensions: opcode, REX.W and source size
+var extensions = map[string]struct {
+	opcode []byte
+	w      bool
+	size   int
+}{
+	"movzbl": {[]byte{0x0f, 0xb6}, false, 1}, "movzbq": {[]byte{0x0f, 0xb6}, true, 1},
+	"movzwl": {[]byte{0x0f, 0xb7}, false, 2}, "movzwq": {[]byte{0x0f, 0xb7}, true, 2},
+	"movsbl": {[]byte{0x0f, 0xbe}, false, 1}, "movsbq": {[]byte{0x0f, 0xbe}, true, 1},
+	"movswl": {[]byte{0x0f, 0xbf}, false, 2}, "movswq": {[]byte{0x0f, 0xbf}, true, 2},
+	"movslq": {[]byte{0x63}, true, 4},
+}
+
+// SSE instructions taking the destination in the reg field: mandatory
+// prefix, opcode after 0F and REX.W
+var sseOps = map[string]struct {
+	prefix byte
+	opcode byte
+	w      bool
+}{
+	"addss": {0xf3, 0x58, false}, "addsd": {0xf2, 0x58, false},
+	"mulss": {0xf3, 0x59, false}, "mulsd": {0xf2, 0x59, false},
+	"subss": {0xf3, 0x5c, false}, "subsd": {0xf2, 0x5c, false},
+	"minss": {0xf3, 0x5d, false}, "minsd": {0xf2, 0x5d, false},
+	"divss": {0xf3, 0x5e, false}, "divsd": {0xf2, 0x5e, false},
+	"maxss": {0xf3, 0x5f, false}, "maxsd": {0xf2, 0x5f, false},
+	"sqrtss": {0xf3, 0x51, false}, "sqrtsd": {0xf2, 0x51, false},
+	"andps": {0, 0x54, false}, "andpd": {0x66, 0x54, false},
+	"orps": {0, 0x56, false}, "orpd": {0x66, 0x56, false},
+	"xorps": {0, 0x57, false}, "xorpd": {0x66, 0x57, false},
+	"ucomiss": {0, 0x2e, false}, "ucomisd": {0x66, 0x2e, false},
+	"comiss": {0, 0x2f, false}, "comisd": {0x66, 0x2f, false},
+	"cvtss2sd": {0xf3, 0x5a, false}, "cvtsd2ss": {0xf2, 0x5a, false},
+	"cvttss2si": {0xf3, 0x2c, false}, "cvttss2sil": {0xf3, 0x2c, false}, "cvttss2siq": {0xf3, 0x2c, true},
+	"cvttsd2si": {0xf2, 0x2c, false}, "cvttsd2sil": {0xf2, 0x2c, false}, "cvttsd2siq": {0xf2, 0x2c, true},
+	"cvtsi2ss": {0xf3, 0x2a, false}, "cvtsi2ssl": {0xf3, 0x2a, false}, "cvtsi2ssq": {0xf3, 0x2a, true},
+	"cvtsi2sd": {0xf2, 0x2a, false}, "cvtsi2sdl": {0xf2, 0x2a, false}, "cvtsi2sdq": {0xf2, 0x2a, true},
+}
+
+// suffixes maps the operand size suffixes to sizes in bytes
+var suffixes = map[byte]int{'b': 1, 'w': 2, 'l': 4, 'q': 8}
+
+func (a *assembler) instruction(name string, ops []operand) error {
+	if b, ok := fixed[name]; ok {
+		if len(ops) != 0 {
+			return a.errorf("%s takes no operands", name)
+		}
+		a.sec.Data = append(a.sec.Data, b...)
+		return nil
+	}
+	switch {
+	case name == "jmp" || name == "call":
+		if len(ops) != 1 {
+			return a.errorf("%s takes one operand", name)
+		}
+		op := byte(0xe9)
+		if name == "call" {
+			op = 0xe8
+		}
+		return a.branch([]byte{op}, ops[0])
+	case name[0] == 'j':
+		cc, ok := conditions[name[1:]]
+		if !ok || len(ops) != 1 {
+			break
+		}
+		return a.branch([]byte{0x0f, 0x80 | cc}, ops[0])
+	case strings.HasPrefix(name, "set"):
+		cc, ok := conditions[name[3:]]
+		if !ok || len(ops) != 1 || !isGPR(ops[0], 1) && ops[0].kind != opMem {
+			break
+		}
+		return a.emit(enc{opcode: []byte{0x0f, 0x90 | cc}, rm: ops[0]})
+	case strings.HasPrefix(name, "cmov"):
+		cc, ok := conditions[name[4:]]
+		if !ok && (strings.HasSuffix(name, "l") || strings.HasSuffix(name, "q")) {
+			cc, ok = conditions[name[4:len(name)-1]]
+		}
+		if !ok || len(ops) != 2 || !isGPR(ops[1], 0) || ops[1].size < 4 {
+			break
+		}
+		return a.emit(enc{w: ops[1].size == 8, opcode: []byte{0x0f, 0x40 | cc}, reg: ops[1].reg, rm: ops[0]})
+	}
+	if x, ok := extensions[name]; ok {
+		if len(ops) != 2 || !isGPR(ops[1], 0) || !isGPR(ops[0], x.size) && ops[0].kind != opMem {
+			return a.errorf("bad operands")
+		}
+		return a.emit(enc{w: x.w, opcode: x.opcode, reg: ops[1].reg, rm: ops[0]})
+	}
+	if x, ok := sseOps[name]; ok {
+		if len(ops) != 2 || ops[0].kind == opImm || ops[1].kind == opImm || ops[1].kind == opMem {
+			return a.errorf("bad operands")
+		}
+		return a.emit(enc{prefix: x.prefix, w: x.w, opcode: []byte{0x0f, x.opcode}, reg: ops[1].reg, rm: ops[0]})
+	}
+	switch name {
+	case "movss", "movsd":
+		prefix := map[string]byte{"movss": 0xf3, "movsd": 0xf2}[name]
+		if len(ops) != 2 {
+			break
+		}
+		switch {
+		case ops[1].kind == opXMM && (ops[0].kind == opXMM || ops[0].kind == opMem):
+			return a.emit(enc{prefix: prefix, opcode: []byte{0x0f, 0x10}, reg: ops[1].reg, rm: ops[0]})
+		case ops[0].kind == opXMM && ops[1].kind == opMem:
+			return a.emit(enc{prefix: prefix, opcode: []byte{0x0f, 0x11}, reg: ops[0].reg, rm: ops[1]})
+		}
+	case "movabsq":
+		if len(ops) != 2 || ops[0].kind != opImm || !isGPR(ops[1], 8) {
+			break
+		}
+		b := []byte{0x48 | byte(ops[1].reg>>3), 0xb8 | byte(ops[1].reg&7)}
+		a.sec.Data = appendInt(append(a.sec.Data, b...), ops[0].imm, 8)
+		return nil
+	}
+
+	return a.sized(name, ops)
+}
+
+// sized encodes the instructions spelled with an operand size suffix
+func (a *assembler) sized(name string, ops []operand) error {
+	size, ok := suffixes[name[len(name)-1]]
+	if !ok {
+		return a.errorf("unsupported instruction %s", name)
+	}
+	base := name[:len(name)-1]
+	var prefix byte
+	if size == 2 {
+		prefix = 0x66
+	}
+	w := size == 8
+	// op returns the opcode for the operand size: the byte form is one less
+	op := func(b byte) []byte {
+		if size == 1 {
+			return []byte{b - 1}
+		}
+		return []byte{b}
+	}
+	// immediate returns the size of a full-width immediate
+	immediate := func() int {
+		return min(size, 4)
+	}
+	bad := func() error { return a.errorf("bad operands") }
+	for i, o := range ops {
+		if o.kind != opReg || o.size == size {
+			continue
+		}
+		// Shift counts are always %cl
+		if _, ok := shifts[base]; ok && i == 0 && len(ops) == 2 && o.reg == 1 && o.size == 1 {
+			continue
+		}
+		return a.errorf("operand size does not match the %s suffix", name[len(name)-1:])
+	}
+
+	if n, ok := arith[base]; ok {
+		if len(ops) != 2 {
+			return bad()
+		}
+		src, dst := ops[0], ops[1]
+		switch {
+		case src.kind == opImm:
+			if dst.kind != opReg && dst.kind != opMem {
+				return bad()
+			}
+			if size > 1 && src.imm == int64(int8(src.imm)) {
+				return a.emit(enc{prefix: prefix, w: w, opcode: []byte{0x83}, reg: n, rm: dst, imm: src.imm, immSize: 1})
+			}
+			if !fitsImm(src.imm, size) {
+				return a.errorf("immediate out of range")
+			}
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0x81), reg: n, rm: dst, imm: src.imm, immSize: immediate()})
+		case src.kind == opReg && (dst.kind == opReg || dst.kind == opMem):
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(byte(n<<3 | 1)), reg: src.reg, rm: dst, byteReg: size == 1})
+		case src.kind == opMem && dst.kind == opReg:
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(byte(n<<3 | 3)), reg: dst.reg, rm: src, byteReg: size == 1})
+		}
+		return bad()
+	}
+	if n, ok := unary[base]; ok {
+		if len(ops) != 1 || ops[0].kind != opReg && ops[0].kind != opMem {
+			return bad()
+		}
+		return a.emit(enc{prefix: prefix, w: w, opcode: op(0xf7), reg: n, rm: ops[0]})
+	}
+	if n, ok := shifts[base]; ok {
+		switch {
+		case len(ops) == 1:
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0xd1), reg: n, rm: ops[0]})
+		case len(ops) == 2 && ops[0].kind == opReg && ops[0].reg == 1 && ops[0].size == 1:
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0xd3), reg: n, rm: ops[1]})
+		case len(ops) == 2 && ops[0].kind == opImm:
+			if ops[0].imm == 1 {
+				return a.emit(enc{prefix: prefix, w: w, opcode: op(0xd1), reg: n, rm: ops[1]})
+			}
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0xc1), reg: n, rm: ops[1], imm: ops[0].imm, immSize: 1})
+		}
+		return bad()
+	}
+	if n, ok := bitTests[base]; ok {
+		if len(ops) != 2 || ops[0].kind != opImm || size == 1 {
+			return bad()
+		}
+		return a.emit(enc{prefix: prefix, w: w, opcode: []byte{0x0f, 0xba}, reg: n, rm: ops[1], imm: ops[0].imm, immSize: 1})
+	}
+
+	switch base {
+	case "mov":
+		if len(ops) != 2 {
+			return bad()
+		}
+		src, dst := ops[0], ops[1]
+		switch {
+		case src.kind == opXMM && dst.kind == opReg && size >= 4:
+			return a.emit(enc{prefix: 0x66, w: w, opcode: []byte{0x0f, 0x7e}, reg: src.reg, rm: dst})
+		case dst.kind == opXMM && src.kind == opReg && size >= 4:
+			return a.emit(enc{prefix: 0x66, w: w, opcode: []byte{0x0f, 0x6e}, reg: dst.reg, rm: src})
+		case src.kind == opImm && (dst.kind == opReg || dst.kind == opMem):
+			if !fitsImm(src.imm, size) {
+				return a.errorf("immediate out of range")
+			}
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0xc7), reg: 0, rm: dst, imm: src.imm, immSize: immediate()})
+		case src.kind == opReg && (dst.kind == opReg || dst.kind == opMem):
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0x89), reg: src.reg, rm: dst, byteReg: size == 1})
+		case src.kind == opMem && dst.kind == opReg:
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0x8b), reg: dst.reg, rm: src, byteReg: size == 1})
+		}
+		return bad()
+	case "test":
+		if len(ops) != 2 {
+			return bad()
+		}
+		src, dst := ops[0], ops[1]
+		switch {
+		case src.kind == opImm && (dst.kind == opReg || dst.kind == opMem):
+			if !fitsImm(src.imm, size) {
+				return a.errorf("immediate out of range")
+			}
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0xf7), reg: 0, rm: dst, imm: src.imm, immSize: immediate()})
+		case src.kind == opReg && (dst.kind == opReg || dst.kind == opMem):
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0x85), reg: src.reg, rm: dst, byteReg: size == 1})
+		}
+		return bad()
+	case "lea":
+		if len(ops) != 2 || ops[0].kind != opMem || !isGPR(ops[1], 0) || size == 1 {
+			return bad()
+		}
+		return a.emit(enc{prefix: prefix, w: w, opcode: []byte{0x8d}, reg: ops[1].reg, rm: ops[0]})
+	case "imul":
+		switch {
+		case len(ops) == 1:
+			return a.emit(enc{prefix: prefix, w: w, opcode: op(0xf7), reg: 5, rm: ops[0]})
+		case size == 1:
+		case len(ops) == 2 && isGPR(ops[1], 0) && ops[0].kind != opImm:
+			return a.emit(enc{prefix: prefix, w: w, opcode: []byte{0x0f, 0xaf}, reg: ops[1].reg, rm: ops[0]})
+		case len(ops) == 3 && ops[0].kind == opImm && isGPR(ops[2], 0):
+			if ops[0].imm == int64(int8(ops[0].imm)) {
+				return a.emit(enc{prefix: prefix, w: w, opcode: []byte{0x6b}, reg: ops[2].reg, rm: ops[1], imm: ops[0].imm, immSize: 1})
+			}
+			if !fitsImm(ops[0].imm, size) {
+				return a.errorf("immediate out of range")
+			}
+			return a.emit(enc{prefix: prefix, w: w, opcode: []byte{0x69}, reg: ops[2].reg, rm: ops[1], imm: ops[0].imm, immSize: immediate()})
+		}
+		return bad()
+	case "push", "pop":
+		if len(ops) != 1 || !isGPR(ops[0], 8) {
+			return bad()
+		}
+		op := byte(0x50)
+		if base == "pop" {
+			op = 0x58
+		}
+		if ops[0].reg >= 8 {
+			a.sec.Data = append(a.sec.Data, 0x41)
+		}
+		a.sec.Data = append(a.sec.Data, op|byte(ops[0].reg&7))
+		return nil
+	}
+	return a.errorf("unsupported instruction %s", name)
+}
+
+// isGPR reports whether o is a general purpose register of the given size,
+// or of any size if size is 0
+func isGPR(o operand, size int) bool {
+	return o.kind == opReg && (size == 0 || o.size == size)
+}
+
+// fitsImm reports whether v fits the immediate of an instruction on size
+// bytes, where 64-bit instructions sign-extend a 32-bit immediate
+func fitsImm(v int64, size int) bool {
+	switch size {
+	case 1:
+		return v >= -128 && v < 256
+	case 2:
+		return v >= -1<<15 && v < 1<<16
+	case 4:
+		return v >= -1<<31 && v < 1<<32
+	}
+	return v == int64(int32(v))
+}
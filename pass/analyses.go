@@ -0,0 +1,78 @@
+package pass
+
+import (
+	"github.com/arc-language/core-builder/analysis"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// Analyses caches analysis results per function. The results stay valid
+// until the function's control flow graph changes.
+type Analyses struct {
+	dom       map[*ir.Function]*analysis.DomTree
+	postDom   map[*ir.Function]*analysis.DomTree
+	frontiers map[*ir.Function]analysis.Frontier
+	loops     map[*ir.Function]*analysis.LoopInfo
+}
+
+// NewAnalyses creates an empty cache
+func NewAnalyses() *Analyses {
+	a := &Analyses{}
+	a.InvalidateAll()
+	return a
+}
+
+// Dominators returns the dominator tree of fn
+func (a *Analyses) Dominators(fn *ir.Function) *analysis.DomTree {
+	t, ok := a.dom[fn]
+	if !ok {
+		t = analysis.Dominators(fn)
+		a.dom[fn] = t
+	}
+	return t
+}
+
+// PostDominators returns the post-dominator tree of fn
+func (a *Analyses) PostDominators(fn *ir.Function) *analysis.DomTree {
+	t, ok := a.postDom[fn]
+	if !ok {
+		t = analysis.PostDominators(fn)
+		a.postDom[fn] = t
+	}
+	return t
+}
+
+// Frontiers returns the dominance frontiers of fn
+func (a *Analyses) Frontiers(fn *ir.Function) analysis.Frontier {
+	df, ok := a.frontiers[fn]
+	if !ok {
+		df = a.Dominators(fn).Frontiers()
+		a.frontiers[fn] = df
+	}
+	return df
+}
+
+// Loops returns the loop nesting forest of fn
+func (a *Analyses) Loops(fn *ir.Function) *analysis.LoopInfo {
+	li, ok := a.loops[fn]
+	if !ok {
+		li = a.Dominators(fn).Loops()
+		a.loops[fn] = li
+	}
+	return li
+}
+
+// Invalidate drops the cached results for fn
+func (a *Analyses) Invalidate(fn *ir.Function) {
+	delete(a.dom, fn)
+	delete(a.postDom, fn)
+	delete(a.frontiers, fn)
+	delete(a.loops, fn)
+}
+
+// InvalidateAll drops every cached result
+func (a *Analyses) InvalidateAll() {
+	a.dom = make(map[*ir.Function]*analysis.DomTree)
+	a.postDom = make(map[*ir.Function]*analysis.DomTree)
+	a.frontiers = make(map[*ir.Function]analysis.Frontier)
+	a.loops = make(map[*ir.Function]*analysis.LoopInfo)
+}
@@ -0,0 +1,159 @@
+// Package pass runs pipelines of transforms over a module.
+//
+// A PassManager holds an ordered list of module and function passes. It
+// caches the analyses passes request through Analyses and drops the cached
+// results for a function when a pass reports that it changed the function's
+// control flow graph.
+package pass
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/transform"
+	"github.com/arc-language/core-builder/verify"
+)
+
+// Effect reports what a pass changed
+type Effect int
+
+const (
+	// Unchanged means the pass did not modify the IR
+	Unchanged Effect = iota
+	// ChangedInstructions means instructions were added, removed or
+	// rewritten but every block keeps its successors and predecessors
+	ChangedInstructions
+	// ChangedCFG means blocks or the edges between them changed
+	ChangedCFG
+)
+
+func (e Effect) String() string {
+	switch e {
+	case Unchanged:
+		return "unchanged"
+	case ChangedInstructions:
+		return "changed instructions"
+	case ChangedCFG:
+		return "changed CFG"
+	}
+	return fmt.Sprintf("Effect(%d)", int(e))
+}
+
+// Pass is the common interface of module and function passes
+type Pass interface {
+	Name() string
+}
+
+// ModulePass transforms a whole module
+type ModulePass interface {
+	Pass
+	RunOnModule(m *ir.Module, a *Analyses) Effect
+}
+
+// FunctionPass transforms one function at a time. It is only run on
+// functions with a body.
+type FunctionPass interface {
+	Pass
+	RunOnFunction(fn *ir.Function, a *Analyses) Effect
+}
+
+// FunctionPassFunc adapts a function to the FunctionPass interface
+type FunctionPassFunc struct {
+	PassName string
+	Run      func(fn *ir.Function, a *Analyses) Effect
+}
+
+func (p FunctionPassFunc) Name() string { return p.PassName }
+
+func (p FunctionPassFunc) RunOnFunction(fn *ir.Function, a *Analyses) Effect {
+	return p.Run(fn, a)
+}
+
+// PassManager runs an ordered pipeline of passes
+type PassManager struct {
+	passes []Pass
+
+	// VerifyEach runs the verifier after every pass that changed the IR
+	VerifyEach bool
+}
+
+// NewPassManager creates an empty pipeline
+func NewPassManager() *PassManager {
+	return &PassManager{}
+}
+
+// Add appends passes to the pipeline. Each must be a ModulePass or a
+// FunctionPass.
+func (pm *PassManager) Add(passes ...Pass) {
+	for _, p := range passes {
+		switch p.(type) {
+		case ModulePass, FunctionPass:
+			pm.passes = append(pm.passes, p)
+		default:
+			panic(fmt.Sprintf("pass %s is neither a ModulePass nor a FunctionPass", p.Name()))
+		}
+	}
+}
+
+// Passes returns the pipeline in order
+func (pm *PassManager) Passes() []Pass {
+	return pm.passes
+}
+
+// Run executes the pipeline on m and reports whether any pass changed it.
+// With VerifyEach set, it stops at the first pass that leaves the module
+// invalid.
+func (pm *PassManager) Run(m *ir.Module) (bool, error) {
+	a := NewAnalyses()
+	changed := false
+	for _, p := range pm.passes {
+		effect := Unchanged
+		switch p := p.(type) {
+		case ModulePass:
+			effect = p.RunOnModule(m, a)
+			if effect == ChangedCFG {
+				a.InvalidateAll()
+			}
+		case FunctionPass:
+			for _, fn := range m.Functions {
+				if len(fn.Blocks) == 0 {
+					continue
+				}
+				e := p.RunOnFunction(fn, a)
+				if e == ChangedCFG {
+					a.Invalidate(fn)
+				}
+				if e > effect {
+					effect = e
+				}
+			}
+		}
+		if effect == Unchanged {
+			continue
+		}
+		changed = true
+		if pm.VerifyEach {
+			if errs := verify.Module(m); len(errs) > 0 {
+				return changed, fmt.Errorf("pass %s produced invalid IR: %v", p.Name(), errs[0])
+			}
+		}
+	}
+	return changed, nil
+}
+
+// ============================================================================
+// Standard passes
+// ============================================================================
+
+// Mem2Reg returns a pass promoting allocas to SSA values
+func Mem2Reg() FunctionPass {
+	return FunctionPassFunc{
+		PassName: "mem2reg",
+		Run: func(fn *ir.Function, a *Analyses) Effect {
+			if transform.PromoteAllocas(fn, a.Dominators(fn)) {
+				return ChangedInstructions
+			}
+			return Unchanged
+		},
+	}
+}
@@ -0,0 +1,169 @@
+package pass
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/ir"
+)
+
+const src = `
+declare external i32 @ext(i32)
+
+define external i32 @f(i32 %x) {
+entry:
+  %p = alloca i32
+  store i32 %x, ptr<i32> %p
+  %v = load i32, ptr<i32> %p
+  ret i32 %v
+}
+
+define external i32 @g(i32 %x) {
+entry:
+  ret i32 %x
+}
+`
+
+func parse(t *testing.T) *ir.Module {
+	t.Helper()
+	m, err := asm.Parse("test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// recorder is a function pass that logs the functions it runs on and
+// reports a fixed effect
+type recorder struct {
+	name   string
+	effect Effect
+	log    *[]string
+}
+
+func (r recorder) Name() string { return r.name }
+
+func (r recorder) RunOnFunction(fn *ir.Function, a *Analyses) Effect {
+	*r.log = append(*r.log, r.name+":"+fn.Name())
+	return r.effect
+}
+
+type modulePass struct{ log *[]string }
+
+func (p modulePass) Name() string { return "module" }
+
+func (p modulePass) RunOnModule(m *ir.Module, a *Analyses) Effect {
+	*p.log = append(*p.log, "module:"+m.Name)
+	return Unchanged
+}
+
+func TestOrderAndDeclarations(t *testing.T) {
+	var log []string
+	pm := NewPassManager()
+	pm.Add(recorder{"first", Unchanged, &log}, modulePass{&log}, recorder{"second", Unchanged, &log})
+	changed, err := pm.Run(parse(t))
+	if err != nil || changed {
+		t.Fatalf("Run = %v, %v, want no change", changed, err)
+	}
+	want := "first:f first:g module:test second:f second:g"
+	if got := strings.Join(log, " "); got != want {
+		t.Errorf("passes ran as %q, want %q", got, want)
+	}
+	if len(pm.Passes()) != 3 {
+		t.Errorf("pipeline has %d passes, want 3", len(pm.Passes()))
+	}
+}
+
+type badPass struct{}
+
+func (badPass) Name() string { return "bad" }
+
+func TestAddRejectsOtherPasses(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Add accepted a pass that is neither kind")
+		}
+	}()
+	NewPassManager().Add(badPass{})
+}
+
+func TestAnalysisCache(t *testing.T) {
+	m := parse(t)
+	f := m.GetFunction("f")
+	a := NewAnalyses()
+	dom := a.Dominators(f)
+	if a.Dominators(f) != dom {
+		t.Error("dominator tree not cached")
+	}
+	if a.Frontiers(f) == nil || a.PostDominators(f) != a.PostDominators(f) {
+		t.Error("frontiers or post-dominators not cached")
+	}
+	if li := a.Loops(f); li == nil || a.Loops(f) != li {
+		t.Error("loops not cached")
+	}
+
+	// Only passes changing the CFG drop the cached results
+	var kept, dropped *Analyses
+	pm := NewPassManager()
+	pm.Add(
+		FunctionPassFunc{"warm", func(fn *ir.Function, a *Analyses) Effect { a.Dominators(fn); return Unchanged }},
+		FunctionPassFunc{"insts", func(fn *ir.Function, a *Analyses) Effect { return ChangedInstructions }},
+		FunctionPassFunc{"check", func(fn *ir.Function, a *Analyses) Effect {
+			if _, ok := a.dom[fn]; ok {
+				kept = a
+			}
+			return Unchanged
+		}},
+		FunctionPassFunc{"cfg", func(fn *ir.Function, a *Analyses) Effect { return ChangedCFG }},
+		FunctionPassFunc{"check2", func(fn *ir.Function, a *Analyses) Effect {
+			if _, ok := a.dom[fn]; !ok {
+				dropped = a
+			}
+			return Unchanged
+		}},
+	)
+	changed, err := pm.Run(m)
+	if err != nil || !changed {
+		t.Fatalf("Run = %v, %v, want a change", changed, err)
+	}
+	if kept == nil {
+		t.Error("ChangedInstructions dropped the dominator tree")
+	}
+	if dropped == nil {
+		t.Error("ChangedCFG kept the dominator tree")
+	}
+}
+
+func TestVerifyEach(t *testing.T) {
+	m := parse(t)
+	pm := NewPassManager()
+	pm.VerifyEach = true
+	pm.Add(FunctionPassFunc{"break", func(fn *ir.Function, a *Analyses) Effect {
+		entry := fn.EntryBlock()
+		entry.Instructions = entry.Instructions[:len(entry.Instructions)-1]
+		return ChangedInstructions
+	}})
+	_, err := pm.Run(m)
+	if err == nil || !strings.Contains(err.Error(), "pass break produced invalid IR") {
+		t.Errorf("got error %v, want the verifier to reject pass break", err)
+	}
+}
+
+func TestMem2RegPass(t *testing.T) {
+	m := parse(t)
+	pm := NewPassManager()
+	pm.VerifyEach = true
+	pm.Add(Mem2Reg())
+	changed, err := pm.Run(m)
+	if err != nil || !changed {
+		t.Fatalf("Run = %v, %v, want a change", changed, err)
+	}
+	f := m.GetFunction("f")
+	if n := len(f.Blocks[0].Instructions); n != 1 {
+		t.Errorf("@f has %d instructions after mem2reg, want just the ret:\n%s", n, f)
+	}
+	if changed, _ := pm.Run(m); changed {
+		t.Error("second run reported a change")
+	}
+}
@@ -0,0 +1,14 @@
+define external i32 @fib(i32 %n) {
+entry:
+  %cond = icmp slt i32 %n, 2
+  br i1 %cond, label %base_case, label %recurse
+base_case:
+  ret i32 %n
+recurse:
+  %sub1 = sub i32 %n, 1
+  %call1 = call i32 @fib(i32 %sub1)
+  %sub2 = sub i32 %n, 2
+  %call2 = call i32 @fib(i32 %sub2)
+  %sum = add i32 %call1, %call2
+  ret i32 %sum
+}
@@ -0,0 +1,10 @@
+@g_val = external global i32 42
+
+define external i32 @main() {
+entry:
+  %loaded_val = load i32, ptr<i32> %g_val
+  %stack_arr = alloca [2 x i32]
+  %elem_ptr = getelementptr [2 x i32], ptr<[2 x i32]> %stack_arr, i32 0, i32 0
+  store i32 %loaded_val, ptr<i32> %elem_ptr
+  ret i32 %loaded_val
+}
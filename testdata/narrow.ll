@@ -0,0 +1,19 @@
+define external i8 @wrap(i8 %a, i8 %b) {
+entry:
+  %m = mul i8 %a, 3
+  %r = add i8 %m, %b
+  ret i8 %r
+}
+
+define external u8 @twice(u8 %b) {
+entry:
+  %s = add u8 %b, %b
+  ret u8 %s
+}
+
+define external i32 @widen(i8 %a) {
+entry:
+  %w = call i8 @wrap(i8 %a, i8 1)
+  %x = sext i8 %w to i32
+  ret i32 %x
+}
@@ -0,0 +1,8 @@
+%Point = type { i32, i32 }
+
+define external void @update_y(ptr<%Point> %p, i32 %new_y) {
+entry:
+  %y_ptr = getelementptr %Point, ptr<%Point> %p, i32 0, i32 1
+  store i32 %new_y, ptr<i32> %y_ptr
+  ret void
+}
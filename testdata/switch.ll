@@ -0,0 +1,16 @@
+define external i32 @classify(i32 %n) {
+entry:
+  switch i32 %n, label %default [
+    i32 0, label %case_zero
+    i32 1, label %case_one
+  ]
+case_zero:
+  br label %merge
+case_one:
+  br label %merge
+default:
+  br label %merge
+merge:
+  %result = phi i32 [ 100, %case_zero ], [ 200, %case_one ], [ -1, %default ]
+  ret i32 %result
+}
@@ -0,0 +1,158 @@
+package transform
+
+import (
+	"github.com/arc-language/core-builder/analysis"
+	"github.com/arc-language/core-builder/ir"
+)
+
+// Copy is an assignment performed at the end of a block, just before its
+// terminator: the variable Dst takes the value of Src. Dst is a phi or a
+// Temp; Src is any value, and reads the variable's current contents when
+// it is a phi or a Temp.
+type Copy struct {
+	Dst ir.Value
+	Src ir.Value
+}
+
+// Temp is a variable introduced to break a cycle of copies, such as the
+// swap of two phis. It is not part of the function.
+type Temp struct {
+	ir.BaseValue
+}
+
+func (t *Temp) String() string {
+	return "%" + t.ValName
+}
+
+// LowerPhis takes fn out of SSA form for backends that keep each phi in a
+// variable of its own, such as the C backend and backends built on mir. It
+// returns, for each block, the copies to perform before its terminator so
+// that every phi holds its incoming value when control reaches the phi's
+// block. Code generated for the phis themselves then only reads their
+// variables, and each Temp becomes one more variable.
+//
+// Critical edges are split first, so that the copies of an edge run on
+// that edge only. Splitting edges is the only change made to fn; the phis
+// and their incoming lists stay valid IR. Unlike the other transforms,
+// LowerPhis returns the copies instead of reporting a change. A function
+// without phis is left alone and gets an empty table.
+func LowerPhis(fn *ir.Function) map[*ir.BasicBlock][]Copy {
+	copies := make(map[*ir.BasicBlock][]Copy)
+	found := false
+	for _, b := range fn.Blocks {
+		found = found || hasPhis(b)
+	}
+	if !found {
+		return copies
+	}
+	analysis.SplitCriticalEdges(fn)
+	names := usedNames(fn)
+	for _, b := range fn.Blocks {
+		if !hasPhis(b) {
+			continue
+		}
+		for _, p := range uniqueBlocks(b.Predecessors) {
+			copies[p] = append(copies[p], edgeCopies(p, b, names)...)
+		}
+	}
+	return copies
+}
+
+// EdgeCopies returns the copies that assign the phis of succ their values
+// for the edge from pred, for backends that can place code on an edge
+// without splitting it. The phis of a block are parallel copies; they are
+// ordered so that no variable is overwritten before every copy reading it
+// has run, using a Temp when copies form a cycle.
+func EdgeCopies(pred, succ *ir.BasicBlock) []Copy {
+	return edgeCopies(pred, succ, usedNames(succ.Parent))
+}
+
+func edgeCopies(pred, succ *ir.BasicBlock, names map[string]bool) []Copy {
+	var parallel []Copy
+	for _, inst := range succ.Instructions {
+		phi, ok := inst.(*ir.PhiInst)
+		if !ok {
+			break
+		}
+		for _, inc := range phi.Incoming {
+			if inc.Block == pred {
+				parallel = append(parallel, Copy{Dst: phi, Src: inc.Value})
+				break
+			}
+		}
+	}
+	return sequentialize(parallel, names)
+}
+
+// sequentialize orders a parallel copy, whose destinations are distinct,
+// so that each source is read before it is overwritten
+func sequentialize(parallel []Copy, names map[string]bool) []Copy {
+	var pending []Copy
+	for _, c := range parallel {
+		if c.Dst != c.Src {
+			pending = append(pending, c)
+		}
+	}
+	// reads counts the pending copies reading each variable
+	reads := make(map[ir.Value]int)
+	for _, c := range pending {
+		reads[c.Src]++
+	}
+
+	var seq []Copy
+	for len(pending) > 0 {
+		// Perform a copy whose destination nothing still needs
+		emitted := false
+		for i, c := range pending {
+			if reads[c.Dst] > 0 {
+				continue
+			}
+			seq = append(seq, c)
+			reads[c.Src]--
+			pending = append(pending[:i], pending[i+1:]...)
+			emitted = true
+			break
+		}
+		if emitted {
+			continue
+		}
+
+		// Every destination left is read by another copy, so the copies
+		// form cycles. Save one destination in a temporary and read that
+		// instead, which frees the destination.
+		dst := pending[0].Dst
+		tmp := &Temp{}
+		tmp.SetType(dst.Type())
+		tmp.SetName(uniqueName(names, dst.Name()+".tmp"))
+		seq = append(seq, Copy{Dst: tmp, Src: dst})
+		for i := range pending {
+			if pending[i].Src == dst {
+				pending[i].Src = tmp
+				reads[dst]--
+				reads[tmp]++
+			}
+		}
+	}
+	return seq
+}
+
+func hasPhis(b *ir.BasicBlock) bool {
+	if len(b.Instructions) == 0 {
+		return false
+	}
+	_, ok := b.Instructions[0].(*ir.PhiInst)
+	return ok
+}
+
+// uniqueBlocks returns blocks without duplicates, in order
+func uniqueBlocks(blocks []*ir.BasicBlock) []*ir.BasicBlock {
+	var list []*ir.BasicBlock
+	seen := make(map[*ir.BasicBlock]bool)
+	for _, b := range blocks {
+		if !seen[b] {
+			seen[b] = true
+			list = append(list, b)
+		}
+	}
+	return list
+}
@@ -0,0 +1,311 @@
+package transform
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/analysis"
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/interp"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/verify"
+)
+
+// phiTests holds functions whose phis are the classic hazards of leaving
+// SSA form: a swap, a rotation, a phi used after its next value is
+// computed (the lost copy) and a switch with several edges to one block
+const phiTests = `
+define i64 @swap(i64 %n) {
+entry:
+  br label %loop
+loop:
+  %i = phi i64 [ 0, %entry ], [ %i1, %loop ]
+  %a = phi i64 [ 1, %entry ], [ %b, %loop ]
+  %b = phi i64 [ 2, %entry ], [ %a, %loop ]
+  %c = phi i64 [ 3, %entry ], [ %a, %loop ]
+  %i1 = add i64 %i, 1
+  %done = icmp sge i64 %i1, %n
+  br i1 %done, label %exit, label %loop
+exit:
+  %r1 = mul i64 %a, 100
+  %r2 = mul i64 %b, 10
+  %r3 = add i64 %r1, %r2
+  %r = add i64 %r3, %c
+  ret i64 %r
+}
+
+define i64 @rotate(i64 %n) {
+entry:
+  br label %loop
+loop:
+  %i = phi i64 [ 0, %entry ], [ %i1, %latch ]
+  %x = phi i64 [ 1, %entry ], [ %y, %latch ]
+  %y = phi i64 [ 2, %entry ], [ %z, %latch ]
+  %z = phi i64 [ 3, %entry ], [ %x, %latch ]
+  %w = phi i64 [ 4, %entry ], [ %w, %latch ]
+  %i1 = add i64 %i, 1
+  br label %latch
+latch:
+  %done = icmp sge i64 %i1, %n
+  br i1 %done, label %exit, label %loop
+exit:
+  %r1 = mul i64 %x, 1000
+  %r2 = mul i64 %y, 100
+  %r3 = mul i64 %z, 10
+  %s1 = add i64 %r1, %r2
+  %s2 = add i64 %s1, %r3
+  %s = add i64 %s2, %w
+  ret i64 %s
+}
+
+define i64 @lost(i64 %n) {
+entry:
+  br label %loop
+loop:
+  %x = phi i64 [ 0, %entry ], [ %x1, %loop ]
+  %x1 = add i64 %x, 1
+  %c = icmp slt i64 %x1, %n
+  br i1 %c, label %loop, label %exit
+exit:
+  %r = add i64 %x, 0
+  ret i64 %r
+}
+
+define i64 @switch(i64 %n) {
+entry:
+  switch i64 %n, label %other [
+    i64 0, label %join
+    i64 1, label %join
+    i64 2, label %mid
+  ]
+mid:
+  br label %join
+other:
+  %o = mul i64 %n, 7
+  %t = icmp sge i64 %n, 0
+  br i1 %t, label %join, label %join
+join:
+  %p = phi i64 [ 10, %entry ], [ 10, %entry ], [ 20, %mid ], [ %o, %other ], [ %o, %other ]
+  ret i64 %p
+}
+`
+
+// run calls fn of m with each argument and returns the results
+func run(t *testing.T, m *ir.Module, fn string, args []int64) []int64 {
+	t.Helper()
+	in, err := interp.New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []int64
+	for _, a := range args {
+		v, err := in.Call(fn, interp.Int(a))
+		if err != nil {
+			t.Fatalf("@%s(%d): %v", fn, a, err)
+		}
+		res = append(res, v.Int())
+	}
+	return res
+}
+
+// counts returns the number of allocas, loads, stores and phis in fn
+func counts(fn *ir.Function) [4]int {
+	var n [4]int
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Instructions {
+			switch inst.(type) {
+			case *ir.AllocaInst:
+				n[0]++
+			case *ir.LoadInst:
+				n[1]++
+			case *ir.StoreInst:
+				n[2]++
+			case *ir.PhiInst:
+				n[3]++
+			}
+		}
+	}
+	return n
+}
+
+// materialize performs the copies of LowerPhis through stack slots so the
+// interpreter can run the result: every phi and Temp gets a slot, a copy
+// stores its source, read from its slot if it is a phi or a Temp, and a phi
+// becomes a load of its slot. Copies reading a variable therefore see its
+// contents at the time they run, as in a backend.
+func materialize(fn *ir.Function, copies map[*ir.BasicBlock][]Copy) {
+	b := builder.New()
+	slots := make(map[ir.Value]*ir.AllocaInst)
+	slot := func(v ir.Value) *ir.AllocaInst {
+		if s, ok := slots[v]; ok {
+			return s
+		}
+		b.SetInsertPointBefore(fn.EntryBlock().Instructions[0])
+		slots[v] = b.CreateAlloca(v.Type(), v.Name()+".slot")
+		return slots[v]
+	}
+	var phis []*ir.PhiInst
+	for _, blk := range fn.Blocks {
+		for _, inst := range blk.Instructions {
+			if phi, ok := inst.(*ir.PhiInst); ok {
+				phis = append(phis, phi)
+				slot(phi)
+			}
+		}
+	}
+	for blk, list := range copies {
+		for _, c := range list {
+			dst := slot(c.Dst)
+			b.SetInsertPointBefore(blk.Terminator())
+			src := c.Src
+			if s, ok := slots[src]; ok {
+				src = b.CreateLoad(src.Type(), s, "")
+			}
+			b.CreateStore(src, dst)
+		}
+	}
+	for _, phi := range phis {
+		b.SetInsertPointBefore(phi)
+		phi.ReplaceAllUsesWith(b.CreateLoad(phi.Type(), slots[phi], ""))
+	}
+	for _, phi := range phis {
+		phi.EraseFromParent()
+	}
+}
+
+func TestLowerPhis(t *testing.T) {
+	m, err := asm.Parse("phis", phiTests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []int64{-1, 0, 1, 2, 3, 4, 5}
+	want := make(map[string][]int64)
+	for _, fn := range m.Functions {
+		want[fn.Name()] = run(t, m, fn.Name(), args)
+	}
+
+	for _, fn := range m.Functions {
+		before := counts(fn)
+		copies := LowerPhis(fn)
+		if got := counts(fn); got != before {
+			t.Errorf("@%s: allocas, loads, stores and phis went from %v to %v", fn.Name(), before, got)
+		}
+		if errs := verify.Function(fn); len(errs) > 0 {
+			t.Fatalf("@%s: %v", fn.Name(), errs)
+		}
+		for _, b := range fn.Blocks {
+			for _, s := range b.Successors {
+				if analysis.IsCriticalEdge(b, s) {
+					t.Errorf("@%s: edge %%%s -> %%%s is critical", fn.Name(), b.Name(), s.Name())
+				}
+			}
+			// Copies feed phis through the edges to the one successor of
+			// their block
+			if n := len(uniqueBlocks(b.Successors)); len(copies[b]) > 0 && n != 1 {
+				t.Errorf("@%s: %%%s has copies and %d successors", fn.Name(), b.Name(), n)
+			}
+		}
+		materialize(fn, copies)
+	}
+	for name, w := range want {
+		got := run(t, m, name, args)
+		for i := range w {
+			if got[i] != w[i] {
+				t.Errorf("@%s(%d) = %d after lowering, want %d", name, args[i], got[i], w[i])
+			}
+		}
+	}
+}
+
+// copyString formats copies as dst=src pairs
+func copyString(copies []Copy) string {
+	var list []string
+	for _, c := range copies {
+		src := c.Src.Name()
+		if k, ok := c.Src.(*ir.ConstantInt); ok {
+			src = strconv.FormatInt(k.Value, 10)
+		}
+		list = append(list, c.Dst.Name()+"="+src)
+	}
+	return strings.Join(list, " ")
+}
+
+func TestLowerPhisCopies(t *testing.T) {
+	m, err := asm.Parse("phis", phiTests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fn, block string
+		want      string
+	}{
+		// The back edge of the swap is split out of %loop
+		{"swap", "loop.loop", "i=i1 c=a a.tmp=a a=b b=a.tmp"},
+		{"rotate", "latch.loop", "i=i1 x.tmp=x x=y y=z z=x.tmp"},
+		{"lost", "loop.loop", "x=x1"},
+		{"switch", "mid", "p=20"},
+	}
+	for _, tt := range tests {
+		fn := m.GetFunction(tt.fn)
+		copies := LowerPhis(fn)
+		var blk *ir.BasicBlock
+		for _, b := range fn.Blocks {
+			if b.Name() == tt.block {
+				blk = b
+			}
+		}
+		if blk == nil {
+			t.Errorf("@%s: no block %%%s", tt.fn, tt.block)
+			continue
+		}
+		if got := copyString(copies[blk]); got != tt.want {
+			t.Errorf("@%s: copies in %%%s are %q, want %q", tt.fn, tt.block, got, tt.want)
+		}
+	}
+}
+
+func TestLowerPhisResults(t *testing.T) {
+	m, err := asm.Parse("phis", phiTests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range m.Functions {
+		materialize(fn, LowerPhis(fn))
+	}
+	// After n iterations %a and %b have swapped n-1 times and %c holds the
+	// value %a had before the last swap
+	tests := []struct {
+		fn   string
+		arg  int64
+		want int64
+	}{
+		{"swap", 1, 123},
+		{"swap", 2, 211},
+		{"swap", 3, 122},
+		{"rotate", 1, 1234},
+		{"rotate", 2, 2314},
+		{"rotate", 3, 3124},
+		{"lost", 5, 4},
+		{"switch", 1, 10},
+		{"switch", 2, 20},
+		{"switch", 3, 21},
+	}
+	for _, tt := range tests {
+		if got := run(t, m, tt.fn, []int64{tt.arg})[0]; got != tt.want {
+			t.Errorf("@%s(%d) = %d, want %d", tt.fn, tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestLowerPhisWithoutPhis(t *testing.T) {
+	m, err := asm.Parse("plain", "define i64 @f(i1 %c) {\nentry:\n  br i1 %c, label %a, label %b\na:\n  br label %b\nb:\n  ret i64 0\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := m.Functions[0]
+	if copies := LowerPhis(fn); len(copies) != 0 || len(fn.Blocks) != 3 {
+		t.Errorf("got %d blocks with copies and %d blocks, want none and 3", len(copies), len(fn.Blocks))
+	}
+}
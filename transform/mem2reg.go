@@ -0,0 +1,307 @@
+package transform
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/analysis"
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Mem2Reg promotes stack slots to SSA values. An alloca is promoted when it
+// holds a single integer, float or pointer value and is only used as the
+// address of non-volatile loads and stores of that type. Phis are placed at
+// the iterated dominance frontier of the blocks storing to the slot; loads
+// are replaced by the reaching value and the memory operations are removed.
+// Loads that no store reaches become undef.
+func Mem2Reg(fn *ir.Function) bool {
+	if len(fn.Blocks) == 0 {
+		return false
+	}
+	return PromoteAllocas(fn, analysis.Dominators(fn))
+}
+
+// PromoteAllocas is Mem2Reg using an already computed dominator tree of fn
+func PromoteAllocas(fn *ir.Function, dom *analysis.DomTree) bool {
+	allocas := promotableAllocas(fn)
+	if len(allocas) == 0 {
+		return false
+	}
+
+	df := dom.Frontiers()
+	names := usedNames(fn)
+
+	// phis maps each inserted phi to the alloca it stands for
+	phis := make(map[*ir.PhiInst]*ir.AllocaInst)
+	b := builder.New()
+	for _, a := range allocas {
+		var defs []*ir.BasicBlock
+		seen := make(map[*ir.BasicBlock]bool)
+		for _, blk := range fn.Blocks {
+			for _, inst := range blk.Instructions {
+				if st, ok := inst.(*ir.StoreInst); ok && st.Ops[1] == a && !seen[blk] {
+					seen[blk] = true
+					defs = append(defs, blk)
+				}
+			}
+		}
+		for _, blk := range df.Iterated(defs) {
+			if !dom.Reachable(blk) {
+				continue
+			}
+			b.SetInsertPointBefore(blk.Instructions[0])
+			phi := b.CreatePhi(a.AllocatedType, uniqueName(names, a.Name()))
+			phis[phi] = a
+		}
+	}
+
+	r := &renamer{
+		dom:     dom,
+		phis:    phis,
+		repl:    make(map[ir.Value]ir.Value),
+		dead:    make(map[ir.Instruction]bool),
+		current: make(map[*ir.AllocaInst]ir.Value),
+	}
+	for _, a := range allocas {
+		r.dead[a] = true
+		r.current[a] = undef(a.AllocatedType)
+	}
+	r.rename(fn.EntryBlock())
+
+	// Memory operations in unreachable blocks are removed as well
+	for _, blk := range fn.Blocks {
+		if dom.Reachable(blk) {
+			continue
+		}
+		for _, inst := range blk.Instructions {
+			switch i := inst.(type) {
+			case *ir.LoadInst:
+				if a, ok := i.Ops[0].(*ir.AllocaInst); ok && r.dead[a] {
+					r.repl[i] = undef(a.AllocatedType)
+					r.dead[i] = true
+				}
+			case *ir.StoreInst:
+				if a, ok := i.Ops[1].(*ir.AllocaInst); ok && r.dead[a] {
+					r.dead[i] = true
+				}
+			}
+		}
+	}
+
+	// Phis need an entry for predecessors the renaming walk never visited
+	for phi, a := range phis {
+		blk := phi.Parent()
+		for _, p := range blk.Predecessors {
+			if !dom.Reachable(p) {
+				phi.AddIncoming(undef(a.AllocatedType), p)
+			}
+		}
+	}
+
+	replaceUses(r.repl)
+	removeDeadPhis(phis, r.dead)
+	removeInstructions(fn, r.dead)
+	return true
+}
+
+// promotableAllocas returns the allocas of fn that Mem2Reg can promote
+func promotableAllocas(fn *ir.Function) []*ir.AllocaInst {
+	var candidates []*ir.AllocaInst
+	ok := make(map[*ir.AllocaInst]bool)
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Instructions {
+			a, isAlloca := inst.(*ir.AllocaInst)
+			if !isAlloca || !isScalar(a.AllocatedType) {
+				continue
+			}
+			if a.NumElements != nil {
+				if c, isConst := a.NumElements.(*ir.ConstantInt); !isConst || c.Value != 1 {
+					continue
+				}
+			}
+			candidates = append(candidates, a)
+			ok[a] = true
+		}
+	}
+
+	escape := func(v ir.Value) {
+		if a, isAlloca := v.(*ir.AllocaInst); isAlloca {
+			ok[a] = false
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Instructions {
+			switch i := inst.(type) {
+			case *ir.LoadInst:
+				if a, isAlloca := i.Ops[0].(*ir.AllocaInst); isAlloca &&
+					(i.Volatile || !i.Type().Equal(a.AllocatedType)) {
+					ok[a] = false
+				}
+				continue
+			case *ir.StoreInst:
+				escape(i.Ops[0])
+				if a, isAlloca := i.Ops[1].(*ir.AllocaInst); isAlloca &&
+					(i.Volatile || !i.Ops[0].Type().Equal(a.AllocatedType)) {
+					ok[a] = false
+				}
+				continue
+			}
+			for _, op := range inst.Operands() {
+				escape(op)
+			}
+		}
+	}
+
+	var result []*ir.AllocaInst
+	for _, a := range candidates {
+		if ok[a] {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+func isScalar(t types.Type) bool {
+	switch t.Kind() {
+	case types.IntegerKind, types.FloatKind, types.PointerKind:
+		return true
+	}
+	return false
+}
+
+func undef(t types.Type) ir.Value {
+	c := &ir.ConstantUndef{}
+	c.SetType(t)
+	return c
+}
+
+type renamer struct {
+	dom     *analysis.DomTree
+	phis    map[*ir.PhiInst]*ir.AllocaInst
+	repl    map[ir.Value]ir.Value
+	dead    map[ir.Instruction]bool
+	current map[*ir.AllocaInst]ir.Value
+}
+
+// resolve returns the final value v stands for after load replacement
+func (r *renamer) resolve(v ir.Value) ir.Value {
+	for {
+		next, ok := r.repl[v]
+		if !ok {
+			return v
+		}
+		v = next
+	}
+}
+
+// rename walks the dominator tree from b, tracking the value each promoted
+// alloca holds
+func (r *renamer) rename(b *ir.BasicBlock) {
+	saved := make(map[*ir.AllocaInst]ir.Value, len(r.current))
+	for a, v := range r.current {
+		saved[a] = v
+	}
+
+	for _, inst := range b.Instructions {
+		switch i := inst.(type) {
+		case *ir.PhiInst:
+			if a, ok := r.phis[i]; ok {
+				r.current[a] = i
+			}
+		case *ir.LoadInst:
+			if a, ok := i.Ops[0].(*ir.AllocaInst); ok && r.dead[a] {
+				r.repl[i] = r.current[a]
+				r.dead[i] = true
+			}
+		case *ir.StoreInst:
+			if a, ok := i.Ops[1].(*ir.AllocaInst); ok && r.dead[a] {
+				r.current[a] = r.resolve(i.Ops[0])
+				r.dead[i] = true
+			}
+		}
+	}
+
+	for _, s := range b.Successors {
+		for _, inst := range s.Instructions {
+			phi, ok := inst.(*ir.PhiInst)
+			if !ok {
+				break
+			}
+			if a, ok := r.phis[phi]; ok {
+				phi.AddIncoming(r.current[a], b)
+			}
+		}
+	}
+
+	for _, c := range r.dom.Children(b) {
+		r.rename(c)
+	}
+	r.current = saved
+}
+
+// removeDeadPhis marks inserted phis whose only users are other dead phis
+// as dead
+func removeDeadPhis(phis map[*ir.PhiInst]*ir.AllocaInst, dead map[ir.Instruction]bool) {
+	// A phi is live if anything other than an inserted phi uses it, or if a
+	// live phi does
+	live := make(map[*ir.PhiInst]bool)
+	var work []*ir.PhiInst
+	for phi := range phis {
+		for _, u := range phi.Uses() {
+			if dead[u.User()] {
+				continue
+			}
+			if up, ok := u.User().(*ir.PhiInst); !ok || phis[up] == nil {
+				live[phi] = true
+				work = append(work, phi)
+				break
+			}
+		}
+	}
+	for len(work) > 0 {
+		phi := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, inc := range phi.Incoming {
+			if p, ok := inc.Value.(*ir.PhiInst); ok && phis[p] != nil && !live[p] {
+				live[p] = true
+				work = append(work, p)
+			}
+		}
+	}
+	for phi := range phis {
+		if !live[phi] {
+			dead[phi] = true
+		}
+	}
+}
+
+// usedNames returns the names of the values defined in fn
+func usedNames(fn *ir.Function) map[string]bool {
+	names := make(map[string]bool)
+	for _, a := range fn.Arguments {
+		names[a.Name()] = true
+	}
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Instructions {
+			names[inst.Name()] = true
+		}
+	}
+	return names
+}
+
+// uniqueName returns base if it is not in names, and otherwise base with
+// the first numeric suffix not in names
+func uniqueName(names map[string]bool, base string) string {
+	if !names[base] {
+		names[base] = true
+		return base
+	}
+	for n := 0; ; n++ {
+		name := fmt.Sprintf("%s.%d", base, n)
+		if !names[name] {
+			names[name] = true
+			return name
+		}
+	}
+}
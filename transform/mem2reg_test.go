@@ -0,0 +1,155 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/arc-language/core-builder/asm"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/verify"
+)
+
+// count returns the number of instructions of fn with the given opcode
+func count(fn *ir.Function, op ir.Opcode) int {
+	n := 0
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Instructions {
+			if inst.Opcode() == op {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+const loopSrc = `
+declare external void @use(ptr<i32>)
+
+define external i32 @sum(i32 %n) {
+entry:
+  %i = alloca i32
+  %s = alloca i32
+  store i32 0, ptr<i32> %i
+  store i32 0, ptr<i32> %s
+  br label %head
+head:
+  %iv = load i32, ptr<i32> %i
+  %c = icmp slt i32 %iv, %n
+  br i1 %c, label %body, label %exit
+body:
+  %sv = load i32, ptr<i32> %s
+  %iv2 = load i32, ptr<i32> %i
+  %odd = srem i32 %iv2, 2
+  %isodd = icmp ne i32 %odd, 0
+  br i1 %isodd, label %skip, label %add
+add:
+  %ns = add i32 %sv, %iv2
+  store i32 %ns, ptr<i32> %s
+  br label %skip
+skip:
+  %ni = add i32 %iv2, 1
+  store i32 %ni, ptr<i32> %i
+  br label %head
+exit:
+  %r = load i32, ptr<i32> %s
+  ret i32 %r
+}
+
+define external i32 @escape(i32 %x) {
+entry:
+  %p = alloca i32
+  %q = alloca i32
+  store i32 %x, ptr<i32> %p
+  store i32 %x, ptr<i32> %q
+  call void @use(ptr<i32> %q)
+  %a = load i32, ptr<i32> %p
+  %b = load i32, ptr<i32> %q
+  %r = add i32 %a, %b
+  ret i32 %r
+}
+`
+
+func TestMem2RegLoop(t *testing.T) {
+	m, err := asm.Parse("loop", loopSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []int64{-1, 0, 1, 2, 5, 10}
+	want := run(t, m, "sum", args)
+
+	fn := m.GetFunction("sum")
+	if !Mem2Reg(fn) {
+		t.Fatal("Mem2Reg reported no change")
+	}
+	if errs := verify.Function(fn); len(errs) > 0 {
+		t.Fatalf("%v\n%s", errs, fn)
+	}
+	for _, op := range []ir.Opcode{ir.OpAlloca, ir.OpLoad, ir.OpStore} {
+		if n := count(fn, op); n != 0 {
+			t.Errorf("%d %s instructions left", n, op)
+		}
+	}
+	// %i and %s meet in head, and %s again in skip
+	phis := make(map[string]int)
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Instructions {
+			if _, ok := inst.(*ir.PhiInst); ok {
+				phis[b.Name()]++
+			}
+		}
+	}
+	if phis["head"] != 2 || phis["skip"] != 1 || len(phis) != 2 {
+		t.Errorf("phis per block %v, want 2 in head and 1 in skip", phis)
+	}
+	got := run(t, m, "sum", args)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("@sum(%d) = %d after Mem2Reg, want %d", args[i], got[i], want[i])
+		}
+	}
+	if Mem2Reg(fn) {
+		t.Errorf("second Mem2Reg reported a change")
+	}
+}
+
+func TestMem2RegEscapingSlot(t *testing.T) {
+	m, err := asm.Parse("escape", loopSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := m.GetFunction("escape")
+	if !Mem2Reg(fn) {
+		t.Fatal("Mem2Reg reported no change")
+	}
+	if errs := verify.Function(fn); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	// %p is promoted; %q is passed to a call and stays in memory
+	if count(fn, ir.OpAlloca) != 1 || count(fn, ir.OpLoad) != 1 || count(fn, ir.OpStore) != 1 {
+		t.Errorf("wrong instructions left:\n%s", fn)
+	}
+	add := fn.Blocks[0].Instructions[len(fn.Blocks[0].Instructions)-2]
+	if add.Operands()[0] != fn.Arguments[0] {
+		t.Errorf("load of %%p not replaced by the stored value:\n%s", fn)
+	}
+}
+
+func TestMem2RegUndef(t *testing.T) {
+	src := `
+define external i32 @f() {
+entry:
+  %p = alloca i32
+  %v = load i32, ptr<i32> %p
+  ret i32 %v
+}
+`
+	m, err := asm.Parse("undef", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := m.GetFunction("f")
+	Mem2Reg(fn)
+	ret := fn.Blocks[0].Instructions[0]
+	if _, ok := ret.Operands()[0].(*ir.ConstantUndef); !ok || len(fn.Blocks[0].Instructions) != 1 {
+		t.Errorf("load without a store should become undef:\n%s", fn)
+	}
+}
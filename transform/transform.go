@@ -0,0 +1,46 @@
+// Package transform implements IR-to-IR transformations. Each transform
+// works on a single function, reports whether it changed anything and
+// leaves the function in a state accepted by the verify package. LowerPhis,
+// which takes functions out of SSA form for backends, returns the copies
+// that replace the phis instead.
+package transform
+
+import "github.com/arc-language/core-builder/ir"
+
+// replaceUses rewrites every use of a value in repl to its replacement,
+// following chains of replacements
+func replaceUses(repl map[ir.Value]ir.Value) {
+	resolve := func(v ir.Value) ir.Value {
+		for {
+			r, ok := repl[v]
+			if !ok {
+				return v
+			}
+			v = r
+		}
+	}
+	for v := range repl {
+		v.ReplaceAllUsesWith(resolve(v))
+	}
+}
+
+// removeInstructions deletes the instructions in dead from fn and drops
+// their operands from the values' use lists
+func removeInstructions(fn *ir.Function, dead map[ir.Instruction]bool) {
+	for _, b := range fn.Blocks {
+		kept := b.Instructions[:0]
+		for _, inst := range b.Instructions {
+			if !dead[inst] {
+				kept = append(kept, inst)
+				continue
+			}
+			for i := range inst.Operands() {
+				inst.SetOperand(i, nil)
+			}
+		}
+		for i := len(kept); i < len(b.Instructions); i++ {
+			b.Instructions[i] = nil
+		}
+		b.Instructions = kept
+	}
+}
@@ -0,0 +1,386 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DataLayout describes how a target lays out types in memory. It is parsed
+// from a specification string using the LLVM datalayout syntax, for example
+// "e-p:32:32-i64:64-n32-S128". All sizes, offsets and alignments it reports
+// are in bytes.
+//
+// Supported specifications are e and E (endianness), p[n]:size:abi[:pref]
+// (pointers in address space n), i, f and v<size>:abi[:pref] (integers,
+// floats and vectors of the given bit size), a:abi[:pref] (aggregates),
+// n<size>:... (native integer widths) and S<size> (stack alignment). The
+// mangling (m:), function pointer alignment (F) and address space
+// specifications (P, A, G) are accepted and ignored.
+type DataLayout struct {
+	BigEndian       bool
+	StackAlign      int64 // natural stack alignment, 0 if unspecified
+	NativeIntWidths []int // in bits
+
+	pointers  map[int]pointerSpec
+	ints      []alignSpec
+	floats    []alignSpec
+	vectors   []alignSpec
+	aggregate alignSpec
+}
+
+type alignSpec struct {
+	bits      int
+	abi, pref int64
+}
+
+type pointerSpec struct {
+	size, abi, pref int64
+}
+
+// StructLayout is the memory layout of a struct type
+type StructLayout struct {
+	Size    int64   // allocation size, including tail padding
+	Align   int64   // ABI alignment
+	Offsets []int64 // byte offset of each field
+}
+
+// DefaultDataLayout returns the layout used when a module does not specify
+// one: little endian, 64-bit pointers and naturally aligned scalars
+func DefaultDataLayout() *DataLayout {
+	return &DataLayout{
+		pointers: map[int]pointerSpec{0: {size: 8, abi: 8, pref: 8}},
+		ints: []alignSpec{
+			{1, 1, 1}, {8, 1, 1}, {16, 2, 2}, {32, 4, 4}, {64, 8, 8}, {128, 16, 16},
+		},
+		floats: []alignSpec{
+			{16, 2, 2}, {32, 4, 4}, {64, 8, 8}, {128, 16, 16},
+		},
+		vectors: []alignSpec{
+			{64, 8, 8}, {128, 16, 16},
+		},
+		aggregate: alignSpec{abi: 1, pref: 8},
+	}
+}
+
+// ParseDataLayout parses a datalayout specification. Entries it does not
+// mention keep their DefaultDataLayout values; an empty string yields the
+// default layout.
+func ParseDataLayout(spec string) (*DataLayout, error) {
+	dl := DefaultDataLayout()
+	if spec == "" {
+		return dl, nil
+	}
+	for _, item := range strings.Split(spec, "-") {
+		if err := dl.parseItem(item); err != nil {
+			return nil, fmt.Errorf("invalid datalayout %q: %v", spec, err)
+		}
+	}
+	return dl, nil
+}
+
+func (dl *DataLayout) parseItem(item string) error {
+	if item == "" {
+		return fmt.Errorf("empty specification")
+	}
+	kind, rest := item[0], item[1:]
+	switch kind {
+	case 'e', 'E':
+		if rest != "" {
+			return fmt.Errorf("unexpected %q after %c", rest, kind)
+		}
+		dl.BigEndian = kind == 'E'
+		return nil
+	case 'm', 'P', 'A', 'G', 'F':
+		return nil
+	case 'S':
+		bits, err := parseBits(item, rest)
+		if err != nil {
+			return err
+		}
+		if bits%8 != 0 {
+			return fmt.Errorf("%s: stack alignment is not a whole number of bytes", item)
+		}
+		dl.StackAlign = int64(bits / 8)
+		return nil
+	case 'n':
+		dl.NativeIntWidths = nil
+		for _, f := range strings.Split(rest, ":") {
+			bits, err := parseBits(item, f)
+			if err != nil {
+				return err
+			}
+			dl.NativeIntWidths = append(dl.NativeIntWidths, bits)
+		}
+		return nil
+	}
+
+	fields := strings.Split(rest, ":")
+	switch kind {
+	case 'p':
+		as := 0
+		if fields[0] != "" {
+			n, err := strconv.Atoi(fields[0])
+			if err != nil || n < 0 {
+				return fmt.Errorf("%s: bad address space %q", item, fields[0])
+			}
+			as = n
+		}
+		if len(fields) < 3 || len(fields) > 5 {
+			return fmt.Errorf("%s: expected p[n]:size:abi[:pref[:idx]]", item)
+		}
+		size, err := parseBits(item, fields[1])
+		if err != nil {
+			return err
+		}
+		if size == 0 || size%8 != 0 {
+			return fmt.Errorf("%s: pointer size is not a whole number of bytes", item)
+		}
+		align := fields[2:]
+		if len(align) > 2 {
+			align = align[:2] // the index size is not used
+		}
+		abi, pref, err := parseAlign(item, align)
+		if err != nil {
+			return err
+		}
+		dl.pointers[as] = pointerSpec{size: int64(size / 8), abi: abi, pref: pref}
+		return nil
+	case 'i', 'f', 'v':
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("%s: expected %c<size>:abi[:pref]", item, kind)
+		}
+		size, err := parseBits(item, fields[0])
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return fmt.Errorf("%s: zero size", item)
+		}
+		abi, pref, err := parseAlign(item, fields[1:])
+		if err != nil {
+			return err
+		}
+		s := alignSpec{bits: size, abi: abi, pref: pref}
+		switch kind {
+		case 'i':
+			dl.ints = setSpec(dl.ints, s)
+		case 'f':
+			dl.floats = setSpec(dl.floats, s)
+		default:
+			dl.vectors = setSpec(dl.vectors, s)
+		}
+		return nil
+	case 'a':
+		if fields[0] != "" && fields[0] != "0" || len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("%s: expected a:abi[:pref]", item)
+		}
+		abi, pref, err := parseAlign(item, fields[1:])
+		if err != nil {
+			return err
+		}
+		dl.aggregate = alignSpec{abi: abi, pref: pref}
+		return nil
+	}
+	return fmt.Errorf("unknown specification %q", item)
+}
+
+func parseBits(item, s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: bad size %q", item, s)
+	}
+	return n, nil
+}
+
+// parseAlign parses "abi[:pref]" alignments given in bits. An ABI alignment
+// of 0 means byte aligned; the preferred alignment defaults to the ABI one.
+func parseAlign(item string, fields []string) (abi, pref int64, err error) {
+	var vals [2]int64
+	for i, f := range fields {
+		bits, err := parseBits(item, f)
+		if err != nil {
+			return 0, 0, err
+		}
+		if bits%8 != 0 || bits&(bits-1) != 0 {
+			return 0, 0, fmt.Errorf("%s: alignment %d is not a power-of-two number of bytes", item, bits)
+		}
+		vals[i] = int64(bits / 8)
+	}
+	abi = max(vals[0], 1)
+	pref = abi
+	if len(fields) > 1 {
+		pref = vals[1]
+	}
+	if pref < abi {
+		return 0, 0, fmt.Errorf("%s: preferred alignment is less than the ABI alignment", item)
+	}
+	return abi, pref, nil
+}
+
+func setSpec(specs []alignSpec, s alignSpec) []alignSpec {
+	for i := range specs {
+		if specs[i].bits == s.bits {
+			specs[i] = s
+			return specs
+		}
+	}
+	specs = append(specs, s)
+	sort.Slice(specs, func(i, j int) bool { return specs[i].bits < specs[j].bits })
+	return specs
+}
+
+// lookup returns the spec for a bit size: the exact one if present, else the
+// smallest larger one, else the largest
+func lookup(specs []alignSpec, bits int) alignSpec {
+	for _, s := range specs {
+		if s.bits >= bits {
+			return s
+		}
+	}
+	return specs[len(specs)-1]
+}
+
+func (dl *DataLayout) pointer(addrSpace int) pointerSpec {
+	if p, ok := dl.pointers[addrSpace]; ok {
+		return p
+	}
+	return dl.pointers[0]
+}
+
+// PointerSize returns the size of a pointer in the given address space.
+// Address spaces without their own specification use address space 0.
+func (dl *DataLayout) PointerSize(addrSpace int) int64 {
+	return dl.pointer(addrSpace).size
+}
+
+// PointerAlign returns the ABI alignment of a pointer in the given address space
+func (dl *DataLayout) PointerAlign(addrSpace int) int64 {
+	return dl.pointer(addrSpace).abi
+}
+
+// IntPtrType returns the integer type as wide as a pointer in the given
+// address space
+func (dl *DataLayout) IntPtrType(addrSpace int) *IntType {
+	return NewInt(int(dl.PointerSize(addrSpace)*8), false)
+}
+
+// BitSizeOf returns the number of bits needed to hold a value of type t.
+// Unlike Type.BitSize it uses the target's pointer width and struct padding.
+func (dl *DataLayout) BitSizeOf(t Type) int64 {
+	switch ty := t.(type) {
+	case *IntType:
+		return int64(ty.BitWidth)
+	case *FloatType:
+		return int64(ty.BitWidth)
+	case *PointerType:
+		return dl.PointerSize(ty.AddressSpace) * 8
+	case *VectorType:
+		return int64(ty.Length) * dl.BitSizeOf(ty.ElementType)
+	case *ArrayType, *StructType:
+		return dl.SizeOf(t) * 8
+	}
+	return 0
+}
+
+// StoreSize returns the number of bytes a store of type t writes, without
+// the padding needed to reach its alignment
+func (dl *DataLayout) StoreSize(t Type) int64 {
+	switch t.(type) {
+	case *ArrayType, *StructType:
+		return dl.SizeOf(t)
+	}
+	return (dl.BitSizeOf(t) + 7) / 8
+}
+
+// SizeOf returns the allocation size of t: the distance between successive
+// elements of an array of t, including padding
+func (dl *DataLayout) SizeOf(t Type) int64 {
+	switch ty := t.(type) {
+	case *ArrayType:
+		return ty.Length * dl.SizeOf(ty.ElementType)
+	case *StructType:
+		return dl.StructLayout(ty).Size
+	}
+	return alignTo(dl.StoreSize(t), dl.AlignOf(t))
+}
+
+// AlignOf returns the ABI alignment of t
+func (dl *DataLayout) AlignOf(t Type) int64 {
+	return dl.align(t, false)
+}
+
+// PrefAlignOf returns the preferred alignment of t, used for globals and
+// stack slots when nothing else is requested
+func (dl *DataLayout) PrefAlignOf(t Type) int64 {
+	return dl.align(t, true)
+}
+
+func (dl *DataLayout) align(t Type, pref bool) int64 {
+	pick := func(s alignSpec) int64 {
+		if pref {
+			return s.pref
+		}
+		return s.abi
+	}
+	switch ty := t.(type) {
+	case *IntType:
+		return pick(lookup(dl.ints, ty.BitWidth))
+	case *FloatType:
+		return pick(lookup(dl.floats, ty.BitWidth))
+	case *PointerType:
+		p := dl.pointer(ty.AddressSpace)
+		if pref {
+			return p.pref
+		}
+		return p.abi
+	case *VectorType:
+		bits := int(dl.BitSizeOf(ty))
+		for _, s := range dl.vectors {
+			if s.bits == bits {
+				return pick(s)
+			}
+		}
+		// Vectors without a specification are aligned to their size
+		a := int64(1)
+		for a*8 < int64(bits) {
+			a *= 2
+		}
+		return a
+	case *ArrayType:
+		return dl.align(ty.ElementType, pref)
+	case *StructType:
+		if pref {
+			return max(dl.StructLayout(ty).Align, dl.aggregate.pref)
+		}
+		return dl.StructLayout(ty).Align
+	}
+	return 1
+}
+
+// StructLayout returns the field offsets, size and alignment of t. Fields of
+// a packed struct are not padded and the struct is byte aligned.
+func (dl *DataLayout) StructLayout(t *StructType) StructLayout {
+	l := StructLayout{Align: dl.aggregate.abi, Offsets: make([]int64, len(t.Fields))}
+	for i, f := range t.Fields {
+		if !t.Packed {
+			a := dl.AlignOf(f)
+			l.Size = alignTo(l.Size, a)
+			l.Align = max(l.Align, a)
+		}
+		l.Offsets[i] = l.Size
+		l.Size += dl.SizeOf(f)
+	}
+	l.Size = alignTo(l.Size, l.Align)
+	return l
+}
+
+// FieldOffset returns the byte offset of field i of t
+func (dl *DataLayout) FieldOffset(t *StructType, i int) int64 {
+	return dl.StructLayout(t).Offsets[i]
+}
+
+func alignTo(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
@@ -0,0 +1,124 @@
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefaultStructLayout(t *testing.T) {
+	dl := DefaultDataLayout()
+	tests := []struct {
+		name    string
+		st      *StructType
+		offsets []int64
+		size    int64
+		align   int64
+	}{
+		{"padded", NewStruct("", []Type{I8, I32, I64}, false), []int64{0, 4, 8}, 16, 8},
+		{"tail padding", NewStruct("", []Type{I64, I8}, false), []int64{0, 8}, 16, 8},
+		{"packed", NewStruct("", []Type{I8, I32, I16}, true), []int64{0, 1, 5}, 7, 1},
+		{"pointer", NewStruct("", []Type{I1, NewPointer(I8)}, false), []int64{0, 8}, 16, 8},
+		{"nested", NewStruct("", []Type{I8, NewStruct("", []Type{I16, I8}, false), I8}, false), []int64{0, 2, 6}, 8, 2},
+		{"array", NewStruct("", []Type{I8, NewArray(NewStruct("", []Type{I32, I8}, false), 3)}, false), []int64{0, 4}, 28, 4},
+		{"empty", NewStruct("", nil, false), []int64{}, 0, 1},
+	}
+	for _, tt := range tests {
+		l := dl.StructLayout(tt.st)
+		if !reflect.DeepEqual(l.Offsets, tt.offsets) || l.Size != tt.size || l.Align != tt.align {
+			t.Errorf("%s: offsets %v size %d align %d, want %v %d %d", tt.name, l.Offsets, l.Size, l.Align, tt.offsets, tt.size, tt.align)
+		}
+		if got := dl.SizeOf(tt.st); got != tt.size {
+			t.Errorf("%s: SizeOf = %d, want %d", tt.name, got, tt.size)
+		}
+	}
+	if off := dl.FieldOffset(tests[0].st, 2); off != 8 {
+		t.Errorf("FieldOffset = %d, want 8", off)
+	}
+}
+
+func TestScalarSizes(t *testing.T) {
+	dl := DefaultDataLayout()
+	tests := []struct {
+		t                    Type
+		bits, store, size, a int64
+	}{
+		{I1, 1, 1, 1, 1},
+		{I32, 32, 4, 4, 4},
+		{NewInt(24, false), 24, 3, 4, 4},
+		{F64, 64, 8, 8, 8},
+		{NewPointer(I8), 64, 8, 8, 8},
+		{NewVector(I32, 4), 128, 16, 16, 16},
+		{NewVector(I8, 3), 24, 3, 4, 4},
+		{NewArray(I16, 5), 80, 10, 10, 2},
+	}
+	for _, tt := range tests {
+		if bits, store, size, a := dl.BitSizeOf(tt.t), dl.StoreSize(tt.t), dl.SizeOf(tt.t), dl.AlignOf(tt.t); bits != tt.bits || store != tt.store || size != tt.size || a != tt.a {
+			t.Errorf("%s: bits %d store %d size %d align %d, want %d %d %d %d", tt.t, bits, store, size, a, tt.bits, tt.store, tt.size, tt.a)
+		}
+	}
+}
+
+func TestParseDataLayout(t *testing.T) {
+	// i386 Linux: 32-bit pointers, i64 and f64 only 4-byte aligned in structs
+	dl, err := ParseDataLayout("e-m:e-p:32:32-p270:32:32-i64:32:64-f64:32:64-f80:32-n8:16:32-S128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.BigEndian || dl.StackAlign != 16 || !reflect.DeepEqual(dl.NativeIntWidths, []int{8, 16, 32}) {
+		t.Errorf("endianness %v, stack %d, native %v", dl.BigEndian, dl.StackAlign, dl.NativeIntWidths)
+	}
+	if dl.PointerSize(0) != 4 || dl.PointerAlign(0) != 4 || dl.IntPtrType(0).BitWidth != 32 {
+		t.Errorf("pointer size %d align %d", dl.PointerSize(0), dl.PointerAlign(0))
+	}
+	st := NewStruct("", []Type{I8, I64, F64, NewPointer(I8)}, false)
+	l := dl.StructLayout(st)
+	if !reflect.DeepEqual(l.Offsets, []int64{0, 4, 12, 20}) || l.Size != 24 || l.Align != 4 {
+		t.Errorf("struct layout %+v", l)
+	}
+	if dl.PrefAlignOf(I64) != 8 || dl.AlignOf(I64) != 4 {
+		t.Errorf("i64 align %d pref %d, want 4 and 8", dl.AlignOf(I64), dl.PrefAlignOf(I64))
+	}
+
+	// Address spaces without a specification use address space 0
+	dl, err = ParseDataLayout("E-p:64:64-p1:16:16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dl.BigEndian || dl.PointerSize(1) != 2 || dl.PointerSize(3) != 8 {
+		t.Errorf("big endian %v, pointer sizes %d and %d", dl.BigEndian, dl.PointerSize(1), dl.PointerSize(3))
+	}
+	if s := dl.SizeOf(NewPointerWithAddressSpace(I8, 1)); s != 2 {
+		t.Errorf("addrspace(1) pointer size %d, want 2", s)
+	}
+
+	if dl, err := ParseDataLayout(""); err != nil || !reflect.DeepEqual(dl, DefaultDataLayout()) {
+		t.Errorf("empty layout: %v, %v", dl, err)
+	}
+}
+
+func TestParseDataLayoutErrors(t *testing.T) {
+	tests := []struct {
+		spec, want string
+	}{
+		{"e--p:32:32", "empty specification"},
+		{"e1", "unexpected \"1\" after e"},
+		{"x", "unknown specification \"x\""},
+		{"p:32", "expected p[n]:size:abi[:pref[:idx]]"},
+		{"p:33:32", "pointer size is not a whole number of bytes"},
+		{"pq:32:32", "bad address space \"q\""},
+		{"i32:24", "alignment 24 is not a power-of-two number of bytes"},
+		{"i32:64:32", "preferred alignment is less than the ABI alignment"},
+		{"i0:8", "zero size"},
+		{"i32", "expected i<size>:abi[:pref]"},
+		{"f64:x", "bad size \"x\""},
+		{"a1:8", "expected a:abi[:pref]"},
+		{"S12", "stack alignment is not a whole number of bytes"},
+	}
+	for _, tt := range tests {
+		_, err := ParseDataLayout(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+}
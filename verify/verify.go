@@ -0,0 +1,576 @@
+// Package verify checks the structural and type invariants of IR built
+// with the builder package. It is meant to run between the frontend and
+// any consumer of the IR so that malformed modules are reported as errors
+// instead of surfacing as panics much later.
+package verify
+
+import (
+	"fmt"
+
+	"github.com/arc-language/core-builder/analysis"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// Error describes a single verification failure
+type Error struct {
+	Func  *ir.Function
+	Block *ir.BasicBlock
+	Inst  ir.Instruction
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	loc := ""
+	if e.Func != nil {
+		loc = "@" + e.Func.Name()
+	}
+	if e.Block != nil {
+		loc += ", block %" + e.Block.Name()
+	}
+	if e.Inst != nil {
+		loc += ", " + describe(e.Inst)
+	}
+	if loc == "" {
+		return e.Msg
+	}
+	return loc + ": " + e.Msg
+}
+
+// describe names an instruction without calling String, which may panic
+// on exactly the malformed instructions the verifier reports
+func describe(inst ir.Instruction) string {
+	if inst.Name() != "" {
+		return fmt.Sprintf("%s %%%s", inst.Opcode(), inst.Name())
+	}
+	return inst.Opcode().String()
+}
+
+// Module verifies every function in the module and returns all errors found
+func Module(m *ir.Module) []error {
+	var errs []error
+	for _, g := range m.Globals {
+		if _, ok := g.Type().(*types.PointerType); !ok {
+			errs = append(errs, &Error{Msg: fmt.Sprintf("global @%s does not have pointer type", g.Name())})
+		}
+	}
+	for _, fn := range m.Functions {
+		errs = append(errs, Function(fn)...)
+	}
+	return errs
+}
+
+// Function verifies a single function. Declarations are always valid.
+func Function(fn *ir.Function) []error {
+	v := &verifier{fn: fn}
+	if len(fn.Blocks) == 0 {
+		return nil
+	}
+	v.run()
+	return v.errs
+}
+
+type verifier struct {
+	fn   *ir.Function
+	errs []error
+
+	// Position of every instruction inside its block, for same-block dominance
+	index map[ir.Instruction]int
+	dom   *analysis.DomTree
+}
+
+func (v *verifier) errorf(b *ir.BasicBlock, inst ir.Instruction, format string, args ...interface{}) {
+	v.errs = append(v.errs, &Error{Func: v.fn, Block: b, Inst: inst, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *verifier) run() {
+	v.index = make(map[ir.Instruction]int)
+	blocks := make(map[*ir.BasicBlock]bool)
+	for _, b := range v.fn.Blocks {
+		if blocks[b] {
+			v.errorf(b, nil, "block appears twice in function")
+		}
+		blocks[b] = true
+		for i, inst := range b.Instructions {
+			v.index[inst] = i
+		}
+	}
+
+	structureOK := true
+	for _, b := range v.fn.Blocks {
+		if !v.checkBlock(b, blocks) {
+			structureOK = false
+		}
+	}
+	// Dominance is only meaningful once every block has a well-formed terminator
+	if structureOK {
+		v.dom = analysis.Dominators(v.fn)
+	}
+	for _, b := range v.fn.Blocks {
+		for _, inst := range b.Instructions {
+			v.checkInstruction(b, inst)
+		}
+	}
+}
+
+// checkBlock verifies terminator placement, phi placement and CFG edges
+func (v *verifier) checkBlock(b *ir.BasicBlock, blocks map[*ir.BasicBlock]bool) bool {
+	if b.Parent != v.fn {
+		v.errorf(b, nil, "block parent is not the enclosing function")
+	}
+	if len(b.Instructions) == 0 {
+		v.errorf(b, nil, "block is empty")
+		return false
+	}
+	ok := true
+	seenNonPhi := false
+	last := len(b.Instructions) - 1
+	for i, inst := range b.Instructions {
+		if inst == nil {
+			v.errorf(b, nil, "nil instruction at position %d", i)
+			ok = false
+			continue
+		}
+		if inst.Parent() != b {
+			v.errorf(b, inst, "instruction parent is not the enclosing block")
+		}
+		if inst.IsTerminator() && i != last {
+			v.errorf(b, inst, "terminator in the middle of the block")
+			ok = false
+		}
+		if _, isPhi := inst.(*ir.PhiInst); isPhi {
+			if seenNonPhi {
+				v.errorf(b, inst, "phi node is not at the start of the block")
+			}
+		} else {
+			seenNonPhi = true
+		}
+	}
+	term := b.Instructions[last]
+	if term == nil || !term.IsTerminator() {
+		v.errorf(b, nil, "block does not end in a terminator")
+		return false
+	}
+
+	targets, targetsOK := successors(term)
+	if !targetsOK {
+		v.errorf(b, term, "branch target is nil")
+		return false
+	}
+	for _, t := range targets {
+		if !blocks[t] {
+			v.errorf(b, term, "branch target %%%s is not in this function", t.Name())
+			ok = false
+		}
+	}
+	if !sameBlocks(targets, b.Successors) {
+		v.errorf(b, term, "successor list does not match terminator targets")
+	}
+	for _, s := range b.Successors {
+		if count(s.Predecessors, b) != count(b.Successors, s) {
+			v.errorf(b, nil, "edge to %%%s is missing from its predecessor list", s.Name())
+		}
+	}
+	return ok
+}
+
+func (v *verifier) checkInstruction(b *ir.BasicBlock, inst ir.Instruction) {
+	if inst == nil {
+		return
+	}
+	if !fieldsMatchOperands(inst) {
+		v.errorf(b, inst, "operand list does not match the instruction's fields")
+		return
+	}
+	if lo, hi := operandCount(inst); len(inst.Operands()) < lo || hi >= 0 && len(inst.Operands()) > hi {
+		n := len(inst.Operands())
+		switch {
+		case hi < 0:
+			v.errorf(b, inst, "instruction has %d operands, expected at least %d", n, lo)
+		case lo == hi:
+			v.errorf(b, inst, "instruction has %d operands, expected %d", n, lo)
+		default:
+			v.errorf(b, inst, "instruction has %d operands, expected %d to %d", n, lo, hi)
+		}
+		return
+	}
+	ops := operands(inst)
+	for i, op := range ops {
+		if op == nil {
+			// Missing optional operands are allowed for ret void only
+			if _, isRet := inst.(*ir.RetInst); isRet && len(ops) == 1 {
+				continue
+			}
+			v.errorf(b, inst, "operand %d is nil", i)
+			return
+		}
+		if op.Type() == nil {
+			v.errorf(b, inst, "operand %d has no type", i)
+			return
+		}
+		v.checkDominance(b, inst, op)
+	}
+
+	switch i := inst.(type) {
+	case *ir.RetInst:
+		v.checkRet(b, i)
+	case *ir.CondBrInst:
+		if !isBool(i.Condition.Type()) {
+			v.errorf(b, inst, "branch condition has type %s, expected i1", i.Condition.Type())
+		}
+	case *ir.SwitchInst:
+		if !types.IsInteger(i.Condition.Type()) {
+			v.errorf(b, inst, "switch condition has non-integer type %s", i.Condition.Type())
+		}
+		for _, c := range i.Cases {
+			if c.Value == nil {
+				v.errorf(b, inst, "switch case value is nil")
+			} else if !c.Value.Type().Equal(i.Condition.Type()) {
+				v.errorf(b, inst, "switch case type %s does not match condition type %s",
+					c.Value.Type(), i.Condition.Type())
+			}
+		}
+	case *ir.BinaryInst:
+		v.checkBinary(b, i)
+	case *ir.ICmpInst:
+		lhs, rhs := i.Ops[0], i.Ops[1]
+		if !lhs.Type().Equal(rhs.Type()) {
+			v.errorf(b, inst, "icmp operand types %s and %s differ", lhs.Type(), rhs.Type())
+		} else if !types.IsInteger(lhs.Type()) && !types.IsPointer(lhs.Type()) {
+			v.errorf(b, inst, "icmp operands have type %s, expected integer or pointer", lhs.Type())
+		}
+	case *ir.FCmpInst:
+		lhs, rhs := i.Ops[0], i.Ops[1]
+		if !lhs.Type().Equal(rhs.Type()) {
+			v.errorf(b, inst, "fcmp operand types %s and %s differ", lhs.Type(), rhs.Type())
+		} else if !types.IsFloat(lhs.Type()) {
+			v.errorf(b, inst, "fcmp operands have type %s, expected float", lhs.Type())
+		}
+	case *ir.LoadInst:
+		if !types.IsPointer(i.Ops[0].Type()) {
+			v.errorf(b, inst, "load address has non-pointer type %s", i.Ops[0].Type())
+		}
+	case *ir.StoreInst:
+		val, ptr := i.Ops[0], i.Ops[1]
+		ptrTy, ok := ptr.Type().(*types.PointerType)
+		if !ok {
+			v.errorf(b, inst, "store address has non-pointer type %s", ptr.Type())
+		} else if !val.Type().Equal(ptrTy.ElementType) {
+			v.errorf(b, inst, "stored value type %s does not match pointer type %s", val.Type(), ptr.Type())
+		}
+	case *ir.GetElementPtrInst:
+		if !types.IsPointer(i.Ops[0].Type()) {
+			v.errorf(b, inst, "getelementptr base has non-pointer type %s", i.Ops[0].Type())
+		}
+		for _, idx := range i.Ops[1:] {
+			if !types.IsInteger(idx.Type()) {
+				v.errorf(b, inst, "getelementptr index has non-integer type %s", idx.Type())
+			}
+		}
+		v.checkGEPType(b, i)
+	case *ir.ExtractValueInst:
+		t, err := ir.AggregateIndexedType(i.Ops[0].Type(), i.Indices)
+		if err != nil {
+			v.errorf(b, inst, "%v", err)
+		} else if !t.Equal(i.Type()) {
+			v.errorf(b, inst, "extractvalue result type %s, expected %s", i.Type(), t)
+		}
+	case *ir.InsertValueInst:
+		agg, val := i.Ops[0], i.Ops[1]
+		t, err := ir.AggregateIndexedType(agg.Type(), i.Indices)
+		if err != nil {
+			v.errorf(b, inst, "%v", err)
+		} else if !val.Type().Equal(t) {
+			v.errorf(b, inst, "inserted value has type %s, expected %s", val.Type(), t)
+		}
+		if !agg.Type().Equal(i.Type()) {
+			v.errorf(b, inst, "insertvalue result type %s does not match aggregate type %s", i.Type(), agg.Type())
+		}
+	case *ir.SelectInst:
+		if !isBool(i.Ops[0].Type()) {
+			v.errorf(b, inst, "select condition has type %s, expected i1", i.Ops[0].Type())
+		}
+		if !i.Ops[1].Type().Equal(i.Ops[2].Type()) {
+			v.errorf(b, inst, "select arm types %s and %s differ", i.Ops[1].Type(), i.Ops[2].Type())
+		}
+	case *ir.CallInst:
+		v.checkCall(b, i)
+	case *ir.PhiInst:
+		v.checkPhi(b, i)
+	}
+}
+
+func (v *verifier) checkGEPType(b *ir.BasicBlock, inst *ir.GetElementPtrInst) {
+	base, ok := inst.Ops[0].Type().(*types.PointerType)
+	if !ok {
+		return
+	}
+	t, err := ir.GEPIndexedType(inst.SourceElementType, inst.Ops[1:])
+	if err != nil {
+		v.errorf(b, inst, "%v", err)
+		return
+	}
+	want := types.NewPointerWithAddressSpace(t, base.AddressSpace)
+	if !want.Equal(inst.Type()) {
+		v.errorf(b, inst, "getelementptr result type %s, expected %s", inst.Type(), want)
+	}
+}
+
+func (v *verifier) checkRet(b *ir.BasicBlock, inst *ir.RetInst) {
+	retTy := v.fn.FuncType.ReturnType
+	var val ir.Value
+	if len(inst.Ops) > 0 {
+		val = inst.Ops[0]
+	}
+	if retTy.Kind() == types.VoidKind {
+		if val != nil {
+			v.errorf(b, inst, "void function returns a value")
+		}
+		return
+	}
+	if val == nil {
+		v.errorf(b, inst, "function returning %s has ret void", retTy)
+		return
+	}
+	if !val.Type().Equal(retTy) {
+		v.errorf(b, inst, "returned type %s does not match function return type %s", val.Type(), retTy)
+	}
+}
+
+func (v *verifier) checkBinary(b *ir.BasicBlock, inst *ir.BinaryInst) {
+	lhs, rhs := inst.Ops[0], inst.Ops[1]
+	if !lhs.Type().Equal(rhs.Type()) {
+		v.errorf(b, inst, "operand types %s and %s differ", lhs.Type(), rhs.Type())
+		return
+	}
+	if inst.Type() == nil || !inst.Type().Equal(lhs.Type()) {
+		v.errorf(b, inst, "result type does not match operand type %s", lhs.Type())
+	}
+	elem := lhs.Type()
+	if vec, ok := elem.(*types.VectorType); ok {
+		elem = vec.ElementType
+	}
+	switch inst.Opcode() {
+	case ir.OpFAdd, ir.OpFSub, ir.OpFMul, ir.OpFDiv, ir.OpFRem:
+		if !types.IsFloat(elem) {
+			v.errorf(b, inst, "floating point operation on type %s", lhs.Type())
+		}
+	default:
+		if !types.IsInteger(elem) {
+			v.errorf(b, inst, "integer operation on type %s", lhs.Type())
+		}
+	}
+}
+
+func (v *verifier) checkCall(b *ir.BasicBlock, inst *ir.CallInst) {
+	if inst.Callee == nil {
+		// Calls by name cannot be checked until the callee is resolved
+		return
+	}
+	ft := inst.Callee.FuncType
+	n := len(inst.Ops)
+	if n < len(ft.ParamTypes) || (!ft.Variadic && n != len(ft.ParamTypes)) {
+		v.errorf(b, inst, "call to @%s has %d arguments, expected %d",
+			inst.Callee.Name(), n, len(ft.ParamTypes))
+		return
+	}
+	for i, pt := range ft.ParamTypes {
+		if arg := inst.Ops[i]; arg != nil && !arg.Type().Equal(pt) {
+			v.errorf(b, inst, "argument %d to @%s has type %s, expected %s",
+				i, inst.Callee.Name(), arg.Type(), pt)
+		}
+	}
+	if inst.Type() != nil && !inst.Type().Equal(ft.ReturnType) {
+		v.errorf(b, inst, "call result type %s does not match @%s return type %s",
+			inst.Type(), inst.Callee.Name(), ft.ReturnType)
+	}
+}
+
+func (v *verifier) checkPhi(b *ir.BasicBlock, inst *ir.PhiInst) {
+	incoming := make([]*ir.BasicBlock, 0, len(inst.Incoming))
+	for _, inc := range inst.Incoming {
+		if inc.Block == nil || inc.Value == nil {
+			v.errorf(b, inst, "phi has a nil incoming entry")
+			return
+		}
+		incoming = append(incoming, inc.Block)
+		if !inc.Value.Type().Equal(inst.Type()) {
+			v.errorf(b, inst, "incoming value from %%%s has type %s, expected %s",
+				inc.Block.Name(), inc.Value.Type(), inst.Type())
+		}
+		v.checkPhiDominance(b, inst, inc)
+	}
+	if !sameBlocks(incoming, b.Predecessors) {
+		v.errorf(b, inst, "incoming blocks do not match block predecessors")
+	}
+}
+
+// checkDominance verifies that an instruction operand is defined before
+// every use, and that arguments come from the enclosing function
+func (v *verifier) checkDominance(b *ir.BasicBlock, user ir.Instruction, op ir.Value) {
+	switch def := op.(type) {
+	case *ir.Argument:
+		if def.Parent != v.fn {
+			v.errorf(b, user, "uses an argument of another function")
+		}
+	case ir.Instruction:
+		defBlock := def.Parent()
+		if defBlock == nil || defBlock.Parent != v.fn {
+			v.errorf(b, user, "uses %%%s, which is not in this function", def.Name())
+			return
+		}
+		if v.dom == nil || !v.dom.Reachable(b) {
+			return
+		}
+		if defBlock == b {
+			if v.index[def] >= v.index[user] {
+				v.errorf(b, user, "uses %%%s before it is defined", def.Name())
+			}
+			return
+		}
+		if !v.dom.Dominates(defBlock, b) {
+			v.errorf(b, user, "definition of %%%s does not dominate this use", def.Name())
+		}
+	}
+}
+
+// checkPhiDominance verifies that an incoming value is available at the end
+// of its incoming block
+func (v *verifier) checkPhiDominance(b *ir.BasicBlock, phi *ir.PhiInst, inc ir.PhiIncoming) {
+	def, ok := inc.Value.(ir.Instruction)
+	if !ok {
+		if arg, isArg := inc.Value.(*ir.Argument); isArg && arg.Parent != v.fn {
+			v.errorf(b, phi, "uses an argument of another function")
+		}
+		return
+	}
+	defBlock := def.Parent()
+	if defBlock == nil || defBlock.Parent != v.fn {
+		v.errorf(b, phi, "uses %%%s, which is not in this function", def.Name())
+		return
+	}
+	if v.dom == nil || !v.dom.Reachable(inc.Block) {
+		return
+	}
+	if !v.dom.Dominates(defBlock, inc.Block) {
+		v.errorf(b, phi, "definition of %%%s does not dominate incoming block %%%s",
+			def.Name(), inc.Block.Name())
+	}
+}
+
+// operands returns every value an instruction reads. Phi incoming values
+// are handled separately since they are used at the end of the incoming
+// block.
+func operands(inst ir.Instruction) []ir.Value {
+	switch inst.(type) {
+	case *ir.PhiInst:
+		return nil
+	case *ir.RetInst:
+		if len(inst.Operands()) == 0 {
+			return []ir.Value{nil}
+		}
+	}
+	return inst.Operands()
+}
+
+// operandCount returns the smallest and largest number of operands inst may
+// have; hi is -1 when there is no upper bound
+func operandCount(inst ir.Instruction) (lo, hi int) {
+	switch inst.(type) {
+	case *ir.RetInst, *ir.AllocaInst:
+		return 0, 1
+	case *ir.LoadInst, *ir.CastInst, *ir.ExtractValueInst, *ir.VaStartInst, *ir.VaArgInst, *ir.VaEndInst:
+		return 1, 1
+	case *ir.BinaryInst, *ir.ICmpInst, *ir.FCmpInst, *ir.StoreInst, *ir.InsertValueInst:
+		return 2, 2
+	case *ir.SelectInst:
+		return 3, 3
+	case *ir.GetElementPtrInst:
+		return 1, -1
+	}
+	return 0, -1
+}
+
+// fieldsMatchOperands reports whether the operands an instruction also keeps
+// in named fields agree with its operand list. The fields fall out of step
+// when they are assigned directly instead of through SetOperand, which also
+// leaves the def-use chains stale.
+func fieldsMatchOperands(inst ir.Instruction) bool {
+	ops := inst.Operands()
+	switch i := inst.(type) {
+	case *ir.CondBrInst:
+		return len(ops) == 1 && ops[0] == i.Condition
+	case *ir.SwitchInst:
+		return len(ops) == 1 && ops[0] == i.Condition
+	case *ir.AllocaInst:
+		if i.NumElements == nil {
+			return len(ops) == 0
+		}
+		return len(ops) == 1 && ops[0] == i.NumElements
+	case *ir.PhiInst:
+		if len(ops) != len(i.Incoming) {
+			return false
+		}
+		for j, inc := range i.Incoming {
+			if ops[j] != inc.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// successors returns the blocks a terminator can transfer control to
+func successors(term ir.Instruction) ([]*ir.BasicBlock, bool) {
+	var targets []*ir.BasicBlock
+	switch t := term.(type) {
+	case *ir.BrInst:
+		targets = []*ir.BasicBlock{t.Target}
+	case *ir.CondBrInst:
+		targets = []*ir.BasicBlock{t.TrueBlock, t.FalseBlock}
+	case *ir.SwitchInst:
+		targets = append(targets, t.DefaultBlock)
+		for _, c := range t.Cases {
+			targets = append(targets, c.Block)
+		}
+	}
+	for _, t := range targets {
+		if t == nil {
+			return nil, false
+		}
+	}
+	return targets, true
+}
+
+func isBool(t types.Type) bool {
+	it, ok := t.(*types.IntType)
+	return ok && it.BitWidth == 1
+}
+
+func count(list []*ir.BasicBlock, b *ir.BasicBlock) int {
+	n := 0
+	for _, x := range list {
+		if x == b {
+			n++
+		}
+	}
+	return n
+}
+
+// sameBlocks reports whether two block lists are equal as multisets
+func sameBlocks(a, b []*ir.BasicBlock) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[*ir.BasicBlock]int)
+	for _, x := range a {
+		counts[x]++
+	}
+	for _, x := range b {
+		counts[x]--
+		if counts[x] < 0 {
+			return false
+		}
+	}
+	return true
+}
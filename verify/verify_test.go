@@ -0,0 +1,141 @@
+package verify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/core-builder/builder"
+	"github.com/arc-language/core-builder/ir"
+	"github.com/arc-language/core-builder/types"
+)
+
+// newFunc returns a builder positioned before the ret of
+// define i32 @f(i32 %x, i32 %y) whose entry block returns %x
+func newFunc() (*builder.Builder, *ir.Function) {
+	b := builder.New()
+	b.CreateModule("test")
+	fn := b.CreateFunction("f", types.I32, []types.Type{types.I32, types.I32}, false)
+	fn.Arguments[0].SetName("x")
+	fn.Arguments[1].SetName("y")
+	b.SetInsertPoint(b.CreateBlock("entry"))
+	ret := b.CreateRet(fn.Arguments[0])
+	b.SetInsertPointBefore(ret)
+	return b, fn
+}
+
+// expectError fails unless one of errs contains msg
+func expectError(t *testing.T, errs []error, msg string) {
+	t.Helper()
+	for _, err := range errs {
+		if strings.Contains(err.Error(), msg) {
+			return
+		}
+	}
+	t.Errorf("no error containing %q in %v", msg, errs)
+}
+
+func TestValidFunction(t *testing.T) {
+	b, fn := newFunc()
+	x, y := fn.Arguments[0], fn.Arguments[1]
+	sum := b.CreateAdd(x, y, "sum")
+	p := b.CreateAlloca(types.I32, "p")
+	b.CreateStore(sum, p)
+	b.CreateLoad(types.I32, p, "v")
+	cmp := b.CreateICmpSLT(x, y, "lt")
+	b.CreateSelect(cmp, x, y, "min")
+	if errs := Function(fn); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+func TestOperandCount(t *testing.T) {
+	// The zero value of each instruction has no operands
+	tests := []struct {
+		name string
+		inst func() ir.Instruction
+	}{
+		{"icmp", func() ir.Instruction { i := &ir.ICmpInst{}; i.Op = ir.OpICmp; return i }},
+		{"fcmp", func() ir.Instruction { i := &ir.FCmpInst{}; i.Op = ir.OpFCmp; return i }},
+		{"load", func() ir.Instruction { i := &ir.LoadInst{}; i.Op = ir.OpLoad; return i }},
+		{"store", func() ir.Instruction { i := &ir.StoreInst{}; i.Op = ir.OpStore; return i }},
+		{"getelementptr", func() ir.Instruction { i := &ir.GetElementPtrInst{}; i.Op = ir.OpGetElementPtr; return i }},
+		{"extractvalue", func() ir.Instruction { i := &ir.ExtractValueInst{}; i.Op = ir.OpExtractValue; return i }},
+		{"insertvalue", func() ir.Instruction { i := &ir.InsertValueInst{}; i.Op = ir.OpInsertValue; return i }},
+		{"select", func() ir.Instruction { i := &ir.SelectInst{}; i.Op = ir.OpSelect; return i }},
+		{"zext", func() ir.Instruction { i := &ir.CastInst{}; i.Op = ir.OpZExt; return i }},
+		{"add", func() ir.Instruction { i := &ir.BinaryInst{}; i.Op = ir.OpAdd; return i }},
+		{"va_end", func() ir.Instruction { i := &ir.VaEndInst{}; i.Op = ir.OpVaEnd; return i }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fn := newFunc()
+			fn.EntryBlock().InsertInstruction(0, tt.inst())
+			expectError(t, Function(fn), tt.name+": instruction has 0 operands, expected")
+		})
+	}
+}
+
+func TestTooManyOperands(t *testing.T) {
+	b, fn := newFunc()
+	x, y := fn.Arguments[0], fn.Arguments[1]
+	cmp := b.CreateICmpEQ(x, y, "eq")
+	cmp.SetOperand(2, x)
+	expectError(t, Function(fn), "icmp %eq: instruction has 3 operands, expected 2")
+}
+
+func TestTypeErrors(t *testing.T) {
+	b, fn := newFunc()
+	x := fn.Arguments[0]
+	wide := b.CreateSExt(x, types.I64, "wide")
+	add := b.CreateAdd(x, x, "add")
+	add.SetOperand(1, wide)
+	b.CreateLoad(types.I32, x, "bad")
+	cond := b.CreateICmpEQ(x, x, "eq")
+	b.CreateSelect(cond, x, wide, "sel")
+	errs := Function(fn)
+	expectError(t, errs, "operand types i32 and i64 differ")
+	expectError(t, errs, "load address has non-pointer type i32")
+	expectError(t, errs, "select arm types i32 and i64 differ")
+}
+
+func TestUseBeforeDef(t *testing.T) {
+	b, fn := newFunc()
+	x := fn.Arguments[0]
+	first := b.CreateAdd(x, x, "first")
+	second := b.CreateAdd(x, x, "second")
+	first.SetOperand(1, second)
+	expectError(t, Function(fn), "add %first: uses %second before it is defined")
+}
+
+func TestMissingTerminator(t *testing.T) {
+	b, fn := newFunc()
+	x := fn.Arguments[0]
+	b.CreateAdd(x, x, "sum")
+	entry := fn.EntryBlock()
+	entry.Instructions = entry.Instructions[:len(entry.Instructions)-1]
+	expectError(t, Function(fn), "block does not end in a terminator")
+}
+
+func TestFieldsOutOfStep(t *testing.T) {
+	b, fn := newFunc()
+	x := fn.Arguments[0]
+	cond := b.CreateICmpEQ(x, x, "eq")
+	entry := fn.EntryBlock()
+	other := b.CreateBlock("other")
+	b.SetInsertPoint(other)
+	b.CreateRet(x)
+	entry.Instructions = entry.Instructions[:len(entry.Instructions)-1]
+	b.SetInsertPoint(entry)
+	br := b.CreateCondBr(cond, other, other)
+	br.Condition = x
+	expectError(t, Function(fn), "operand list does not match the instruction's fields")
+}
+
+func TestDeclarationIsValid(t *testing.T) {
+	b := builder.New()
+	b.CreateModule("test")
+	fn := b.DeclareFunction("ext", types.Void, nil, false)
+	if errs := Function(fn); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}